@@ -0,0 +1,41 @@
+package main
+
+import (
+	"log"
+	"strings"
+
+	"github.com/vsekhar/gocal/internal/itercal"
+)
+
+// wheelchairAccessible returns true if r has a wheelchair accessibility
+// feature.
+func wheelchairAccessible(r *itercal.Resource) bool {
+	for _, f := range r.Features {
+		if strings.Contains(strings.ToLower(f), "wheelchair") {
+			return true
+		}
+	}
+	return false
+}
+
+// usableRoom returns a function reporting whether the room at an index in
+// resources may be booked: it must have the features of -features, and with
+// -accessible, be wheelchair accessible, unless no room in resources is marked
+// as such, in which case the features are probably not maintained and
+// accessibility is ignored.
+func usableRoom(resources []*itercal.Resource) func(i int) bool {
+	want := splitList(*roomFeatures)
+	ok := make([]bool, len(resources))
+	found := false
+	for i, r := range resources {
+		ok[i] = hasFeatures(r, want)
+		found = found || wheelchairAccessible(r)
+	}
+	if *accessible && !found {
+		log.Printf("no rooms have a wheelchair accessibility feature; ignoring -accessible for room choice")
+	}
+	checkAccessible := *accessible && found
+	return func(i int) bool {
+		return ok[i] && (!checkAccessible || wheelchairAccessible(resources[i]))
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"log"
+
+	"github.com/vsekhar/gocal/internal/rank"
+	"google.golang.org/api/calendar/v3"
+)
+
+// dayAnchors returns the anchor location, if any, of each of events based on
+// -first-near and -last-near. Events must be ordered by start time.
+//
+// If a day has only one event, it is anchored by -first-near.
+func dayAnchors(events []*calendar.Event) []*rank.Location {
+	parse := func(name, s string) *rank.Location {
+		if s == "" {
+			return nil
+		}
+		l, err := rank.ParseLocation(s)
+		if err != nil {
+			log.Fatalf("parsing -%s: %v", name, err)
+		}
+		return &l
+	}
+	first, last := parse("first-near", *firstNear), parse("last-near", *lastNear)
+
+	ret := make([]*rank.Location, len(events))
+	if first == nil && last == nil {
+		return ret
+	}
+	for _, day := range days(events) {
+		ret[day[0]] = first
+		if len(day) > 1 {
+			ret[day[len(day)-1]] = last
+		}
+	}
+	return ret
+}
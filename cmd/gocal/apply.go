@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/vsekhar/gocal/internal/interval"
+	"github.com/vsekhar/gocal/internal/itercal"
+	"google.golang.org/api/calendar/v3"
+)
+
+// 'gocal plan -out plan.json' saves the rooms it proposes so that they can be
+// reviewed, e.g. by a person or a policy checker, and booked later with
+// 'gocal apply plan.json', optionally subject to a policy (see policy.go). A
+// plan is applied only if it still holds: if any of its events has changed
+// since it was planned, or any of its rooms is no longer free, nothing is
+// booked and the plan must be made again.
+
+// savedPlan is the format of plan files.
+type savedPlan struct {
+	Created  time.Time        `json:"created"`
+	Building string           `json:"building"`
+	Bookings []plannedBooking `json:"bookings"`
+}
+
+// plannedBooking is a room planned for an event.
+type plannedBooking struct {
+	Calendar string `json:"calendar"`
+	EventID  string `json:"eventId"`
+	Summary  string `json:"summary"`
+	Start    string `json:"start"`
+	End      string `json:"end"`
+
+	// Updated is the event's modification time when it was planned.
+	Updated string `json:"updated"`
+
+	// Attendees is the number of people attending the event.
+	Attendees int64 `json:"attendees"`
+
+	Room         string   `json:"room"`
+	RoomName     string   `json:"roomName"`
+	RoomCapacity int64    `json:"roomCapacity"`
+	RoomFeatures []string `json:"roomFeatures,omitempty"`
+}
+
+// writePlan writes the rooms proposed for events to a plan file at path.
+// calendars holds the calendar of each event, rooms its room and proposed
+// whether the room was proposed rather than already booked.
+func writePlan(path string, events []*calendar.Event, calendars []string, rooms []*itercal.Resource, proposed []bool) error {
+	p := savedPlan{Created: time.Now().UTC(), Building: *buildingId, Bookings: []plannedBooking{}}
+	for i, e := range events {
+		if !proposed[i] || rooms[i] == nil {
+			continue
+		}
+		p.Bookings = append(p.Bookings, plannedBooking{
+			Calendar:     calendars[i],
+			EventID:      e.Id,
+			Summary:      e.Summary,
+			Start:        e.Start.DateTime,
+			End:          e.End.DateTime,
+			Updated:      e.Updated,
+			Attendees:    attendeeCount(e),
+			Room:         rooms[i].ResourceEmail,
+			RoomName:     rooms[i].GeneratedResourceName,
+			RoomCapacity: rooms[i].Capacity,
+			RoomFeatures: rooms[i].Features,
+		})
+	}
+	b, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(b, '\n'), 0600)
+}
+
+func readPlan(path string) (*savedPlan, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	p := new(savedPlan)
+	if err := json.Unmarshal(b, p); err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", path, err)
+	}
+	return p, nil
+}
+
+var applyFlagSet *flag.FlagSet
+
+func applyFlags(fs *flag.FlagSet) {
+	applyFlagSet = fs
+	policyCommand = fs.String("policy", "", "command evaluating the plan before it is applied, which may veto or amend it (see policy.go)")
+}
+
+// applyPlan books the rooms in the plan file given as the argument.
+func applyPlan(ctx context.Context) {
+	if applyFlagSet.NArg() != 1 {
+		log.Fatalf("usage: gocal apply plan.json")
+	}
+	path := applyFlagSet.Arg(0)
+	p, err := readPlan(path)
+	if err != nil {
+		log.Fatalf("reading plan: %v", err)
+	}
+	if p, err = applyPolicy(ctx, p); err != nil {
+		log.Fatalf("not applying plan %s: %v", path, err)
+	}
+	deferDuringQuietHours(time.Now())
+	if *dryRun {
+		log.Printf("Dry run")
+	}
+
+	dirSrv, calSrv := newServices(ctx)
+	cacheSpace := openCache()
+	*buildingId = p.Building
+	building, err := loadBuilding(ctx, cacheSpace, dirSrv)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if zone, err = buildingZone(ctx, building); err != nil {
+		log.Fatal(err)
+	}
+	resources, err := loadResources(ctx, cacheSpace, dirSrv)
+	if err != nil {
+		log.Fatalf("loading resources for building %s: %v", *buildingId, err)
+	}
+
+	events, rooms, err := checkPlan(ctx, calSrv, p, resources)
+	if err != nil {
+		log.Fatalf("not applying plan %s: %v", path, err)
+	}
+	var n int64
+	for _, e := range events {
+		n += reserveMutations(ctx, calSrv, e)
+	}
+	if !withinBudget(ctx, n) {
+		log.Fatalf("not applying plan %s: its %d changes exceed the budget", path, n)
+	}
+	for i, e := range events {
+		ok, err := reserve(ctx, newCalendarProvider(calSrv), calSrv, p.Bookings[i].Calendar, e, rooms[i])
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !ok {
+			rooms[i] = nil
+		}
+	}
+	logTable(msg("Booked:"), events, rooms)
+}
+
+// checkPlan returns the current events of the bookings in p and their rooms.
+// It returns an error describing every booking that no longer holds.
+func checkPlan(ctx context.Context, calSrv *calendar.Service, p *savedPlan, resources itercal.Resources) ([]*calendar.Event, []*itercal.Resource, error) {
+	byEmail := make(map[string]*itercal.Resource)
+	for _, r := range resources {
+		byEmail[r.ResourceEmail] = r
+	}
+	var problems []string
+	events := make([]*calendar.Event, len(p.Bookings))
+	rooms := make([]*itercal.Resource, len(p.Bookings))
+	var ids []string
+	var start, end time.Time
+	for i, b := range p.Bookings {
+		if rooms[i] = byEmail[b.Room]; rooms[i] == nil {
+			problems = append(problems, fmt.Sprintf("%s: room %s not found in building %s", b.Summary, b.RoomName, p.Building))
+			continue
+		}
+		e, err := calSrv.Events.Get(b.Calendar, b.EventID).Context(ctx).Do()
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", b.Summary, err))
+			continue
+		}
+		if e.Updated != b.Updated || e.Status == "cancelled" {
+			problems = append(problems, fmt.Sprintf("%s: changed since planned", b.Summary))
+			continue
+		}
+		events[i] = e
+		span := interval.OrDie(e.Start.DateTime, e.End.DateTime)
+		if start.IsZero() || span.Start.Before(start) {
+			start = span.Start
+		}
+		if span.End.After(end) {
+			end = span.End
+		}
+		ids = append(ids, b.Room)
+	}
+	if len(ids) > 0 {
+		freeBusy, err := roomFreeBusy(ctx, calSrv, ids, start, end)
+		if err != nil {
+			return nil, nil, err
+		}
+		// Policies may move bookings into the same room, so check the plan
+		// against itself too.
+		planned := make(map[string][]interval.Interval)
+		for i, e := range events {
+			if e == nil {
+				continue
+			}
+			span := interval.OrDie(e.Start.DateTime, e.End.DateTime)
+			email := rooms[i].ResourceEmail
+			free := isFree(freeBusy, email, span)
+			for _, other := range planned[email] {
+				free = free && !other.Overlaps(span)
+			}
+			planned[email] = append(planned[email], span)
+			if !free {
+				problems = append(problems, fmt.Sprintf("%s: %s is no longer free", e.Summary, rooms[i].GeneratedResourceName))
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return nil, nil, fmt.Errorf("%d bookings no longer hold; plan again:\n  %s", len(problems), strings.Join(problems, "\n  "))
+	}
+	return events, rooms, nil
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/vsekhar/gocal/internal/cache"
+	"github.com/vsekhar/gocal/internal/itercal"
+	"google.golang.org/api/calendar/v3"
+)
+
+// Some rooms only accept bookings once their owner approves them, leaving the
+// room's response pending until then. gocal records such bookings in the
+// cache and leaves them be for -approval-timeout, reporting when they are
+// approved or denied. If the owner denies a booking, or doesn't answer in
+// time, gocal withdraws the request and books the next best room, never
+// choosing a room that has declined the event.
+
+// approvalsFile holds the bookings awaiting approval.
+const approvalsFile = "approvals.json"
+
+// pendingApproval is a booking awaiting its room owner's approval.
+type pendingApproval struct {
+	Summary   string    `json:"summary"`
+	Room      string    `json:"room"`
+	RoomName  string    `json:"roomName"`
+	Requested time.Time `json:"requested"`
+}
+
+// approvals maps the keys of events to their bookings awaiting approval.
+type approvals map[string]pendingApproval
+
+// loadApprovals returns the bookings awaiting approval recorded in s or
+// -state-db.
+func loadApprovals(s *cache.Space) approvals {
+	if db := openStateDB(); db != nil {
+		ret, err := loadApprovalsDB(db)
+		if err != nil {
+			log.Printf("warning: ignoring pending approvals: %v", err)
+			return make(approvals)
+		}
+		return ret
+	}
+	ret := make(approvals)
+	b, err := s.ReadFile(s.Path(approvalsFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return ret
+	}
+	if err == nil {
+		err = json.Unmarshal(b, &ret)
+	}
+	if err != nil {
+		log.Printf("warning: ignoring pending approvals: %v", err)
+		return make(approvals)
+	}
+	return ret
+}
+
+func (a approvals) save(s *cache.Space) {
+	var err error
+	if db := openStateDB(); db != nil {
+		err = inStateTx(db, func(tx *sql.Tx) error { return saveApprovalsTx(tx, a) })
+	} else {
+		var b []byte
+		if b, err = json.Marshal(a); err == nil {
+			err = s.WriteFile(s.Path(approvalsFile), b)
+		}
+	}
+	if err != nil {
+		log.Printf("warning: recording pending approvals: %v", err)
+	}
+}
+
+// pendingRoom returns the resource in resources of one of the
+// -room-categories that has yet to respond to e, or nil if there is none.
+// Resources must be sorted by email.
+func pendingRoom(e *calendar.Event, resources []*itercal.Resource) *itercal.Resource {
+	for _, a := range e.Attendees {
+		if !a.Resource || (a.ResponseStatus != "needsAction" && a.ResponseStatus != "tentative") {
+			continue
+		}
+		if r := roomResource(a.Email, resources); r != nil {
+			return r
+		}
+	}
+	return nil
+}
+
+// declinedRooms returns the emails of the resources that have declined e.
+func declinedRooms(e *calendar.Event) map[string]bool {
+	ret := make(map[string]bool)
+	for _, a := range e.Attendees {
+		if a.Resource && a.ResponseStatus == "declined" {
+			ret[a.Email] = true
+		}
+	}
+	return ret
+}
+
+// checkApprovals updates the bookings awaiting approval for events, whose
+// calendars are in calendarOf and rooms in rooms, as of now. It returns the
+// room still awaiting approval for each event that should wait for it, by
+// index. Requests that time out are withdrawn.
+func checkApprovals(calSrv *calendar.Service, pending approvals, events []*calendar.Event, calendarOf map[*calendar.Event]string, rooms []*itercal.Resource, resources []*itercal.Resource, now time.Time) map[int]*itercal.Resource {
+	ret := make(map[int]*itercal.Resource)
+	for i, e := range events {
+		key := eventKey(calendarOf[e], e.Id)
+		p, tracked := pending[key]
+		if rooms[i] != nil {
+			if tracked && rooms[i].ResourceEmail == p.Room {
+				log.Printf("Approved: %s for %s", p.RoomName, e.Summary)
+			}
+			delete(pending, key)
+			continue
+		}
+		if r := pendingRoom(e, resources); r != nil {
+			if !tracked || p.Room != r.ResourceEmail {
+				p = pendingApproval{Summary: e.Summary, Room: r.ResourceEmail, RoomName: r.GeneratedResourceName, Requested: now}
+				pending[key] = p
+			}
+			if now.Sub(p.Requested) < *approvalTimeout {
+				log.Printf("Awaiting approval of %s for %s", r.GeneratedResourceName, e.Summary)
+				ret[i] = r
+				continue
+			}
+			log.Printf("No approval of %s for %s after %s; withdrawing the request and booking another room", r.GeneratedResourceName, e.Summary, *approvalTimeout)
+			withdrawRoom(calSrv, calendarOf[e], e, r)
+			delete(pending, key)
+			continue
+		}
+		if tracked {
+			if declinedRooms(e)[p.Room] {
+				log.Printf("Denied: %s for %s; booking another room", p.RoomName, e.Summary)
+			}
+			delete(pending, key)
+		}
+	}
+	for key, p := range pending {
+		if now.Sub(p.Requested) > historyPeriod {
+			delete(pending, key)
+		}
+	}
+	return ret
+}
+
+// withdrawRoom removes room from the attendees and location of event on
+// calendar calId, marking it declined in event so that another room is
+// booked.
+func withdrawRoom(calSrv *calendar.Service, calId string, event *calendar.Event, room *itercal.Resource) {
+	patch := new(calendar.Event)
+	for _, a := range event.Attendees {
+		if strings.EqualFold(a.Email, room.ResourceEmail) {
+			a.ResponseStatus = "declined"
+			continue
+		}
+		patch.Attendees = append(patch.Attendees, a)
+	}
+	if len(patch.Attendees) == 0 {
+		// Otherwise, an empty list is left out of the patch, leaving the
+		// room in place.
+		patch.ForceSendFields = append(patch.ForceSendFields, "Attendees")
+	}
+	if loc, ok := locationWithoutRoom(event.Location, room); ok {
+		patch.Location, event.Location = loc, loc
+		patch.ForceSendFields = append(patch.ForceSendFields, "Location")
+	}
+	if *dryRun {
+		return
+	}
+	atomic.AddInt64(&mutations, 1)
+	if _, err := calSrv.Events.Patch(calId, event.Id, patch).SendUpdates("none").Do(); err != nil {
+		log.Printf("warning: withdrawing %s from %s: %v", room.GeneratedResourceName, event.Summary, err)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+// With -hold-attachments, room holds carry the meeting's attachments, or if it
+// has none, the first Google Doc linked from its description as its agenda.
+// Attaching files needs Drive access, which gocal requests when the flag is
+// set; tokens granted without it skip attachments with a warning rather than
+// fail to create holds.
+
+// driveScope grants read access to the user's Drive files.
+const driveScope = "https://www.googleapis.com/auth/drive.readonly"
+
+// grantedScopes are the scopes of the user's token, if known.
+var grantedScopes []string
+
+var warnNoDrive sync.Once
+
+// attachToHolds returns true if holds should carry attachments: if
+// -hold-attachments is set and the token grants Drive access.
+func attachToHolds() bool {
+	if !*attachHolds {
+		return false
+	}
+	for _, s := range grantedScopes {
+		if s == driveScope {
+			return true
+		}
+	}
+	warnNoDrive.Do(func() {
+		log.Printf("warning: -hold-attachments needs Drive access, which %s doesn't grant; delete it and sign in again to grant it. Skipping attachments.", *tokenFile)
+	})
+	return false
+}
+
+// agendaDoc matches links to Google Docs.
+var agendaDoc = regexp.MustCompile(`https://docs\.google\.com/document/d/[\w-]+[^\s"'<>]*`)
+
+// holdAttachments returns the attachments for a hold for event.
+func holdAttachments(event *calendar.Event) []*calendar.EventAttachment {
+	if len(event.Attachments) > 0 {
+		return event.Attachments
+	}
+	if url := agendaDoc.FindString(event.Description); url != "" {
+		return []*calendar.EventAttachment{{
+			FileUrl:  strings.TrimRight(url, ".,;)"),
+			Title:    "Agenda",
+			MimeType: "application/vnd.google-apps.document",
+		}}
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/vsekhar/gocal/internal/itercal"
+	"google.golang.org/api/calendar/v3"
+)
+
+// Counts of API calls and calendar mutations made during the run, limited by
+// -max-api-calls and -max-mutations.
+var apiCalls, mutations int64
+
+// retryableFailures counts API calls that failed with network errors, rate
+// limiting or server errors, after which -daemon backs off.
+var retryableFailures int64
+
+var (
+	errAPIBudget = errors.New("API call budget (-max-api-calls) exhausted")
+	errAPIQuota  = errors.New("daily API call quota exhausted")
+)
+
+// countingTransport counts requests, failing them once -max-api-calls is
+// exceeded, and failed requests for -telemetry. With a quota, requests are
+// counted and limited by it instead.
+type countingTransport struct {
+	base  http.RoundTripper
+	quota *apiQuota
+}
+
+func (t countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.quota != nil {
+		if !t.quota.take(time.Now()) {
+			return nil, errAPIQuota
+		}
+	} else {
+		atomic.AddInt64(&unrecordedAPICalls, 1)
+		if n := atomic.AddInt64(&apiCalls, 1); *maxAPICalls > 0 && n > *maxAPICalls {
+			return nil, errAPIBudget
+		}
+	}
+	resp, err := t.base.RoundTrip(req)
+	countAPIError(resp, err)
+	if err != nil || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		atomic.AddInt64(&retryableFailures, 1)
+	}
+	return resp, err
+}
+
+// apiQuota counts the API calls made with a client of its own, e.g. a
+// tenant's, limiting those made each day.
+type apiQuota struct {
+	// perDay limits the calls made each UTC day (default: no limit).
+	perDay int64
+
+	mu    sync.Mutex
+	day   string
+	today int64
+	total int64
+}
+
+// take counts a call at now, returning false if the day's calls are
+// exhausted, in which case it mustn't be made.
+func (q *apiQuota) take(now time.Time) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.newDay(now)
+	if q.perDay > 0 && q.today >= q.perDay {
+		return false
+	}
+	q.today++
+	q.total++
+	return true
+}
+
+// exhausted returns true if no more calls can be made on the day of now.
+func (q *apiQuota) exhausted(now time.Time) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.newDay(now)
+	return q.perDay > 0 && q.today >= q.perDay
+}
+
+// calls returns the number of calls made in all.
+func (q *apiQuota) calls() int64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.total
+}
+
+// newDay starts counting the calls of the day of now, if it is a new one.
+func (q *apiQuota) newDay(now time.Time) {
+	if day := now.UTC().Format("2006-01-02"); day != q.day {
+		q.day, q.today = day, 0
+	}
+}
+
+// withinBudget returns true if n more mutations, each an API call, can be made
+// without exceeding -max-api-calls or -max-mutations, and ctx, bounded by
+// -deadline, isn't done.
+func withinBudget(ctx context.Context, n int64) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if *maxAPICalls > 0 && atomic.LoadInt64(&apiCalls)+n > *maxAPICalls {
+		return false
+	}
+	if *maxMutations > 0 && atomic.LoadInt64(&mutations)+n > *maxMutations {
+		return false
+	}
+	return true
+}
+
+// reserveMutations returns the number of mutations reserve makes for event.
+func reserveMutations(ctx context.Context, calSrv *calendar.Service, event *calendar.Event) int64 {
+	if externalProvider() != nil || usesHold(ctx, calSrv, event) {
+		return 2 // insert the hold or booking and link the event to it
+	}
+	return 1
+}
+
+// reportUnfinished logs the events after the budget was exhausted or ctx was
+// done that have no room.
+func reportUnfinished(ctx context.Context, events []*calendar.Event, rooms []*itercal.Resource) {
+	var remaining []string
+	for i, e := range events {
+		if rooms[i] == nil {
+			remaining = append(remaining, "  "+e.Summary+" ("+e.Start.DateTime+")")
+		}
+	}
+	reason := "Budget exhausted"
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		reason = "Deadline passed"
+	}
+	log.Printf("%s after %d API calls and %d mutations; %d events still need rooms:\n%s",
+		reason, atomic.LoadInt64(&apiCalls), atomic.LoadInt64(&mutations), len(remaining), strings.Join(remaining, "\n"))
+}
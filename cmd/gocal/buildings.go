@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/vsekhar/gocal/internal/interval"
+	"github.com/vsekhar/gocal/internal/timeutil"
+	directory "google.golang.org/api/admin/directory/v1"
+	"google.golang.org/api/calendar/v3"
+)
+
+// -building can list several buildings, e.g. 'tor-111,nyc-9' for people who
+// work from more than one office, and gocal then books each event's room in
+// the building it infers for the event:
+//
+//  1. that of rooms already on the event, e.g. booked by other attendees;
+//  2. that of a room, or the building's name, ID or city, in its location;
+//  3. the building inferred for most other events that day, since people
+//     tend to spend the day in one office;
+//  4. the first building listed.
+//
+// Rooms are booked in one pass per building, each booking only the events
+// assigned to its building, so that no event gets a room in two buildings.
+// -floor and -section, and those from the Directory profile, are the user's
+// location in the first building; rooms in the others are chosen near the
+// day's other meetings.
+
+// office is one of the buildings of -building.
+type office struct {
+	id       string
+	building *directory.Building
+
+	// rooms are the emails of the building's resources, and names their
+	// names, in lower case.
+	rooms map[string]bool
+	names []string
+}
+
+// buildingPass is a building's part of a booking pass over several buildings.
+type buildingPass struct {
+	// mine returns the events, of events, whose rooms to book in the
+	// building.
+	mine func(events []*calendar.Event) []*calendar.Event
+
+	// changedAfter is when the last pass started, for quick passes.
+	changedAfter time.Time
+
+	// finished is set by the pass if it booked the rooms it could.
+	finished bool
+}
+
+// bookBuildings books rooms for upcoming events in the buildings identified
+// by ids, each in the building inferred for it.
+func bookBuildings(ctx context.Context, ids []string) error {
+	passStart := time.Now()
+	dirSrv, _, cacheSpace := scanServices(ctx)
+
+	oldBuilding, oldFloor, oldSection := *buildingId, *floor, *section
+	defer func() { *buildingId, *floor, *section = oldBuilding, oldFloor, oldSection }()
+	var offices []*office
+	for _, id := range ids {
+		*buildingId = id
+		if err := resolveBuilding(ctx, cacheSpace, dirSrv); err != nil {
+			return err
+		}
+		resources, err := loadResources(ctx, cacheSpace, dirSrv)
+		if err != nil {
+			return fmt.Errorf("loading resources for building %s: %v", *buildingId, err)
+		}
+		building, err := loadBuilding(ctx, cacheSpace, dirSrv)
+		if err != nil {
+			return err
+		}
+		o := &office{id: *buildingId, building: building, rooms: make(map[string]bool)}
+		for _, r := range resources {
+			o.rooms[strings.ToLower(r.ResourceEmail)] = true
+			o.names = append(o.names, strings.ToLower(r.GeneratedResourceName))
+		}
+		offices = append(offices, o)
+	}
+	// Days are those of the first building, so that every pass assigns
+	// events alike.
+	dayZone, err := buildingZone(ctx, offices[0].building)
+	if err != nil {
+		return err
+	}
+
+	var changedAfter time.Time
+	if *pass == "quick" {
+		if changedAfter, err = lastPass(cacheSpace); err != nil {
+			return err
+		}
+	}
+	// Events are assigned by the first pass to see them, so that rooms booked
+	// by earlier passes don't move others to buildings already done.
+	assigned := make(map[string]int) // by event ID
+	finished := true
+	for i, o := range offices {
+		*buildingId = o.id
+		if i > 0 {
+			*floor, *section = 0, 0
+		}
+		log.Printf("Booking rooms in %s", o.building.BuildingName)
+		p := &buildingPass{
+			mine: func(events []*calendar.Event) []*calendar.Event {
+				for j, b := range assignBuildings(events, offices, dayZone) {
+					if _, ok := assigned[events[j].Id]; !ok {
+						assigned[events[j].Id] = b
+					}
+				}
+				var ret []*calendar.Event
+				for _, e := range events {
+					if assigned[e.Id] == i {
+						ret = append(ret, e)
+					}
+				}
+				return ret
+			},
+			changedAfter: changedAfter,
+		}
+		if err := bookIn(ctx, p); err != nil {
+			return err
+		}
+		finished = finished && p.finished
+		if ctx.Err() != nil {
+			finished = false
+			break
+		}
+	}
+	if finished && !*dryRun {
+		recordPass(cacheSpace, passStart)
+	}
+	return nil
+}
+
+// assignBuildings returns the index in offices of the building in which to
+// book each of events. Days are in zone.
+func assignBuildings(events []*calendar.Event, offices []*office, zone *time.Location) []int {
+	ret := make([]int, len(events))
+	byDay := make(map[string][]int)
+	var dates []string
+	for i, e := range events {
+		ret[i] = eventBuilding(e, offices)
+		d := timeutil.Date(interval.OrDie(e.Start.DateTime, e.End.DateTime).Start, zone)
+		if byDay[d] == nil {
+			dates = append(dates, d)
+		}
+		byDay[d] = append(byDay[d], i)
+	}
+	for _, d := range dates {
+		day := byDay[d]
+		counts := make([]int, len(offices))
+		for _, i := range day {
+			if ret[i] >= 0 {
+				counts[ret[i]]++
+			}
+		}
+		best := 0
+		for b, n := range counts {
+			if n > counts[best] {
+				best = b
+			}
+		}
+		for _, i := range day {
+			if ret[i] < 0 {
+				ret[i] = best
+			}
+		}
+	}
+	return ret
+}
+
+// eventBuilding returns the index in offices of the building of the rooms on
+// e or mentioned in its location, or -1 if there is none.
+func eventBuilding(e *calendar.Event, offices []*office) int {
+	for _, a := range e.Attendees {
+		if !a.Resource || a.ResponseStatus == "declined" {
+			continue
+		}
+		for b, o := range offices {
+			if o.rooms[strings.ToLower(a.Email)] {
+				return b
+			}
+		}
+	}
+	loc := strings.ToLower(e.Location)
+	if loc == "" {
+		return -1
+	}
+	for b, o := range offices {
+		for _, name := range o.names {
+			if name != "" && strings.Contains(loc, name) {
+				return b
+			}
+		}
+	}
+	for b, o := range offices {
+		names := []string{o.id, o.building.BuildingName}
+		if o.building.Address != nil {
+			names = append(names, o.building.Address.Locality)
+		}
+		for _, name := range names {
+			if name != "" && strings.Contains(loc, strings.ToLower(name)) {
+				return b
+			}
+		}
+	}
+	return -1
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+// Translations of user-facing messages, keyed by their English text. Messages
+// without a translation are printed in English.
+var catalog = map[language.Tag]map[string]string{
+	language.French: {
+		"Going to:":                              "Réunions :",
+		"Booked:":                                "Réservations :",
+		"Start":                                  "Début",
+		"Room":                                   "Salle",
+		"Event":                                  "Événement",
+		"(none)":                                 "(aucune)",
+		"'%s' is not a number":                   "« %s » n'est pas un nombre",
+		"OAuth client credentials file":          "Fichier d'identifiants du client OAuth",
+		"File in which to store the OAuth token": "Fichier où enregistrer le jeton OAuth",
+		"Search for your building":               "Rechercher votre bâtiment",
+		"No buildings found matching '%s'":       "Aucun bâtiment ne correspond à « %s »",
+		"Choose a building (0 to search again)":  "Choisissez un bâtiment (0 pour chercher à nouveau)",
+		"Preferred floor":                        "Étage préféré",
+		"Preferred section":                      "Section préférée",
+		"Saved config to %s":                     "Configuration enregistrée dans %s",
+		"No free rooms %s-%s":                    "Aucune salle libre de %s à %s",
+		"Booked %s":                              "Réservé : %s",
+		"Skipped %d calendars that could not be read:":      "%d agendas illisibles ignorés :",
+		"%s (floor %s, section %s, seats %d) is free %s-%s": "%s (étage %s, section %s, %d places) est libre de %s à %s",
+		"%s not found. Create an OAuth client ID of type 'Desktop app' in the\nGoogle Cloud Console (APIs & Services > Credentials), download it as JSON,\nand provide its path.": "%s introuvable. Créez un ID client OAuth de type « Application de bureau »\ndans la Google Cloud Console (API et services > Identifiants), téléchargez-le\nen JSON et indiquez son chemin.",
+	},
+}
+
+func init() {
+	for tag, msgs := range catalog {
+		for key, translation := range msgs {
+			message.SetString(tag, key, translation)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/vsekhar/gocal/internal/interval"
+	"github.com/vsekhar/gocal/internal/itercal"
+	directory "google.golang.org/api/admin/directory/v1"
+	"google.golang.org/api/calendar/v3"
+)
+
+// Short meetings of people who sit together, e.g. a team's stand-up, rarely
+// need a room: they can meet at their desks. With -colocated-max, gocal skips
+// meetings that last at most that long, have at most -colocated-attendees
+// accepted attendees, and whose accepted attendees all have desks in the
+// same section of the same floor, according to their Directory profiles.
+// Meetings with guests whose desks aren't known, and those tagged with one
+// of -room-tags, are booked as usual.
+
+// colocation looks up where attendees sit, once per run.
+type colocation struct {
+	dirSrv *directory.Service
+	desks  map[string]*directory.UserLocation // by email; nil if unknown
+}
+
+func newColocation(dirSrv *directory.Service) *colocation {
+	return &colocation{dirSrv: dirSrv, desks: make(map[string]*directory.UserLocation)}
+}
+
+// desk returns the desk location of the user with email, or nil if it is
+// unknown.
+func (c *colocation) desk(ctx context.Context, email string) *directory.UserLocation {
+	if d, ok := c.desks[email]; ok {
+		return d
+	}
+	var ret *directory.UserLocation
+	locs, err := itercal.UserLocations(ctx, c.dirSrv, email)
+	if err != nil && *verbose {
+		log.Printf("looking up the desk of %s: %v", email, err)
+	}
+	for _, l := range locs {
+		if l.Type == "desk" && l.BuildingId != "" && l.FloorName != "" && l.FloorSection != "" {
+			ret = l
+			break
+		}
+	}
+	c.desks[email] = ret
+	return ret
+}
+
+// area returns the desk location shared by the accepted attendees of e if e
+// needs no room by the rules of -colocated-max, or nil otherwise.
+func (c *colocation) area(ctx context.Context, e *calendar.Event) *directory.UserLocation {
+	if *colocatedMax <= 0 || isTagged(e) || interval.OrDie(e.Start.DateTime, e.End.DateTime).Duration() > *colocatedMax {
+		return nil
+	}
+	var emails []string
+	for _, a := range e.Attendees {
+		if !a.Resource && a.ResponseStatus == "accepted" {
+			emails = append(emails, a.Email)
+		}
+	}
+	if len(emails) < 2 || len(emails) > *colocatedAttendees {
+		return nil
+	}
+	var ret *directory.UserLocation
+	for _, email := range emails {
+		d := c.desk(ctx, email)
+		if d == nil {
+			return nil
+		}
+		if ret == nil {
+			ret = d
+		} else if d.BuildingId != ret.BuildingId || d.FloorName != ret.FloorName || d.FloorSection != ret.FloorSection {
+			return nil
+		}
+	}
+	return ret
+}
+
+// notColocated removes events without a room whose attendees sit together,
+// which need none. rooms holds the room booked for each event, and is
+// filtered along with events.
+func notColocated(ctx context.Context, dirSrv *directory.Service, events []*calendar.Event, rooms []*itercal.Resource) ([]*calendar.Event, []*itercal.Resource) {
+	if *colocatedMax <= 0 || externalProvider() != nil {
+		return events, rooms
+	}
+	c := newColocation(dirSrv)
+	var retEvents []*calendar.Event
+	var retRooms []*itercal.Resource
+	for i, e := range events {
+		if rooms[i] == nil {
+			if d := c.area(ctx, e); d != nil {
+				log.Printf("Skipping %s: its attendees sit together on floor %s, section %s of %s", e.Summary, d.FloorName, d.FloorSection, d.BuildingId)
+				continue
+			}
+		}
+		retEvents = append(retEvents, e)
+		retRooms = append(retRooms, rooms[i])
+	}
+	return retEvents, retRooms
+}
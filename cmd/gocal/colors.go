@@ -0,0 +1,55 @@
+package main
+
+import (
+	"log"
+	"strings"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+// colorFilter returns a function reporting whether an event should be
+// considered, going by its color and -skip-colors and -only-colors. Colors are
+// event color IDs, "default" for events without a color, or names given to
+// color IDs by -color-labels.
+func colorFilter() func(e *calendar.Event) bool {
+	labels := make(map[string]string) // name to color ID
+	for _, kv := range splitList(*colorLabels) {
+		id, name, ok := strings.Cut(kv, "=")
+		if !ok {
+			log.Fatalf("color label '%s' is not of the form id=name", kv)
+		}
+		labels[strings.ToLower(strings.TrimSpace(name))] = strings.TrimSpace(id)
+	}
+	set := func(s string) map[string]bool {
+		ret := make(map[string]bool)
+		for _, c := range splitList(s) {
+			if id, ok := labels[strings.ToLower(c)]; ok {
+				c = id
+			}
+			if strings.EqualFold(c, "default") {
+				c = ""
+			}
+			ret[c] = true
+		}
+		return ret
+	}
+	skip, only := set(*skipColors), set(*onlyColors)
+	return func(e *calendar.Event) bool {
+		if skip[e.ColorId] {
+			return false
+		}
+		return len(only) == 0 || only[e.ColorId]
+	}
+}
+
+// splitList splits a comma-separated list, trimming space and omitting empty
+// elements.
+func splitList(s string) []string {
+	var ret []string
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			ret = append(ret, e)
+		}
+	}
+	return ret
+}
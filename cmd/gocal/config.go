@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"gopkg.in/yaml.v3"
+)
+
+// The config file is a JSON object mapping flag names to values, e.g.:
+//
+//	{
+//	  "building": "tor-111",
+//	  "floor": 3,
+//	  "section": 2
+//	}
+//
+// or, if its name ends in .yaml or .yml, the same in YAML:
+//
+//	building: tor-111
+//	floor: 3
+//	section: 2
+//
+// Values in the config file are used for any flags not provided on the command
+// line. 'gocal config' prints the effective value of each flag and where it
+// came from.
+type config map[string]interface{}
+
+// defaultConfigFile returns config.yaml in the user's config directory if it
+// exists, and config.json otherwise.
+func defaultConfigFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "gocal.json"
+	}
+	if p := filepath.Join(dir, "gocal", "config.yaml"); fileExists(p) {
+		return p
+	}
+	return filepath.Join(dir, "gocal", "config.json")
+}
+
+func fileExists(p string) bool {
+	_, err := os.Stat(p)
+	return err == nil
+}
+
+// isYAML returns true if the config file at path is in YAML.
+func isYAML(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+func loadConfig(path string) (config, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return config{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	c := make(config)
+	if isYAML(path) {
+		err = yaml.NewDecoder(f).Decode(&c)
+		if err == io.EOF {
+			// Empty
+			err = nil
+		}
+	} else {
+		err = json.NewDecoder(f).Decode(&c)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func saveConfig(path string, c config) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if isYAML(path) {
+		enc := yaml.NewEncoder(f)
+		enc.SetIndent(2)
+		if err := enc.Encode(c); err != nil {
+			return err
+		}
+		return enc.Close()
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	return enc.Encode(c)
+}
+
+// configured records the flags set by applyConfig.
+var configured = make(map[string]bool)
+
+// applyConfig sets flags in fs from the config file at path, unless they were
+// provided on the command line. Names of flags not accepted by fs are ignored,
+// since they may belong to another command.
+func applyConfig(fs *flag.FlagSet, path string) error {
+	c, err := loadConfig(path)
+	if err != nil {
+		return err
+	}
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	// Apply in a consistent order so errors are reproducible.
+	names := make([]string, 0, len(c))
+	for name := range c {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if set[name] || fs.Lookup(name) == nil {
+			continue
+		}
+		if err := fs.Set(name, fmt.Sprint(c[name])); err != nil {
+			return fmt.Errorf("flag '%s': %v", name, err)
+		}
+		configured[name] = true
+	}
+	return nil
+}
+
+var configFlagSet *flag.FlagSet
+
+func configFlags(fs *flag.FlagSet) {
+	configFlagSet = fs
+}
+
+// showConfig prints the effective value of each flag and whether it came
+// from the command line, the config file or the flag's default. Secret values
+// are elided.
+func showConfig(ctx context.Context) {
+	fmt.Printf("Config file: %s\n", *configFile)
+	writeConfig(os.Stdout, configFlagSet)
+}
+
+// writeConfig writes the flags in fs, their values and sources to w.
+func writeConfig(w io.Writer, fs *flag.FlagSet) {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fs.VisitAll(func(f *flag.Flag) {
+		if hiddenFlags[f.Name] || f.Name == "config" {
+			return
+		}
+		source := "default"
+		switch {
+		case set[f.Name] && !configured[f.Name]:
+			source = "command line"
+		case configured[f.Name]:
+			source = "config"
+		}
+		value := f.Value.String()
+		if secretFlags[f.Name] && value != "" {
+			value = "(secret)"
+		}
+		if value == "" {
+			value = `""`
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", f.Name, value, source)
+	})
+	tw.Flush()
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/vsekhar/gocal/internal/interval"
+	"github.com/vsekhar/gocal/internal/itercal"
+	"github.com/vsekhar/gocal/internal/rank"
+	"google.golang.org/api/calendar/v3"
+)
+
+// -confirm asks before booking each room: for each event needing one, gocal
+// lists the best free rooms with their distance, capacity and floor, e.g.
+//
+//	Design review, Tue 14:00-15:00, 6 attendees
+//	  1) Room B       floor 1, section 2    8 seats    12m  *
+//	  2) Room A       floor 1, section 1    6 seats    30m
+//	Room to book (1-2, or s to skip) [1]:
+//
+// and books the one picked, or none if the event is skipped. Pressing enter
+// picks the room gocal would book on its own, marked '*'.
+
+// confirmChoices is the number of rooms offered for each event.
+const confirmChoices = 5
+
+// confirmRoom asks which of the best rooms for slots[j], that of event, to
+// book, returning its index in resources, or -1 to skip the event.
+func confirmRoom(w rank.Weights, slots []rank.Slot, j int, plan []int, rooms []rank.Room, resources []*itercal.Resource, event *calendar.Event) int {
+	idxs, scores := rankCandidates(w, slots, j, plan, rooms)
+	var choices []int
+	planned := false
+	for _, idx := range idxs {
+		if len(choices) == confirmChoices {
+			break
+		}
+		if slots[j].Available(idx) {
+			choices = append(choices, idx)
+			planned = planned || idx == plan[j]
+		}
+	}
+	if !planned {
+		choices = append(choices, plan[j])
+	}
+
+	span := interval.OrDie(event.Start.DateTime, event.End.DateTime)
+	fmt.Printf("%s, %s-%s, %s\n", event.Summary, span.Start.In(zone).Format("Mon 15:04"), span.End.In(zone).Format("15:04"),
+		msg("%d attendees", attendeeCount(event)))
+	def := 0
+	for n, idx := range choices {
+		r := resources[idx]
+		marker := ""
+		if idx == plan[j] {
+			def, marker = n, "  *"
+		}
+		fmt.Printf("  %d) %-20s %-20s %s  %5dm%s\n", n+1, r.GeneratedResourceName,
+			msg("floor %s, section %s", r.FloorName, r.FloorSection), msg("%2d seats", r.Capacity), scores[idx].Distance, marker)
+	}
+	for {
+		ans := prompt(fmt.Sprintf("Room to book (1-%d, or s to skip)", len(choices)), strconv.Itoa(def+1))
+		if strings.EqualFold(ans, "s") {
+			return -1
+		}
+		if n, err := strconv.Atoi(ans); err == nil && n >= 1 && n <= len(choices) {
+			return choices[n-1]
+		}
+		fmt.Println(msg("'%s' is not one of the rooms", ans))
+	}
+}
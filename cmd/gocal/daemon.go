@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/vsekhar/gocal/internal/cache"
+	directory "google.golang.org/api/admin/directory/v1"
+	"google.golang.org/api/calendar/v3"
+)
+
+// With -daemon, gocal keeps running and books rooms every -interval, e.g. as
+// a systemd service:
+//
+//	[Service]
+//	ExecStart=/usr/local/bin/gocal -daemon -interval 15m
+//	Restart=on-failure
+//
+// Scans share the user's credentials, and building and rooms stay in the cache
+// between them, so that scans only fetch events and availability. -deadline,
+// -max-api-calls and -max-mutations apply to each scan. After a scan during
+// which API calls failed with network errors, rate limiting or server errors,
+// the next waits twice as long, up to daemonMaxBackoff, even if the scan
+// failed because of them. Other errors that stop a one-shot run, e.g. expired
+// credentials, still exit, for the service manager to report or restart. SIGTERM stops the daemon after the scan underway.
+// With -watch, scans also follow changes to the calendars (see watch.go). The
+// cached building and rooms are refreshed between scans (see refresh.go).
+
+// daemonMaxBackoff bounds the wait between scans after API errors, unless
+// -interval is longer.
+const daemonMaxBackoff = time.Hour
+
+// passServices are the services and cache reused by each booking pass of a
+// long-running process: each scan of -daemon, or each pass a server's worker
+// makes (see worker.bookRooms).
+var passServices struct {
+	dirSrv     *directory.Service
+	calSrv     *calendar.Service
+	cacheSpace *cache.Space
+}
+
+// scanServices returns the services and cache for a booking pass,
+// authenticating the user unless a -daemon scan or a worker already has.
+func scanServices(ctx context.Context) (*directory.Service, *calendar.Service, *cache.Space) {
+	if passServices.calSrv != nil {
+		return passServices.dirSrv, passServices.calSrv, passServices.cacheSpace
+	}
+	dirSrv, calSrv := newServices(ctx)
+	return dirSrv, calSrv, openCache()
+}
+
+// runDaemon books rooms every -interval, and with -watch when notified of
+// changes, until ctx is done or SIGTERM is received.
+func runDaemon(ctx context.Context) {
+	if *daemonInterval <= 0 {
+		log.Fatalf("-interval must be positive")
+	}
+	stopCtx, stop := signal.NotifyContext(ctx, syscall.SIGTERM)
+	defer stop()
+	passServices.dirSrv, passServices.calSrv = newServices(ctx)
+	passServices.cacheSpace = openCache()
+	defer func() { passServices.dirSrv, passServices.calSrv, passServices.cacheSpace = nil, nil, nil }()
+
+	refreshCtx, stopRefreshing := context.WithCancel(ctx)
+	defer stopRefreshing()
+	ids := resolvedBuildings(ctx, passServices.cacheSpace, passServices.dirSrv)
+	serveMetrics(refreshMetrics(startRefresher(refreshCtx, passServices.cacheSpace, passServices.dirSrv, *customer, ids)))
+
+	w := startWatching(ctx, passServices.calSrv)
+	if w != nil {
+		defer w.stop(context.Background())
+	}
+
+	failures := 0
+	scan := func(quick bool) {
+		if scanDaemon(ctx, quick) {
+			failures++
+			log.Printf("API errors during scan; backing off for %s", daemonDelay(*daemonInterval, failures))
+		} else {
+			failures = 0
+		}
+	}
+	next := time.Now()
+	for {
+		if !time.Now().Before(next) {
+			scan(false)
+			next = time.Now().Add(daemonDelay(*daemonInterval, failures))
+		}
+		wake := next
+		var changed <-chan struct{}
+		if w != nil {
+			if r := w.renew(ctx); r.Before(wake) {
+				wake = r
+			}
+			changed = w.changed
+		}
+		select {
+		case <-stopCtx.Done():
+			if ctx.Err() == nil {
+				log.Printf("Stopping")
+			}
+			return
+		case <-time.After(time.Until(wake)):
+		case <-changed:
+			if failures > 0 || stopCtx.Err() != nil {
+				break
+			}
+			time.Sleep(watchSettle)
+			if w.needsRooms(ctx) {
+				log.Printf("Notified of events needing rooms")
+				scan(true)
+			}
+		}
+	}
+}
+
+// scanDaemon books rooms once, bounded by -deadline, returning true if API
+// calls failed in ways that warrant backing off, whether or not the scan
+// failed because of them. Quick scans make a quick pass
+// (see -pass).
+func scanDaemon(ctx context.Context, quick bool) bool {
+	if quick {
+		oldPass := *pass
+		*pass = "quick"
+		defer func() { *pass = oldPass }()
+	}
+	// Quiet hours only make this scan a dry run.
+	oldDryRun := *dryRun
+	defer func() { *dryRun = oldDryRun }()
+	atomic.StoreInt64(&apiCalls, 0)
+	atomic.StoreInt64(&mutations, 0)
+	atomic.StoreInt64(&retryableFailures, 0)
+	if *deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *deadline)
+		defer cancel()
+	}
+	err := bookPass(ctx)
+	retry := atomic.LoadInt64(&retryableFailures) > 0
+	if err != nil {
+		if !retry {
+			log.Fatal(err)
+		}
+		log.Printf("Scan failed: %v", err)
+	}
+	return retry
+}
+
+// daemonDelay returns the wait before the next scan after failures
+// consecutive scans with API errors.
+func daemonDelay(interval time.Duration, failures int) time.Duration {
+	limit := daemonMaxBackoff
+	if interval > limit {
+		limit = interval
+	}
+	d := interval
+	for i := 0; i < failures && d < limit; i++ {
+		d *= 2
+	}
+	if d > limit {
+		d = limit
+	}
+	return d
+}
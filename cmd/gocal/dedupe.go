@@ -0,0 +1,38 @@
+package main
+
+import (
+	"log"
+
+	"github.com/vsekhar/gocal/internal/itercal"
+	"google.golang.org/api/calendar/v3"
+)
+
+// dedupeEvents removes events that are copies of the same meeting, e.g. an
+// invite and a copy of it, identified by iCalUID and start time, since instances
+// of a recurring meeting share an iCalUID. Of each set of copies, the first with
+// a room in rooms is kept, or else the first. rooms holds the room booked for
+// each event, and is filtered along with events.
+func dedupeEvents(events []*calendar.Event, rooms []*itercal.Resource) ([]*calendar.Event, []*itercal.Resource) {
+	type key struct{ uid, start string }
+	keep := make(map[key]int) // index of the copy to keep
+	for i, e := range events {
+		if e.ICalUID == "" {
+			continue
+		}
+		k := key{e.ICalUID, e.Start.DateTime}
+		if j, ok := keep[k]; !ok || (rooms[j] == nil && rooms[i] != nil) {
+			keep[k] = i
+		}
+	}
+	var retEvents []*calendar.Event
+	var retRooms []*itercal.Resource
+	for i, e := range events {
+		if e.ICalUID != "" && keep[key{e.ICalUID, e.Start.DateTime}] != i {
+			log.Printf("Skipping duplicate of %s", e.Summary)
+			continue
+		}
+		retEvents = append(retEvents, e)
+		retRooms = append(retRooms, rooms[i])
+	}
+	return retEvents, retRooms
+}
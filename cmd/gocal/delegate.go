@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+// canWrite caches whether the user can modify events on a calendar, and
+// organizerCopies the results of organizerCopy by event ID, for one booking
+// pass (see resetDelegates).
+var (
+	canWrite        = make(map[string]bool)
+	organizerCopies = make(map[string]*calendar.Event)
+)
+
+// resetDelegates clears the caches of organizerCopy, so that a pass sees
+// changes to access and to organizers' copies since the last.
+func resetDelegates() {
+	canWrite = make(map[string]bool)
+	organizerCopies = make(map[string]*calendar.Event)
+}
+
+// organizerCopy returns the organizer's copy of e if the user is not the
+// organizer but can modify the organizer's calendar, e.g. as a delegate, or
+// nil otherwise, or if ctx is done or the budget spent before looking it up.
+// Booking a room on the organizer's copy adds it for all attendees.
+func organizerCopy(ctx context.Context, calSrv *calendar.Service, e *calendar.Event) *calendar.Event {
+	if e.Organizer == nil || e.Organizer.Self || e.Organizer.Email == "" {
+		return nil
+	}
+	if c, ok := organizerCopies[e.Id]; ok {
+		return c
+	}
+	org := e.Organizer.Email
+	writable, ok := canWrite[org]
+	if !ok {
+		if !withinBudget(ctx, 1) {
+			return nil
+		}
+		entry, err := calSrv.CalendarList.Get(org).Context(ctx).Do()
+		writable = err == nil && (entry.AccessRole == "writer" || entry.AccessRole == "owner")
+		canWrite[org] = writable
+		if writable {
+			log.Printf("Booking rooms on %s's calendar as a delegate", org)
+		}
+	}
+	var c *calendar.Event
+	if writable {
+		if !withinBudget(ctx, 1) {
+			return nil
+		}
+		var err error
+		if c, err = calSrv.Events.Get(org, e.Id).Context(ctx).Do(); err != nil {
+			log.Printf("getting organizer's copy of %s: %v", e.Summary, err)
+			c = nil
+		}
+	}
+	organizerCopies[e.Id] = c
+	return c
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/vsekhar/gocal/internal/itercal"
+	"google.golang.org/api/calendar/v3"
+)
+
+// Meetings shrink too: when attendees decline, a room gocal booked can end up
+// far bigger than the meeting, e.g. a 12-person room for 2, keeping others
+// from using it. With -daemon, each scan looks for rooms gocal booked that
+// seat at least oversizedFactor times a meeting's attendees, and for meetings
+// left with only one attendee, whose rooms aren't needed at all. By default
+// gocal offers, through -notify, the smallest suitable room near the old one
+// or the room's release, once per meeting and room while the daemon runs.
+// With -downgrade-rooms=move it makes the change, and with
+// -downgrade-rooms=off it does neither. As with upgrades (see upgrade.go),
+// rooms gocal didn't book and rooms in holds are left alone.
+
+// oversizedFactor is how many times a meeting's attendees its room must seat
+// for the room to be swapped for a smaller one.
+const oversizedFactor = 3
+
+// offeredDowngrades records the downgrades offered, by event key and room, so
+// that each is offered once.
+var offeredDowngrades = make(map[string]bool)
+
+// downgrading returns true if rooms are checked for downgrades.
+func downgrading() bool {
+	return *daemon && *downgradeRooms != "off" && externalProvider() == nil
+}
+
+// oversizedRooms returns, for each of events, the room gocal booked for it if
+// it is far bigger than needed, clearing that room from rooms so that a
+// smaller one is planned. rooms holds the room booked for each event and
+// calendarOf the calendar of each event.
+func oversizedRooms(events []*calendar.Event, rooms []*itercal.Resource, calendarOf map[*calendar.Event]string, now time.Time) []*itercal.Resource {
+	ret := make([]*itercal.Resource, len(events))
+	if !downgrading() {
+		return ret
+	}
+	for i, e := range events {
+		r := rooms[i]
+		if r == nil || privateProperty(e, roomProperty) != r.ResourceEmail || tooLate(e, now) != "" {
+			continue
+		}
+		if *downgradeRooms == "offer" && offeredDowngrades[downgradeKey(calendarOf[e], e, r)] {
+			continue
+		}
+		if n := attendeeCount(e); n > 0 && r.Capacity >= oversizedFactor*n {
+			log.Printf("%s has %d attendees in %s (capacity %d); looking for a smaller room", e.Summary, n, r.GeneratedResourceName, r.Capacity)
+			ret[i], rooms[i] = r, nil
+		}
+	}
+	return ret
+}
+
+// smallerRoom returns true if r suits event better than old, which is too big
+// for it.
+func smallerRoom(r *itercal.Resource, event *calendar.Event, old *itercal.Resource) bool {
+	return r.Capacity >= attendeeCount(event) && r.Capacity < old.Capacity
+}
+
+// downgradeKey returns the key recording an offer to swap room on event, on
+// calendar calId.
+func downgradeKey(calId string, event *calendar.Event, room *itercal.Resource) string {
+	return eventKey(calId, event.Id) + "/" + room.ResourceEmail
+}
+
+// offerDowngrade offers to move event, on calendar calId, from old to room.
+func offerDowngrade(calId string, event *calendar.Event, old, room *itercal.Resource) {
+	offeredDowngrades[downgradeKey(calId, event, old)] = true
+	notifyUser(fmt.Sprintf("%s (%s) has %d attendees in %s, which seats %d; %s, which seats %d, is free (run with -downgrade-rooms=move to move it)",
+		event.Summary, event.Start.DateTime, attendeeCount(event), old.GeneratedResourceName, old.Capacity, room.GeneratedResourceName, room.Capacity))
+}
+
+// releaseOversized releases old, which is far bigger than event needs, from
+// event on calendar calId once room has been booked for it, and notifies the
+// user.
+func releaseOversized(calSrv *calendar.Service, calId string, event *calendar.Event, old, room *itercal.Resource) {
+	withdrawRoom(calSrv, calId, event, old)
+	if *dryRun {
+		return
+	}
+	notifyUser(fmt.Sprintf("Moved %s (%s) from %s to %s: its %d attendees needed far fewer than the room's %d seats",
+		event.Summary, event.Start.DateTime, old.GeneratedResourceName, room.GeneratedResourceName, attendeeCount(event), old.Capacity))
+}
+
+// isDeserted returns true if e, which has a room gocal booked, has been left
+// with at most one attendee.
+func isDeserted(e *calendar.Event) bool {
+	if !downgrading() || e.Start.DateTime == "" || e.Status == "cancelled" || isHold(e) {
+		return false
+	}
+	room := privateProperty(e, roomProperty)
+	if room == "" || attendeeCount(e) > 1 {
+		return false
+	}
+	for _, a := range e.Attendees {
+		if a.Resource && a.ResponseStatus == "accepted" && a.Email == room {
+			return true
+		}
+	}
+	return false
+}
+
+// releaseDeserted offers to release, or releases, the rooms of events, which
+// are deserted, in resources. calendarOf holds the calendar of each event.
+// Resources must be sorted by email.
+func releaseDeserted(calSrv *calendar.Service, events []*calendar.Event, calendarOf map[*calendar.Event]string, resources []*itercal.Resource, now time.Time) {
+	for _, e := range events {
+		r := roomResource(privateProperty(e, roomProperty), resources)
+		if r == nil || tooLate(e, now) != "" {
+			continue
+		}
+		calId := calendarOf[e]
+		if *downgradeRooms == "offer" {
+			if key := downgradeKey(calId, e, r); !offeredDowngrades[key] {
+				offeredDowngrades[key] = true
+				notifyUser(fmt.Sprintf("%s (%s) has no other attendees left; run with -downgrade-rooms=move to release %s", e.Summary, e.Start.DateTime, r.GeneratedResourceName))
+			}
+			continue
+		}
+		log.Printf("Releasing %s from %s, which has no other attendees left", r.GeneratedResourceName, e.Summary)
+		withdrawRoom(calSrv, calId, e, r)
+		if !*dryRun {
+			notifyUser(fmt.Sprintf("Released %s from %s (%s), which has no other attendees left", r.GeneratedResourceName, e.Summary, e.Start.DateTime))
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/vsekhar/gocal/internal/cache"
+	"github.com/vsekhar/gocal/internal/itercal"
+	"github.com/vsekhar/gocal/internal/timeutil"
+	"google.golang.org/api/calendar/v3"
+)
+
+// With -equipment-health, facilities can note broken equipment in a CSV file,
+// or at a URL serving one, e.g.:
+//
+//	room,feature,until,note
+//	Phoenix,Video conferencing,2022-04-08,VC down until Friday
+//	Osprey,,,Flooded
+//
+// Each note names a room (by name or email) and, optionally, one of its
+// features. Until the note's end, a date (inclusive) or RFC 3339 time, the
+// feature is removed from the room, or without a feature, the room is treated
+// as busy. Notes without an end stay in effect until removed. The notes are
+// cached for equipmentMaxAge, so that each run needn't fetch them.
+
+// equipmentMaxAge is how long equipment notes are cached.
+const equipmentMaxAge = 15 * time.Minute
+
+// equipmentVersion is the version of the equipment notes' cache entry.
+var equipmentVersion = cache.Version{Number: 1}
+
+// equipmentNote notes broken equipment in a room.
+type equipmentNote struct {
+	Room, Feature, Until, Note string
+}
+
+// end returns the time until which n is in effect, or the zero time if it
+// has no end.
+func (n equipmentNote) end() (time.Time, error) {
+	if n.Until == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, n.Until); err == nil {
+		return t, nil
+	}
+	d, err := timeutil.ParseDate(n.Until, zone)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("until '%s' is neither a date nor an RFC 3339 time", n.Until)
+	}
+	return d.AddDate(0, 0, 1), nil
+}
+
+// matches returns true if n names r.
+func (n equipmentNote) matches(r *itercal.Resource) bool {
+	for _, name := range []string{r.ResourceEmail, r.ResourceName, r.GeneratedResourceName} {
+		if name != "" && strings.EqualFold(name, n.Room) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseEquipmentNotes parses equipment notes in CSV form with a header row.
+func parseEquipmentNotes(r io.Reader) ([]equipmentNote, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+	columns := make(map[string]int)
+	for i, h := range records[0] {
+		columns[strings.ToLower(strings.TrimSpace(h))] = i
+	}
+	if _, ok := columns["room"]; !ok {
+		return nil, fmt.Errorf("no 'room' column")
+	}
+	field := func(rec []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(rec) {
+			return strings.TrimSpace(rec[i])
+		}
+		return ""
+	}
+	var ret []equipmentNote
+	for _, rec := range records[1:] {
+		n := equipmentNote{
+			Room:    field(rec, "room"),
+			Feature: field(rec, "feature"),
+			Until:   field(rec, "until"),
+			Note:    field(rec, "note"),
+		}
+		if n.Room != "" {
+			ret = append(ret, n)
+		}
+	}
+	return ret, nil
+}
+
+// fetchEquipmentNotes returns the contents of -equipment-health.
+func fetchEquipmentNotes() ([]byte, error) {
+	src := *equipmentHealth
+	if !strings.HasPrefix(src, "https://") && !strings.HasPrefix(src, "http://") {
+		return os.ReadFile(src)
+	}
+	resp, err := httpClient().Get(src)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: %s", src, resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// loadEquipmentNotes returns the equipment notes in effect at now, or nil if
+// there is no -equipment-health.
+func loadEquipmentNotes(cacheSpace *cache.Space, now time.Time) []equipmentNote {
+	if *equipmentHealth == "" {
+		return nil
+	}
+	const notesFilename = "equipment.csv"
+	load := func(dir string) ([]equipmentNote, error) {
+		b, err := cacheSpace.ReadFile(filepath.Join(dir, notesFilename))
+		if err != nil {
+			return nil, err
+		}
+		return parseEquipmentNotes(strings.NewReader(string(b)))
+	}
+	entry := &cache.Entry[[]equipmentNote]{
+		ID:      fmt.Sprintf("equipment-%x", sha256.Sum256([]byte(*equipmentHealth)))[:len("equipment-")+16],
+		Version: equipmentVersion,
+		MaxAge:  equipmentMaxAge,
+		Source:  *equipmentHealth,
+		Load:    load,
+		Create: func(dir string) ([]equipmentNote, error) {
+			b, err := fetchEquipmentNotes()
+			if err != nil {
+				return nil, err
+			}
+			if err := cacheSpace.WriteFile(filepath.Join(dir, notesFilename), b); err != nil {
+				return nil, err
+			}
+			return load(dir)
+		},
+		Count: func(n []equipmentNote) int { return len(n) },
+	}
+	notes, err := entry.Get(cacheSpace)
+	if err != nil {
+		log.Printf("warning: ignoring equipment notes: %v", err)
+		return nil
+	}
+	var ret []equipmentNote
+	for _, n := range notes {
+		end, err := n.end()
+		if err != nil {
+			log.Printf("warning: ignoring equipment note for %s: %v", n.Room, err)
+			continue
+		}
+		if end.IsZero() || end.After(now) {
+			ret = append(ret, n)
+		}
+	}
+	return ret
+}
+
+// removeBrokenFeatures removes the features noted as broken from resources,
+// replacing the affected resources with copies.
+func removeBrokenFeatures(notes []equipmentNote, resources []*itercal.Resource) {
+	for _, n := range notes {
+		if n.Feature == "" {
+			continue
+		}
+		for i, r := range resources {
+			if !n.matches(r) {
+				continue
+			}
+			var kept []string
+			for _, f := range r.Features {
+				if strings.EqualFold(f, n.Feature) {
+					log.Printf("%s without %s: %s", r.GeneratedResourceName, f, n.Note)
+					continue
+				}
+				kept = append(kept, f)
+			}
+			c := *r
+			c.Features = kept
+			resources[i] = &c
+		}
+	}
+}
+
+// addEquipmentOutages marks rooms noted as unusable as busy in freeBusy
+// between start and the end of each note, or end.
+func addEquipmentOutages(notes []equipmentNote, resources []*itercal.Resource, freeBusy map[string]calendar.FreeBusyCalendar, start, end time.Time) {
+	for _, n := range notes {
+		if n.Feature != "" {
+			continue
+		}
+		until, _ := n.end()
+		if until.IsZero() || until.After(end) {
+			until = end
+		}
+		for _, r := range resources {
+			fb, ok := freeBusy[r.ResourceEmail]
+			if !ok || !n.matches(r) {
+				continue
+			}
+			log.Printf("%s out of service: %s", r.GeneratedResourceName, n.Note)
+			fb.Busy = append(fb.Busy, &calendar.TimePeriod{Start: timeutil.Format(start, zone), End: timeutil.Format(until, zone)})
+			freeBusy[r.ResourceEmail] = fb
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// -inject-failures makes API calls fail, to exercise gocal's handling of
+// errors in integration tests against a fake backend, e.g.
+//
+//	-inject-failures freebusy=0.1,patch=429
+//
+// fails a tenth of freeBusy queries with 503 Service Unavailable, and every
+// patch with 429 Too Many Requests. Each failure names an operation: an HTTP
+// method (get, post, patch, put, delete), a segment of the API path (e.g.
+// freebusy, events, resources) or '*' for any call. Its value is a status
+// code, 'network' for a connection error, a probability of a 503, or a status
+// code and probability such as '500@0.5'.
+//
+// The flag is left out of gocal's usage, and is refused unless
+// -calendar-endpoint, -directory-endpoint and any -maps-endpoint are on the
+// local machine, so that it can't disrupt real calendars. Only calls to the
+// local machine fail, not e.g. those refreshing OAuth tokens with Google.
+
+var injectFailures failureInjection
+
+func init() {
+	flag.Var(&injectFailures, "inject-failures", "make API calls fail for testing, e.g. 'freebusy=0.1,patch=429', with fake API endpoints on the local machine (see failures.go)")
+	hiddenFlags["inject-failures"] = true
+	flag.CommandLine.Usage = usage(flag.CommandLine)
+}
+
+// hiddenFlags are left out of usage messages.
+var hiddenFlags = map[string]bool{}
+
+// usage prints the usage of fs without hiddenFlags.
+func usage(fs *flag.FlagSet) func() {
+	return func() {
+		visible := flag.NewFlagSet(fs.Name(), flag.ContinueOnError)
+		visible.SetOutput(fs.Output())
+		fs.VisitAll(func(f *flag.Flag) {
+			if !hiddenFlags[f.Name] {
+				visible.Var(f.Value, f.Name, f.Usage)
+			}
+		})
+		fmt.Fprintf(fs.Output(), "Usage of %s:\n", fs.Name())
+		visible.PrintDefaults()
+	}
+}
+
+// injectedFailure is how calls to an operation fail.
+type injectedFailure struct {
+	op string
+
+	// code is the status with which calls fail, or 0 for a network error.
+	code int
+
+	// p is the probability that a call fails.
+	p float64
+}
+
+// failureInjection is a flag.Value for -inject-failures.
+type failureInjection []injectedFailure
+
+func (f *failureInjection) String() string {
+	if f == nil {
+		return ""
+	}
+	var parts []string
+	for _, x := range *f {
+		v := "network"
+		if x.code != 0 {
+			v = strconv.Itoa(x.code)
+		}
+		if x.p < 1 {
+			v += "@" + strconv.FormatFloat(x.p, 'g', -1, 64)
+		}
+		parts = append(parts, x.op+"="+v)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (f *failureInjection) Set(s string) error {
+	var ret failureInjection
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		op, v, ok := strings.Cut(part, "=")
+		if !ok || op == "" {
+			return fmt.Errorf("failure '%s' is not of the form operation=failure", part)
+		}
+		x := injectedFailure{op: strings.ToLower(op), code: http.StatusServiceUnavailable, p: 1}
+		code, p, hasP := strings.Cut(v, "@")
+		var err error
+		switch {
+		case hasP:
+			if x.p, err = parseProbability(p); err != nil {
+				return err
+			}
+			x.code, err = parseFailureCode(code)
+		case strings.Contains(v, "."):
+			x.p, err = parseProbability(v)
+		default:
+			x.code, err = parseFailureCode(v)
+		}
+		if err != nil {
+			return fmt.Errorf("failure '%s': %v", part, err)
+		}
+		ret = append(ret, x)
+	}
+	*f = ret
+	return nil
+}
+
+func parseProbability(s string) (float64, error) {
+	p, err := strconv.ParseFloat(s, 64)
+	if err != nil || p <= 0 || p > 1 {
+		return 0, fmt.Errorf("probability '%s' is not in (0, 1]", s)
+	}
+	return p, nil
+}
+
+func parseFailureCode(s string) (int, error) {
+	if s == "network" {
+		return 0, nil
+	}
+	code, err := strconv.Atoi(s)
+	if err != nil || code < 400 || code > 599 {
+		return 0, fmt.Errorf("'%s' is neither 'network' nor an error status", s)
+	}
+	return code, nil
+}
+
+// matches returns true if x applies to req.
+func (x injectedFailure) matches(req *http.Request) bool {
+	if x.op == "*" || x.op == strings.ToLower(req.Method) {
+		return true
+	}
+	for _, seg := range strings.Split(req.URL.Path, "/") {
+		if strings.ToLower(seg) == x.op {
+			return true
+		}
+	}
+	return false
+}
+
+// errInjectedFailure is the network error injected by -inject-failures.
+var errInjectedFailure = errors.New("connection reset (injected by -inject-failures)")
+
+// checkFakeBackend returns an error unless -calendar-endpoint,
+// -directory-endpoint and, if set, -maps-endpoint are on the local machine.
+func checkFakeBackend() error {
+	endpoints := []struct{ flag, url string }{
+		{"calendar-endpoint", *calendarEndpoint},
+		{"directory-endpoint", *directoryEndpoint},
+	}
+	if *mapsEndpoint != "" {
+		endpoints = append(endpoints, struct{ flag, url string }{"maps-endpoint", *mapsEndpoint})
+	}
+	for _, e := range endpoints {
+		u, err := url.Parse(e.url)
+		if e.url == "" || err != nil {
+			return fmt.Errorf("-inject-failures needs a fake -%s", e.flag)
+		}
+		if !isLocalHost(u.Hostname()) {
+			return fmt.Errorf("-inject-failures needs a fake -%s on the local machine, not %s", e.flag, u.Hostname())
+		}
+	}
+	return nil
+}
+
+// isLocalHost returns true if host names the local machine.
+func isLocalHost(host string) bool {
+	ip := net.ParseIP(host)
+	return host == "localhost" || ip != nil && ip.IsLoopback()
+}
+
+// failingTransport fails requests as -inject-failures specifies.
+type failingTransport struct {
+	base     http.RoundTripper
+	failures failureInjection
+
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+func newFailingTransport(base http.RoundTripper, failures failureInjection) *failingTransport {
+	return &failingTransport{base: base, failures: failures, rng: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+func (t *failingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !isLocalHost(req.URL.Hostname()) {
+		return t.base.RoundTrip(req)
+	}
+	for _, x := range t.failures {
+		if !x.matches(req) {
+			continue
+		}
+		t.mu.Lock()
+		fail := x.p >= 1 || t.rng.Float64() < x.p
+		t.mu.Unlock()
+		if !fail {
+			continue
+		}
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		if x.code == 0 {
+			return nil, errInjectedFailure
+		}
+		// The error format of Google APIs, which clients parse.
+		body := fmt.Sprintf(`{"error": {"code": %d, "message": "%s (injected by -inject-failures)"}}`, x.code, http.StatusText(x.code))
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", x.code, http.StatusText(x.code)),
+			StatusCode:    x.code,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        http.Header{"Content-Type": {"application/json"}},
+			Body:          ioutil.NopCloser(strings.NewReader(body)),
+			ContentLength: int64(len(body)),
+			Request:       req,
+		}, nil
+	}
+	return t.base.RoundTrip(req)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/vsekhar/gocal/internal/itercal"
+	"github.com/vsekhar/gocal/internal/rank"
+	"google.golang.org/api/calendar/v3"
+)
+
+// -features limits the rooms gocal books to those with all of the given
+// features, e.g. 'vc,jamboard,wheelchair', before ranking them by distance.
+// Features are matched by their names in the Directory, a word of them or
+// their initials (see rank.HasFeature), so 'vc' matches "Video conference".
+// Events can require further features with a room tag followed by a list,
+// e.g. '#room[vc,whiteboard]', in their summary or description.
+
+// hasFeatures returns true if r has all of the features in want.
+func hasFeatures(r *itercal.Resource, want []string) bool {
+	for _, f := range want {
+		if !rank.HasFeature(r.Features, f) {
+			return false
+		}
+	}
+	return true
+}
+
+// taggedFeatures returns the features listed in room tags such as
+// '#room[vc,whiteboard]' on event.
+func taggedFeatures(event *calendar.Event) []string {
+	var ret []string
+	for _, s := range []string{event.Summary, event.Description} {
+		for _, w := range strings.Fields(s) {
+			for _, tag := range roomTags() {
+				if !strings.HasPrefix(w, tag+"[") {
+					continue
+				}
+				list, _, ok := strings.Cut(strings.TrimPrefix(w, tag+"["), "]")
+				if ok {
+					ret = append(ret, splitList(list)...)
+				}
+				break
+			}
+		}
+	}
+	return ret
+}
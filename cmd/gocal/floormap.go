@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/vsekhar/gocal/internal/interval"
+	"github.com/vsekhar/gocal/internal/itercal"
+	"github.com/vsekhar/gocal/internal/rank"
+	"github.com/vsekhar/gocal/internal/timeutil"
+	"google.golang.org/api/calendar/v3"
+)
+
+var mapDay *string
+var mapFormat *string
+var floorplanFile *string
+
+func floorMapFlags(fs *flag.FlagSet) {
+	mapDay = fs.String("day", "today", "day to map: 'today', a weekday such as 'tue', or a date such as '2022-03-15'")
+	mapFormat = fs.String("format", "ascii", "output format, 'ascii' or 'dot' (Graphviz, e.g. render with 'neato -Tsvg')")
+	floorplanFile = fs.String("floorplan", "", "floorplan file positioning locations (default: floors as rows and sections as columns)")
+}
+
+// A floorplan positions locations in a building for drawing. Floorplan files
+// are JSON objects mapping "floor/section" to positions, e.g.:
+//
+//	{
+//	  "1/1": {"x": 0, "y": 4},
+//	  "1/2": {"x": 3, "y": 4}
+//	}
+//
+// Larger y is further down.
+type floorplan map[rank.Location]position
+
+type position struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+func loadFloorplan(path string) (floorplan, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]position
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, err
+	}
+	fp := make(floorplan)
+	for k, p := range raw {
+		l, err := rank.ParseLocation(k)
+		if err != nil {
+			return nil, err
+		}
+		fp[l] = p
+	}
+	return fp, nil
+}
+
+// position returns the position of l, placing locations missing from the
+// floorplan with floors as rows, from the top floor down, and sections as
+// columns.
+func (fp floorplan) position(l rank.Location, topFloor int) position {
+	if p, ok := fp[l]; ok {
+		return p
+	}
+	return position{X: l.Section, Y: topFloor - l.Floor}
+}
+
+// parseDay returns the number of days from now until the day described by s,
+// in the location of now.
+func parseDay(s string, now time.Time) (int, error) {
+	s = strings.ToLower(s)
+	if s == "today" || s == "" {
+		return 0, nil
+	}
+	if s == "tomorrow" {
+		return 1, nil
+	}
+	for d := 0; d < 7; d++ {
+		day := strings.ToLower(now.AddDate(0, 0, d).Weekday().String())
+		if len(s) >= 2 && strings.HasPrefix(day, s) {
+			return d, nil
+		}
+	}
+	t, err := timeutil.ParseDate(s, now.Location())
+	if err != nil {
+		return 0, fmt.Errorf("unknown day '%s'", s)
+	}
+	today, _ := timeutil.ParseDate(timeutil.Date(now, now.Location()), now.Location())
+	n := int(t.Sub(today).Hours()+12) / 24
+	if n < 0 {
+		return 0, fmt.Errorf("%s is in the past", s)
+	}
+	return n, nil
+}
+
+// floorMap plans rooms as book does, without making changes, and draws the
+// rooms of one day's meetings and the path between them.
+func floorMap(ctx context.Context) {
+	if *mapFormat != "ascii" && *mapFormat != "dot" {
+		log.Fatalf("unknown format '%s'", *mapFormat)
+	}
+	fp := make(floorplan)
+	if *floorplanFile != "" {
+		var err error
+		if fp, err = loadFloorplan(*floorplanFile); err != nil {
+			log.Fatalf("loading floorplan: %v", err)
+		}
+	}
+	n, err := parseDay(*mapDay, time.Now())
+	if err != nil {
+		log.Fatal(err)
+	}
+	*lookAhead = time.Duration(n+1) * 24 * time.Hour
+	*dryRun = true
+	afterPlan = func(events []*calendar.Event, _ []string, rooms []*itercal.Resource, _ []bool) {
+		date := timeutil.Date(time.Now().In(zone).AddDate(0, 0, n), zone)
+		var stops []stop
+		for i, e := range events {
+			if rooms[i] == nil {
+				continue
+			}
+			span := interval.OrDie(e.Start.DateTime, e.End.DateTime)
+			l, ok := roomLocation(rooms[i])
+			if timeutil.Date(span.Start, zone) != date || !ok {
+				continue
+			}
+			stops = append(stops, stop{event: e, room: rooms[i], location: l})
+		}
+		switch *mapFormat {
+		case "ascii":
+			drawASCII(os.Stdout, fp, stops)
+		case "dot":
+			drawDot(os.Stdout, fp, stops)
+		}
+	}
+	book(ctx)
+}
+
+// A stop is a meeting on the day's path.
+type stop struct {
+	event    *calendar.Event
+	room     *itercal.Resource
+	location rank.Location
+}
+
+func topFloor(stops []stop) int {
+	top := 0
+	for _, s := range stops {
+		if s.location.Floor > top {
+			top = s.location.Floor
+		}
+	}
+	return top
+}
+
+// drawASCII draws a grid of the locations of stops, each labelled with the
+// numbers of the meetings held there, followed by a legend.
+func drawASCII(w io.Writer, fp floorplan, stops []stop) {
+	if len(stops) == 0 {
+		fmt.Fprintln(w, "No meetings with rooms")
+		return
+	}
+	top := topFloor(stops)
+	labels := make(map[position][]string)
+	minX, maxX, minY, maxY := 1<<30, -1<<30, 1<<30, -1<<30
+	for i, s := range stops {
+		p := fp.position(s.location, top)
+		labels[p] = append(labels[p], fmt.Sprint(i+1))
+		if p.X < minX {
+			minX = p.X
+		}
+		if p.X > maxX {
+			maxX = p.X
+		}
+		if p.Y < minY {
+			minY = p.Y
+		}
+		if p.Y > maxY {
+			maxY = p.Y
+		}
+	}
+	const cell = 8
+	for y := minY; y <= maxY; y++ {
+		var b strings.Builder
+		for x := minX; x <= maxX; x++ {
+			label := "."
+			if l, ok := labels[position{x, y}]; ok {
+				label = strings.Join(l, ",")
+			}
+			fmt.Fprintf(&b, "%-*s", cell, label)
+		}
+		fmt.Fprintln(w, strings.TrimRight(b.String(), " "))
+	}
+	fmt.Fprintln(w)
+	for i, s := range stops {
+		start, err := timeutil.Parse(s.event.Start.DateTime, zone)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Fprintf(w, "%d. %s %s, floor %d section %d: %s\n", i+1, start.Format("15:04"),
+			s.room.GeneratedResourceName, s.location.Floor, s.location.Section, s.event.Summary)
+	}
+}
+
+// drawDot writes a Graphviz graph of the rooms of stops, positioned by fp,
+// with edges along the path between them.
+func drawDot(w io.Writer, fp floorplan, stops []stop) {
+	top := topFloor(stops)
+	fmt.Fprintln(w, "digraph day {")
+	fmt.Fprintln(w, "  node [shape=box];")
+	seen := make(map[string]bool)
+	var emails []string
+	rooms := make(map[string]stop)
+	for _, s := range stops {
+		if !seen[s.room.ResourceEmail] {
+			seen[s.room.ResourceEmail] = true
+			emails = append(emails, s.room.ResourceEmail)
+			rooms[s.room.ResourceEmail] = s
+		}
+	}
+	sort.Strings(emails)
+	for _, e := range emails {
+		s := rooms[e]
+		p := fp.position(s.location, top)
+		// Graphviz's y axis points up.
+		fmt.Fprintf(w, "  %q [label=%q, pos=\"%d,%d!\"];\n", e, s.room.GeneratedResourceName, p.X*2, -p.Y*2)
+	}
+	for i := 1; i < len(stops); i++ {
+		fmt.Fprintf(w, "  %q -> %q [label=\"%d\"];\n", stops[i-1].room.ResourceEmail, stops[i].room.ResourceEmail, i)
+	}
+	fmt.Fprintln(w, "}")
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"strconv"
+)
+
+// floorOrder maps the names of a building's floors to levels, which increase
+// going up. Levels are numbered so that the lowest numbered floor keeps its
+// number, e.g. "LL", "G", "1", "2" are levels -1, 0, 1 and 2, so that
+// -floor matches the floor numbers of most buildings.
+type floorOrder map[string]int
+
+// floorLevels is the order of the floors of the building in which rooms are
+// booked.
+var floorLevels floorOrder
+
+// newFloorOrder returns the order of floors with the given names, listed from
+// lowest to highest as in the Directory API's Building.FloorNames.
+func newFloorOrder(names []string) floorOrder {
+	offset := 0
+	for i, n := range names {
+		if f, err := strconv.Atoi(n); err == nil {
+			offset = f - i
+			break
+		}
+	}
+	ret := make(floorOrder, len(names))
+	for i, n := range names {
+		ret[n] = i + offset
+	}
+	return ret
+}
+
+// level returns the level of the floor with the given name, or false if it
+// is neither a known floor name nor a number.
+func (o floorOrder) level(name string) (int, bool) {
+	if l, ok := o[name]; ok {
+		return l, true
+	}
+	l, err := strconv.Atoi(name)
+	return l, err == nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/vsekhar/gocal/internal/interval"
+	"github.com/vsekhar/gocal/internal/itercal"
+	"google.golang.org/api/calendar/v3"
+)
+
+// Other room-booking tools, e.g. a workplace app or a room panel, often book
+// rooms in holds of their own: events with the room and the user, created by
+// the tool's account, sometimes copying the meeting's summary and tags. gocal
+// leaves such foreign holds alone rather than booking rooms for them, and
+// counts the room held when a meeting starts as the meeting's room, so that
+// it isn't booked twice. Holds are recognized as foreign if they have a room
+// and were created or organized by a service account or a room's calendar, or
+// match one of -foreign-holds:
+//
+//	roombot@example.com       created or organized by this account
+//	@robin.example.com        created or organized by an account in this domain
+//	property:robinEventId     having this private or shared extended property
+//	property:source=panel     having the property with this value
+//	summary:[Reserved]        with this text in the summary (ignoring case)
+
+// foreignMarker is one of -foreign-holds.
+type foreignMarker struct {
+	// kind is "email", "property" or "summary".
+	kind       string
+	key, value string
+}
+
+// parseForeignMarkers parses the markers of -foreign-holds.
+func parseForeignMarkers(s string) ([]foreignMarker, error) {
+	var ret []foreignMarker
+	for _, m := range splitList(s) {
+		kind, v, _ := strings.Cut(m, ":")
+		switch {
+		case kind == "property" && v != "":
+			key, value, _ := strings.Cut(v, "=")
+			ret = append(ret, foreignMarker{kind: kind, key: key, value: value})
+		case kind == "summary" && v != "":
+			ret = append(ret, foreignMarker{kind: kind, value: strings.ToLower(v)})
+		case strings.Contains(m, "@") && !strings.Contains(m, ":"):
+			ret = append(ret, foreignMarker{kind: "email", value: strings.ToLower(m)})
+		default:
+			return nil, fmt.Errorf("-foreign-holds marker '%s' is neither an email, '@domain', 'property:key[=value]' nor 'summary:text'", m)
+		}
+	}
+	return ret, nil
+}
+
+// matches returns true if e bears marker m.
+func (m foreignMarker) matches(e *calendar.Event) bool {
+	switch m.kind {
+	case "email":
+		for _, email := range creators(e) {
+			email = strings.ToLower(email)
+			if email == m.value || (strings.HasPrefix(m.value, "@") && strings.HasSuffix(email, m.value)) {
+				return true
+			}
+		}
+	case "property":
+		if e.ExtendedProperties == nil {
+			return false
+		}
+		for _, props := range []map[string]string{e.ExtendedProperties.Private, e.ExtendedProperties.Shared} {
+			if v, ok := props[m.key]; ok && (m.value == "" || v == m.value) {
+				return true
+			}
+		}
+	case "summary":
+		return strings.Contains(strings.ToLower(e.Summary), m.value)
+	}
+	return false
+}
+
+// creators returns the emails of the creator and organizer of e.
+func creators(e *calendar.Event) []string {
+	var ret []string
+	if e.Creator != nil {
+		ret = append(ret, e.Creator.Email)
+	}
+	if e.Organizer != nil {
+		ret = append(ret, e.Organizer.Email)
+	}
+	return ret
+}
+
+// isBot returns true if email is that of a service account or a room's
+// calendar, which create events on behalf of booking tools.
+func isBot(email string) bool {
+	email = strings.ToLower(email)
+	return strings.HasSuffix(email, ".gserviceaccount.com") || strings.HasSuffix(email, "@resource.calendar.google.com")
+}
+
+// isForeignHold returns true if e is a room hold made by another booking tool.
+func isForeignHold(e *calendar.Event) bool {
+	if isHold(e) {
+		return false
+	}
+	hasRoom := false
+	for _, a := range e.Attendees {
+		if a.Resource && a.ResponseStatus != "declined" {
+			hasRoom = true
+		}
+	}
+	if !hasRoom {
+		return false
+	}
+	for _, email := range creators(e) {
+		if isBot(email) {
+			return true
+		}
+	}
+	// Checked in main.
+	markers, _ := parseForeignMarkers(*foreignHolds)
+	for _, m := range markers {
+		if m.matches(e) {
+			return true
+		}
+	}
+	return false
+}
+
+// withForeignHolds fills in the rooms of events that have none with the rooms
+// of holds, which are foreign, held when the events start. rooms holds the
+// room booked for each event. Resources must be sorted by email.
+func withForeignHolds(events []*calendar.Event, rooms []*itercal.Resource, holds []*calendar.Event, resources []*itercal.Resource) {
+	for i, e := range events {
+		if rooms[i] != nil {
+			continue
+		}
+		start := interval.OrDie(e.Start.DateTime, e.End.DateTime).Start
+		for _, h := range holds {
+			if h.Start.DateTime == "" {
+				continue
+			}
+			held := interval.OrDie(h.Start.DateTime, h.End.DateTime)
+			if start.Before(held.Start) || !start.Before(held.End) {
+				continue
+			}
+			for _, a := range h.Attendees {
+				if !a.Resource || a.ResponseStatus != "accepted" {
+					continue
+				}
+				if r := roomResource(a.Email, resources); r != nil {
+					log.Printf("Counting %s, held by another booking tool, as the room for %s", r.GeneratedResourceName, e.Summary)
+					rooms[i] = r
+				}
+			}
+			if rooms[i] != nil {
+				break
+			}
+		}
+	}
+}
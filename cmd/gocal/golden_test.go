@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/vsekhar/gocal/internal/itercal"
+	"github.com/vsekhar/gocal/internal/rank"
+	"google.golang.org/api/calendar/v3"
+)
+
+var update = flag.Bool("update", false, "update golden files in testdata")
+
+// checkGolden compares got with the contents of testdata/name, or replaces
+// them with got if -update is set.
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	p := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(p, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(p)
+	if err != nil {
+		t.Fatalf("%v (run with -update to create)", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("output differs from %s (run with -update if intended):\ngot:\n%s\nwant:\n%s", p, got, want)
+	}
+}
+
+func TestHeatmapGolden(t *testing.T) {
+	oldZone := zone
+	zone = time.UTC
+	t.Cleanup(func() { zone = oldZone })
+
+	start := time.Date(2022, 3, 14, 9, 0, 0, 0, time.UTC)
+	report := heatmapReport{
+		Building: "tst-1",
+		Hours:    []time.Time{start, start.Add(time.Hour), start.Add(2 * time.Hour)},
+		Rooms: []roomOccupancy{
+			{Name: "Room A", Email: "room-a@resource.example.com", Occupancy: []float64{1, 0.5, 0}},
+			{Name: "Room B, the big one", Email: "room-b@resource.example.com", Occupancy: []float64{0, 0.25, 1}},
+		},
+	}
+	for _, format := range []string{"csv", "json"} {
+		t.Run(format, func(t *testing.T) {
+			var b bytes.Buffer
+			if err := writeHeatmap(&b, report, format); err != nil {
+				t.Fatal(err)
+			}
+			checkGolden(t, "heatmap."+format, b.Bytes())
+		})
+	}
+}
+
+func TestScheduleGolden(t *testing.T) {
+	oldZone := zone
+	zone = time.UTC
+	t.Cleanup(func() { zone = oldZone })
+
+	at := func(s string) *calendar.EventDateTime { return &calendar.EventDateTime{DateTime: s} }
+	roomA := &itercal.Resource{ResourceEmail: "a", GeneratedResourceName: "Room A", FloorName: "1", FloorSection: "1"}
+	roomB := &itercal.Resource{ResourceEmail: "b", GeneratedResourceName: "Room B", FloorName: "5", FloorSection: "2"}
+	training := &itercal.Resource{ResourceEmail: "t", GeneratedResourceName: "Training room", FloorName: "G"}
+	events := []*calendar.Event{
+		{Summary: "Standup", Start: at("2022-03-14T09:00:00Z"), End: at("2022-03-14T09:30:00Z")},
+		{Summary: "Design review", Start: at("2022-03-14T09:30:00Z"), End: at("2022-03-14T10:30:00Z")},
+		{Summary: "Interview", Start: at("2022-03-14T10:00:00Z"), End: at("2022-03-14T11:00:00Z")},
+		{Summary: "1:1", Start: at("2022-03-14T13:00:00Z"), End: at("2022-03-14T13:30:00Z")},
+		{Summary: "Training", Start: at("2022-03-15T09:00:00Z"), End: at("2022-03-15T12:00:00Z")},
+	}
+	rooms := []*itercal.Resource{roomA, roomB, nil, roomA, training}
+	proposed := []bool{false, true, false, true, false}
+	var b bytes.Buffer
+	printSchedule(&b, events, rooms, proposed)
+	checkGolden(t, "schedule.txt", b.Bytes())
+}
+
+func TestFloorMapGolden(t *testing.T) {
+	oldZone := zone
+	zone = time.UTC
+	t.Cleanup(func() { zone = oldZone })
+
+	at := func(s string) *calendar.EventDateTime { return &calendar.EventDateTime{DateTime: s} }
+	room := func(name string) *itercal.Resource {
+		return &itercal.Resource{ResourceEmail: name + "@resource.example.com", GeneratedResourceName: name}
+	}
+	stops := []stop{
+		{&calendar.Event{Summary: "Standup", Start: at("2022-03-14T09:00:00Z")}, room("Room A"), rank.Location{Floor: 1, Section: 1}},
+		{&calendar.Event{Summary: "Review", Start: at("2022-03-14T10:00:00Z")}, room("Room B"), rank.Location{Floor: 3, Section: 2}},
+		{&calendar.Event{Summary: "1:1", Start: at("2022-03-14T13:00:00Z")}, room("Room A"), rank.Location{Floor: 1, Section: 1}},
+	}
+	fp := floorplan{{Floor: 3, Section: 2}: {X: 4, Y: 0}}
+	var b bytes.Buffer
+	drawASCII(&b, fp, stops)
+	checkGolden(t, "floormap.txt", b.Bytes())
+	b.Reset()
+	drawDot(&b, fp, stops)
+	checkGolden(t, "floormap.dot", b.Bytes())
+}
+
+func TestReportGolden(t *testing.T) {
+	fake := setupFake(t)
+	out, err := os.Create(filepath.Join(t.TempDir(), "report.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	setFlag(t, "dryrun", "true")
+	setFlag(t, "output", "json")
+	oldStdout := os.Stdout
+	os.Stdout = out
+	t.Cleanup(func() { os.Stdout = oldStdout })
+
+	start := hoursAhead(2)
+	for i, e := range []struct{ summary, room string }{
+		{"Booked", "room-b@resource.example.com"},
+		{"Tagged #room:room-a@resource.example.com", ""},
+		{"Free", ""},
+	} {
+		attendees := []*calendar.EventAttendee{
+			{Email: testUser, ResponseStatus: "accepted"},
+			{Email: "other@example.com", ResponseStatus: "accepted"},
+		}
+		if e.room != "" {
+			attendees = append(attendees, &calendar.EventAttendee{Email: e.room, Resource: true, ResponseStatus: "accepted"})
+		}
+		s := start.Add(time.Duration(i) * time.Hour)
+		fake.AddEvent(testUser, &calendar.Event{Summary: e.summary, Start: at(s), End: at(s.Add(30 * time.Minute)), Attendees: attendees})
+	}
+
+	book(context.Background())
+
+	b, err := os.ReadFile(out.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var r planReport
+	if err := json.Unmarshal(b, &r); err != nil {
+		t.Fatalf("report %s: %v", b, err)
+	}
+	// Pin the times, which follow the clock, to those of a fixed day.
+	base := time.Date(2022, 3, 14, 9, 0, 0, 0, time.UTC)
+	r.Created = base.Add(-time.Hour)
+	pin := func(s string) string {
+		tm, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			t.Fatalf("event time %q: %v", s, err)
+		}
+		return base.Add(tm.Sub(start)).Format(time.RFC3339)
+	}
+	for i := range r.Events {
+		r.Events[i].Start, r.Events[i].End = pin(r.Events[i].Start), pin(r.Events[i].End)
+	}
+	var got bytes.Buffer
+	if err := r.write(&got); err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "report.json", got.Bytes())
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/vsekhar/gocal/internal/itercal"
+	"google.golang.org/api/calendar/v3"
+)
+
+// domain returns the domain of an email address.
+func domain(email string) string {
+	if i := strings.LastIndex(email, "@"); i >= 0 {
+		return strings.ToLower(email[i+1:])
+	}
+	return ""
+}
+
+// hasExternalGuests returns true if e has attendees whose domain differs from
+// that of the user.
+func hasExternalGuests(e *calendar.Event) bool {
+	own := ""
+	if e.Organizer != nil && e.Organizer.Self {
+		own = domain(e.Organizer.Email)
+	}
+	for _, a := range e.Attendees {
+		if a.Self {
+			own = domain(a.Email)
+		}
+	}
+	if own == "" {
+		return false
+	}
+	for _, a := range e.Attendees {
+		if a.Resource || a.ResponseStatus == "declined" {
+			continue
+		}
+		if d := domain(a.Email); d != "" && d != own {
+			return true
+		}
+	}
+	return false
+}
+
+// guestAccessible returns a function reporting whether a room is accessible to
+// guests, going by -guest-rooms and -guest-feature.
+func guestAccessible() func(r *itercal.Resource) bool {
+	var re *regexp.Regexp
+	if *guestRooms != "" {
+		var err error
+		if re, err = regexp.Compile(*guestRooms); err != nil {
+			log.Fatalf("parsing -guest-rooms: %v", err)
+		}
+	}
+	return func(r *itercal.Resource) bool {
+		if re != nil && (re.MatchString(r.GeneratedResourceName) || re.MatchString(r.ResourceName)) {
+			return true
+		}
+		if *guestFeature != "" {
+			for _, f := range r.Features {
+				if strings.EqualFold(f, *guestFeature) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+}
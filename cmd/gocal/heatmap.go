@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/vsekhar/gocal/internal/interval"
+	"github.com/vsekhar/gocal/internal/timeutil"
+)
+
+var heatmapWeek *bool
+var heatmapFormat *string
+
+func heatmapFlags(fs *flag.FlagSet) {
+	heatmapWeek = fs.Bool("week", false, "cover the next week (overrides -next)")
+	heatmapFormat = fs.String("format", "csv", "output format, 'csv' or 'json'")
+}
+
+type roomOccupancy struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+
+	// Occupancy is the fraction of each hour for which the room is busy.
+	Occupancy []float64 `json:"occupancy"`
+}
+
+type heatmapReport struct {
+	Building string          `json:"building"`
+	Hours    []time.Time     `json:"hours"`
+	Rooms    []roomOccupancy `json:"rooms"`
+}
+
+// heatmap writes per-room, per-hour occupancy of the rooms in a building to
+// stdout.
+func heatmap(ctx context.Context) {
+	if *heatmapFormat != "csv" && *heatmapFormat != "json" {
+		log.Fatalf("unknown format '%s'", *heatmapFormat)
+	}
+	period := *lookAhead
+	if *heatmapWeek {
+		period = 7 * 24 * time.Hour
+	}
+
+	dirSrv, calSrv := newServices(ctx)
+	cacheSpace := openCache()
+	inferLocation(ctx, dirSrv, calSrv)
+	if err := resolveBuilding(ctx, cacheSpace, dirSrv); err != nil {
+		log.Fatal(err)
+	}
+	building, err := loadBuilding(ctx, cacheSpace, dirSrv)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if zone, err = buildingZone(ctx, building); err != nil {
+		log.Fatal(err)
+	}
+	startTime := time.Now().In(zone).Truncate(time.Hour)
+	endTime := timeutil.Add(startTime, period, zone)
+	resources, err := loadResources(ctx, cacheSpace, dirSrv)
+	if err != nil {
+		log.Fatalf("loading resources for building %s: %v", *buildingId, err)
+	}
+	resources = resources.ConferenceRooms()
+	ids := make([]string, len(resources))
+	for i, r := range resources {
+		ids[i] = r.ResourceEmail
+	}
+	freeBusy, err := roomFreeBusy(ctx, calSrv, ids, startTime, endTime)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	report := heatmapReport{Building: *buildingId}
+	for h := startTime; h.Before(endTime); h = h.Add(time.Hour) {
+		report.Hours = append(report.Hours, h)
+	}
+	for _, r := range resources {
+		fb, ok := freeBusy[r.ResourceEmail]
+		if !ok {
+			continue
+		}
+		ro := roomOccupancy{
+			Name:      r.GeneratedResourceName,
+			Email:     r.ResourceEmail,
+			Occupancy: make([]float64, len(report.Hours)),
+		}
+		for _, tp := range fb.Busy {
+			busy := interval.OrDie(tp.Start, tp.End)
+			for i, h := range report.Hours {
+				hour := interval.Interval{Start: h, End: h.Add(time.Hour)}
+				ro.Occupancy[i] += float64(hour.Overlap(busy)) / float64(time.Hour)
+			}
+		}
+		report.Rooms = append(report.Rooms, ro)
+	}
+
+	if err := writeHeatmap(os.Stdout, report, *heatmapFormat); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// writeHeatmap writes report to out in format, "csv" or "json". Times are
+// formatted in zone.
+func writeHeatmap(out io.Writer, report heatmapReport, format string) error {
+	switch format {
+	case "csv":
+		w := csv.NewWriter(out)
+		header := []string{"room", "email"}
+		for _, h := range report.Hours {
+			header = append(header, timeutil.Format(h, zone))
+		}
+		w.Write(header)
+		for _, ro := range report.Rooms {
+			row := []string{ro.Name, ro.Email}
+			for _, o := range ro.Occupancy {
+				row = append(row, fmt.Sprintf("%.2f", o))
+			}
+			w.Write(row)
+		}
+		w.Flush()
+		return w.Error()
+	case "json":
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+	return fmt.Errorf("unknown format '%s'", format)
+}
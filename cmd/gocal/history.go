@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/vsekhar/gocal/internal/itercal"
+	"github.com/vsekhar/gocal/internal/rank"
+	"google.golang.org/api/calendar/v3"
+)
+
+// historyPeriod is how far back to look for rooms the user has met in.
+const historyPeriod = 30 * 24 * time.Hour
+
+// history summarizes the rooms the user met in during the historyPeriod.
+type history struct {
+	// seriesRooms maps recurring event IDs to the email of the room most
+	// recently booked for an instance of the series.
+	seriesRooms map[string]string
+
+	// locations counts meetings by the floor and section of their room.
+	locations map[rank.Location]int
+}
+
+// newHistory returns an empty history.
+func newHistory() *history {
+	return &history{
+		seriesRooms: make(map[string]string),
+		locations:   make(map[rank.Location]int),
+	}
+}
+
+// loadHistory reads the user's meetings during the historyPeriod before now.
+// Resources must be sorted by email.
+func loadHistory(ctx context.Context, calSrv *calendar.Service, resources []*itercal.Resource, now time.Time) *history {
+	h := newHistory()
+	err := itercal.ForEachEvent(ctx, calSrv, *calendarId, now.Add(-historyPeriod), now, zone, func(e *calendar.Event) error {
+		if e.Status == "cancelled" {
+			return nil
+		}
+		for _, a := range e.Attendees {
+			if a.Self && a.ResponseStatus != "accepted" {
+				return nil
+			}
+		}
+		r := bookedRoom(e, resources)
+		if r == nil {
+			return nil
+		}
+		h.add(e, r)
+		return nil
+	})
+	if err != nil {
+		log.Printf("reading event history: %v", err)
+	}
+	return h
+}
+
+// add records that e was held in room r. Events must be added in order of
+// start time.
+func (h *history) add(e *calendar.Event, r *itercal.Resource) {
+	if e.RecurringEventId != "" {
+		h.seriesRooms[e.RecurringEventId] = r.ResourceEmail
+	}
+	if l, ok := roomLocation(r); ok {
+		h.locations[l]++
+	}
+}
+
+// inferPreference fills in -floor and -section, if they were not provided, with
+// the floor and section of the rooms the user most often met in.
+func (h *history) inferPreference() {
+	if *floor != 0 && *section != 0 {
+		return
+	}
+	var best rank.Location
+	for l, n := range h.locations {
+		if n > h.locations[best] || (n == h.locations[best] && (l.Floor < best.Floor || (l.Floor == best.Floor && l.Section < best.Section))) {
+			best = l
+		}
+	}
+	if h.locations[best] == 0 {
+		return
+	}
+	if *floor == 0 {
+		*floor = best.Floor
+		log.Printf("Inferred floor %d from past meetings", *floor)
+	}
+	if *section == 0 {
+		*section = best.Section
+		log.Printf("Inferred section %d from past meetings", *section)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/vsekhar/gocal/internal/itercal"
+)
+
+var stdin = bufio.NewScanner(os.Stdin)
+
+// prompt asks the user for a value, returning def if they provide none.
+func prompt(question, def string) string {
+	if def != "" {
+		fmt.Printf("%s [%s]: ", msg(question), def)
+	} else {
+		fmt.Printf("%s: ", msg(question))
+	}
+	if !stdin.Scan() {
+		if err := stdin.Err(); err != nil {
+			log.Fatal(err)
+		}
+		log.Fatal("unexpected end of input")
+	}
+	if ans := strings.TrimSpace(stdin.Text()); ans != "" {
+		return ans
+	}
+	return def
+}
+
+func promptInt(question string, def int) int {
+	for {
+		ans := prompt(question, strconv.Itoa(def))
+		if i, err := strconv.Atoi(ans); err == nil {
+			return i
+		}
+		fmt.Println(msg("'%s' is not a number", ans))
+	}
+}
+
+// onboard interactively sets up credentials and preferences and writes them to
+// the config file.
+func onboard(ctx context.Context) {
+	c, err := loadConfig(*configFile)
+	if err != nil {
+		log.Fatalf("loading config %s: %v", *configFile, err)
+	}
+
+	// OAuth
+	for {
+		*credentialFile = prompt("OAuth client credentials file", *credentialFile)
+		if _, err := os.Stat(*credentialFile); err == nil {
+			break
+		} else if !errors.Is(err, os.ErrNotExist) {
+			log.Fatal(err)
+		}
+		fmt.Println(msg("%s not found. Create an OAuth client ID of type 'Desktop app' in the\n"+
+			"Google Cloud Console (APIs & Services > Credentials), download it as JSON,\n"+
+			"and provide its path.", *credentialFile))
+	}
+	*tokenFile = prompt("File in which to store the OAuth token", *tokenFile)
+	abs := func(p string) string {
+		if a, err := filepath.Abs(p); err == nil {
+			return a
+		}
+		return p
+	}
+	c["credentials"] = abs(*credentialFile)
+	c["token"] = abs(*tokenFile)
+	dirSrv, _ := newServices(ctx)
+
+	// Building
+	cacheSpace := openCache()
+	buildingIndex, err := itercal.Buildings(ctx, cacheSpace, dirSrv, *customer)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer buildingIndex.Close()
+	for {
+		q := prompt("Search for your building", *buildingId)
+		matches, err := itercal.BuildingCandidates(buildingIndex, q, 10)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(matches) == 0 {
+			fmt.Println(msg("No buildings found matching '%s'", q))
+			continue
+		}
+		for i, m := range matches {
+			fmt.Printf("  %d: %s\n", i+1, m)
+		}
+		choice := promptInt("Choose a building (0 to search again)", 1)
+		if choice < 1 || choice > len(matches) {
+			continue
+		}
+		*buildingId = matches[choice-1].ID
+		break
+	}
+	c["building"] = *buildingId
+
+	// Location within building
+	c["floor"] = promptInt("Preferred floor", *floor)
+	c["section"] = promptInt("Preferred section", *section)
+
+	if err := saveConfig(*configFile, c); err != nil {
+		log.Fatalf("saving config: %v", err)
+	}
+	fmt.Println(msg("Saved config to %s", *configFile))
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/vsekhar/gocal/internal/itercal"
+	directory "google.golang.org/api/admin/directory/v1"
+	"google.golang.org/api/calendar/v3"
+)
+
+// Organizations without Workspace resource management can list their rooms in
+// a -room-inventory file instead of the Directory, giving each room a shared
+// Google calendar, e.g.:
+//
+//	{
+//	  "building": {"id": "hq", "name": "HQ", "floorNames": ["1", "2"], "timeZone": "America/Toronto"},
+//	  "rooms": [
+//	    {"calendar": "c_123@group.calendar.google.com", "name": "Phoenix", "floor": "2", "section": "1", "capacity": 6, "features": ["VC"]}
+//	  ]
+//	}
+//
+// Rooms' availability is read from their calendars, which the user must be
+// able to edit. Since such calendars can't accept invitations, a room is
+// booked by creating an event for the meeting in its calendar and noting the
+// room on the meeting itself (see provider.go).
+
+// roomInventory is the format of -room-inventory files.
+type roomInventory struct {
+	Building struct {
+		ID         string   `json:"id"`
+		Name       string   `json:"name"`
+		FloorNames []string `json:"floorNames"`
+		TimeZone   string   `json:"timeZone"`
+	} `json:"building"`
+	Rooms []struct {
+		Calendar string   `json:"calendar"`
+		Name     string   `json:"name"`
+		Floor    string   `json:"floor"`
+		Section  string   `json:"section"`
+		Capacity int64    `json:"capacity"`
+		Features []string `json:"features"`
+	} `json:"rooms"`
+}
+
+// inventoryProvider provides the rooms in a -room-inventory.
+type inventoryProvider struct {
+	inv *roomInventory
+}
+
+// loadInventory loads the -room-inventory and makes its building -building.
+func loadInventory() *inventoryProvider {
+	b, err := os.ReadFile(*roomInventoryFile)
+	if err != nil {
+		log.Fatalf("reading room inventory: %v", err)
+	}
+	inv := new(roomInventory)
+	if err := json.Unmarshal(b, inv); err != nil {
+		log.Fatalf("parsing room inventory %s: %v", *roomInventoryFile, err)
+	}
+	if inv.Building.ID == "" {
+		log.Fatalf("room inventory %s has no building ID", *roomInventoryFile)
+	}
+	for _, r := range inv.Rooms {
+		if r.Calendar == "" || r.Name == "" {
+			log.Fatalf("room inventory %s lists a room without a calendar or name", *roomInventoryFile)
+		}
+	}
+	*buildingId = inv.Building.ID
+	return &inventoryProvider{inv}
+}
+
+func (p *inventoryProvider) building(context.Context) *directory.Building {
+	return &directory.Building{
+		BuildingId:   p.inv.Building.ID,
+		BuildingName: p.inv.Building.Name,
+		FloorNames:   p.inv.Building.FloorNames,
+	}
+}
+
+func (p *inventoryProvider) zone(context.Context) *time.Location {
+	if p.inv.Building.TimeZone == "" {
+		log.Printf("building %s has no time zone, using local time zone", *buildingId)
+		return time.Local
+	}
+	loc, err := time.LoadLocation(p.inv.Building.TimeZone)
+	if err != nil {
+		log.Fatalf("loading time zone of building %s: %v", *buildingId, err)
+	}
+	return loc
+}
+
+// resources returns the rooms in the inventory, identified by their
+// calendars.
+func (p *inventoryProvider) resources(context.Context) (itercal.Resources, error) {
+	var ret itercal.Resources
+	for _, r := range p.inv.Rooms {
+		ret = append(ret, &itercal.Resource{
+			ResourceEmail:         r.Calendar,
+			ResourceName:          r.Name,
+			GeneratedResourceName: r.Name,
+			ResourceCategory:      "CONFERENCE_ROOM",
+			BuildingId:            p.inv.Building.ID,
+			FloorName:             r.Floor,
+			FloorSection:          r.Section,
+			Capacity:              r.Capacity,
+			Features:              r.Features,
+		})
+	}
+	return ret, nil
+}
+
+func (p *inventoryProvider) freeBusy(ctx context.Context, calSrv *calendar.Service, ids []string, start, end time.Time) (map[string]calendar.FreeBusyCalendar, error) {
+	return itercal.FreeBusy(ctx, calSrv, ids, start, end, zone)
+}
+
+// book creates an event for event in room's calendar.
+func (p *inventoryProvider) book(ctx context.Context, calSrv *calendar.Service, event *calendar.Event, room *itercal.Resource) (string, error) {
+	booking := &calendar.Event{
+		Summary: fmt.Sprintf("Room for '%s'", markBooked(event.Summary)),
+		Start:   event.Start,
+		End:     event.End,
+	}
+	linkToSource(booking, event)
+	created, err := calSrv.Events.Insert(room.ResourceEmail, booking).SendUpdates("none").Context(ctx).Do()
+	if err != nil {
+		return "", err
+	}
+	return created.Id, nil
+}
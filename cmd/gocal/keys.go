@@ -0,0 +1,35 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+
+	"github.com/vsekhar/gocal/internal/cache"
+	"github.com/vsekhar/gocal/internal/keychain"
+)
+
+const keychainService = "gocal"
+
+// cacheKey returns the key used to encrypt the cache, generating and storing
+// one in the OS keychain if there is none.
+func cacheKey() ([]byte, error) {
+	const account = "cache-key"
+	s, err := keychain.Get(keychainService, account)
+	if err == nil {
+		return hex.DecodeString(s)
+	}
+	if !errors.Is(err, keychain.ErrNotFound) {
+		return nil, err
+	}
+	key := make([]byte, cache.KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if err := keychain.Set(keychainService, account, hex.EncodeToString(key)); err != nil {
+		return nil, err
+	}
+	log.Printf("Stored new cache key in keychain")
+	return key, nil
+}
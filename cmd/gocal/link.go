@@ -0,0 +1,119 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/vsekhar/gocal/internal/itercal"
+	"google.golang.org/api/calendar/v3"
+)
+
+// Private extended properties used to link a room hold to the event it was
+// created for, and vice versa. Private properties are only visible on the copy
+// of the event in the calendar being operated on.
+const (
+	sourceEventIdProperty   = "gocalSourceEventId"
+	sourceEventLinkProperty = "gocalSourceEventLink"
+	holdEventIdProperty     = "gocalHoldEventId"
+	holdEventLinkProperty   = "gocalHoldEventLink"
+
+	// originalLocationProperty holds an event's Location before gocal added
+	// its room, so that the change can be reverted.
+	originalLocationProperty = "gocalOriginalLocation"
+)
+
+func privateProperty(e *calendar.Event, key string) string {
+	if e.ExtendedProperties == nil {
+		return ""
+	}
+	return e.ExtendedProperties.Private[key]
+}
+
+// isHold returns true if e is a room hold created by gocal.
+func isHold(e *calendar.Event) bool {
+	return privateProperty(e, sourceEventIdProperty) != ""
+}
+
+// hasHold returns true if gocal has already created a room hold for e.
+func hasHold(e *calendar.Event) bool {
+	return privateProperty(e, holdEventIdProperty) != ""
+}
+
+// linkToSource populates hold with a reference back to the source event.
+func linkToSource(hold, source *calendar.Event) {
+	hold.Source = &calendar.EventSource{
+		Title: source.Summary,
+		Url:   source.HtmlLink,
+	}
+	hold.ExtendedProperties = &calendar.EventExtendedProperties{
+		Private: map[string]string{
+			sourceEventIdProperty:   source.Id,
+			sourceEventLinkProperty: source.HtmlLink,
+		},
+	}
+}
+
+// linkToHold populates patch with a reference to hold, for application to the
+// hold's source event.
+func linkToHold(patch, hold *calendar.Event) {
+	setPrivateProperty(patch, holdEventIdProperty, hold.Id)
+	setPrivateProperty(patch, holdEventLinkProperty, hold.HtmlLink)
+}
+
+func setPrivateProperty(e *calendar.Event, key, value string) {
+	if e.ExtendedProperties == nil {
+		e.ExtendedProperties = new(calendar.EventExtendedProperties)
+	}
+	if e.ExtendedProperties.Private == nil {
+		e.ExtendedProperties.Private = make(map[string]string)
+	}
+	e.ExtendedProperties.Private[key] = value
+}
+
+// setLocation adds room to the Location of patch, an update to event, if
+// -set-location is set, recording event's original Location.
+func setLocation(patch, event *calendar.Event, room *itercal.Resource) {
+	loc, ok := locationWithRoom(event.Location, room)
+	if !*setLocationFlag || !ok {
+		return
+	}
+	patch.Location = loc
+	if privateProperty(event, originalLocationProperty) == "" {
+		// Record a placeholder for an empty location so that it is restored.
+		orig := event.Location
+		if orig == "" {
+			orig = " "
+		}
+		setPrivateProperty(patch, originalLocationProperty, orig)
+	}
+}
+
+// locationWithRoom returns loc, an event's Location, with room added, or false
+// if loc already mentions room.
+func locationWithRoom(loc string, room *itercal.Resource) (string, bool) {
+	if loc != "" && strings.Contains(loc, room.GeneratedResourceName) {
+		return loc, false
+	}
+	text := room.GeneratedResourceName
+	if wheelchairAccessible(room) {
+		text += " (wheelchair accessible)"
+	}
+	if loc == "" {
+		return text, true
+	}
+	return loc + "; " + text, true
+}
+
+// locationWithoutRoom returns loc, an event's Location, without room as added
+// by locationWithRoom, or false if loc doesn't list it.
+func locationWithoutRoom(loc string, room *itercal.Resource) (string, bool) {
+	var parts []string
+	found := false
+	for _, p := range strings.Split(loc, "; ") {
+		if p == room.GeneratedResourceName || p == room.GeneratedResourceName+" (wheelchair accessible)" {
+			found = true
+			continue
+		}
+		parts = append(parts, p)
+	}
+	return strings.Join(parts, "; "), found
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vsekhar/gocal/internal/cache"
+	"github.com/vsekhar/gocal/internal/itercal"
+	directory "google.golang.org/api/admin/directory/v1"
+	"google.golang.org/api/calendar/v3"
+	"googlemaps.github.io/maps"
+)
+
+// inferLocation fills in any of -building, -floor and -section that were not
+// provided using the locations in the user's Directory profile. Desk locations
+// are preferred over other kinds.
+func inferLocation(ctx context.Context, dirSrv *directory.Service, calSrv *calendar.Service) {
+	if *buildingId != "" && *floor != 0 && *section != 0 || externalProvider() != nil {
+		return
+	}
+	primary, err := calSrv.Calendars.Get("primary").Context(ctx).Do()
+	if err != nil {
+		log.Printf("looking up user: %v", err)
+		return
+	}
+	loc := profileLocation(ctx, dirSrv, primary.Id)
+	if loc == nil {
+		return
+	}
+	if *buildingId == "" {
+		*buildingId = loc.BuildingId
+		log.Printf("Using building %s from profile", *buildingId)
+	} else if *buildingId != loc.BuildingId {
+		// Floor and section in the profile are for some other building.
+		return
+	}
+	if f, err := strconv.Atoi(loc.FloorName); err == nil && *floor == 0 {
+		*floor = f
+		log.Printf("Using floor %d from profile", *floor)
+	}
+	if s, err := strconv.Atoi(loc.FloorSection); err == nil && *section == 0 {
+		*section = s
+		log.Printf("Using section %d from profile", *section)
+	}
+}
+
+// profileLocation returns the location in a building from the Directory
+// profile of user, preferring desk locations, or nil if there is none.
+func profileLocation(ctx context.Context, dirSrv *directory.Service, user string) *directory.UserLocation {
+	locs, err := itercal.UserLocations(ctx, dirSrv, user)
+	if err != nil {
+		log.Printf("looking up locations of %s: %v", user, err)
+		return nil
+	}
+	var loc *directory.UserLocation
+	for _, l := range locs {
+		if l.BuildingId == "" {
+			continue
+		}
+		if loc == nil || (l.Type == "desk" && loc.Type != "desk") {
+			loc = l
+		}
+	}
+	return loc
+}
+
+// loadBuilding returns the building identified by -building.
+func loadBuilding(ctx context.Context, cacheSpace *cache.Space, dirSrv *directory.Service) (*directory.Building, error) {
+	if p := externalProvider(); p != nil {
+		return p.building(ctx), nil
+	}
+	b, err := itercal.Building(ctx, cacheSpace, dirSrv, *customer, *buildingId)
+	if err != nil {
+		return nil, fmt.Errorf("looking up building %s: %v", *buildingId, err)
+	}
+	return b, nil
+}
+
+// buildingZone returns the time zone of building b, looked up by its
+// coordinates. It returns time.Local if the building has no coordinates.
+func buildingZone(ctx context.Context, b *directory.Building) (*time.Location, error) {
+	if p := externalProvider(); p != nil {
+		return p.zone(ctx), nil
+	}
+	if b.Coordinates == nil {
+		log.Printf("building %s has no coordinates, using local time zone", *buildingId)
+		return time.Local, nil
+	}
+	mapsAPIKey, err := ioutil.ReadFile(*mapsAPIKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	key := strings.TrimSpace(string(mapsAPIKey))
+	mapsClient, err := newMapsClient(key)
+	if err != nil {
+		return nil, err
+	}
+	tzr, err := mapsClient.Timezone(ctx, &maps.TimezoneRequest{
+		Location: &maps.LatLng{
+			Lat: b.Coordinates.Latitude,
+			Lng: b.Coordinates.Longitude,
+		},
+		Timestamp: time.Now(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("looking up time zone of building %s: %v", *buildingId, err)
+	}
+	loc, err := time.LoadLocation(tzr.TimeZoneID)
+	if err != nil {
+		return nil, fmt.Errorf("loading time zone of building %s: %v", *buildingId, err)
+	}
+	return loc, nil
+}
@@ -3,62 +3,143 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
-	"math"
 	"net/http"
 	"os"
 	"os/signal"
 	"runtime/pprof"
 	"sort"
-	"strconv"
 	"strings"
-	"sync"
+	"sync/atomic"
 	"time"
 
-	"golang.org/x/exp/constraints"
-
 	"github.com/vsekhar/gocal/internal/cache"
 	"github.com/vsekhar/gocal/internal/interval"
 	"github.com/vsekhar/gocal/internal/itercal"
+	"github.com/vsekhar/gocal/internal/rank"
+	"github.com/vsekhar/gocal/internal/robin"
+	"github.com/vsekhar/gocal/internal/timeutil"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
+	"golang.org/x/sync/errgroup"
 	directory "google.golang.org/api/admin/directory/v1"
 	"google.golang.org/api/calendar/v3"
-	"google.golang.org/api/option"
-	"googlemaps.github.io/maps"
 )
 
-var lookAhead = flag.Duration("next", 24*time.Hour, "process events for the next time period specified, e.g. '72h' (default: '24h'")
-var buildingId = flag.String("building", "", "building in which to book rooms (e.g. 'tor-111')")
-var floor = flag.Int("floor", 0, "preferred floor")
-var section = flag.Int("section", 0, "preferred section")
+var lookAhead = durationFlag("next", 24*time.Hour, "process events for the next time period specified, e.g. '72h' or '7d'")
+var customer = flag.String("customer", itercal.DefaultCustomer, "Directory customer ID whose buildings and rooms to use")
+var buildingId = flag.String("building", "", "building in which to book rooms, e.g. 'tor-111', or a comma-separated list of buildings in which to book each event's room where it takes place (see buildings.go; default: from Directory profile)")
+var searchMargin = flag.Float64("search-margin", itercal.DefaultSearchMargin, "factor by which the best building matching -building must outscore the next to be chosen")
+var city = flag.String("city", "", "city or region of the building, to distinguish buildings with the same name on different campuses")
+var floor = flag.Int("floor", 0, "preferred floor (default: from Directory profile)")
+var section = flag.Int("section", 0, "preferred section (default: from Directory profile)")
 var credentialFile = flag.String("credentials", "credentials.json", "credentials file")
 var tokenFile = flag.String("token", "token.json", "token file")
 var mapsAPIKeyFile = flag.String("mapsapikey", "mapsapikey.txt", "Google Maps API Key file")
+var firstNear = flag.String("first-near", "", "location the first meeting of each day should be near, e.g. the entrance, as 'floor/section'")
+var lastNear = flag.String("last-near", "", "location the last meeting of each day should be near, e.g. the exit, as 'floor/section'")
+var preset = flag.String("preset", rank.DefaultPreset, "room scoring preset: 'closest', 'best-fit' or 'balanced'")
+var weights = flag.String("weights", "", "room scoring weights overriding the preset, e.g. 'distance=1,capacity=0.5' (names: distance, capacity, features, floor, anchor, sameroom, popularity, guest)")
+var explain = flag.Bool("explain", false, "print the top candidate rooms for each event and their scores")
+var speedy = flag.Duration("speedy", 0, "book rooms in separate holds ending this much earlier than meetings, e.g. '5m', leaving meeting times unchanged")
+var speedyStart = flag.Bool("speedy-start", false, "with -speedy, start room holds late instead of ending them early")
 var dryRun = flag.Bool("dryrun", false, "don't actually change anything")
+var pass = flag.String("pass", "full", "'full' to consider all events, or 'quick' to only book rooms for events changed since the last pass and skip -locations-calendar, e.g. for hourly runs between nightly full passes")
+var quietHours = windowFlag("quiet-hours", "daily window, e.g. '22:00-07:00', during which runs plan without booking, to avoid notifying other attendees at night")
 var calendarId = flag.String("calendar", "primary", "calendar ID to operate on")
+var otherCalendars = flag.String("other-calendars", "", "comma-separated IDs of further calendars, e.g. shared team calendars, whose events to book rooms for")
+var configFile = flag.String("config", defaultConfigFile(), "config file providing defaults for flags, in JSON or, if named .yaml, YAML (see 'gocal config')")
+var allowStale = flag.Bool("allow-stale", false, "if the Directory API is unavailable, use cached buildings and rooms even if they are out of date")
+var encryptCache = flag.Bool("encrypt-cache", false, "encrypt cached room data with a key stored in the OS keychain, except the search index of building names, which stays plaintext")
+var proxyURL = flag.String("proxy", "", "URL of the HTTPS proxy for API requests (default: from HTTPS_PROXY)")
+var httpTimeout = flag.Duration("http-timeout", time.Minute, "timeout for each API request, or 0 for none")
+var calendarEndpoint = flag.String("calendar-endpoint", "", "base URL of the Calendar API, e.g. for Private Google Access or testing")
+var directoryEndpoint = flag.String("directory-endpoint", "", "base URL of the Directory API")
+var mapsEndpoint = flag.String("maps-endpoint", "", "base URL of the Maps API")
+var stdio = flag.Bool("stdio", false, "stay running and answer JSON-RPC requests on stdin, e.g. for editor and launcher integrations")
+var verbose = flag.Bool("v", false, "log more detail, e.g. memory usage")
+var debugHTTP = flag.Bool("debug-http", false, "log API requests and responses, with credentials and email addresses redacted")
+var roomInventoryFile = flag.String("room-inventory", "", "JSON file listing the building's rooms and their shared calendars, for use instead of the Directory (see inventory.go)")
+var robinLocation = flag.String("robin-location", "", "ID of a Robin location whose spaces to book instead of Workspace calendar resources (see robin.go)")
+var robinTokenFile = flag.String("robin-token", "robin-token.txt", "file holding a Robin API access token, for -robin-location")
+var robinEndpoint = flag.String("robin-endpoint", robin.DefaultEndpoint, "base URL of the Robin API")
+var roomCategories = flag.String("room-categories", "CONFERENCE_ROOM", "comma-separated resource categories which, if already booked for an event, count as its room, e.g. 'CONFERENCE_ROOM,OTHER'")
+var guestRooms = flag.String("guest-rooms", "", "regular expression matching the names of rooms accessible to external guests, e.g. 'Reception|Lobby'")
+var guestFeature = flag.String("guest-feature", "", "name of the room feature marking rooms accessible to external guests")
+var roomFeatures = flag.String("features", "", "comma-separated features rooms must have, e.g. 'vc,jamboard,wheelchair'; events can require more with tags such as '#room[vc]' (see features.go)")
+var accessible = flag.Bool("accessible", false, "only book wheelchair-accessible rooms (by feature), and measure distances taking the elevator between floors")
+var attachHolds = flag.Bool("hold-attachments", false, "attach the meeting's attachments, or the Google Doc linked from its description as its agenda, to room holds; needs Drive access, granted when signing in with this flag")
+var holdPrivacy = flag.String("hold-privacy", "default", "visibility of room holds: 'default' to copy the meeting's, or 'private' to hide their details from others, e.g. on the room's calendar")
+var includeStarted = flag.Bool("include-started", false, "also book rooms for meetings already in progress (meetings that have ended are never changed)")
+var startedWithin = flag.Duration("started-within", 0, "book rooms for meetings that started up to this long ago, e.g. '15m' for huddles that need a room after all, from now until they end")
+var bookingHorizon = flag.Duration("booking-horizon", 0, "how far ahead the organization allows rooms to be booked, e.g. '336h' for 14 days; later events are skipped (default: no limit)")
+var maxAPICalls = flag.Int64("max-api-calls", 0, "stop after this many API calls, to protect shared quotas (default: no limit)")
+var approvalTimeout = flag.Duration("approval-timeout", 24*time.Hour, "how long to wait for the owner of a room requiring approval to accept a booking before booking another room")
+var useWaitlist = flag.Bool("waitlist", false, "keep events for which no room is free on a waitlist, so that 'gocal serve -waitlist-interval' books one as soon as it frees up")
+var deadline = flag.Duration("deadline", 0, "bound the whole run, e.g. '90s' for login hooks; once it passes, changes underway finish and the events still needing rooms are reported (default: no limit)")
+var maxMutations = flag.Int64("max-mutations", 0, "stop booking after this many calendar changes (default: no limit)")
+var colorLabels = flag.String("color-labels", "", "names for event color IDs for use in -skip-colors and -only-colors, e.g. '11=personal,2=interviews'")
+var skipColors = flag.String("skip-colors", "", "comma-separated event colors (IDs, labels or 'default') to not book rooms for")
+var onlyColors = flag.String("only-colors", "", "comma-separated event colors (IDs, labels or 'default') to exclusively book rooms for")
+var colorOutput = flag.String("color", "auto", "color terminal output: 'auto', 'always' or 'never' (auto respects NO_COLOR)")
+var locationsCalendar = flag.String("locations-calendar", "", "calendar ID, or name of a calendar to create, in which to note the room of each meeting, e.g. to share with teammates")
+var setLocationFlag = flag.Bool("set-location", true, "also add the room to events' location, so that clients show it prominently")
+var roomAliasesFile = flag.String("room-aliases", defaultAliasesFile(), "JSON file mapping names used in '#room:name' tags to room names or emails")
+var equipmentHealth = flag.String("equipment-health", "", "CSV file or URL noting broken equipment, which removes features from rooms or takes rooms out of service until fixed (see equipment.go)")
+var stateDBFile = flag.String("state-db", "", "SQLite database in which to keep gocal's state, e.g. the waitlist, instead of JSON files in the cache (see statedb.go)")
+var telemetryURL = flag.String("telemetry", "", "opt in to reporting anonymous counts of runs, bookings and API errors to this URL daily, to help gocal's maintainers (see telemetry.go; default: off)")
+var daemon = flag.Bool("daemon", false, "keep running, booking rooms every -interval, e.g. as a systemd service (see daemon.go)")
+var daemonInterval = flag.Duration("interval", 15*time.Minute, "with -daemon, how often to book rooms")
+var watchURL = flag.String("watch", "", "with -daemon, HTTPS address at which Calendar can notify gocal of changes to the scanned calendars, served on -watch-addr, to book rooms within seconds (see watch.go)")
+var watchAddr = flag.String("watch-addr", "localhost:8081", "address on which to serve -watch notifications")
+var roomTagsFlag = flag.String("room-tags", "#room", "comma-separated tags in event summaries or descriptions which ask for a room, e.g. '#book' if other automation uses '#room' (see tags.go)")
+var roomTagDone = flag.String("room-tag-done", "#addedroom", "tag replacing -room-tags on events once their room is booked")
+var foreignHolds = flag.String("foreign-holds", "", "comma-separated markers of room holds made by other booking tools, besides those of service accounts and room calendars: creator emails or '@domain', 'property:key[=value]' or 'summary:text' (see foreign.go)")
+var upgradeRooms = flag.Bool("upgrade-rooms", true, "with -daemon, move meetings whose attendees outgrow the room gocal booked to a bigger room nearby (see upgrade.go)")
+var downgradeRooms = flag.String("downgrade-rooms", "offer", "with -daemon, when attendees decline until the room gocal booked is far too big, or not needed at all, 'offer' a smaller room or the room's release through -notify, 'move' to make the change, or 'off' (see downgrade.go)")
+var overflow = flag.Bool("overflow", false, "when a meeting has more attendees than any free room seats, also book overflow rooms with -overflow-feature near its room, in holds (see overflow.go)")
+var overflowFeature = flag.String("overflow-feature", "vc", "feature overflow rooms must have, e.g. 'vc' for video conferencing")
+var confirm = flag.Bool("confirm", false, "before booking each room, list the best free rooms and pick one, or skip the event, at a prompt (see confirm.go)")
+var colocatedMax = flag.Duration("colocated-max", 0, "skip meetings this short or shorter, e.g. '30m', whose accepted attendees all have desks in the same floor section per the Directory, since they can meet there (see colocated.go; default: off)")
+var colocatedAttendees = flag.Int("colocated-attendees", 6, "with -colocated-max, the most accepted attendees a meeting skipped for sitting together may have")
+var notifyCommand = flag.String("notify", "", "command run with a message as its last argument when gocal moves a booking on its own or offers to, e.g. 'notify-send gocal' (default: log only)")
+var output = flag.String("output", "text", "with -dryrun, 'json' to print the planned bookings, with alternatives and reasons, as JSON on stdout instead of logging them (see report.go)")
+var maintenanceCalendarId = flag.String("maintenance", "", "calendar ID whose events mark rooms (by email or name in the summary) as out of service")
 
-const roomTag = "#room"
-const roomTagDone = "#addedroom"
+// zone is the location of the building in which rooms are booked.
+var zone = time.Local
 
 // Retrieve a token, saves the token, then returns the generated client.
 func getClient(config *oauth2.Config) *http.Client {
 	// The file token.json stores the user's access and refresh tokens, and is
 	// created automatically when the authorization flow completes for the first
 	// time.
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, httpClient())
 	tok, err := tokenFromFile(*tokenFile)
 	if err != nil {
-		tok = getTokenFromWeb(config)
+		tok = getTokenFromWeb(ctx, config)
 		saveToken(*tokenFile, tok)
 	}
-	return config.Client(context.Background(), tok)
+	grantedScopes = strings.Fields(tok.Scope)
+	client := config.Client(ctx, tok.Token)
+	client.Timeout = *httpTimeout
+	return client
+}
+
+// savedToken is a token as stored in -token, with the scopes it grants.
+type savedToken struct {
+	*oauth2.Token
+	// Scope is the space-separated scopes granted, if known.
+	Scope string `json:"scope,omitempty"`
 }
 
 // Request a token from the web, then returns the retrieved token.
-func getTokenFromWeb(config *oauth2.Config) *oauth2.Token {
+func getTokenFromWeb(ctx context.Context, config *oauth2.Config) savedToken {
 	authURL := config.AuthCodeURL("state-token", oauth2.AccessTypeOffline)
 	fmt.Printf("Go to the following link in your browser then type the "+
 		"authorization code: \n%v\n", authURL)
@@ -68,27 +149,28 @@ func getTokenFromWeb(config *oauth2.Config) *oauth2.Token {
 		log.Fatalf("Unable to read authorization code: %v", err)
 	}
 
-	tok, err := config.Exchange(context.TODO(), authCode)
+	tok, err := config.Exchange(ctx, authCode)
 	if err != nil {
 		log.Fatalf("Unable to retrieve token from web: %v", err)
 	}
-	return tok
+	scope, _ := tok.Extra("scope").(string)
+	return savedToken{Token: tok, Scope: scope}
 }
 
 // Retrieves a token from a local file.
-func tokenFromFile(file string) (*oauth2.Token, error) {
+func tokenFromFile(file string) (savedToken, error) {
 	f, err := os.Open(file)
 	if err != nil {
-		return nil, err
+		return savedToken{}, err
 	}
 	defer f.Close()
-	tok := &oauth2.Token{}
-	err = json.NewDecoder(f).Decode(tok)
+	tok := savedToken{Token: &oauth2.Token{}}
+	err = json.NewDecoder(f).Decode(&tok)
 	return tok, err
 }
 
 // Saves a token to a file path.
-func saveToken(path string, token *oauth2.Token) {
+func saveToken(path string, token savedToken) {
 	log.Printf("Saving credential file to: %s\n", path)
 	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
 	if err != nil {
@@ -98,401 +180,1154 @@ func saveToken(path string, token *oauth2.Token) {
 	json.NewEncoder(f).Encode(token)
 }
 
+// A command is a subcommand of gocal, selected by the first argument.
+type command struct {
+	// flags, if non-nil, registers command-specific flags.
+	flags func(fs *flag.FlagSet)
+	run   func(ctx context.Context)
+}
+
+var commands = map[string]command{
+	"apply":   {flags: applyFlags, run: applyPlan},
+	"config":  {flags: configFlags, run: showConfig},
+	"heatmap": {flags: heatmapFlags, run: heatmap},
+	"init":    {run: onboard},
+	"map":     {flags: floorMapFlags, run: floorMap},
+	"plan":    {flags: planFlags, run: previewPlan},
+	"prefer":  {flags: preferFlags, run: preferRooms},
+	"quick":   {flags: quickFlags, run: quick},
+	"serve":   {flags: serveFlags, run: serve},
+	"state":   {flags: stateFlags, run: manageState},
+}
+
 func main() {
-	ctx := context.Background()
-	sigCtx, stop := signal.NotifyContext(ctx, os.Interrupt)
-	defer stop()
+	// An interrupt cancels the run, which finishes changes underway and
+	// reports what remains, as when -deadline passes. Another interrupt
+	// exits immediately.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
 	go func() {
-		<-sigCtx.Done()
-		pprof.Lookup("goroutine").WriteTo(os.Stdout, 1)
-		panic("interrupt")
+		<-interrupts
+		signal.Stop(interrupts)
+		log.Printf("Interrupted; finishing changes underway (interrupt again to quit)")
+		if *verbose {
+			pprof.Lookup("goroutine").WriteTo(os.Stderr, 1)
+		}
+		cancel()
 	}()
 
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
-	flag.Parse()
-	if *dryRun {
-		log.Printf("Dry run")
-	}
 
-	startTime := time.Now()
-	endTime := startTime.Add(*lookAhead)
-	log.Printf("From %s to %s", startTime, endTime)
+	// Subcommands accept the global flags in addition to their own.
+	fs, args, run := flag.CommandLine, os.Args[1:], book
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		name := os.Args[1]
+		cmd, ok := commands[name]
+		if !ok {
+			log.Fatalf("unknown command '%s'", name)
+		}
+		fs = flag.NewFlagSet(name, flag.ExitOnError)
+		flag.VisitAll(func(f *flag.Flag) { fs.Var(f.Value, f.Name, f.Usage) })
+		fs.Usage = usage(fs)
+		if cmd.flags != nil {
+			cmd.flags(fs)
+		}
+		args, run = os.Args[2:], cmd.run
+	}
+	fs.Parse(args)
+	if err := applyConfig(fs, *configFile); err != nil {
+		log.Fatalf("loading config %s: %v", *configFile, err)
+	}
+	if err := checkRoomTags(); err != nil {
+		log.Fatal(err)
+	}
+	if _, err := parseForeignMarkers(*foreignHolds); err != nil {
+		log.Fatal(err)
+	}
+	switch {
+	case *output != "text" && *output != "json":
+		log.Fatalf("unknown -output '%s'", *output)
+	case *output == "json" && (!*dryRun || *daemon || *stdio || fs != flag.CommandLine):
+		log.Fatalf("-output json needs -dryrun, and only applies to booking rooms")
+	case *output == "json" && !*verbose:
+		log.SetOutput(io.Discard)
+	}
+	if *confirm && (*daemon || *stdio || *output == "json") {
+		log.Fatalf("-confirm doesn't apply to -daemon, -stdio or -output json")
+	}
+	if *stdio {
+		run = serveStdio
+	}
+	if *daemon {
+		if fs != flag.CommandLine || *stdio {
+			log.Fatalf("-daemon only applies to booking rooms")
+		}
+		// -deadline bounds each scan.
+		run = runDaemon
+	} else if *watchURL != "" {
+		log.Fatalf("-watch needs -daemon")
+	} else if *deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *deadline)
+		defer cancel()
+	}
+	run(ctx)
+}
 
+// newServices authenticates the user and returns the Directory and Calendar
+// services.
+func newServices(ctx context.Context) (*directory.Service, *calendar.Service) {
 	cred, err := ioutil.ReadFile(*credentialFile)
 	if err != nil {
-		log.Fatalf("Unable to read client secret file: %v", err)
+		log.Fatalf("Unable to read client secret file (run 'gocal init' to set up): %v", err)
 	}
 
-	config, err := google.ConfigFromJSON(cred,
+	scopes := []string{
 		// If modifying these scopes, delete your previously saved token.json.
 		calendar.CalendarReadonlyScope,
 		calendar.CalendarEventsScope, // read/write
 		directory.AdminDirectoryResourceCalendarReadonlyScope,
-	)
+		directory.AdminDirectoryUserReadonlyScope, // user's locations
+	}
+	if *attachHolds {
+		scopes = append(scopes, driveScope) // attachments on holds
+	}
+	config, err := google.ConfigFromJSON(cred, scopes...)
 
 	if err != nil {
 		log.Fatalf("Unable to parse client secret file to config: %v", err)
 	}
-	client := getClient(config)
+	return clientServices(ctx, getClient(config))
+}
 
-	// Create services
-	dirSrv, err := directory.NewService(ctx, option.WithHTTPClient(client))
+// clientServices returns the Directory and Calendar services using client.
+func clientServices(ctx context.Context, client *http.Client) (*directory.Service, *calendar.Service) {
+	dirSrv, err := directory.NewService(ctx, endpointOptions(*directoryEndpoint, client)...)
 	if err != nil {
 		log.Fatalf("Unable to retrieve Admin client: %v", err)
 	}
-	calSrv, err := calendar.NewService(ctx, option.WithHTTPClient(client))
+	calSrv, err := calendar.NewService(ctx, endpointOptions(*calendarEndpoint, client)...)
 	if err != nil {
 		log.Fatalf("Unable to retrieve Calendar client: %v", err)
 	}
+	return dirSrv, calSrv
+}
 
-	cacheSpace, err := cache.Application("gocal")
+// openCache returns gocal's cache space, encrypted if -encrypt-cache is set and
+// falling back to stale entries if -allow-stale is set.
+func openCache() *cache.Space {
+	return openCacheFor("gocal")
+}
+
+// openCacheFor returns the cache space of appId, configured as by openCache.
+func openCacheFor(appId string) *cache.Space {
+	cacheSpace, err := cache.Application(appId)
 	if err != nil {
 		log.Fatal(err)
 	}
+	if *encryptCache {
+		key, err := cacheKey()
+		if err != nil {
+			log.Fatalf("getting cache key: %v", err)
+		}
+		if err := cacheSpace.Encrypt(key); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *allowStale {
+		cacheSpace.AllowStale()
+	}
+	return cacheSpace
+}
 
-	buildingIndex, err := itercal.Buildings(ctx, cacheSpace, dirSrv)
+// resolveBuilding replaces *buildingId with the ID of the building it
+// identifies.
+func resolveBuilding(ctx context.Context, cacheSpace *cache.Space, dirSrv *directory.Service) error {
+	if externalProvider() != nil {
+		return nil
+	}
+	if *buildingId == "" {
+		return errors.New("no building specified (provide -building or run 'gocal init')")
+	}
+	if strings.Contains(*buildingId, ",") {
+		return errors.New("several buildings in -building are only supported when booking rooms")
+	}
+	// If -building is an ID whose building was cached after first use, the
+	// index of all buildings needn't be opened, nor on first use, when what
+	// looks like an ID is looked up as one. Once the index is cached, it
+	// tells IDs and names apart, so that names aren't looked up as IDs on
+	// every run.
+	if itercal.BuildingEntry(ctx, cacheSpace, dirSrv, *customer, *buildingId).Cached(cacheSpace) {
+		return nil
+	}
+	looksLikeID := !strings.ContainsAny(*buildingId, " \t")
+	lookedUp := false
+	if looksLikeID && !itercal.BuildingsEntry(ctx, dirSrv, *customer).Cached(cacheSpace) {
+		if _, err := itercal.Building(ctx, cacheSpace, dirSrv, *customer, *buildingId); err == nil {
+			return nil
+		}
+		lookedUp = true
+	}
+	buildingIndex, err := itercal.Buildings(ctx, cacheSpace, dirSrv, *customer)
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+	defer buildingIndex.Close()
+	if ok, err := itercal.HasBuilding(buildingIndex, *buildingId); err != nil || ok {
+		return err
+	}
+	b, err := itercal.SearchBuildings(buildingIndex, *buildingId, *city, *searchMargin)
+	if looksLikeID && !lookedUp && (err != nil || !strings.EqualFold(b.Name, *buildingId)) {
+		// The building may be newer than the index.
+		if _, err := itercal.Building(ctx, cacheSpace, dirSrv, *customer, *buildingId); err == nil {
+			return nil
+		}
+	}
+	var ambiguous *itercal.AmbiguousError
+	if errors.As(err, &ambiguous) {
+		for _, c := range ambiguous.Candidates {
+			log.Printf("  %s", c)
+		}
+		return fmt.Errorf("%v; use a building ID, a more specific -building, or -city", err)
 	}
-
-	// Lookup the provided building
-	b, err := itercal.SearchBuildings(buildingIndex, *buildingId)
 	if err != nil {
-		log.Fatalf("searching for office '%s': %v", *buildingId, err)
+		return fmt.Errorf("searching for office '%s': %v", *buildingId, err)
 	}
-	log.Printf("Inferred building ID: %s\n", b)
-	*buildingId = b
+	log.Printf("Inferred building: %s\n", b)
+	*buildingId = b.ID
+	return nil
+}
 
-	// Get building's timezone
-	mapsAPIKey, err := ioutil.ReadFile(*mapsAPIKeyFile)
-	if err != nil {
+// book books rooms for upcoming events, in each of the buildings of -building
+// (see buildings.go).
+func book(ctx context.Context) {
+	if err := bookPass(ctx); err != nil {
 		log.Fatal(err)
 	}
-	key := strings.TrimSpace(string(mapsAPIKey))
-	mapsClient, err := maps.NewClient(maps.WithAPIKey(key))
-	if err != nil {
-		log.Fatal(err)
+}
+
+// bookPass is book, returning errors, e.g. from the APIs, rather than exiting,
+// so that servers can report them and carry on.
+func bookPass(ctx context.Context) error {
+	deferDuringQuietHours(time.Now())
+	if *dryRun {
+		log.Printf("Dry run")
 	}
-	tzr, err := mapsClient.Timezone(ctx, &maps.TimezoneRequest{
-		Location: &maps.LatLng{
-			// TODO
-		},
-		Timestamp: time.Now(),
-	})
+	if *holdPrivacy != "default" && *holdPrivacy != "private" {
+		return fmt.Errorf("unknown -hold-privacy '%s'", *holdPrivacy)
+	}
+	if *pass != "full" && *pass != "quick" {
+		return fmt.Errorf("unknown -pass '%s'", *pass)
+	}
+	if *downgradeRooms != "offer" && *downgradeRooms != "move" && *downgradeRooms != "off" {
+		return fmt.Errorf("unknown -downgrade-rooms '%s'", *downgradeRooms)
+	}
+	if *output == "json" {
+		report = &planReport{Created: time.Now().UTC(), DryRun: *dryRun, Events: []reportedEvent{}}
+		defer func() {
+			if err := report.write(os.Stdout); err != nil {
+				log.Fatalf("writing report: %v", err)
+			}
+			report = nil
+		}()
+	}
+	var err error
+	if ids := splitList(*buildingId); len(ids) > 1 && externalProvider() == nil {
+		err = bookBuildings(ctx, ids)
+	} else {
+		err = bookIn(ctx, nil)
+	}
+	if !*dryRun {
+		recordTelemetry(ctx)
+	}
+	return err
+}
+
+// bookIn books rooms in -building for upcoming events, or with p, for those
+// p assigns to the building.
+func bookIn(ctx context.Context, p *buildingPass) error {
+	passStart := time.Now()
+	resetDelegates()
+
+	dirSrv, calSrv, cacheSpace := scanServices(ctx)
+	cal := newCalendarProvider(calSrv)
+	inferLocation(ctx, dirSrv, calSrv)
+	if err := resolveBuilding(ctx, cacheSpace, dirSrv); err != nil {
+		return err
+	}
+
+	building, err := loadBuilding(ctx, cacheSpace, dirSrv)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-	_ = tzr
+	if zone, err = buildingZone(ctx, building); err != nil {
+		return err
+	}
+	floorLevels = newFloorOrder(building.FloorNames)
+
+	startTime := time.Now().In(zone)
+	endTime := timeutil.Add(startTime, *lookAhead, zone)
+	log.Printf("From %s to %s", startTime, endTime)
 
-	resourcesInBuildingIndex, err := itercal.ResourcesInBuilding(ctx, cacheSpace, dirSrv, *buildingId)
+	allResources, err := loadResources(ctx, cacheSpace, dirSrv)
 	if err != nil {
-		log.Fatalf("loading resources for building %s: %v", *buildingId, err)
+		return fmt.Errorf("loading resources for building %s: %v", *buildingId, err)
 	}
 
-	// TODO: iterate by day, break up chaining of room distance
+	// Sort resources by email so we can binary search for them when looking up
+	// existing room bookings.
+	sort.Slice(allResources, func(i, j int) bool {
+		return allResources[i].ResourceEmail < allResources[j].ResourceEmail
+	})
+	resourcesInBuildingIndex := allResources.ConferenceRooms()
 
-	freeBusy := make(map[string]calendar.FreeBusyCalendar)
-	freeBusyWg := sync.WaitGroup{}
-	freeBusyWg.Add(1)
-	go func() {
-		defer freeBusyWg.Done()
-		start := 0
-		for start < len(resourcesInBuildingIndex) {
-			// tried and failed: 50, 25
-			// worked: 10
-			const batchSize = 20
-			end := start + batchSize
-			if end > len(resourcesInBuildingIndex) {
-				end = len(resourcesInBuildingIndex)
-			}
-			req := &calendar.FreeBusyRequest{TimeMin: startTime.Format(time.RFC3339), TimeMax: endTime.Format(time.RFC3339)}
-			for i := start; i < end; i++ {
-				req.Items = append(req.Items, &calendar.FreeBusyRequestItem{Id: resourcesInBuildingIndex[i].ResourceEmail})
-			}
-			fc := calSrv.Freebusy.Query(req)
-			fr, err := fc.Do()
-			if err != nil {
-				panic(err)
-			}
-			for email, cal := range fr.Calendars {
-				notFound := false
-				if len(cal.Errors) > 0 {
-					for _, e := range cal.Errors {
-						if e.Reason == "notFound" {
-							notFound = true
-							continue // just don't add it
-						}
-						log.Printf("freebusy (%s): %v", email, e)
-						os.Exit(1)
-					}
-				}
-				if !notFound {
-					freeBusy[email] = cal
-				}
+	// Book rooms for the events on the calendars that can be read, reporting
+	// the rest rather than giving up on all of them.
+	colorOK := colorFilter()
+	var eventsImGoingTo, foreign, vacated []*calendar.Event
+	calendarOf := make(map[*calendar.Event]string)
+	calendarIds := scannedCalendars()
+	failedCalendars := make(map[string]error)
+	for _, calId := range calendarIds {
+		var events, holds, deserted []*calendar.Event
+		err := cal.ListEvents(ctx, calId, startTime, endTime, zone, func(e *calendar.Event) error {
+			if goingTo(e, colorOK) {
+				events = append(events, e)
+			} else if isForeignHold(e) {
+				holds = append(holds, e)
+			} else if isDeserted(e) {
+				deserted = append(deserted, e)
 			}
-			start = end
-		}
-	}()
-
-	var eventsImGoingTo []*calendar.Event
-	err = itercal.ForEachEvent(ctx, calSrv, *calendarId, time.Now(), time.Now().Add(*lookAhead), func(e *calendar.Event) error {
-		if e.Start.DateTime == "" {
-			// all day event
 			return nil
+		})
+		if err != nil {
+			log.Printf("warning: skipping calendar %s: %v", calId, err)
+			failedCalendars[calId] = err
+			continue
 		}
-		if e.Status == "cancelled" {
-			return nil
+		for _, e := range events {
+			calendarOf[e] = calId
 		}
-		if e.Transparency == "transparent" {
-			return nil
+		for _, e := range deserted {
+			calendarOf[e] = calId
 		}
-		if strings.Contains(e.Summary, roomTag) || strings.Contains(e.Description, roomTag) {
-			eventsImGoingTo = append(eventsImGoingTo, e)
+		eventsImGoingTo = append(eventsImGoingTo, events...)
+		foreign = append(foreign, holds...)
+		vacated = append(vacated, deserted...)
+	}
+	if len(failedCalendars) == len(calendarIds) {
+		return errors.New("no calendars could be read")
+	}
+	if len(calendarIds) > 1 {
+		sort.SliceStable(eventsImGoingTo, func(i, j int) bool {
+			a := interval.OrDie(eventsImGoingTo[i].Start.DateTime, eventsImGoingTo[i].End.DateTime)
+			b := interval.OrDie(eventsImGoingTo[j].Start.DateTime, eventsImGoingTo[j].End.DateTime)
+			return a.Start.Before(b.Start)
+		})
+	}
+	if p != nil {
+		eventsImGoingTo = p.mine(eventsImGoingTo)
+	}
+
+	roomsImGoingTo := make([]*itercal.Resource, len(eventsImGoingTo))
+	for eNo, e := range eventsImGoingTo {
+		roomsImGoingTo[eNo] = bookedRoom(e, allResources)
+	}
+	withForeignHolds(eventsImGoingTo, roomsImGoingTo, foreign, allResources)
+	eventsImGoingTo, roomsImGoingTo = dedupeEvents(eventsImGoingTo, roomsImGoingTo)
+	eventsImGoingTo, roomsImGoingTo = notStarted(eventsImGoingTo, roomsImGoingTo, startTime)
+	eventsImGoingTo, roomsImGoingTo = withinHorizon(eventsImGoingTo, roomsImGoingTo, startTime)
+	eventsImGoingTo, roomsImGoingTo = notColocated(ctx, dirSrv, eventsImGoingTo, roomsImGoingTo)
+	outgrown := outgrownRooms(eventsImGoingTo, roomsImGoingTo, startTime)
+	oversized := oversizedRooms(eventsImGoingTo, roomsImGoingTo, calendarOf, startTime)
+	releaseDeserted(calSrv, vacated, calendarOf, allResources, startTime)
+
+	// proposed records which rooms are planned during this run.
+	proposed := make([]bool, len(eventsImGoingTo))
+
+	// Bookings awaiting approval are left to their rooms' owners for a while.
+	approvalState := loadApprovals(cacheSpace)
+	awaiting := checkApprovals(calSrv, approvalState, eventsImGoingTo, calendarOf, roomsImGoingTo, allResources, startTime)
+	if !*dryRun {
+		approvalState.save(cacheSpace)
+	}
+
+	// Quick passes only book rooms for events changed since the last pass,
+	// leaving the rest to the next full pass.
+	var changedAfter time.Time
+	if *pass == "quick" {
+		if p != nil {
+			// The buildings' passes form one pass.
+			changedAfter = p.changedAfter
+		} else if changedAfter, err = lastPass(cacheSpace); err != nil {
+			return err
+		}
+		log.Printf("Quick pass for events changed since %s", changedAfter)
+	}
+
+	// If every event already has a room, skip looking up rooms' availability
+	// and past meetings, which are only needed to plan new bookings.
+	toBook := 0
+	for i, e := range eventsImGoingTo {
+		if roomsImGoingTo[i] == nil && awaiting[i] == nil && changedSince(e, changedAfter) {
+			toBook++
+		}
+	}
+	// Rooms' availability loads while past meetings are read. If either
+	// fails, the other is canceled.
+	freeBusy := make(map[string]calendar.FreeBusyCalendar)
+	hist := newHistory()
+	g, gctx := errgroup.WithContext(ctx)
+	if toBook > 0 {
+		g.Go(func() error {
+			ids := make([]string, len(resourcesInBuildingIndex))
+			for i, r := range resourcesInBuildingIndex {
+				ids[i] = r.ResourceEmail
+			}
+			var err error
+			freeBusy, err = roomFreeBusy(gctx, calSrv, ids, startTime, endTime)
+			return err
+		})
+		g.Go(func() error {
+			hist = loadHistory(gctx, calSrv, allResources, startTime)
+			hist.inferPreference()
 			return nil
+		})
+	} else {
+		log.Printf("No events need rooms")
+	}
+
+	logTable(msg("Going to:"), eventsImGoingTo, roomsImGoingTo)
+
+	if err := g.Wait(); err != nil {
+		return err
+	}
+	if err := addOutages(ctx, calSrv, resourcesInBuildingIndex, freeBusy, startTime, endTime); err != nil {
+		return err
+	}
+	addEquipmentOutages(loadEquipmentNotes(cacheSpace, startTime), resourcesInBuildingIndex, freeBusy, startTime, endTime)
+	w := scoringWeights()
+	anchors := dayAnchors(eventsImGoingTo)
+	popularity := busyTimes(freeBusy)
+	isGuestAccessible := guestAccessible()
+	isUsable := usableRoom(resourcesInBuildingIndex)
+	aliases := loadRoomAliases()
+	rooms := make([]rank.Room, len(resourcesInBuildingIndex))
+	for i, r := range resourcesInBuildingIndex {
+		rooms[i] = rank.Room{
+			Email:           r.ResourceEmail,
+			Location:        location(r),
+			Capacity:        r.Capacity,
+			Features:        r.Features,
+			Popularity:      popularity[r.ResourceEmail].Hours(),
+			GuestAccessible: isGuestAccessible(r),
 		}
+	}
 
-		// Check for humans >= 2
-		humans := 0
-		for _, a := range e.Attendees {
-			if a.Self && (a.ResponseStatus == "declined" || a.ResponseStatus == "needsAction") {
-				return nil
+	// Plan each day's rooms as a whole so that rooms are close to those of the
+	// surrounding meetings.
+	finished := true
+	var suggestions []suggestion
+	var wl waitlist
+	if *useWaitlist {
+		wl = loadWaitlist(cacheSpace)
+	}
+days:
+	for _, day := range days(eventsImGoingTo) {
+		slots := make([]rank.Slot, len(day))
+		why := make([][]string, len(day)) // reasons for reports
+		for j, i := range day {
+			event := eventsImGoingTo[i]
+			e := interval.OrDie(event.Start.DateTime, event.End.DateTime)
+			if e.Start.Before(startTime) {
+				// Meetings in progress need rooms only from now on.
+				e.Start = startTime
 			}
-			if !a.Resource && a.ResponseStatus != "declined" {
-				humans++
+			slots[j] = rank.Slot{
+				Request: rank.Request{
+					Attendees: attendeeCount(event),
+					Floor:     *floor,
+					Anchor:    anchors[i],
+					SameRoom:  hist.seriesRooms[event.RecurringEventId],
+					Guests:    hasExternalGuests(event),
+					Elevator:  *accessible,
+				},
+				Start:    e.Start,
+				End:      e.End,
+				Fixed:    -1,
+				Priority: eventPriority(event),
+				Available: func(r int) bool {
+					return isUsable(r) && isFree(freeBusy, resourcesInBuildingIndex[r].ResourceEmail, e)
+				},
+			}
+			if old := outgrown[i]; old != nil {
+				if l, ok := roomLocation(old); ok {
+					slots[j].Request.Anchor = &l
+				}
+				why[j] = append(why[j], reasonOutgrown)
+			}
+			if old := oversized[i]; old != nil {
+				if l, ok := roomLocation(old); ok {
+					slots[j].Request.Anchor = &l
+				}
+				available := slots[j].Available
+				slots[j].Available = func(r int) bool {
+					return smallerRoom(resourcesInBuildingIndex[r], event, old) && available(r)
+				}
+				why[j] = append(why[j], reasonOversized)
+			}
+			if t := eventTemplate(event); t != nil {
+				slots[j].Request.Features = t.Features
+				slots[j].Weights = &t.weights
+				if t.near != nil {
+					slots[j].Request.Anchor = t.near
+				}
+				why[j] = append(why[j], reasonTemplate)
+				available := slots[j].Available
+				slots[j].Available = func(r int) bool {
+					return hasFeatures(resourcesInBuildingIndex[r], t.Features) && available(r)
+				}
+			}
+			if want := taggedFeatures(event); len(want) > 0 {
+				slots[j].Request.Features = append(slots[j].Request.Features, want...)
+				available := slots[j].Available
+				slots[j].Available = func(r int) bool {
+					return hasFeatures(resourcesInBuildingIndex[r], want) && available(r)
+				}
+			}
+			if declined := declinedRooms(event); len(declined) > 0 {
+				available := slots[j].Available
+				slots[j].Available = func(r int) bool {
+					return !declined[resourcesInBuildingIndex[r].ResourceEmail] && available(r)
+				}
+			}
+			if len(slots[j].Request.Features) > 0 || *roomFeatures != "" {
+				why[j] = append(why[j], reasonFeatures)
+			}
+			// The first free preferred room of a series is booked for it.
+			for _, k := range preferredRooms(event, resourcesInBuildingIndex) {
+				if available := slots[j].Available; available(k) {
+					slots[j].Available = func(r int) bool { return r == k && available(r) }
+					why[j] = append(why[j], reasonPreferredRoom)
+					break
+				}
+			}
+			if name := requestedRoomName(event); name != "" {
+				if k := findRoom(name, aliases, resourcesInBuildingIndex); k >= 0 {
+					available := slots[j].Available
+					slots[j].Available = func(r int) bool { return r == k && available(r) }
+					why[j] = append(why[j], reasonRequestedRoom)
+				} else {
+					log.Printf("warning: no single room named '%s' for %s; choosing one", name, event.Summary)
+					why[j] = append(why[j], reasonUnknownRoom)
+				}
+			}
+			room := roomsImGoingTo[i]
+			if room != nil {
+				hist.add(event, room)
+			} else {
+				// Plan around rooms awaiting approval as if booked.
+				room = awaiting[i]
+			}
+			if room != nil {
+				k := sort.Search(len(resourcesInBuildingIndex), func(k int) bool {
+					return resourcesInBuildingIndex[k].ResourceEmail >= room.ResourceEmail
+				})
+				if k < len(resourcesInBuildingIndex) && resourcesInBuildingIndex[k].ResourceEmail == room.ResourceEmail {
+					slots[j].Fixed = k
+				} else {
+					// Booked in a resource of another category, which can't
+					// be planned around.
+					slots[j].Available = func(int) bool { return false }
+				}
 			}
 		}
-		if humans > 1 {
-			eventsImGoingTo = append(eventsImGoingTo, e)
-		}
-		return nil
-	})
-	if err != nil {
-		log.Fatalf("error: %v", err)
-	}
 
-	// Sort resources by email so we can binary search for them when looking up
-	// existing room bookings.
-	sort.Slice(resourcesInBuildingIndex, func(i, j int) bool {
-		return resourcesInBuildingIndex[i].ResourceEmail < resourcesInBuildingIndex[j].ResourceEmail
-	})
+		// Without any existing bookings, stay near the preferred location.
+		unanchored := true
+		for _, s := range slots {
+			if s.Fixed >= 0 {
+				unanchored = false
+			}
+		}
+		if unanchored {
+			if *floor != 0 && *section != 0 {
+				for j := range slots {
+					slots[j].Request.Near = []rank.Location{{Floor: *floor, Section: *section}}
+				}
+			} else {
+				// Without a location to be near, rank by capacity and popularity.
+				log.Printf("warning: no -floor and -section, and insufficient existing bookings to infer them; choosing smallest adequate rooms for %s", timeutil.Date(slots[0].Start, zone))
+			}
+		}
 
-	roomsImGoingTo := make([]*directory.CalendarResource, len(eventsImGoingTo))
-	for eNo, e := range eventsImGoingTo {
-		for _, a := range e.Attendees {
-			if !a.Resource || a.ResponseStatus != "accepted" {
+		plan := rank.Plan(w, slots, rooms)
+		desk := rank.Location{Floor: *floor, Section: *section}
+		var baseline []int
+		if *floor != 0 && *section != 0 {
+			baseline = nearestFreeRooms(slots, rooms, desk)
+		}
+		for j, i := range day {
+			event := eventsImGoingTo[i]
+			switch {
+			case roomsImGoingTo[i] != nil:
+				report.add(calendarOf[event], event, "keep", newReportedRoom(roomsImGoingTo[i], nil), nil, reasonAlreadyBooked)
+				continue
+			case awaiting[i] != nil:
+				report.add(calendarOf[event], event, "wait", newReportedRoom(awaiting[i], nil), nil, reasonAwaitingApproval)
+				continue
+			case !changedSince(event, changedAfter):
+				report.add(calendarOf[event], event, "skip", nil, nil, reasonUnchanged)
 				continue
 			}
-			i := sort.Search(len(resourcesInBuildingIndex), func(i int) bool {
-				return resourcesInBuildingIndex[i].ResourceEmail >= a.Email
-			})
-			if i < len(resourcesInBuildingIndex) {
-				r := resourcesInBuildingIndex[i]
-				if r.ResourceCategory != "CONFERENCE_ROOM" {
+			if plan[j] < 0 && outgrown[i] != nil {
+				log.Printf("No bigger room available for %s; keeping %s", event.Summary, outgrown[i].GeneratedResourceName)
+				report.add(calendarOf[event], event, "keep", newReportedRoom(outgrown[i], nil), nil, append(why[j], reasonNoRoomFree)...)
+				roomsImGoingTo[i] = outgrown[i]
+				continue
+			}
+			if old := oversized[i]; old != nil && (plan[j] < 0 || *downgradeRooms == "offer") {
+				if plan[j] < 0 {
+					log.Printf("No smaller room available for %s; keeping %s", event.Summary, old.GeneratedResourceName)
+					why[j] = append(why[j], reasonNoRoomFree)
+				} else {
+					offerDowngrade(calendarOf[event], event, old, resourcesInBuildingIndex[plan[j]])
+					why[j] = append(why[j], reasonDowngradeOffered)
+				}
+				report.add(calendarOf[event], event, "keep", newReportedRoom(old, nil), nil, why[j]...)
+				roomsImGoingTo[i] = old
+				continue
+			}
+			if plan[j] < 0 {
+				log.Printf("No rooms available for %s", event.Summary)
+				why[j] = append(why[j], reasonNoRoomFree)
+				if wl != nil {
+					wl.add(w, calendarOf[event], event, slots[j].Request, rooms, resourcesInBuildingIndex, isUsable)
+					why[j] = append(why[j], reasonWaitlisted)
+				}
+				report.add(calendarOf[event], event, "none", nil, nil, why[j]...)
+				window := interval.Interval{Start: startTime, End: endTime}
+				if s, ok := suggestAdjustment(w, event, slots[j].Request, window, rooms, resourcesInBuildingIndex, isUsable, freeBusy); ok {
+					suggestions = append(suggestions, s)
+				} else {
+					suggestions = append(suggestions, alternativeTimes(w, event, slots[j].Request, window, rooms, resourcesInBuildingIndex, isUsable, freeBusy)...)
+				}
+				continue
+			}
+			if *explain {
+				explainPlan(w, slots, j, plan, rooms, resourcesInBuildingIndex, event)
+			}
+			if *confirm {
+				k := confirmRoom(w, slots, j, plan, rooms, resourcesInBuildingIndex, event)
+				if k < 0 {
+					log.Printf("Skipping %s", event.Summary)
 					continue
 				}
-				roomsImGoingTo[eNo] = r
+				plan[j] = k
 			}
+			room := resourcesInBuildingIndex[plan[j]]
+			if !withinBudget(ctx, reserveMutations(ctx, calSrv, event)) {
+				reportUnfinished(ctx, eventsImGoingTo[i:], roomsImGoingTo[i:])
+				for k, e := range eventsImGoingTo[i:] {
+					if r := roomsImGoingTo[i+k]; r != nil {
+						report.add(calendarOf[e], e, "keep", newReportedRoom(r, nil), nil, reasonAlreadyBooked)
+					} else {
+						report.add(calendarOf[e], e, "skip", nil, nil, reasonOutOfBudget)
+					}
+				}
+				finished = false
+				break days
+			}
+			ok, err := reserve(ctx, cal, calSrv, calendarOf[event], event, room)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				report.add(calendarOf[event], event, "none", nil, nil, append(why[j], reasonReserveFailed)...)
+				continue
+			}
+			if extra := overflowRooms(event, room, resourcesInBuildingIndex, isUsable, freeBusy); len(extra) > 0 {
+				if len(bookOverflow(ctx, cal, calendarOf[event], event, extra, freeBusy)) > 0 {
+					why[j] = append(why[j], reasonOverflow)
+				}
+			}
+			if report != nil {
+				alternatives, score := reportedAlternatives(w, slots, j, plan, rooms, resourcesInBuildingIndex)
+				report.add(calendarOf[event], event, "book", newReportedRoom(room, score), alternatives, bookingReasons(why[j])...)
+			}
+			roomsImGoingTo[i] = room
+			proposed[i] = true
+			hist.add(event, room)
+			if outgrown[i] != nil {
+				releaseOutgrown(calSrv, calendarOf[event], event, outgrown[i], room)
+			}
+			if oversized[i] != nil {
+				releaseOversized(calSrv, calendarOf[event], event, oversized[i], room)
+			}
+
+			// Don't double-book the room for overlapping events.
+			fb := freeBusy[room.ResourceEmail]
+			fb.Busy = append(fb.Busy, &calendar.TimePeriod{Start: event.Start.DateTime, End: event.End.DateTime})
+			freeBusy[room.ResourceEmail] = fb
+		}
+		if baseline != nil && hasUnfixed(slots) {
+			booked := make([]*itercal.Resource, len(day))
+			for j, i := range day {
+				booked[j] = roomsImGoingTo[i]
+			}
+			logWalking(timeutil.Date(slots[0].Start, zone), desk, booked, baseline, rooms)
 		}
 	}
 
-	log.Printf("Going to:\n")
-	for i, r := range roomsImGoingTo {
-		b := strings.Builder{}
-		b.WriteString(fmt.Sprintf("  %d: ", i+1))
-		if r != nil {
-			b.WriteString(r.GeneratedResourceName)
-		} else {
-			b.WriteString("(none)")
+	logTable(msg("Booked:"), eventsImGoingTo, roomsImGoingTo)
+	reportSuggestions(suggestions)
+	if wl != nil && !*dryRun {
+		for i, e := range eventsImGoingTo {
+			if roomsImGoingTo[i] != nil {
+				delete(wl, eventKey(calendarOf[e], e.Id))
+			}
 		}
-		b.WriteString(fmt.Sprintf(" (%s)", eventsImGoingTo[i].Summary))
-		if eventsImGoingTo[i].AttendeesOmitted {
-			b.WriteString("*")
+		wl.prune(startTime)
+		wl.save(cacheSpace)
+	}
+	if len(failedCalendars) > 0 {
+		reportFailedCalendars(failedCalendars)
+		finished = false
+	}
+	if *locationsCalendar != "" && *pass == "full" && ctx.Err() == nil {
+		if err := syncLocations(ctx, calSrv, eventsImGoingTo, roomsImGoingTo, startTime, endTime); err != nil {
+			return err
+		}
+	}
+	if afterPlan != nil {
+		calendars := make([]string, len(eventsImGoingTo))
+		for i, e := range eventsImGoingTo {
+			calendars[i] = calendarOf[e]
+		}
+		afterPlan(eventsImGoingTo, calendars, roomsImGoingTo, proposed)
+	}
+	if p != nil {
+		p.finished = finished
+	} else if finished && !*dryRun {
+		recordPass(cacheSpace, passStart)
+	}
+	if !*dryRun {
+		booked, unbooked := 0, 0
+		for i := range eventsImGoingTo {
+			if proposed[i] {
+				booked++
+			}
+			if roomsImGoingTo[i] == nil {
+				unbooked++
+			}
 		}
-		log.Print(b.String())
+		countTelemetry(len(eventsImGoingTo), booked, unbooked)
+	}
+	log.Printf("Done in %s", time.Since(passStart).Round(time.Millisecond))
+	if *verbose {
+		logMemory()
 	}
 
-	freeBusyWg.Wait()
+	// TODO: preferred or disallowed list?
+	return nil
+}
 
-	for i, r := range roomsImGoingTo {
-		event := eventsImGoingTo[i]
-		if r != nil {
-			continue
-		}
-		var prevRoom, nextRoom *directory.CalendarResource
-		if i > 0 {
-			prevRoom = roomsImGoingTo[i-1]
+// goingTo returns true if the user is going to event e and it needs a room:
+// it is tagged, or the user has accepted it along with someone else.
+func goingTo(e *calendar.Event, colorOK func(*calendar.Event) bool) bool {
+	if e.Start.DateTime == "" {
+		// all day event
+		return false
+	}
+	if !colorOK(e) {
+		return false
+	}
+	if e.Status == "cancelled" {
+		return false
+	}
+	if e.Transparency == "transparent" {
+		return false
+	}
+	if isHold(e) || hasHold(e) || isForeignHold(e) {
+		return false
+	}
+	if isTagged(e) {
+		return true
+	}
+
+	// Check for humans >= 2
+	humans := 0
+	for _, a := range e.Attendees {
+		if a.Self && (a.ResponseStatus == "declined" || a.ResponseStatus == "needsAction") {
+			return false
 		}
-		if i < len(roomsImGoingTo)-1 && roomsImGoingTo[i+1] != nil {
-			nextRoom = roomsImGoingTo[i+1]
+		if !a.Resource && a.ResponseStatus != "declined" {
+			humans++
 		}
+	}
+	return humans > 1
+}
 
-		// Create a ranked list of all rooms in building based on
-		// min(distance(priorRoom), distance(nextRoom))
+// scannedCalendars returns the IDs of -calendar and -other-calendars.
+func scannedCalendars() []string {
+	ids := []string{*calendarId}
+	for _, id := range strings.Split(*otherCalendars, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
 
-		idxs := make([]int, len(resourcesInBuildingIndex))
-		for j := range idxs {
-			idxs[j] = j
+// reserve books room for event on calendar calId with cal, returning false if
+// it is too late to (see tooLate). calSrv serves what cal doesn't, e.g. finding
+// organizers' copies. Changes underway are finished even if ctx is done.
+func reserve(ctx context.Context, cal itercal.CalendarProvider, calSrv *calendar.Service, calId string, event *calendar.Event, room *itercal.Resource) (bool, error) {
+	// Check again at the time of booking, as long runs may outlast events.
+	if why := tooLate(event, time.Now()); why != "" {
+		log.Printf("Not booking %s: %s", event.Summary, why)
+		return false, nil
+	}
+	if p := externalProvider(); p != nil {
+		if err := reserveProvided(p, calSrv, calId, event, room); err != nil {
+			return false, err
 		}
-		sort.Slice(idxs, func(i, j int) bool {
-			if prevRoom == nil && nextRoom == nil {
-				if *floor == 0 || *section == 0 {
-					log.Printf("must provide -floor and -section (insufficient existing bookings to infer)")
-					os.Exit(1)
-				}
-				prefLoc := &directory.CalendarResource{
-					FloorName:    fmt.Sprintf("%d", *floor),
-					FloorSection: fmt.Sprintf("%d", *section),
-				}
-				return distance(prefLoc, resourcesInBuildingIndex[idxs[i]]) <
-					distance(prefLoc, resourcesInBuildingIndex[idxs[j]])
+		return true, nil
+	}
+	var err error
+	roomAttendee := &calendar.EventAttendee{Email: room.ResourceEmail}
+	tagged := isTagged(event)
+	if usesHold(ctx, calSrv, event) {
+		// Create a new entry
+		hold := &calendar.Event{
+			Summary:        fmt.Sprintf("Room for '%s'", markBooked(event.Summary)),
+			Attendees:      []*calendar.EventAttendee{roomAttendee},
+			ColorId:        event.ColorId,
+			ConferenceData: event.ConferenceData,
+			Description:    markBooked(event.Description),
+			HangoutLink:    event.HangoutLink,
+			Location:       event.Location,
+			Transparency:   event.Transparency,
+			Visibility:     event.Visibility,
+		}
+		if holdPrivacyFor(event) == "private" {
+			// Private events appear only as busy to those who can see the
+			// room's calendar, while the details remain on the user's.
+			hold.Visibility = "private"
+		}
+		if loc, ok := locationWithRoom(event.Location, room); ok && *setLocationFlag {
+			hold.Location = loc
+		}
+		hold.Start, hold.End = holdTimes(event)
+		linkToSource(hold, event)
+		if attachToHolds() {
+			hold.Attachments = holdAttachments(event)
+		}
+		log.Printf("Creating %s - %s", hold.Summary, room.GeneratedResourceName)
+		var created *calendar.Event
+		if !*dryRun {
+			atomic.AddInt64(&mutations, 1)
+			if created, err = cal.InsertEvent(context.Background(), calId, hold); err != nil {
+				return false, fmt.Errorf("booking %s for %s: %v", room.GeneratedResourceName, event.Summary, err)
 			}
+		}
+		patch := new(calendar.Event)
+		if !event.AttendeesOmitted && tagged {
+			// Remove room tag from original entry
+			log.Printf("Removing room tag from %s", event.Summary)
+			patch.Summary = markBooked(event.Summary)
+			patch.Description = markBooked(event.Description)
+		}
+		if created != nil {
+			// Link original entry to the hold
+			linkToHold(patch, created)
+		}
+		if !*dryRun {
+			atomic.AddInt64(&mutations, 1)
+			if _, err = cal.PatchEvent(context.Background(), calId, event.Id, patch); err != nil {
+				return false, fmt.Errorf("linking %s to its room hold: %v", event.Summary, err)
+			}
+		}
+	} else if org := organizerCopy(ctx, calSrv, event); org != nil {
+		// Patch into the organizer's entry, which updates all attendees'
+		log.Printf("Adding %s for %s on the organizer's calendar\n", room.GeneratedResourceName, event.Summary)
+		patch := new(calendar.Event)
+		patch.Attendees = append([]*calendar.EventAttendee(nil), org.Attendees...)
+		patch.Attendees = append(patch.Attendees, roomAttendee)
+		setLocation(patch, org, room)
+		if !*dryRun {
+			atomic.AddInt64(&mutations, 1)
+			if _, err := cal.PatchEvent(context.Background(), event.Organizer.Email, org.Id, patch); err != nil {
+				return false, fmt.Errorf("booking %s for %s: %v", room.GeneratedResourceName, event.Summary, err)
+			}
+		}
+	} else {
+		// Patch into existing entry
+		log.Printf("Adding %s for %s\n", room.GeneratedResourceName, event.Summary)
+		patch := new(calendar.Event)
+		patch.Attendees = append([]*calendar.EventAttendee(nil), event.Attendees...)
+		patch.Attendees = append(patch.Attendees, roomAttendee)
+		setLocation(patch, event, room)
+		setPrivateProperty(patch, roomProperty, room.ResourceEmail)
+		if !*dryRun {
+			atomic.AddInt64(&mutations, 1)
+			if _, err := cal.PatchEvent(context.Background(), calId, event.Id, patch); err != nil {
+				return false, fmt.Errorf("booking %s for %s: %v", room.GeneratedResourceName, event.Summary, err)
+			}
+		}
+		if patch.Location != "" {
+			// So that the room it replaces can be withdrawn from it.
+			event.Location = patch.Location
+		}
+	}
+	event.Attendees = append(event.Attendees, roomAttendee)
+	return true, nil
+}
 
-			di_prev := distance(prevRoom, resourcesInBuildingIndex[idxs[i]])
-			di_next := distance(nextRoom, resourcesInBuildingIndex[idxs[i]])
-			dj_prev := distance(prevRoom, resourcesInBuildingIndex[idxs[j]])
-			dj_next := distance(nextRoom, resourcesInBuildingIndex[idxs[j]])
-			return min(di_prev, di_next) < min(dj_prev, dj_next)
-		})
+// tooLate returns why it is too late at now to book a room for event, or ""
+// if it isn't: rooms are never booked for events that have ended, nor for
+// those in progress without -include-started, unless they started within
+// -started-within.
+func tooLate(event *calendar.Event, now time.Time) string {
+	e := interval.OrDie(event.Start.DateTime, event.End.DateTime)
+	switch {
+	case !e.End.After(now):
+		return "already ended"
+	case e.Start.Before(now) && !*includeStarted && now.Sub(e.Start) > *startedWithin:
+		return "already started (see -include-started and -started-within)"
+	}
+	return ""
+}
 
-		/*
-			log.Printf("room preferences for %s:", event.Summary)
-			for _, r := range idxs[:5] {
-				log.Printf("  %s", resourcesInBuildingIndex[r].GeneratedResourceName)
+// notStarted removes events without a room that it is too late at now to book
+// rooms for. rooms holds the room booked for each event, and is filtered along
+// with events.
+func notStarted(events []*calendar.Event, rooms []*itercal.Resource, now time.Time) ([]*calendar.Event, []*itercal.Resource) {
+	var retEvents []*calendar.Event
+	var retRooms []*itercal.Resource
+	for i, e := range events {
+		if rooms[i] == nil {
+			if why := tooLate(e, now); why != "" {
+				log.Printf("Skipping %s: %s", e.Summary, why)
+				continue
 			}
-		*/
+		}
+		retEvents = append(retEvents, e)
+		retRooms = append(retRooms, rooms[i])
+	}
+	return retEvents, retRooms
+}
 
-		// book the first one that is free
-	rooms:
-		for _, idx := range idxs {
-			room := resourcesInBuildingIndex[idx]
+// withinHorizon removes events without a room that start beyond
+// -booking-horizon after now, since rooms would decline them. rooms holds the
+// room booked for each event, and is filtered along with events.
+func withinHorizon(events []*calendar.Event, rooms []*itercal.Resource, now time.Time) ([]*calendar.Event, []*itercal.Resource) {
+	if *bookingHorizon <= 0 {
+		return events, rooms
+	}
+	horizon := now.Add(*bookingHorizon)
+	var retEvents []*calendar.Event
+	var retRooms []*itercal.Resource
+	for i, e := range events {
+		if rooms[i] == nil && interval.OrDie(e.Start.DateTime, e.End.DateTime).Start.After(horizon) {
+			log.Printf("Skipping %s: starts after the booking horizon (%s)", e.Summary, timeutil.Format(horizon, zone))
+			continue
+		}
+		retEvents = append(retEvents, e)
+		retRooms = append(retRooms, rooms[i])
+	}
+	return retEvents, retRooms
+}
 
-			fb, ok := freeBusy[room.ResourceEmail]
-			if !ok {
-				log.Printf("failed to find free/busy calendar for %s", room.ResourceEmail)
-				continue rooms
-			}
-			for _, timePeriod := range fb.Busy {
-				e := interval.OrDie(event.Start.DateTime, event.End.DateTime)
-				busy := interval.OrDie(timePeriod.Start, timePeriod.End)
-				if e.Overlaps(busy) {
-					continue rooms
-				}
-			}
+// isTagged returns true if event is tagged with one of -room-tags.
+func isTagged(event *calendar.Event) bool {
+	return hasRoomTag(event.Summary) || hasRoomTag(event.Description)
+}
 
-			// Book the room
-			roomAttendee := &calendar.EventAttendee{Email: room.ResourceEmail}
-			if event.AttendeesOmitted || strings.Contains(event.Summary, roomTag) || strings.Contains(event.Description, roomTag) {
-				// Create a new entry
-				hold := &calendar.Event{
-					Summary:        fmt.Sprintf("Room for '%s'", strings.ReplaceAll(event.Summary, roomTag, roomTagDone)),
-					Attachments:    event.Attachments,
-					Attendees:      []*calendar.EventAttendee{roomAttendee},
-					ColorId:        event.ColorId,
-					ConferenceData: event.ConferenceData,
-					Description:    strings.ReplaceAll(event.Description, roomTag, roomTagDone),
-					HangoutLink:    event.HangoutLink,
-					Start:          event.Start,
-					End:            event.End,
-					Location:       event.Location,
-					Transparency:   event.Transparency,
-					Visibility:     event.Visibility,
-				}
-				log.Printf("Creating %s - %s", hold.Summary, room.GeneratedResourceName)
-				if !*dryRun {
-					if _, err := calSrv.Events.Insert(*calendarId, hold).SendUpdates("none").Do(); err != nil {
-						log.Fatal(err)
-					}
-				}
-				if !event.AttendeesOmitted {
-					// Remove room tag from original entry
-					log.Printf("Removing #room tag from %s", event.Summary)
-					patch := &calendar.Event{
-						Summary:     strings.ReplaceAll(event.Summary, roomTag, roomTagDone),
-						Description: strings.ReplaceAll(event.Description, roomTag, roomTagDone),
-					}
-					if !*dryRun {
-						if _, err = calSrv.Events.Patch(*calendarId, event.Id, patch).SendUpdates("none").Do(); err != nil {
-							log.Fatal(err)
-						}
-					}
-				}
-			} else {
-				// Patch into existing entry
-				log.Printf("Adding %s for %s\n", room.GeneratedResourceName, event.Summary)
-				patch := new(calendar.Event)
-				patch.Attendees = append([]*calendar.EventAttendee(nil), event.Attendees...)
-				patch.Attendees = append(patch.Attendees, roomAttendee)
-				pc := calSrv.Events.Patch(*calendarId, event.Id, patch).
-					SendUpdates("none")
-				if !*dryRun {
-					_, err := pc.Do()
-					if err != nil {
-						log.Fatal(err)
-					}
-				}
-			}
-			event.Attendees = append(event.Attendees, roomAttendee)
-			break
+// usesHold returns true if reserve books a room for event in a separate hold
+// rather than adding it to the event. Rooms for events whose attendees are
+// omitted are added to the organizer's copy instead, if possible.
+func usesHold(ctx context.Context, calSrv *calendar.Service, event *calendar.Event) bool {
+	if isTagged(event) || *speedy > 0 {
+		return true
+	}
+	// Rooms would decline meetings in progress if busy before now, so they
+	// are booked from now on in holds.
+	if interval.OrDie(event.Start.DateTime, event.End.DateTime).Start.Before(time.Now()) {
+		return true
+	}
+	if t := eventTemplate(event); t != nil && t.Hold {
+		return true
+	}
+	return event.AttendeesOmitted && organizerCopy(ctx, calSrv, event) == nil
+}
+
+// holdTimes returns the start and end of a room hold for event, trimmed
+// according to -speedy. Holds for meetings in progress start now.
+func holdTimes(event *calendar.Event) (start, end *calendar.EventDateTime) {
+	start, end = event.Start, event.End
+	e := interval.OrDie(event.Start.DateTime, event.End.DateTime)
+	if *speedy > 0 && *speedy < e.Duration()/2 { // else too short to trim
+		if *speedyStart {
+			start = &calendar.EventDateTime{DateTime: timeutil.Format(e.Start.Add(*speedy), zone), TimeZone: event.Start.TimeZone}
+		} else {
+			end = &calendar.EventDateTime{DateTime: timeutil.Format(e.End.Add(-*speedy), zone), TimeZone: event.End.TimeZone}
 		}
+	}
+	if now := time.Now().Truncate(time.Minute); e.Start.Before(now) && start == event.Start {
+		start = &calendar.EventDateTime{DateTime: timeutil.Format(now, zone), TimeZone: event.Start.TimeZone}
+	}
+	return
+}
 
-		// TODO:
-		//   - Start fetching (cached) free/busy calendars for the whole day for those
-		//     rooms in ranked order
-		//   - Attempt to add the room to the corresponding Event in eventsImGoingTo,
-		//     iterating through rooms until it works
-		//   - Add the room to roomsImGoingTo, proceed to next
+// busySet returns the times during which fb is busy.
+func busySet(fb calendar.FreeBusyCalendar) interval.Set {
+	busy := make([]interval.Interval, len(fb.Busy))
+	for i, tp := range fb.Busy {
+		busy[i] = interval.OrDie(tp.Start, tp.End)
 	}
+	return interval.NewSet(busy...)
+}
 
-	// TODO: preferred or disallowed list?
+// isFree returns true if the calendar identified by email in freeBusy is free
+// during e.
+func isFree(freeBusy map[string]calendar.FreeBusyCalendar, email string, e interval.Interval) bool {
+	fb, ok := freeBusy[email]
+	if !ok {
+		return false
+	}
+	return !busySet(fb).Overlaps(e)
+}
+
+// days groups the indexes of events by the day in zone on which they start.
+// Events must be ordered by start time.
+func days(events []*calendar.Event) [][]int {
+	var ret [][]int
+	prev := ""
+	for i, e := range events {
+		d := timeutil.Date(interval.OrDie(e.Start.DateTime, e.End.DateTime).Start, zone)
+		if d != prev {
+			ret = append(ret, nil)
+			prev = d
+		}
+		ret[len(ret)-1] = append(ret[len(ret)-1], i)
+	}
+	return ret
+}
+
+// explainPlan logs the top candidate rooms for slot j given the rooms planned
+// for the slots around it.
+func explainPlan(w rank.Weights, slots []rank.Slot, j int, plan []int, rooms []rank.Room, resources []*itercal.Resource, event *calendar.Event) {
+	const n = 5
+	idxs, scores := rankCandidates(w, slots, j, plan, rooms)
+	log.Printf("room preferences for %s:", event.Summary)
+	shown := 0
+	for _, idx := range idxs {
+		if shown == n {
+			break
+		}
+		if !slots[j].Available(idx) {
+			continue
+		}
+		shown++
+		marker := ""
+		if idx == plan[j] {
+			marker = " *"
+		}
+		log.Printf("  %d: %s (%s)%s", shown, resources[idx].GeneratedResourceName, scores[idx], marker)
+	}
+}
 
+// rankCandidates ranks rooms for slot j given the rooms planned for the slots
+// around it, returning the indexes of rooms, best first, and the score of each
+// room.
+func rankCandidates(w rank.Weights, slots []rank.Slot, j int, plan []int, rooms []rank.Room) ([]int, []rank.Score) {
+	req := slots[j].Request
+	for _, k := range []int{j - 1, j + 1} {
+		if k >= 0 && k < len(plan) && plan[k] >= 0 {
+			req.Near = append(req.Near, rooms[plan[k]].Location)
+		}
+	}
+	return rank.Rank(w, req, rooms)
 }
 
-func distance(r1, r2 *directory.CalendarResource) int {
-	if r1 == nil || r2 == nil {
-		return math.MaxInt
+// bookedRoom returns the resource in resources of one of the -room-categories
+// that has accepted e, or with an external provider the room noted on e, or nil
+// if there is none. Resources must be sorted by email.
+func bookedRoom(e *calendar.Event, resources []*itercal.Resource) *itercal.Resource {
+	if externalProvider() != nil {
+		return notedRoom(e, resources)
 	}
-	// Distances in approximate meters
-	const (
-		subsequentChangeOfSection = 5
-		firstChangeOfSection      = 5
+	var ret *itercal.Resource
+	for _, a := range e.Attendees {
+		if !a.Resource || a.ResponseStatus != "accepted" {
+			continue
+		}
+		if r := roomResource(a.Email, resources); r != nil {
+			ret = r
+		}
+	}
+	return ret
+}
 
-		subsequentChangeOfFloor = 10
-		firstChangeOfFloor      = firstChangeOfSection + subsequentChangeOfFloor
-	)
+// roomResource returns the resource in resources with email if it is of one
+// of the -room-categories, or nil otherwise. Resources must be sorted by
+// email.
+func roomResource(email string, resources []*itercal.Resource) *itercal.Resource {
+	i := sort.Search(len(resources), func(i int) bool {
+		return resources[i].ResourceEmail >= email
+	})
+	if i == len(resources) || resources[i].ResourceEmail != email {
+		return nil
+	}
+	for _, c := range strings.Split(*roomCategories, ",") {
+		if strings.TrimSpace(c) == resources[i].ResourceCategory {
+			return resources[i]
+		}
+	}
+	return nil
+}
 
-	distance := 0
-	f1, f2 := intOrDie(r1.FloorName), intOrDie(r2.FloorName)
-	s1, s2 := intOrDie(r1.FloorSection), intOrDie(r2.FloorSection)
-	if f1 != f2 {
-		distance += firstChangeOfFloor
-		distance += (abs(f1-f2) - 1) * subsequentChangeOfFloor
+// scoringWeights returns the weights selected by -preset and -weights.
+func scoringWeights() rank.Weights {
+	base, ok := rank.Presets[*preset]
+	if !ok {
+		log.Fatalf("unknown preset '%s'", *preset)
 	}
-	if s1 != s2 {
-		distance += firstChangeOfSection
-		distance += (abs(s1-s2) - 1) * subsequentChangeOfSection
+	w, err := rank.ParseWeights(*weights, base)
+	if err != nil {
+		log.Fatalf("parsing -weights: %v", err)
 	}
-	return distance
+	return w
 }
 
-func intOrDie(s string) int {
-	if x, err := strconv.ParseInt(s, 10, 64); err != nil {
-		log.Fatalf("'%s' cannot be converted to int: %v", s, err)
-	} else {
-		return int(x)
+// attendeeCount returns the number of people attending e.
+func attendeeCount(e *calendar.Event) int64 {
+	var n int64
+	for _, a := range e.Attendees {
+		if !a.Resource && a.ResponseStatus != "declined" {
+			n++
+		}
 	}
-	panic("unreachable") // suppress compiler error
+	return n
 }
 
-func abs(x int) int {
-	if x < 0 {
-		return -x
+// busyTimes returns the total time each calendar in freeBusy is busy.
+func busyTimes(freeBusy map[string]calendar.FreeBusyCalendar) map[string]time.Duration {
+	ret := make(map[string]time.Duration)
+	for email, fb := range freeBusy {
+		ret[email] = busySet(fb).Duration()
 	}
-	return x
+	return ret
 }
 
-func min[T constraints.Ordered](x, y T) T {
-	if x < y {
-		return x
+// location returns the location of r within its building.
+func location(r *itercal.Resource) rank.Location {
+	l, ok := roomLocation(r)
+	if !ok {
+		log.Fatalf("%s is on unknown floor '%s' or section '%s'", r.GeneratedResourceName, r.FloorName, r.FloorSection)
 	}
-	return y
+	return l
 }
@@ -0,0 +1,2698 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/vsekhar/gocal/internal/fakegoogle"
+	"github.com/vsekhar/gocal/internal/interval"
+	"github.com/vsekhar/gocal/internal/itercal"
+	"github.com/vsekhar/gocal/internal/timeutil"
+	"golang.org/x/oauth2"
+	directory "google.golang.org/api/admin/directory/v1"
+	"google.golang.org/api/calendar/v3"
+)
+
+const testUser = "user@example.com"
+
+// setupFake starts a fake Google server with one building containing rooms
+// room-a and room-b, and points gocal's flags at it.
+func setupFake(t *testing.T) *fakegoogle.Server {
+	t.Helper()
+	fake := fakegoogle.NewServer(testUser)
+	t.Cleanup(fake.Close)
+
+	fake.AddBuilding(&directory.Building{BuildingId: "tst-1", BuildingName: "Test building"})
+	for _, r := range []*directory.CalendarResource{
+		{ResourceEmail: "room-a@resource.example.com", GeneratedResourceName: "Room A", BuildingId: "tst-1", FloorName: "1", FloorSection: "1", Capacity: 4},
+		{ResourceEmail: "room-b@resource.example.com", GeneratedResourceName: "Room B", BuildingId: "tst-1", FloorName: "1", FloorSection: "2", Capacity: 4},
+	} {
+		r.ResourceCategory = "CONFERENCE_ROOM"
+		fake.AddResource(r)
+	}
+
+	dir := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", filepath.Join(dir, "cache"))
+	t.Setenv("HOME", dir)
+	writeJSON := func(name string, v interface{}) string {
+		p := filepath.Join(dir, name)
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, b, 0600); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	creds := writeJSON("credentials.json", map[string]interface{}{
+		"installed": map[string]interface{}{
+			"client_id":     "id",
+			"client_secret": "secret",
+			"auth_uri":      fake.URL() + "/auth",
+			"token_uri":     fake.URL() + "/token",
+			"redirect_uris": []string{"http://localhost"},
+		},
+	})
+	token := writeJSON("token.json", &oauth2.Token{AccessToken: "token", Expiry: time.Now().Add(time.Hour)})
+
+	setFlag(t, "credentials", creds)
+	setFlag(t, "token", token)
+	setFlag(t, "calendar-endpoint", fake.CalendarEndpoint())
+	setFlag(t, "directory-endpoint", fake.DirectoryEndpoint())
+	setFlag(t, "building", "tst-1")
+	setFlag(t, "config", filepath.Join(dir, "config.json"))
+	setFlag(t, "floor", "1")
+	setFlag(t, "section", "1")
+	return fake
+}
+
+// setFlag sets the flag with name to value for the rest of test t.
+func setFlag(t *testing.T, name, value string) {
+	t.Helper()
+	f := flag.Lookup(name)
+	if f == nil {
+		t.Fatalf("no flag -%s", name)
+	}
+	old := f.Value.String()
+	if err := flag.Set(name, value); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Value.Set(old) })
+}
+
+// at returns t as the start or end of an event.
+func at(t time.Time) *calendar.EventDateTime {
+	return &calendar.EventDateTime{DateTime: timeutil.Format(t, time.Local)}
+}
+
+// hoursAhead returns the top of the hour n hours from now, e.g. for events
+// that haven't started by the time a test books rooms.
+func hoursAhead(n int) time.Time {
+	return time.Now().Add(time.Duration(n) * time.Hour).Truncate(time.Hour)
+}
+
+func TestResolveBuilding(t *testing.T) {
+	fake := setupFake(t)
+	dirSrv, _ := newServices(context.Background())
+	cacheSpace := openCache()
+	resolve := func(building string) (string, int64) {
+		t.Helper()
+		setFlag(t, "building", building)
+		before := atomic.LoadInt64(&apiCalls)
+		if err := resolveBuilding(context.Background(), cacheSpace, dirSrv); err != nil {
+			t.Fatalf("resolving %q: %v", building, err)
+		}
+		return *buildingId, atomic.LoadInt64(&apiCalls) - before
+	}
+	fake.AddBuilding(&directory.Building{BuildingId: "anx-1", BuildingName: "Annex"})
+	resolve("Test building") // caches the index
+
+	// Names and IDs are resolved with the cached index, without looking up
+	// names as IDs.
+	for building, want := range map[string]string{"Test building": "tst-1", "tst-1": "tst-1", "annex": "anx-1"} {
+		if id, n := resolve(building); id != want || n != 0 {
+			t.Errorf("resolved %q as %q with %d API calls, want %s with none", building, id, n, want)
+		}
+	}
+	// Buildings newer than the index are looked up by ID.
+	fake.AddBuilding(&directory.Building{BuildingId: "tst-2", BuildingName: "New building"})
+	if id, _ := resolve("tst-2"); id != "tst-2" {
+		t.Errorf("resolved tst-2 as %q", id)
+	}
+}
+
+func TestBook(t *testing.T) {
+	fake := setupFake(t)
+
+	start := hoursAhead(2)
+	// Room A, nearest the preferred location, is already taken.
+	fake.AddEvent("room-a@resource.example.com", &calendar.Event{
+		Summary: "Someone else's meeting",
+		Start:   at(start),
+		End:     at(start.Add(time.Hour)),
+	})
+	id := fake.AddEvent(testUser, &calendar.Event{
+		Summary: "Sync",
+		Start:   at(start),
+		End:     at(start.Add(30 * time.Minute)),
+		Attendees: []*calendar.EventAttendee{
+			{Email: testUser, ResponseStatus: "accepted"},
+			{Email: "other@example.com", ResponseStatus: "accepted"},
+		},
+	})
+	tagged := fake.AddEvent(testUser, &calendar.Event{
+		Summary: "Focus #room",
+		Start:   at(start.Add(2 * time.Hour)),
+		End:     at(start.Add(3 * time.Hour)),
+	})
+
+	book(context.Background())
+
+	room := func(e *calendar.Event) string {
+		for _, a := range e.Attendees {
+			if a.Resource && a.ResponseStatus == "accepted" {
+				return a.Email
+			}
+		}
+		return ""
+	}
+	if got, want := room(fake.Event(testUser, id)), "room-b@resource.example.com"; got != want {
+		t.Errorf("Sync booked in %q, want %q", got, want)
+	}
+	if got, want := fake.Event(testUser, id).Location, "Room B"; got != want {
+		t.Errorf("Sync location is %q, want %q", got, want)
+	}
+	e := fake.Event(testUser, tagged)
+	if e.Summary != "Focus #addedroom" {
+		t.Errorf("tagged event summary is %q, want tag replaced", e.Summary)
+	}
+	if !hasHold(e) {
+		t.Fatalf("tagged event not linked to a hold")
+	}
+	hold := fake.Event(testUser, privateProperty(e, holdEventIdProperty))
+	if hold == nil {
+		t.Fatalf("hold %s not found", privateProperty(e, holdEventIdProperty))
+	}
+	if got, want := room(hold), "room-a@resource.example.com"; got != want {
+		t.Errorf("hold booked in %q, want %q", got, want)
+	}
+}
+
+// fakeCalendar is a CalendarProvider holding events in memory.
+type fakeCalendar struct {
+	events  map[string][]*calendar.Event // by calendar ID
+	busy    map[string]calendar.FreeBusyCalendar
+	patches map[string]*calendar.Event // by event ID
+	inserts []*calendar.Event
+}
+
+func (c *fakeCalendar) ListEvents(ctx context.Context, calendarId string, start, end time.Time, loc *time.Location, f func(*calendar.Event) error) error {
+	for _, e := range c.events[calendarId] {
+		if err := f(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *fakeCalendar) FreeBusy(ctx context.Context, ids []string, start, end time.Time, loc *time.Location) (map[string]calendar.FreeBusyCalendar, error) {
+	ret := make(map[string]calendar.FreeBusyCalendar)
+	for _, id := range ids {
+		ret[id] = c.busy[id]
+	}
+	return ret, nil
+}
+
+func (c *fakeCalendar) PatchEvent(ctx context.Context, calendarId, eventId string, patch *calendar.Event) (*calendar.Event, error) {
+	c.patches[eventId] = patch
+	return patch, nil
+}
+
+func (c *fakeCalendar) InsertEvent(ctx context.Context, calendarId string, event *calendar.Event) (*calendar.Event, error) {
+	c.inserts = append(c.inserts, event)
+	return event, nil
+}
+
+func TestBookWithProvider(t *testing.T) {
+	fake := setupFake(t)
+	start := hoursAhead(2)
+	// Rooms are booked for the provider's events, when free according to
+	// it, with it rather than with Google Calendar.
+	cal := &fakeCalendar{
+		events: map[string][]*calendar.Event{"primary": {{
+			Id:      "sync",
+			Summary: "Sync",
+			Start:   at(start),
+			End:     at(start.Add(30 * time.Minute)),
+			Attendees: []*calendar.EventAttendee{
+				{Email: testUser, ResponseStatus: "accepted"},
+				{Email: "other@example.com", ResponseStatus: "accepted"},
+			},
+		}}},
+		busy: map[string]calendar.FreeBusyCalendar{
+			"room-a@resource.example.com": {Busy: []*calendar.TimePeriod{{Start: at(start).DateTime, End: at(start.Add(time.Hour)).DateTime}}},
+		},
+		patches: make(map[string]*calendar.Event),
+	}
+	old := newCalendarProvider
+	newCalendarProvider = func(*calendar.Service) itercal.CalendarProvider { return cal }
+	defer func() { newCalendarProvider = old }()
+
+	book(context.Background())
+
+	patch := cal.patches["sync"]
+	if patch == nil || len(patch.Attendees) != 3 || patch.Attendees[2].Email != "room-b@resource.example.com" {
+		t.Errorf("got patch %+v of Sync, want Room B added", patch)
+	}
+	if len(cal.inserts) != 0 || len(fake.Events(testUser)) != 0 {
+		t.Errorf("got holds %v with the provider and events %v in Google Calendar, want none", cal.inserts, fake.Events(testUser))
+	}
+}
+
+func TestDedupeEvents(t *testing.T) {
+	at := func(s string) *calendar.EventDateTime { return &calendar.EventDateTime{DateTime: s} }
+	room := &itercal.Resource{ResourceEmail: "room@resource.example.com"}
+	events := []*calendar.Event{
+		{Id: "invite", ICalUID: "a", Start: at("2022-04-01T09:00:00Z")},
+		{Id: "copy", ICalUID: "a", Start: at("2022-04-01T09:00:00Z")},
+		{Id: "next-instance", ICalUID: "a", Start: at("2022-04-01T10:00:00Z")},
+		{Id: "no-uid", Start: at("2022-04-01T09:00:00Z")},
+		{Id: "no-uid-2", Start: at("2022-04-01T09:00:00Z")},
+	}
+	rooms := []*itercal.Resource{nil, room, nil, nil, nil}
+	gotEvents, gotRooms := dedupeEvents(events, rooms)
+	var ids []string
+	for _, e := range gotEvents {
+		ids = append(ids, e.Id)
+	}
+	if want := "copy next-instance no-uid no-uid-2"; strings.Join(ids, " ") != want {
+		t.Errorf("got %v, want %s", ids, want)
+	}
+	if len(gotRooms) != len(gotEvents) || gotRooms[0] != room {
+		t.Errorf("rooms not filtered with events: %v", gotRooms)
+	}
+}
+
+func TestBookBudget(t *testing.T) {
+	fake := setupFake(t)
+	setFlag(t, "max-mutations", "1")
+	atomic.StoreInt64(&mutations, 0)
+
+	start := hoursAhead(2)
+	attendees := []*calendar.EventAttendee{
+		{Email: testUser, ResponseStatus: "accepted"},
+		{Email: "other@example.com", ResponseStatus: "accepted"},
+	}
+	first := fake.AddEvent(testUser, &calendar.Event{Summary: "First", Start: at(start), End: at(start.Add(time.Hour)), Attendees: attendees})
+	second := fake.AddEvent(testUser, &calendar.Event{Summary: "Second", Start: at(start.Add(time.Hour)), End: at(start.Add(2 * time.Hour)), Attendees: attendees})
+
+	book(context.Background())
+
+	if n := len(fake.Event(testUser, first).Attendees); n != 3 {
+		t.Errorf("first event has %d attendees, want 3 including a room", n)
+	}
+	if n := len(fake.Event(testUser, second).Attendees); n != 2 {
+		t.Errorf("second event has %d attendees, want 2 (budget exhausted)", n)
+	}
+}
+
+func TestBookAsDelegate(t *testing.T) {
+	fake := setupFake(t)
+	const boss = "boss@example.com"
+	fake.SetAccess(boss, "writer")
+
+	start := hoursAhead(2)
+	event := func() *calendar.Event {
+		return &calendar.Event{
+			Id:        "meeting",
+			Summary:   "Boss's meeting",
+			Start:     at(start),
+			End:       at(start.Add(time.Hour)),
+			Organizer: &calendar.EventOrganizer{Email: boss},
+			Attendees: []*calendar.EventAttendee{
+				{Email: boss, ResponseStatus: "accepted"},
+				{Email: testUser, ResponseStatus: "accepted"},
+			},
+		}
+	}
+	fake.AddEvent(boss, event())
+	fake.AddEvent(testUser, event())
+
+	// Attendees the organizer adds after a pass are kept by the next.
+	setFlag(t, "dryrun", "true")
+	book(context.Background())
+	_, calSrv, err := fake.Services(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	attendees := append(event().Attendees, &calendar.EventAttendee{Email: "late@example.com"})
+	if _, err := calSrv.Events.Patch(boss, "meeting", &calendar.Event{Attendees: attendees}).Do(); err != nil {
+		t.Fatal(err)
+	}
+	setFlag(t, "dryrun", "false")
+	book(context.Background())
+
+	e := fake.Event(boss, "meeting")
+	if len(e.Attendees) != 4 || e.Attendees[2].Email != "late@example.com" || !e.Attendees[3].Resource {
+		t.Errorf("room not added to organizer's current copy: %+v", e.Attendees)
+	}
+}
+
+func TestLocationsCalendar(t *testing.T) {
+	fake := setupFake(t)
+	setFlag(t, "locations-calendar", "gocal locations")
+
+	start := hoursAhead(2)
+	fake.AddEvent(testUser, &calendar.Event{
+		Summary: "Sync",
+		Start:   at(start),
+		End:     at(start.Add(30 * time.Minute)),
+		Attendees: []*calendar.EventAttendee{
+			{Email: testUser, ResponseStatus: "accepted"},
+			{Email: "other@example.com", ResponseStatus: "accepted"},
+		},
+	})
+
+	for run := 0; run < 2; run++ {
+		book(context.Background())
+		ids := fake.Calendars("gocal locations")
+		if len(ids) != 1 {
+			t.Fatalf("run %d: got %d locations calendars, want 1", run, len(ids))
+		}
+		events := fake.Events(ids[0])
+		if len(events) != 1 || events[0].Summary != "Room A (floor 1)" {
+			t.Errorf("run %d: got locations %+v, want one event in Room A", run, events)
+		}
+	}
+}
+
+func TestQuietHours(t *testing.T) {
+	var w clockWindow
+	if err := w.Set("22:30-07:00"); err != nil {
+		t.Fatal(err)
+	}
+	at := func(h, m int) time.Time { return time.Date(2022, 4, 1, h, m, 0, 0, time.UTC) }
+	cases := []struct {
+		t     time.Time
+		until time.Time
+		quiet bool
+	}{
+		{at(22, 0), time.Time{}, false},
+		{at(23, 0), time.Date(2022, 4, 2, 7, 0, 0, 0, time.UTC), true},
+		{at(6, 59), at(7, 0), true},
+		{at(7, 0), time.Time{}, false},
+	}
+	for _, c := range cases {
+		until, quiet := w.until(c.t)
+		if quiet != c.quiet || !until.Equal(c.until) {
+			t.Errorf("until(%s) = %s, %t, want %s, %t", c.t, until, quiet, c.until, c.quiet)
+		}
+	}
+	if w.String() != "22:30-07:00" {
+		t.Errorf("got %q after round trip", w.String())
+	}
+	for _, s := range []string{"22-07", "25:00-07:00", "22:00"} {
+		if err := w.Set(s); err == nil {
+			t.Errorf("expected error parsing '%s'", s)
+		}
+	}
+}
+
+func TestQuickPass(t *testing.T) {
+	fake := setupFake(t)
+	setFlag(t, "pass", "quick")
+
+	since := time.Now().Add(-time.Hour)
+	recordPass(openCache(), since)
+	start := hoursAhead(2)
+	event := func(summary string, start time.Time, updated time.Time) string {
+		return fake.AddEvent(testUser, &calendar.Event{
+			Summary: summary,
+			Start:   at(start),
+			End:     at(start.Add(30 * time.Minute)),
+			Updated: updated.UTC().Format(time.RFC3339Nano),
+			Attendees: []*calendar.EventAttendee{
+				{Email: testUser, ResponseStatus: "accepted"},
+				{Email: "other@example.com", ResponseStatus: "accepted"},
+			},
+		})
+	}
+	unchanged := event("Unchanged", start, since.Add(-time.Minute))
+	changed := event("Changed", start.Add(time.Hour), since.Add(time.Minute))
+
+	book(context.Background())
+
+	if n := len(fake.Event(testUser, unchanged).Attendees); n != 2 {
+		t.Errorf("unchanged event has %d attendees, want 2 (left for a full pass)", n)
+	}
+	if n := len(fake.Event(testUser, changed).Attendees); n != 3 {
+		t.Errorf("changed event has %d attendees, want 3 including a room", n)
+	}
+	if last, err := lastPass(openCache()); err != nil || !last.After(since) {
+		t.Errorf("last pass time not updated: %v", err)
+	}
+}
+
+func TestFindRoom(t *testing.T) {
+	for name, want := range map[string]string{
+		"TOR-111-5-012 Phoenix (8) [VC]":   "phoenix",
+		"TOR-111-5-014 Blue Jay (4)":       "blue jay",
+		"MTV-1600-2-201 Room (Video Conf)": "room",
+		"Training_room":                    "training room",
+	} {
+		if got := normalizeRoomName(name); got != want {
+			t.Errorf("normalizeRoomName(%q) = %q, want %q", name, got, want)
+		}
+	}
+
+	rooms := []*itercal.Resource{
+		{ResourceEmail: "phoenix@resource.example.com", GeneratedResourceName: "TOR-111-5-012 Phoenix (8) [VC]"},
+		{ResourceEmail: "jay@resource.example.com", GeneratedResourceName: "TOR-111-5-014 Blue Jay (4)"},
+	}
+	aliases := roomAliases{"big": "Phoenix", "small": "jay@resource.example.com"}
+	for name, want := range map[string]int{
+		"phoenix":                  0,
+		"Blue-Jay":                 1,
+		"big":                      0,
+		"small":                    1,
+		"jay@resource.example.com": 1,
+		"lobby":                    -1,
+	} {
+		if got := findRoom(name, aliases, rooms); got != want {
+			t.Errorf("findRoom(%q) = %d, want %d", name, got, want)
+		}
+	}
+	e := &calendar.Event{Summary: "Design review", Description: "Please book #room:phoenix thanks"}
+	if got := requestedRoomName(e); got != "phoenix" {
+		t.Errorf("requestedRoomName = %q, want phoenix", got)
+	}
+}
+
+func TestRequestedRoomDeclined(t *testing.T) {
+	fake := setupFake(t)
+	start := hoursAhead(2)
+	// Room B, though requested, has already declined the meeting.
+	id := fake.AddEvent(testUser, &calendar.Event{
+		Summary: "Review #room:room-b@resource.example.com",
+		Start:   at(start),
+		End:     at(start.Add(time.Hour)),
+		Attendees: []*calendar.EventAttendee{
+			{Email: testUser, ResponseStatus: "accepted"},
+			{Email: "other@example.com", ResponseStatus: "accepted"},
+			{Email: "room-b@resource.example.com", Resource: true, ResponseStatus: "declined"},
+		},
+	})
+
+	book(context.Background())
+
+	if e := fake.Event(testUser, id); hasHold(e) || e.Summary != "Review #room:room-b@resource.example.com" {
+		t.Errorf("booked %s again after it declined: %+v", "Room B", e)
+	}
+}
+
+func TestFloorOrder(t *testing.T) {
+	o := newFloorOrder([]string{"LL", "G", "1", "M", "2"})
+	for name, want := range map[string]int{"LL": -1, "G": 0, "1": 1, "M": 2, "2": 3, "7": 7} {
+		if got, ok := o.level(name); !ok || got != want {
+			t.Errorf("level(%q) = %d, %t, want %d", name, got, ok, want)
+		}
+	}
+	if _, ok := o.level("Roof"); ok {
+		t.Errorf("level of unknown floor found")
+	}
+	o = newFloorOrder([]string{"Ground", "Mezzanine", "Top"})
+	if got, _ := o.level("Top"); got != 2 {
+		t.Errorf("level(Top) = %d, want 2", got)
+	}
+}
+
+func TestBookFastPath(t *testing.T) {
+	setupFake(t)
+	book(context.Background())
+	// -building is an ID, so the index of all buildings isn't needed.
+	if _, err := os.Stat(filepath.Join(os.Getenv("XDG_CACHE_HOME"), "gocal", "buildings")); !os.IsNotExist(err) {
+		t.Errorf("building index created: %v", err)
+	}
+}
+
+func TestStdio(t *testing.T) {
+	fake := setupFake(t)
+	start := hoursAhead(2)
+	fake.AddEvent("room-a@resource.example.com", &calendar.Event{Summary: "Taken", Start: at(start), End: at(start.Add(time.Hour))})
+
+	w := newWorker(context.Background())
+	in := strings.Join([]string{
+		fmt.Sprintf(`{"jsonrpc": "2.0", "id": 1, "method": "freeRooms", "params": {"start": %q, "end": %q, "attendees": 2}}`,
+			start.Format(time.RFC3339), start.Add(30*time.Minute).Format(time.RFC3339)),
+		`{"jsonrpc": "2.0", "id": "two", "method": "rooms"}`,
+		`{"jsonrpc": "2.0", "id": 3, "method": "fly"}`,
+		`{"jsonrpc": "2.0", "method": "rooms"}`,
+		`not json`,
+	}, "\n")
+	var out bytes.Buffer
+	if err := w.serve(context.Background(), strings.NewReader(in), &out); err != nil {
+		t.Fatal(err)
+	}
+	var resps []struct {
+		ID     json.RawMessage
+		Result json.RawMessage
+		Error  *rpcError
+	}
+	dec := json.NewDecoder(&out)
+	for dec.More() {
+		resps = append(resps, struct {
+			ID     json.RawMessage
+			Result json.RawMessage
+			Error  *rpcError
+		}{})
+		if err := dec.Decode(&resps[len(resps)-1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(resps) != 4 {
+		t.Fatalf("got %d responses, want 4 (none for the notification)", len(resps))
+	}
+	var free []freeRoom
+	if err := json.Unmarshal(resps[0].Result, &free); err != nil {
+		t.Fatal(err)
+	}
+	if len(free) != 1 || free[0].Email != "room-b@resource.example.com" {
+		t.Errorf("got free rooms %+v, want only room-b", free)
+	}
+	if string(resps[1].ID) != `"two"` || !strings.Contains(string(resps[1].Result), "room-a@") {
+		t.Errorf("got rooms response %s %s", resps[1].ID, resps[1].Result)
+	}
+	if resps[2].Error == nil || resps[2].Error.Code != rpcMethodNotFound {
+		t.Errorf("got %+v for unknown method, want method not found", resps[2].Error)
+	}
+	if resps[3].Error == nil || resps[3].Error.Code != rpcParseError || string(resps[3].ID) != "null" {
+		t.Errorf("got %+v for bad JSON, want parse error", resps[3].Error)
+	}
+}
+
+func TestParseQuickQuery(t *testing.T) {
+	now := time.Date(2022, 4, 1, 13, 10, 30, 0, time.UTC)
+	at := func(h, m int) time.Time { return time.Date(2022, 4, 1, h, m, 0, 0, time.UTC) }
+	cases := []struct {
+		query string
+		want  roomQuery
+	}{
+		{"free room", roomQuery{Start: at(13, 10), End: at(13, 40), Limit: 1}},
+		{"free room near 5-2 for 1h", roomQuery{Start: at(13, 10), End: at(14, 10), Near: "5/2", Limit: 1}},
+		{"room for 4 people at 2pm for 45m", roomQuery{Start: at(14, 0), End: at(14, 45), Attendees: 4, Limit: 1}},
+		{"6 people at 9:30", roomQuery{Start: at(9, 30).AddDate(0, 0, 1), End: at(10, 0).AddDate(0, 0, 1), Attendees: 6, Limit: 1}},
+	}
+	for _, c := range cases {
+		got, err := parseQuickQuery(c.query, now)
+		if err != nil {
+			t.Errorf("%q: %v", c.query, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("%q: got %+v, want %+v", c.query, got, c.want)
+		}
+	}
+	for _, s := range []string{"room at noonish", "room for -5m"} {
+		if _, err := parseQuickQuery(s, now); err == nil {
+			t.Errorf("expected error parsing %q", s)
+		}
+	}
+}
+
+func TestQuickBook(t *testing.T) {
+	fake := setupFake(t)
+	w := newWorker(context.Background())
+	title := "Chat"
+	quickTitle = &title
+	q, err := parseQuickQuery("room near 1-2 at 9:00", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	rooms, err := w.freeRooms(context.Background(), q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rooms) != 1 || rooms[0].Email != "room-b@resource.example.com" {
+		t.Fatalf("got %+v, want room-b near 1/2", rooms)
+	}
+	if err := bookQuick(w, q, rooms[0]); err != nil {
+		t.Fatal(err)
+	}
+	events := fake.Events(testUser)
+	if len(events) != 1 || events[0].Summary != "Chat" || events[0].Attendees[0].ResponseStatus != "accepted" {
+		t.Errorf("got events %+v, want Chat with room-b accepted", events)
+	}
+}
+
+func TestServe(t *testing.T) {
+	fake := setupFake(t)
+	fake.AddUser(&directory.User{PrimaryEmail: "far@example.com", Locations: []interface{}{
+		map[string]interface{}{"type": "desk", "buildingId": "tst-1", "floorName": "1", "floorSection": "2"},
+	}})
+	oldValidate, oldDomain, oldAudience, oldAdmins := validateIDToken, serveDomain, serveAudience, serveAdmins
+	defer func() {
+		validateIDToken, serveDomain, serveAudience, serveAdmins = oldValidate, oldDomain, oldAudience, oldAdmins
+	}()
+	domain, audience, admins := "example.com", "client", "Admin@example.com"
+	serveDomain, serveAudience, serveAdmins = &domain, &audience, &admins
+	validateIDToken = func(ctx context.Context, token, aud string) (string, string, error) {
+		if aud != audience {
+			t.Errorf("validating for audience %q, want %q", aud, audience)
+		}
+		user, hd, ok := strings.Cut(token, ",")
+		if !ok {
+			return "", "", fmt.Errorf("bad token")
+		}
+		return user, hd, nil
+	}
+	srv := httptest.NewServer(newRoomServer(newWorker(context.Background())))
+	defer srv.Close()
+
+	do := func(method, token, path string) (int, []freeRoom) {
+		t.Helper()
+		req, err := http.NewRequest(method, srv.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var rooms []freeRoom
+		if resp.StatusCode == http.StatusOK && strings.HasPrefix(path, "/rooms/free") {
+			if err := json.NewDecoder(resp.Body).Decode(&rooms); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return resp.StatusCode, rooms
+	}
+	get, post := http.MethodGet, http.MethodPost
+	for _, c := range []struct {
+		method, token, path string
+		code                int
+		first               string
+	}{
+		{get, "", "/rooms/free", http.StatusUnauthorized, ""},
+		{get, "bad", "/rooms/free", http.StatusUnauthorized, ""},
+		{get, "x@other.com,other.com", "/rooms/free", http.StatusForbidden, ""},
+		{get, testUser + ",example.com", "/rooms/free?duration=-1h", http.StatusBadRequest, ""},
+		// Without a profile location, rooms are ranked near -floor and -section.
+		{get, testUser + ",example.com", "/rooms/free", http.StatusOK, "room-a@resource.example.com"},
+		{get, "far@example.com,example.com", "/rooms/free", http.StatusOK, "room-b@resource.example.com"},
+		{get, "far@example.com,example.com", "/rooms/free?near=1/1", http.StatusOK, "room-a@resource.example.com"},
+		{post, "", "/book", http.StatusUnauthorized, ""},
+		{post, testUser + ",example.com", "/book", http.StatusForbidden, ""},
+		{get, "admin@example.com,example.com", "/book", http.StatusMethodNotAllowed, ""},
+		{post, "admin@example.com,example.com", "/book?dryrun=maybe", http.StatusBadRequest, ""},
+		{post, "admin@example.com,example.com", "/book?dryrun=true", http.StatusOK, ""},
+	} {
+		code, rooms := do(c.method, c.token, c.path)
+		if code != c.code {
+			t.Errorf("%s %s as %q: got status %d, want %d", c.method, c.path, c.token, code, c.code)
+			continue
+		}
+		if c.first != "" && (len(rooms) == 0 || rooms[0].Email != c.first) {
+			t.Errorf("%s as %q: got %+v, want %s first", c.path, c.token, rooms, c.first)
+		}
+	}
+}
+
+func TestServeBookWhileQuerying(t *testing.T) {
+	fake := setupFake(t)
+	start := hoursAhead(2)
+	fake.AddEvent(testUser, &calendar.Event{
+		Summary: "Sync",
+		Start:   at(start),
+		End:     at(start.Add(30 * time.Minute)),
+		Attendees: []*calendar.EventAttendee{
+			{Email: testUser, ResponseStatus: "accepted"},
+			{Email: "other@example.com", ResponseStatus: "accepted"},
+		},
+	})
+	oldValidate, oldDomain, oldAudience, oldAdmins := validateIDToken, serveDomain, serveAudience, serveAdmins
+	defer func() {
+		validateIDToken, serveDomain, serveAudience, serveAdmins = oldValidate, oldDomain, oldAudience, oldAdmins
+	}()
+	domain, audience, admins := "example.com", "client", "admin@example.com"
+	serveDomain, serveAudience, serveAdmins = &domain, &audience, &admins
+	validateIDToken = func(ctx context.Context, token, aud string) (string, string, error) {
+		return token, domain, nil
+	}
+	srv := httptest.NewServer(newRoomServer(newWorker(context.Background())))
+	defer srv.Close()
+
+	// Passes, which set the building's zone and floors, and queries, which
+	// read them, run at once (see go test -race).
+	do := func(method, path string) {
+		req, err := http.NewRequest(method, srv.URL+path, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		req.Header.Set("Authorization", "Bearer admin@example.com")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("%s %s: got status %d", method, path, resp.StatusCode)
+		}
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			do(http.MethodPost, "/book?dryrun=true")
+		}()
+		go func() {
+			defer wg.Done()
+			do(http.MethodGet, "/rooms/free")
+		}()
+	}
+	wg.Wait()
+}
+
+func TestServeBookFailure(t *testing.T) {
+	fake := setupFake(t)
+	start := hoursAhead(2)
+	fake.AddEvent(testUser, &calendar.Event{
+		Summary: "Sync",
+		Start:   at(start),
+		End:     at(start.Add(30 * time.Minute)),
+		Attendees: []*calendar.EventAttendee{
+			{Email: testUser, ResponseStatus: "accepted"},
+			{Email: "other@example.com", ResponseStatus: "accepted"},
+		},
+	})
+	if err := injectFailures.Set("patch=403"); err != nil {
+		t.Fatal(err)
+	}
+	defer injectFailures.Set("")
+	oldValidate, oldDomain, oldAudience, oldAdmins := validateIDToken, serveDomain, serveAudience, serveAdmins
+	defer func() {
+		validateIDToken, serveDomain, serveAudience, serveAdmins = oldValidate, oldDomain, oldAudience, oldAdmins
+	}()
+	domain, audience, admins := "example.com", "client", "admin@example.com"
+	serveDomain, serveAudience, serveAdmins = &domain, &audience, &admins
+	validateIDToken = func(ctx context.Context, token, aud string) (string, string, error) {
+		return token, domain, nil
+	}
+	srv := httptest.NewServer(newRoomServer(newWorker(context.Background())))
+	defer srv.Close()
+
+	// A failed booking is reported to the caller, and the server carries on.
+	for _, c := range []struct {
+		method, path string
+		code         int
+	}{
+		{http.MethodPost, "/book", http.StatusInternalServerError},
+		{http.MethodGet, "/rooms/free", http.StatusOK},
+	} {
+		req, err := http.NewRequest(c.method, srv.URL+c.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer admin@example.com")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != c.code {
+			t.Errorf("%s %s: got status %d, want %d", c.method, c.path, resp.StatusCode, c.code)
+		}
+	}
+}
+
+func TestNotify(t *testing.T) {
+	fake := setupFake(t)
+	oldToken, oldAdmins := channelToken, serveAdmins
+	defer func() { channelToken, serveAdmins = oldToken, oldAdmins }()
+	token, admins := "", ""
+	channelToken, serveAdmins = &token, &admins
+
+	start := hoursAhead(2)
+	event := func(summary string, start time.Time) string {
+		return fake.AddEvent(testUser, &calendar.Event{
+			Summary: summary,
+			Start:   at(start),
+			End:     at(start.Add(30 * time.Minute)),
+			Attendees: []*calendar.EventAttendee{
+				{Email: testUser, ResponseStatus: "accepted"},
+				{Email: "other@example.com", ResponseStatus: "accepted"},
+			},
+		})
+	}
+	hasRoom := func(id string) bool { return len(fake.Event(testUser, id).Attendees) == 3 }
+	w := newWorker(context.Background())
+	notify := func(s *roomServer, tok, state string, num int) int {
+		req := httptest.NewRequest(http.MethodPost, "/notify", nil)
+		req.Header.Set("X-Goog-Channel-ID", "channel")
+		req.Header.Set("X-Goog-Channel-Token", tok)
+		req.Header.Set("X-Goog-Resource-State", state)
+		req.Header.Set("X-Goog-Message-Number", fmt.Sprint(num))
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	s := newRoomServer(w)
+	if code := notify(s, "", "exists", 1); code != http.StatusNotFound {
+		t.Errorf("got status %d without -channel-token, want %d", code, http.StatusNotFound)
+	}
+	token = "secret"
+	if code := notify(s, "wrong", "exists", 1); code != http.StatusForbidden {
+		t.Errorf("got status %d for wrong token, want %d", code, http.StatusForbidden)
+	}
+	first := event("First", start)
+	if code := notify(s, "secret", "sync", 1); code != http.StatusOK || hasRoom(first) {
+		t.Errorf("sync message: got status %d, room booked %t, want 200 and no booking", code, hasRoom(first))
+	}
+	notify(s, "secret", "exists", 2)
+	if s.passes.Wait(); !hasRoom(first) {
+		t.Errorf("no room booked after change notification")
+	}
+
+	// After a restart, a redelivered message is acknowledged but ignored.
+	second := event("Second", start.Add(time.Hour))
+	s = newRoomServer(w)
+	code := notify(s, "secret", "exists", 2)
+	if s.passes.Wait(); code != http.StatusOK || hasRoom(second) {
+		t.Errorf("replayed message: got status %d, room booked %t, want 200 and no booking", code, hasRoom(second))
+	}
+	notify(s, "secret", "exists", 3)
+	if s.passes.Wait(); !hasRoom(second) {
+		t.Errorf("no room booked after new change notification")
+	}
+
+	// Notifications are acknowledged while a pass is underway, and those
+	// received meanwhile are covered by the passes that follow.
+	third := event("Third", start.Add(2*time.Hour))
+	s.bookMu.Lock()
+	for num := 4; num <= 6; num++ {
+		if code := notify(s, "secret", "exists", num); code != http.StatusOK {
+			t.Errorf("message %d during a pass: got status %d, want 200", num, code)
+		}
+	}
+	s.bookMu.Unlock()
+	if s.passes.Wait(); !hasRoom(third) || s.notified["channel"] != 6 {
+		t.Errorf("room booked %t, channel at %d, want Third booked and channel at 6", hasRoom(third), s.notified["channel"])
+	}
+
+	// Messages are only recorded once a pass covering them succeeds.
+	setFlag(t, "downgrade-rooms", "bogus")
+	notify(s, "secret", "exists", 7)
+	s.passes.Wait()
+	if got := s.notified["channel"]; got != 6 {
+		t.Errorf("after a failed pass, got channel at %d, want 6", got)
+	}
+	setFlag(t, "downgrade-rooms", "offer")
+	notify(s, "secret", "exists", 7)
+	s.passes.Wait()
+	if got := s.notified["channel"]; got != 7 {
+		t.Errorf("after a redelivered message, got channel at %d, want 7", got)
+	}
+}
+
+func TestPartialCalendars(t *testing.T) {
+	fake := setupFake(t)
+	const team = "team@group.calendar.google.com"
+	others := team + ",missing@group.calendar.google.com"
+	setFlag(t, "other-calendars", others)
+
+	start := hoursAhead(2)
+	event := func(calId, summary string, start time.Time) string {
+		return fake.AddEvent(calId, &calendar.Event{
+			Summary: summary,
+			Start:   at(start),
+			End:     at(start.Add(30 * time.Minute)),
+			Attendees: []*calendar.EventAttendee{
+				{Email: testUser, ResponseStatus: "accepted"},
+				{Email: "other@example.com", ResponseStatus: "accepted"},
+			},
+		})
+	}
+	mine := event(testUser, "Mine", start.Add(time.Hour))
+	teams := event(team, "Team's", start)
+
+	book(context.Background())
+
+	if n := len(fake.Event(testUser, mine).Attendees); n != 3 {
+		t.Errorf("event on -calendar has %d attendees, want 3 including a room", n)
+	}
+	if n := len(fake.Event(team, teams).Attendees); n != 3 {
+		t.Errorf("event on team calendar has %d attendees, want 3 including a room", n)
+	}
+	if last, err := lastPass(openCache()); err != nil || !last.IsZero() {
+		t.Errorf("pass recorded as finished despite an unreadable calendar")
+	}
+}
+
+func TestDeadline(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+	if !withinBudget(ctx, 1) {
+		t.Errorf("not within budget before the deadline")
+	}
+	ctx, cancel = context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	if withinBudget(ctx, 1) {
+		t.Errorf("within budget after the deadline")
+	}
+}
+
+func TestPlanApply(t *testing.T) {
+	fake := setupFake(t)
+	oldDryRun, oldAfterPlan, oldOut, oldApply := *dryRun, afterPlan, planOut, applyFlagSet
+	t.Cleanup(func() { *dryRun, afterPlan, planOut, applyFlagSet = oldDryRun, oldAfterPlan, oldOut, oldApply })
+
+	start := hoursAhead(2)
+	event := func(summary string, start time.Time) string {
+		return fake.AddEvent(testUser, &calendar.Event{
+			Summary: summary,
+			Start:   at(start),
+			End:     at(start.Add(30 * time.Minute)),
+			Attendees: []*calendar.EventAttendee{
+				{Email: testUser, ResponseStatus: "accepted"},
+				{Email: "other@example.com", ResponseStatus: "accepted"},
+			},
+		})
+	}
+	first := event("First", start)
+	second := event("Second", start.Add(time.Hour))
+	plan := func() *savedPlan {
+		t.Helper()
+		out := filepath.Join(t.TempDir(), "plan.json")
+		planOut = &out
+		previewPlan(context.Background())
+		*dryRun = oldDryRun
+		p, err := readPlan(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	p := plan()
+	if len(p.Bookings) != 2 || p.Building != "tst-1" {
+		t.Fatalf("got plan %+v, want 2 bookings in tst-1", p)
+	}
+	if n := len(fake.Event(testUser, first).Attendees); n != 2 {
+		t.Errorf("planning booked a room")
+	}
+
+	// Plans whose events have changed aren't applied.
+	dirSrv, calSrv, err := fake.Services(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := calSrv.Events.Patch(testUser, second, &calendar.Event{Summary: "Second, renamed"}).Do(); err != nil {
+		t.Fatal(err)
+	}
+	resources, err := itercal.ResourcesInBuilding(context.Background(), openCache(), dirSrv, *customer, "tst-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := checkPlan(context.Background(), calSrv, p, resources); err == nil || !strings.Contains(err.Error(), "Second: changed") {
+		t.Errorf("got error %v for changed event, want one naming it", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "plan.json")
+	planOut = &path
+	previewPlan(context.Background())
+	*dryRun = oldDryRun
+	oldPolicy := policyCommand
+	t.Cleanup(func() { policyCommand = oldPolicy })
+	applyFlags(flag.NewFlagSet("apply", flag.ContinueOnError))
+	applyFlagSet.Parse([]string{path})
+	applyPlan(context.Background())
+	for _, id := range []string{first, second} {
+		if n := len(fake.Event(testUser, id).Attendees); n != 3 {
+			t.Errorf("event %s has %d attendees after apply, want 3 including a room", id, n)
+		}
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	old := policyCommand
+	t.Cleanup(func() { policyCommand = old })
+	p := &savedPlan{Building: "tst-1", Bookings: []plannedBooking{
+		{Calendar: testUser, EventID: "1", Summary: "Sync", Attendees: 2, Room: "room-a@resource.example.com", RoomName: "Room A"},
+	}}
+	script := filepath.Join(t.TempDir(), "policy.sh")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\necho '{\"building\": \"tst-1\", \"bookings\": []}'\n"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		policy   string
+		bookings int
+		veto     bool
+	}{
+		{"", 1, false},
+		{"cat", 1, false},
+		{"false", 0, true},
+		{script, 0, false},
+	} {
+		policy := c.policy
+		policyCommand = &policy
+		got, err := applyPolicy(context.Background(), p)
+		if (err != nil) != c.veto {
+			t.Errorf("policy %q: got error %v, want veto %t", c.policy, err, c.veto)
+			continue
+		}
+		if err == nil && len(got.Bookings) != c.bookings {
+			t.Errorf("policy %q: got %d bookings, want %d", c.policy, len(got.Bookings), c.bookings)
+		}
+	}
+}
+
+func TestTemplates(t *testing.T) {
+	fake := setupFake(t)
+	fake.AddResource(&directory.CalendarResource{
+		ResourceEmail: "room-c@resource.example.com", GeneratedResourceName: "Room C", ResourceCategory: "CONFERENCE_ROOM",
+		BuildingId: "tst-1", FloorName: "1", FloorSection: "9", Capacity: 4,
+		FeatureInstances: []interface{}{map[string]interface{}{"feature": map[string]interface{}{"name": "VC"}}},
+	})
+	if err := saveConfig(*configFile, config{"templates": map[string]interface{}{
+		"interview": map[string]interface{}{"features": []string{"vc"}, "hold": true, "holdPrivacy": "private"},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	bookingTemplates = nil
+	t.Cleanup(func() { bookingTemplates = nil })
+
+	start := hoursAhead(2)
+	id := fake.AddEvent(testUser, &calendar.Event{
+		Summary: "Interview #tmpl:interview",
+		Start:   at(start),
+		End:     at(start.Add(time.Hour)),
+		Attendees: []*calendar.EventAttendee{
+			{Email: testUser, ResponseStatus: "accepted"},
+			{Email: "candidate@example.com", ResponseStatus: "accepted"},
+		},
+	})
+
+	book(context.Background())
+
+	e := fake.Event(testUser, id)
+	if !hasHold(e) {
+		t.Fatalf("templated event not linked to a hold")
+	}
+	hold := fake.Event(testUser, privateProperty(e, holdEventIdProperty))
+	if hold == nil {
+		t.Fatalf("hold not found")
+	}
+	if got := hold.Attendees[0].Email; got != "room-c@resource.example.com" {
+		t.Errorf("booked %s, want room-c, the only one with VC", got)
+	}
+	if hold.Visibility != "private" {
+		t.Errorf("hold visibility is %q, want private", hold.Visibility)
+	}
+}
+
+func TestSuggestions(t *testing.T) {
+	fake := setupFake(t)
+	start := hoursAhead(3)
+	fake.AddEvent("room-a@resource.example.com", &calendar.Event{Summary: "Taken", Start: at(start), End: at(start.Add(time.Hour))})
+	fake.AddEvent("room-b@resource.example.com", &calendar.Event{Summary: "Taken", Start: at(start.Add(-30 * time.Minute)), End: at(start.Add(15 * time.Minute))})
+	fake.AddEvent(testUser, &calendar.Event{
+		Summary: "Sync",
+		Start:   at(start),
+		End:     at(start.Add(time.Hour)),
+		Attendees: []*calendar.EventAttendee{
+			{Email: testUser, ResponseStatus: "accepted"},
+			{Email: "other@example.com", ResponseStatus: "accepted"},
+		},
+	})
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+	book(context.Background())
+
+	if want := "Sync (" + start.Format("15:04") + "-" + start.Add(time.Hour).Format("15:04") + "): start 15 min later"; !strings.Contains(logs.String(), want) {
+		t.Errorf("logs do not suggest %q:\n%s", want, logs.String())
+	}
+	if !strings.Contains(logs.String(), "to get Room B") {
+		t.Errorf("logs do not suggest Room B:\n%s", logs.String())
+	}
+}
+
+func TestAlternativeTimes(t *testing.T) {
+	fake := setupFake(t)
+	start := hoursAhead(3)
+	// Both rooms are taken for longer than small changes could avoid.
+	for _, r := range []string{"room-a@resource.example.com", "room-b@resource.example.com"} {
+		fake.AddEvent(r, &calendar.Event{Summary: "Taken", Start: at(start.Add(-15 * time.Minute)), End: at(start.Add(75 * time.Minute))})
+	}
+	fake.AddEvent(testUser, &calendar.Event{
+		Summary: "Sync",
+		Start:   at(start),
+		End:     at(start.Add(time.Hour)),
+		Attendees: []*calendar.EventAttendee{
+			{Email: testUser, ResponseStatus: "accepted"},
+			{Email: "other@example.com", ResponseStatus: "accepted"},
+		},
+	})
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+	book(context.Background())
+
+	// The nearest free hour is 75 minutes earlier or later, unless that
+	// crosses midnight.
+	if !strings.Contains(logs.String(), "move 1h15m") {
+		t.Errorf("logs do not suggest moving by 1h15m:\n%s", logs.String())
+	}
+}
+
+func TestWaitlist(t *testing.T) {
+	fake := setupFake(t)
+	setFlag(t, "waitlist", "true")
+
+	start := hoursAhead(3)
+	taken := fake.AddEvent("room-a@resource.example.com", &calendar.Event{Summary: "Taken", Start: at(start), End: at(start.Add(time.Hour))})
+	fake.AddEvent("room-b@resource.example.com", &calendar.Event{Summary: "Taken", Start: at(start), End: at(start.Add(time.Hour))})
+	id := fake.AddEvent(testUser, &calendar.Event{
+		Summary: "Sync",
+		Start:   at(start),
+		End:     at(start.Add(time.Hour)),
+		Attendees: []*calendar.EventAttendee{
+			{Email: testUser, ResponseStatus: "accepted"},
+			{Email: "other@example.com", ResponseStatus: "accepted"},
+		},
+	})
+	ctx := context.Background()
+	book(ctx)
+	room := func(e *calendar.Event) string {
+		for _, a := range e.Attendees {
+			if a.Resource && a.ResponseStatus == "accepted" {
+				return a.Email
+			}
+		}
+		return ""
+	}
+
+	w := newWorker(ctx)
+	wl := loadWaitlist(w.cacheSpace)
+	if _, ok := wl[eventKey(*calendarId, id)]; !ok || len(wl) != 1 {
+		t.Fatalf("waitlist is %v, want only Sync", wl)
+	}
+
+	// Nothing has freed up yet.
+	w.grabWaitlisted(ctx)
+	if got := room(fake.Event(testUser, id)); got != "" {
+		t.Fatalf("booked %s while all rooms were taken", got)
+	}
+
+	if err := w.calSrv.Events.Delete("room-a@resource.example.com", taken).Do(); err != nil {
+		t.Fatal(err)
+	}
+	w.grabWaitlisted(ctx)
+	if got, want := room(fake.Event(testUser, id)), "room-a@resource.example.com"; got != want {
+		t.Errorf("booked %q once room A was freed, want %s", got, want)
+	}
+	if wl := loadWaitlist(w.cacheSpace); len(wl) != 0 {
+		t.Errorf("waitlist is %v after booking, want empty", wl)
+	}
+}
+
+func TestInventory(t *testing.T) {
+	fake := setupFake(t)
+	start := hoursAhead(3)
+	const phoenix, osprey = "phoenix@group.calendar.google.com", "osprey@group.calendar.google.com"
+	fake.AddEvent(phoenix, &calendar.Event{Summary: "Taken", Start: at(start), End: at(start.Add(time.Hour))})
+	fake.AddEvent(osprey, &calendar.Event{Summary: "Earlier", Start: at(start.Add(-2 * time.Hour)), End: at(start.Add(-time.Hour))})
+	id := fake.AddEvent(testUser, &calendar.Event{
+		Summary: "Sync",
+		Start:   at(start),
+		End:     at(start.Add(time.Hour)),
+		Attendees: []*calendar.EventAttendee{
+			{Email: testUser, ResponseStatus: "accepted"},
+			{Email: "other@example.com", ResponseStatus: "accepted"},
+		},
+	})
+
+	path := filepath.Join(t.TempDir(), "inventory.json")
+	if err := os.WriteFile(path, []byte(`{
+		"building": {"id": "hq", "name": "HQ", "floorNames": ["1"]},
+		"rooms": [
+			{"calendar": "`+phoenix+`", "name": "Phoenix", "floor": "1", "section": "1", "capacity": 4},
+			{"calendar": "`+osprey+`", "name": "Osprey", "floor": "1", "section": "2", "capacity": 4}
+		]
+	}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	setFlag(t, "room-inventory", path)
+	provider, providerLoaded = nil, false
+	t.Cleanup(func() { provider, providerLoaded = nil, false })
+
+	// The second pass finds the room noted on the event rather than booking
+	// another.
+	book(context.Background())
+	book(context.Background())
+
+	if got := privateProperty(fake.Event(testUser, id), roomProperty); got != osprey {
+		t.Errorf("event notes room %q, want %s", got, osprey)
+	}
+	bookings := fake.Events(osprey)
+	if len(bookings) != 2 || privateProperty(bookings[1], sourceEventIdProperty) != id {
+		t.Errorf("Osprey's calendar has %d events, want Earlier and one booking for Sync", len(bookings))
+	}
+	if n := len(fake.Events(phoenix)); n != 1 {
+		t.Errorf("Phoenix's calendar has %d events, want only Taken", n)
+	}
+}
+
+func TestApprovals(t *testing.T) {
+	fake := setupFake(t)
+	fake.RequireApproval("room-a@resource.example.com")
+	start := hoursAhead(3)
+	event := func(summary string, start time.Time) string {
+		return fake.AddEvent(testUser, &calendar.Event{
+			Summary: summary,
+			Start:   at(start),
+			End:     at(start.Add(time.Hour)),
+			Attendees: []*calendar.EventAttendee{
+				{Email: testUser, ResponseStatus: "accepted"},
+				{Email: "other@example.com", ResponseStatus: "accepted"},
+			},
+		})
+	}
+	denied := event("Denied", start)
+	approved := event("Approved", start.Add(2*time.Hour))
+	rooms := func(id string) map[string]string {
+		ret := make(map[string]string)
+		for _, a := range fake.Event(testUser, id).Attendees {
+			if a.Resource {
+				ret[a.Email] = a.ResponseStatus
+			}
+		}
+		return ret
+	}
+
+	// Room A, nearest, awaits approval for both, and isn't booked again.
+	book(context.Background())
+	book(context.Background())
+	for _, id := range []string{denied, approved} {
+		if got := rooms(id); len(got) != 1 || got["room-a@resource.example.com"] != "needsAction" {
+			t.Fatalf("event %s has rooms %v, want only room A awaiting approval", id, got)
+		}
+	}
+
+	fake.Respond(testUser, denied, "room-a@resource.example.com", "declined")
+	fake.Respond(testUser, approved, "room-a@resource.example.com", "accepted")
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+	book(context.Background())
+
+	if got := rooms(denied); got["room-b@resource.example.com"] != "accepted" {
+		t.Errorf("denied event has rooms %v, want room B as well", got)
+	}
+	if got := rooms(approved); len(got) != 1 || got["room-a@resource.example.com"] != "accepted" {
+		t.Errorf("approved event has rooms %v, want only room A", got)
+	}
+	for _, want := range []string{"Denied: Room A for Denied", "Approved: Room A for Approved"} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("logs do not report %q:\n%s", want, logs.String())
+		}
+	}
+}
+
+func TestWithdrawOnlyRoom(t *testing.T) {
+	fake := setupFake(t)
+	start := hoursAhead(2)
+	// Holds have the room as their only attendee.
+	id := fake.AddEvent(testUser, &calendar.Event{
+		Summary:   "Room for 'Sync'",
+		Start:     at(start),
+		End:       at(start.Add(time.Hour)),
+		Attendees: []*calendar.EventAttendee{{Email: "room-a@resource.example.com", Resource: true, ResponseStatus: "needsAction"}},
+	})
+	_, calSrv, err := fake.Services(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	room := &itercal.Resource{ResourceEmail: "room-a@resource.example.com", GeneratedResourceName: "Room A"}
+	withdrawRoom(calSrv, testUser, fake.Event(testUser, id), room)
+	if e := fake.Event(testUser, id); len(e.Attendees) != 0 {
+		t.Errorf("got attendees %+v after withdrawing the only room, want none", e.Attendees)
+	}
+}
+
+func TestHoldAttachments(t *testing.T) {
+	fake := setupFake(t)
+	setFlag(t, "hold-attachments", "true")
+	t.Cleanup(func() { grantedScopes = nil })
+	start := hoursAhead(2)
+	const doc = "https://docs.google.com/document/d/abc123/edit"
+	event := func(summary string, start time.Time) string {
+		return fake.AddEvent(testUser, &calendar.Event{
+			Summary:     summary,
+			Description: "Agenda: " + doc + ".",
+			Start:       at(start),
+			End:         at(start.Add(time.Hour)),
+		})
+	}
+	hold := func(id string) *calendar.Event {
+		e := fake.Event(testUser, id)
+		return fake.Event(testUser, privateProperty(e, holdEventIdProperty))
+	}
+
+	// The token doesn't grant Drive access, so holds are created without
+	// attachments.
+	skipped := event("Planning #room", start)
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+	book(context.Background())
+	if h := hold(skipped); h == nil || len(h.Attachments) != 0 {
+		t.Fatalf("got hold %+v, want one without attachments", h)
+	}
+	if !strings.Contains(logs.String(), "-hold-attachments needs Drive access") {
+		t.Errorf("logs do not warn of missing Drive access:\n%s", logs.String())
+	}
+
+	b, err := json.Marshal(savedToken{
+		Token: &oauth2.Token{AccessToken: "token", Expiry: time.Now().Add(time.Hour)},
+		Scope: calendar.CalendarEventsScope + " " + driveScope,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(*tokenFile, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+	attached := event("Review #room", start.Add(2*time.Hour))
+	book(context.Background())
+	h := hold(attached)
+	if h == nil || len(h.Attachments) != 1 || h.Attachments[0].FileUrl != doc || h.Attachments[0].Title != "Agenda" {
+		t.Errorf("got hold %+v, want the linked agenda attached", h)
+	}
+}
+
+func TestStartedEvents(t *testing.T) {
+	fake := setupFake(t)
+	now := time.Now()
+	id := fake.AddEvent(testUser, &calendar.Event{
+		Summary: "Huddle #room",
+		Start:   at(now.Add(-30 * time.Minute)),
+		End:     at(now.Add(30 * time.Minute)),
+	})
+
+	book(context.Background())
+	if e := fake.Event(testUser, id); hasHold(e) || e.Summary != "Huddle #room" {
+		t.Errorf("booked %+v in progress without -include-started", e)
+	}
+
+	setFlag(t, "include-started", "true")
+	book(context.Background())
+	if e := fake.Event(testUser, id); !hasHold(e) {
+		t.Errorf("did not book %+v in progress with -include-started", e)
+	}
+
+	ended := &calendar.Event{Start: at(now.Add(-time.Hour)), End: at(now.Add(-time.Minute))}
+	if why := tooLate(ended, now); why != "already ended" {
+		t.Errorf("tooLate(ended event) = %q, want 'already ended'", why)
+	}
+}
+
+func TestStartedWithin(t *testing.T) {
+	fake := setupFake(t)
+	now := time.Now()
+	// Room A is free from now on, though busy when the huddle started.
+	fake.AddEvent("room-a@resource.example.com", &calendar.Event{
+		Summary: "Earlier meeting",
+		Start:   at(now.Add(-time.Hour)),
+		End:     at(now.Add(-5 * time.Minute)),
+	})
+	huddle := func(ago time.Duration) string {
+		return fake.AddEvent(testUser, &calendar.Event{
+			Summary: fmt.Sprintf("Huddle %s ago", ago),
+			Start:   at(now.Add(-ago)),
+			End:     at(now.Add(time.Hour - ago)),
+			Attendees: []*calendar.EventAttendee{
+				{Email: testUser, ResponseStatus: "accepted"},
+				{Email: "other@example.com", ResponseStatus: "accepted"},
+			},
+		})
+	}
+	recent, old := huddle(10*time.Minute), huddle(40*time.Minute)
+
+	setFlag(t, "started-within", "15m")
+	book(context.Background())
+
+	if e := fake.Event(testUser, old); hasHold(e) {
+		t.Errorf("booked a room for a huddle that started 40m ago")
+	}
+	e := fake.Event(testUser, recent)
+	if !hasHold(e) {
+		t.Fatalf("did not book a hold for a huddle that started 10m ago")
+	}
+	hold := fake.Event(testUser, privateProperty(e, holdEventIdProperty))
+	start := interval.OrDie(hold.Start.DateTime, hold.End.DateTime).Start
+	if start.Before(now.Add(-time.Minute)) || start.After(time.Now()) {
+		t.Errorf("hold starts at %s, want now (%s)", start, now)
+	}
+	if !strings.Contains(hold.Summary, "Huddle") || len(hold.Attendees) != 1 || hold.Attendees[0].Email != "room-a@resource.example.com" {
+		t.Errorf("got hold %s with %+v, want room A", hold.Summary, hold.Attendees)
+	}
+}
+
+func TestEquipmentHealth(t *testing.T) {
+	fake := setupFake(t)
+	notes := filepath.Join(t.TempDir(), "equipment.csv")
+	csv := "room,feature,until,note\n" +
+		"Room A,,,Flooded\n" +
+		"Room B,Video conferencing,2000-01-07,VC down until Friday\n" +
+		"room-b@resource.example.com,Whiteboard,,Markers missing\n"
+	if err := os.WriteFile(notes, []byte(csv), 0600); err != nil {
+		t.Fatal(err)
+	}
+	setFlag(t, "equipment-health", notes)
+
+	start := hoursAhead(2)
+	id := fake.AddEvent(testUser, &calendar.Event{
+		Summary: "Sync",
+		Start:   at(start),
+		End:     at(start.Add(30 * time.Minute)),
+		Attendees: []*calendar.EventAttendee{
+			{Email: testUser, ResponseStatus: "accepted"},
+			{Email: "other@example.com", ResponseStatus: "accepted"},
+		},
+	})
+	book(context.Background())
+	var rooms []string
+	for _, a := range fake.Event(testUser, id).Attendees {
+		if a.Resource {
+			rooms = append(rooms, a.Email)
+		}
+	}
+	if len(rooms) != 1 || rooms[0] != "room-b@resource.example.com" {
+		t.Errorf("Sync booked in %v, want room B while room A is out of service", rooms)
+	}
+
+	resources := []*itercal.Resource{{
+		ResourceEmail:         "room-b@resource.example.com",
+		GeneratedResourceName: "Room B",
+		Features:              []string{"Video conferencing", "Whiteboard"},
+	}}
+	removeBrokenFeatures(loadEquipmentNotes(openCache(), time.Now()), resources)
+	if got := resources[0].Features; len(got) != 1 || got[0] != "Video conferencing" {
+		t.Errorf("room B has features %v, want only the expired note's", got)
+	}
+}
+
+func TestTenants(t *testing.T) {
+	setupFake(t)
+	oldValidate, oldAudience, oldAdmins := validateIDToken, serveAudience, serveAdmins
+	defer func() { validateIDToken, serveAudience, serveAdmins = oldValidate, oldAudience, oldAdmins }()
+	audience, admins := "client", ""
+	serveAudience, serveAdmins = &audience, &admins
+	validateIDToken = func(ctx context.Context, token, aud string) (string, string, error) {
+		user, hd, ok := strings.Cut(token, ",")
+		if !ok {
+			return "", "", fmt.Errorf("bad token")
+		}
+		return user, hd, nil
+	}
+	w := newWorker(context.Background())
+	quota := &apiQuota{perDay: 2}
+	w.dirSrv, w.calSrv = clientServices(context.Background(), newHTTPClient(true, quota))
+	s := newRoomServer(w)
+	s.domain = "example.com"
+	ten := &tenant{
+		tenantConfig: tenantConfig{Domain: "example.com", Building: *buildingId, Customer: *customer, MaxAPICallsPerDay: 2},
+		s:            s,
+		zone:         zone,
+		floorLevels:  floorLevels,
+		floor:        *floor,
+		section:      *section,
+		quota:        quota,
+		requests:     make(map[int]int64),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ten.refresher = startRefresher(ctx, w.cacheSpace, w.dirSrv, *customer, []string{*buildingId})
+	m := &tenantServer{tenants: map[string]*tenant{"example.com": ten}}
+
+	get := func(token string) int {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/rooms/free?near=1/1", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		m.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	if code := get("x@other.com,other.com"); code != http.StatusForbidden {
+		t.Errorf("user of another domain got status %d, want %d", code, http.StatusForbidden)
+	}
+	// Calls made for the tenant outside requests, e.g. by its refresher,
+	// count against its daily quota of two API calls, as does the first
+	// query's free/busy lookup. They are counted separately from the
+	// process's.
+	before := atomic.LoadInt64(&apiCalls)
+	if _, err := w.dirSrv.Resources.Buildings.Get(*customer, *buildingId).Do(); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		if code := get(testUser + ",example.com"); code != want {
+			t.Errorf("tenant user got status %d, want %d", code, want)
+		}
+	}
+	if n := atomic.LoadInt64(&apiCalls); n != before || quota.calls() != 2 {
+		t.Errorf("got %d API calls for the tenant and %d more for the process, want 2 and 0", quota.calls(), n-before)
+	}
+
+	rec := httptest.NewRecorder()
+	m.metrics(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	for _, want := range []string{
+		`gocal_requests_total{tenant="example.com",code="200"} 1`,
+		`gocal_requests_total{tenant="example.com",code="429"} 1`,
+		`gocal_api_calls_total{tenant="example.com"} 2`,
+		`gocal_cache_refresh_failing{tenant="example.com",entry="tst-1"} 0`,
+	} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("metrics lack %q:\n%s", want, rec.Body.String())
+		}
+	}
+}
+
+func TestRefreshCache(t *testing.T) {
+	setupFake(t)
+	w := newWorker(context.Background())
+	// The rooms are recreated at once when missing, and the building once
+	// it nears expiry.
+	if err := os.RemoveAll(w.cacheSpace.Path(*buildingId)); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r := startRefresher(ctx, w.cacheSpace, w.dirSrv, *customer, []string{*buildingId})
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if s := r.Status(); len(s) == 2 && !s[1].LastRefresh.IsZero() {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("rooms not refreshed: %+v", r.Status())
+		}
+	}
+	if _, err := os.Stat(w.cacheSpace.Path(*buildingId)); err != nil {
+		t.Errorf("rooms not recreated: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	refreshMetrics(r)(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	for _, want := range []string{
+		`gocal_cache_last_refresh_timestamp_seconds{entry="building-tst-1"} 0`,
+		`gocal_cache_refresh_failing{entry="building-tst-1"} 0`,
+		`gocal_cache_refresh_failing{entry="tst-1"} 0`,
+	} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("metrics lack %q:\n%s", want, rec.Body.String())
+		}
+	}
+}
+
+func TestStateDB(t *testing.T) {
+	setupFake(t)
+	cacheSpace := openCache()
+	since := time.Now().Add(-time.Hour).Truncate(time.Second)
+	waiting := waitlist{"primary/1": {Calendar: "primary", EventID: "1", Summary: "Sync", Since: since, Rooms: []string{"room-a@resource.example.com"}}}
+	waiting.save(cacheSpace)
+
+	setFlag(t, "state-db", filepath.Join(t.TempDir(), "state.db"))
+	reset := closeStateDB
+	t.Cleanup(reset)
+	reset()
+
+	// The JSON files are imported when the database is created.
+	if got := loadWaitlist(cacheSpace); len(got) != 1 || got["primary/1"].Summary != "Sync" || !got["primary/1"].Since.Equal(since) || len(got["primary/1"].Rooms) != 1 {
+		t.Errorf("got waitlist %+v, want the imported one", got)
+	}
+	pending := approvals{"primary/2": {Summary: "Review", Room: "room-b@resource.example.com", RoomName: "Room B", Requested: since}}
+	pending.save(cacheSpace)
+	notified := notifyState{"channel": 7}
+	notified.save(cacheSpace)
+	recordPass(cacheSpace, since)
+
+	// State survives reopening the database, which isn't migrated again.
+	reset()
+	if got := loadApprovals(cacheSpace); len(got) != 1 || got["primary/2"].RoomName != "Room B" || !got["primary/2"].Requested.Equal(since) {
+		t.Errorf("got approvals %+v, want %+v", got, pending)
+	}
+	if got := loadNotifyState(cacheSpace); got["channel"] != 7 {
+		t.Errorf("got notification state %v, want channel at 7", got)
+	}
+	if got, err := lastPass(cacheSpace); err != nil || !got.Equal(since) {
+		t.Errorf("got last pass %s (%v), want %s", got, err, since)
+	}
+	var version int
+	if err := openStateDB().QueryRow(`PRAGMA user_version`).Scan(&version); err != nil || version != len(stateMigrations) {
+		t.Errorf("database at version %d (%v), want %d", version, err, len(stateMigrations))
+	}
+
+	// Servers first open the database from several goroutines at once,
+	// none of which may fall back to the JSON files.
+	reset()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if openStateDB() == nil {
+				t.Errorf("state database not open")
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestStateArchive(t *testing.T) {
+	setupFake(t)
+	dir := t.TempDir()
+	force := false
+	oldForce := stateForce
+	stateForce = &force
+	t.Cleanup(func() { stateForce = oldForce })
+	setFlag(t, "room-aliases", filepath.Join(dir, "aliases.json"))
+	if err := saveConfig(*configFile, config{"building": "tst-1", "channel-token": "secret"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(*roomAliasesFile, []byte(`{"big": "Room B"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	waitlist{"primary/1": {Summary: "Sync", Rooms: []string{"room-a@resource.example.com"}}}.save(openCache())
+	archive := filepath.Join(dir, "state.tar.gz")
+	if err := exportState(archive); err != nil {
+		t.Fatal(err)
+	}
+
+	// Import on a "new machine".
+	t.Setenv("XDG_CACHE_HOME", filepath.Join(dir, "new-cache"))
+	setFlag(t, "config", filepath.Join(dir, "new-config", "config.json"))
+	setFlag(t, "room-aliases", filepath.Join(dir, "new-config", "aliases.json"))
+	if err := importState(archive); err != nil {
+		t.Fatal(err)
+	}
+	c, err := loadConfig(*configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c["building"] != "tst-1" || c["channel-token"] != nil {
+		t.Errorf("imported config %v, want the building without the channel token", c)
+	}
+	if b, err := os.ReadFile(*roomAliasesFile); err != nil || string(b) != `{"big": "Room B"}` {
+		t.Errorf("imported aliases %q (%v)", b, err)
+	}
+	if wl := loadWaitlist(openCache()); wl["primary/1"].Summary != "Sync" {
+		t.Errorf("imported waitlist %+v, want Sync", wl)
+	}
+
+	// An existing config is only replaced with -force.
+	if err := saveConfig(*configFile, config{"building": "other"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := importState(archive); err == nil {
+		t.Errorf("import replaced an existing config without -force")
+	}
+	force = true
+	if err := importState(archive); err != nil {
+		t.Errorf("import with -force: %v", err)
+	}
+}
+
+func TestTelemetry(t *testing.T) {
+	fake := setupFake(t)
+	var reports []string
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		reports = append(reports, string(b))
+	}))
+	defer srv.Close()
+	setFlag(t, "telemetry", srv.URL)
+	atomic.StoreInt64(&apiCalls, 0)
+	atomic.StoreInt64(&unrecordedAPICalls, 0)
+
+	book(context.Background())
+	if len(reports) != 0 {
+		t.Fatalf("reported %v before a day passed", reports)
+	}
+	cacheSpace := openCache()
+	b, err := cacheSpace.ReadFile(cacheSpace.Path(telemetryFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var r telemetryReport
+	if err := json.Unmarshal(b, &r); err != nil {
+		t.Fatal(err)
+	}
+	r.Since = r.Since.Add(-telemetryPeriod)
+	if b, err = json.Marshal(r); err != nil {
+		t.Fatal(err)
+	}
+	if err := cacheSpace.WriteFile(cacheSpace.Path(telemetryFile), b); err != nil {
+		t.Fatal(err)
+	}
+
+	start := hoursAhead(2)
+	fake.AddEvent(testUser, &calendar.Event{
+		Summary: "Sync",
+		Start:   at(start),
+		End:     at(start.Add(30 * time.Minute)),
+		Attendees: []*calendar.EventAttendee{
+			{Email: testUser, ResponseStatus: "accepted"},
+			{Email: "other@example.com", ResponseStatus: "accepted"},
+		},
+	})
+	book(context.Background())
+	if len(reports) != 1 {
+		t.Fatalf("got %d reports, want 1", len(reports))
+	}
+	if strings.Contains(reports[0], "example.com") || strings.Contains(reports[0], "Sync") {
+		t.Errorf("report %s identifies the user", reports[0])
+	}
+	var got telemetryReport
+	if err := json.Unmarshal([]byte(reports[0]), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Passes != 2 || got.Events != 1 || got.Booked != 1 {
+		t.Errorf("got report %+v, want 2 passes booking 1 event", got)
+	}
+	// Each API call is reported once, though apiCalls isn't reset between
+	// passes, and sending the report isn't one.
+	if n := atomic.LoadInt64(&apiCalls); got.APICalls != n {
+		t.Errorf("reported %d API calls, want %d", got.APICalls, n)
+	}
+}
+
+func TestDaemon(t *testing.T) {
+	fake := setupFake(t)
+	start := hoursAhead(2)
+	fake.AddEvent(testUser, &calendar.Event{
+		Summary: "Sync",
+		Start:   at(start),
+		End:     at(start.Add(30 * time.Minute)),
+		Attendees: []*calendar.EventAttendee{
+			{Email: testUser, ResponseStatus: "accepted"},
+			{Email: "other@example.com", ResponseStatus: "accepted"},
+		},
+	})
+	setFlag(t, "interval", "1ms")
+	t.Cleanup(func() { afterPlan = nil })
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	scans := 0
+	afterPlan = func(events []*calendar.Event, _ []string, rooms []*itercal.Resource, proposed []bool) {
+		scans++
+		if len(events) != 1 || rooms[0] == nil || proposed[0] != (scans == 1) {
+			t.Errorf("scan %d: got rooms %v, proposed %v; want Sync booked by the first scan", scans, rooms, proposed)
+		}
+		if scans == 2 {
+			cancel()
+		}
+	}
+	runDaemon(ctx)
+	if scans != 2 {
+		t.Errorf("got %d scans, want 2", scans)
+	}
+
+	for _, c := range []struct {
+		interval time.Duration
+		failures int
+		want     time.Duration
+	}{
+		{15 * time.Minute, 0, 15 * time.Minute},
+		{15 * time.Minute, 1, 30 * time.Minute},
+		{15 * time.Minute, 5, daemonMaxBackoff},
+		{2 * time.Hour, 3, 2 * time.Hour},
+	} {
+		if got := daemonDelay(c.interval, c.failures); got != c.want {
+			t.Errorf("daemonDelay(%s, %d) = %s, want %s", c.interval, c.failures, got, c.want)
+		}
+	}
+}
+
+func TestDaemonFreeBusyFailure(t *testing.T) {
+	fake := setupFake(t)
+	start := hoursAhead(2)
+	id := fake.AddEvent(testUser, &calendar.Event{
+		Summary: "Sync",
+		Start:   at(start),
+		End:     at(start.Add(30 * time.Minute)),
+		Attendees: []*calendar.EventAttendee{
+			{Email: testUser, ResponseStatus: "accepted"},
+			{Email: "other@example.com", ResponseStatus: "accepted"},
+		},
+	})
+	if err := injectFailures.Set("freebusy=503"); err != nil {
+		t.Fatal(err)
+	}
+	defer injectFailures.Set("")
+
+	// The scan fails, and would exit a one-shot run, but backs off.
+	if !scanDaemon(context.Background(), false) {
+		t.Errorf("scan with failing FreeBusy queries doesn't back off")
+	}
+	// The daemon keeps scanning until stopped.
+	setFlag(t, "interval", "1ms")
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	runDaemon(ctx)
+	if n := len(fake.Event(testUser, id).Attendees); n != 2 {
+		t.Errorf("booked a room without rooms' availability")
+	}
+}
+
+// roundTripFunc is an http.RoundTripper calling itself.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+func TestInjectFailures(t *testing.T) {
+	setupFake(t)
+	defer injectFailures.Set("")
+	for _, bad := range []string{"freebusy", "patch=200", "get=1.5", "post=429@0", "=503"} {
+		if err := injectFailures.Set(bad); err == nil {
+			t.Errorf("Set(%q) succeeded, want error", bad)
+		}
+	}
+	if err := injectFailures.Set("freebusy=503,patch=429@0.5,*=0.1,get=network"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := injectFailures.String(), "freebusy=503,patch=429@0.5,*=503@0.1,get=network"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	for name, real := range map[string]string{
+		"calendar-endpoint":  "https://www.googleapis.com/calendar/v3/",
+		"directory-endpoint": "https://admin.googleapis.com/",
+		"maps-endpoint":      "https://maps.googleapis.com",
+	} {
+		fake := flag.Lookup(name).Value.String()
+		setFlag(t, name, real)
+		if err := checkFakeBackend(); err == nil {
+			t.Errorf("injecting failures with the real -%s", name)
+		}
+		setFlag(t, name, fake)
+	}
+	if err := checkFakeBackend(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Calls leaving the local machine, e.g. to refresh tokens, don't fail.
+	if err := injectFailures.Set("*=503"); err != nil {
+		t.Fatal(err)
+	}
+	ft := newFailingTransport(roundTripFunc(func(*http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(""))}, nil
+	}), injectFailures)
+	for url, want := range map[string]int{
+		"https://oauth2.googleapis.com/token":   http.StatusOK,
+		"http://127.0.0.1:1234/calendar/v3/foo": http.StatusServiceUnavailable,
+	} {
+		req, _ := http.NewRequest(http.MethodGet, url, nil)
+		if resp, err := ft.RoundTrip(req); err != nil || resp.StatusCode != want {
+			t.Errorf("%s: got %v, %v, want status %d", url, resp, err, want)
+		}
+	}
+
+	if err := injectFailures.Set("freebusy=503"); err != nil {
+		t.Fatal(err)
+	}
+	w := newWorker(context.Background())
+	atomic.StoreInt64(&retryableFailures, 0)
+	start := hoursAhead(1)
+	if _, err := w.freeRooms(context.Background(), roomQuery{Start: start, End: start.Add(time.Hour)}); err == nil {
+		t.Errorf("freeRooms succeeded despite failing freeBusy queries")
+	}
+	if atomic.LoadInt64(&retryableFailures) == 0 {
+		t.Errorf("injected failures not counted")
+	}
+}
+
+func TestWatch(t *testing.T) {
+	fake := setupFake(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	setFlag(t, "interval", "1h")
+	setFlag(t, "watch", "http://"+addr+"/")
+	setFlag(t, "watch-addr", addr)
+	oldSettle := watchSettle
+	watchSettle = 10 * time.Millisecond
+	t.Cleanup(func() { watchSettle, afterPlan = oldSettle, nil })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	scans := 0
+	afterPlan = func(events []*calendar.Event, _ []string, rooms []*itercal.Resource, proposed []bool) {
+		scans++
+		switch scans {
+		case 1:
+			if n := len(fake.Channels(testUser)); n != 1 {
+				t.Errorf("got %d channels, want 1", n)
+			}
+			// Changes notify the daemon, which books a room without
+			// waiting for the next scan.
+			start := hoursAhead(2)
+			fake.AddEvent(testUser, &calendar.Event{
+				Summary: "Sync",
+				Start:   at(start),
+				End:     at(start.Add(30 * time.Minute)),
+				Attendees: []*calendar.EventAttendee{
+					{Email: testUser, ResponseStatus: "accepted"},
+					{Email: "other@example.com", ResponseStatus: "accepted"},
+				},
+			})
+		case 2:
+			if *pass != "quick" || len(events) != 1 || !proposed[0] {
+				t.Errorf("%s pass proposed %v for %d events, want a quick pass booking Sync", *pass, proposed, len(events))
+			}
+			cancel()
+		}
+	}
+	runDaemon(ctx)
+	if scans != 2 {
+		t.Errorf("got %d scans, want 2", scans)
+	}
+	if n := len(fake.Channels(testUser)); n != 0 {
+		t.Errorf("%d channels left open", n)
+	}
+}
+
+func TestConfigYAML(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := saveConfig(path, config{"building": "tst-1", "floor": 3, "channel-token": "secret"}); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "building: tst-1\n") {
+		t.Errorf("config saved as\n%s\nwant YAML", b)
+	}
+
+	fs := flag.NewFlagSet("config", flag.ContinueOnError)
+	building := fs.String("building", "", "")
+	fl := fs.Int("floor", 0, "")
+	fs.String("channel-token", "", "")
+	fs.Duration("next", 24*time.Hour, "")
+	if err := fs.Parse([]string{"-floor", "5"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyConfig(fs, path); err != nil {
+		t.Fatal(err)
+	}
+	if *building != "tst-1" || *fl != 5 {
+		t.Errorf("got building %s floor %d, want tst-1 from the config and 5 from the command line", *building, *fl)
+	}
+	var out bytes.Buffer
+	writeConfig(&out, fs)
+	for _, want := range []string{
+		"building       tst-1     config\n",
+		"channel-token  (secret)  config\n",
+		"floor          5         command line\n",
+		"next           24h0m0s   default\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("config printed as\n%s\nwant line %q", out.String(), want)
+		}
+	}
+}
+
+func TestRoomTags(t *testing.T) {
+	fake := setupFake(t)
+	setFlag(t, "room-tags", "#book,#bookroom")
+	if err := checkRoomTags(); err != nil {
+		t.Fatal(err)
+	}
+
+	start := hoursAhead(2)
+	add := func(summary string, start time.Time) string {
+		return fake.AddEvent(testUser, &calendar.Event{
+			Summary: summary,
+			Start:   at(start),
+			End:     at(start.Add(time.Hour)),
+		})
+	}
+	other := add("Deploy #room", start)
+	tagged := add("Focus #bookroom:room-b", start.Add(2*time.Hour))
+
+	book(context.Background())
+
+	if e := fake.Event(testUser, other); hasHold(e) || e.Summary != "Deploy #room" {
+		t.Errorf("event tagged for other automation changed to %q", e.Summary)
+	}
+	e := fake.Event(testUser, tagged)
+	if e.Summary != "Focus #addedroom:room-b" {
+		t.Errorf("tagged event summary is %q, want whole tag replaced", e.Summary)
+	}
+	if !hasHold(e) {
+		t.Errorf("tagged event not linked to a hold")
+	}
+
+	setFlag(t, "room-tag-done", "#booked")
+	if err := checkRoomTags(); err == nil {
+		t.Errorf("no error for -room-tag-done containing a room tag")
+	}
+}
+
+func TestForeignHolds(t *testing.T) {
+	fake := setupFake(t)
+	setFlag(t, "foreign-holds", "roombot@example.com")
+
+	start := hoursAhead(2)
+	meeting := fake.AddEvent(testUser, &calendar.Event{
+		Summary: "Sync",
+		Start:   at(start),
+		End:     at(start.Add(time.Hour)),
+		Attendees: []*calendar.EventAttendee{
+			{Email: testUser, ResponseStatus: "accepted"},
+			{Email: "other@example.com", ResponseStatus: "accepted"},
+		},
+	})
+	hold := fake.AddEvent(testUser, &calendar.Event{
+		Summary: "Room for Sync #room",
+		Creator: &calendar.EventCreator{Email: "roombot@example.com"},
+		Start:   at(start),
+		End:     at(start.Add(time.Hour)),
+		Attendees: []*calendar.EventAttendee{
+			{Email: testUser, ResponseStatus: "accepted"},
+			{Email: "room-b@resource.example.com", Resource: true},
+		},
+	})
+	panel := fake.AddEvent(testUser, &calendar.Event{
+		Summary:   "Huddle #room",
+		Organizer: &calendar.EventOrganizer{Email: "c_123@resource.calendar.google.com"},
+		Start:     at(start.Add(2 * time.Hour)),
+		End:       at(start.Add(3 * time.Hour)),
+		Attendees: []*calendar.EventAttendee{
+			{Email: testUser, ResponseStatus: "accepted"},
+			{Email: "room-a@resource.example.com", Resource: true},
+		},
+	})
+
+	var rooms []*itercal.Resource
+	afterPlan = func(_ []*calendar.Event, _ []string, r []*itercal.Resource, _ []bool) { rooms = r }
+	defer func() { afterPlan = nil }()
+	book(context.Background())
+
+	if e := fake.Event(testUser, meeting); len(e.Attendees) != 2 {
+		t.Errorf("meeting with a foreign hold booked again: %v", e.Attendees)
+	}
+	if len(rooms) != 1 || rooms[0] == nil || rooms[0].ResourceEmail != "room-b@resource.example.com" {
+		t.Errorf("got rooms %v, want the foreign hold's room for the meeting", rooms)
+	}
+	for _, id := range []string{hold, panel} {
+		if e := fake.Event(testUser, id); hasHold(e) || len(e.Attendees) != 2 || !strings.Contains(e.Summary, "#room") {
+			t.Errorf("foreign hold %s changed: %+v", e.Summary, e)
+		}
+	}
+
+	if _, err := parseForeignMarkers("roombot"); err == nil {
+		t.Errorf("no error for a marker that is neither an email nor prefixed")
+	}
+}
+
+func TestFeatures(t *testing.T) {
+	fake := setupFake(t)
+	feature := func(names ...string) []interface{} {
+		var ret []interface{}
+		for _, n := range names {
+			ret = append(ret, map[string]interface{}{"feature": map[string]interface{}{"name": n}})
+		}
+		return ret
+	}
+	fake.AddResource(&directory.CalendarResource{
+		ResourceEmail: "room-c@resource.example.com", GeneratedResourceName: "Room C", ResourceCategory: "CONFERENCE_ROOM",
+		BuildingId: "tst-1", FloorName: "1", FloorSection: "9", Capacity: 4,
+		FeatureInstances: feature("Video conference", "Jamboard"),
+	})
+	fake.AddResource(&directory.CalendarResource{
+		ResourceEmail: "room-d@resource.example.com", GeneratedResourceName: "Room D", ResourceCategory: "CONFERENCE_ROOM",
+		BuildingId: "tst-1", FloorName: "1", FloorSection: "2", Capacity: 4,
+		FeatureInstances: feature("Video conference"),
+	})
+	setFlag(t, "features", "vc")
+
+	start := hoursAhead(2)
+	fake.AddEvent(testUser, &calendar.Event{
+		Summary: "Sync",
+		Start:   at(start),
+		End:     at(start.Add(time.Hour)),
+		Attendees: []*calendar.EventAttendee{
+			{Email: testUser, ResponseStatus: "accepted"},
+			{Email: "other@example.com", ResponseStatus: "accepted"},
+		},
+	})
+	fake.AddEvent(testUser, &calendar.Event{
+		Summary:     "Brainstorm",
+		Description: "Sketching #room[jamboard]",
+		Start:       at(start.Add(2 * time.Hour)),
+		End:         at(start.Add(3 * time.Hour)),
+	})
+
+	var rooms []*itercal.Resource
+	afterPlan = func(_ []*calendar.Event, _ []string, r []*itercal.Resource, _ []bool) { rooms = r }
+	defer func() { afterPlan = nil }()
+	book(context.Background())
+
+	var got []string
+	for _, r := range rooms {
+		if r == nil {
+			got = append(got, "")
+		} else {
+			got = append(got, r.ResourceEmail)
+		}
+	}
+	want := []string{"room-d@resource.example.com", "room-c@resource.example.com"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("booked %v, want %v: the nearest VC room, then the only one with a Jamboard", got, want)
+	}
+}
+
+func TestBuildings(t *testing.T) {
+	fake := setupFake(t)
+	fake.AddBuilding(&directory.Building{BuildingId: "nyc-1", BuildingName: "New York"})
+	for _, email := range []string{"room-n@resource.example.com", "room-m@resource.example.com"} {
+		fake.AddResource(&directory.CalendarResource{
+			ResourceEmail: email, GeneratedResourceName: "NYC " + email[:6], ResourceCategory: "CONFERENCE_ROOM",
+			BuildingId: "nyc-1", FloorName: "1", FloorSection: "1", Capacity: 4,
+		})
+	}
+	setFlag(t, "building", "tst-1,nyc-1")
+	setFlag(t, "next", "72h")
+
+	// Tomorrow is spent in New York, going by the first meeting's location.
+	tomorrow := time.Now().AddDate(0, 0, 1)
+	morning := time.Date(tomorrow.Year(), tomorrow.Month(), tomorrow.Day(), 9, 0, 0, 0, time.Local)
+	add := func(summary, location string, start time.Time) string {
+		return fake.AddEvent(testUser, &calendar.Event{
+			Summary:  summary,
+			Location: location,
+			Start:    at(start),
+			End:      at(start.Add(time.Hour)),
+			Attendees: []*calendar.EventAttendee{
+				{Email: testUser, ResponseStatus: "accepted"},
+				{Email: "other@example.com", ResponseStatus: "accepted"},
+			},
+		})
+	}
+	visit := add("Visit", "New York office", morning)
+	lunch := add("Lunch", "", morning.Add(3*time.Hour))
+	later := add("Review", "", morning.AddDate(0, 0, 1))
+
+	book(context.Background())
+
+	building := func(id string) string {
+		var ret []string
+		for _, a := range fake.Event(testUser, id).Attendees {
+			if a.Resource {
+				if strings.HasPrefix(a.Email, "room-n") || strings.HasPrefix(a.Email, "room-m") {
+					ret = append(ret, "nyc-1")
+				} else {
+					ret = append(ret, "tst-1")
+				}
+			}
+		}
+		return strings.Join(ret, ",")
+	}
+	for id, want := range map[string]string{visit: "nyc-1", lunch: "nyc-1", later: "tst-1"} {
+		if got := building(id); got != want {
+			t.Errorf("%s booked in %q, want one room in %s", fake.Event(testUser, id).Summary, got, want)
+		}
+	}
+	if *buildingId != "tst-1,nyc-1" {
+		t.Errorf("-building changed to %s", *buildingId)
+	}
+}
+
+func TestPreferredRooms(t *testing.T) {
+	fake := setupFake(t)
+	setFlag(t, "next", "72h")
+
+	start := hoursAhead(2)
+	attendees := func() []*calendar.EventAttendee {
+		return []*calendar.EventAttendee{
+			{Email: testUser, ResponseStatus: "accepted"},
+			{Email: "other@example.com", ResponseStatus: "accepted"},
+		}
+	}
+	// The fake lists recurring events as well as their instances, so the
+	// series starts before the period booked.
+	series := fake.AddEvent(testUser, &calendar.Event{
+		Summary:    "Team sync",
+		Start:      at(start.AddDate(0, 0, -7)),
+		End:        at(start.AddDate(0, 0, -7).Add(time.Hour)),
+		Recurrence: []string{"RRULE:FREQ=DAILY"},
+		Attendees:  attendees(),
+	})
+	var instances []string
+	for d := 0; d < 2; d++ {
+		s := start.AddDate(0, 0, d)
+		instances = append(instances, fake.AddEvent(testUser, &calendar.Event{
+			Summary:          "Team sync",
+			RecurringEventId: series,
+			Start:            at(s),
+			End:              at(s.Add(time.Hour)),
+			Attendees:        attendees(),
+		}))
+	}
+	// Room B, the first choice, is taken on the second day.
+	fake.AddEvent("room-b@resource.example.com", &calendar.Event{
+		Summary: "Someone else's meeting",
+		Start:   at(start.AddDate(0, 0, 1)),
+		End:     at(start.AddDate(0, 0, 1).Add(time.Hour)),
+	})
+
+	fs := flag.NewFlagSet("prefer", flag.ContinueOnError)
+	preferFlags(fs)
+	if err := fs.Parse([]string{"team sync", "Room B", "room-a@resource.example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	preferRooms(context.Background())
+	if got := privateProperty(fake.Event(testUser, series), preferredRoomsProperty); got != "room-b@resource.example.com,room-a@resource.example.com" {
+		t.Fatalf("preferred rooms are %q", got)
+	}
+
+	book(context.Background())
+
+	for i, want := range []string{"room-b@resource.example.com", "room-a@resource.example.com"} {
+		var got string
+		for _, a := range fake.Event(testUser, instances[i]).Attendees {
+			if a.Resource {
+				got = a.Email
+			}
+		}
+		if got != want {
+			t.Errorf("day %d booked in %q, want %q", i+1, got, want)
+		}
+	}
+}
+
+func TestUpgradeRooms(t *testing.T) {
+	fake := setupFake(t)
+	fake.AddResource(&directory.CalendarResource{
+		ResourceEmail: "room-c@resource.example.com", GeneratedResourceName: "Room C", ResourceCategory: "CONFERENCE_ROOM",
+		BuildingId: "tst-1", FloorName: "1", FloorSection: "2", Capacity: 8,
+	})
+	dir := t.TempDir()
+	script := filepath.Join(dir, "notify.sh")
+	if err := os.WriteFile(script, []byte(`printf '%s' "$1" > "$(dirname "$0")/message"`), 0700); err != nil {
+		t.Fatal(err)
+	}
+	setFlag(t, "daemon", "true")
+	setFlag(t, "notify", "sh "+script)
+
+	start := hoursAhead(2)
+	attendees := []*calendar.EventAttendee{{Email: "room-a@resource.example.com", Resource: true}}
+	for i := 0; i < 6; i++ {
+		attendees = append(attendees, &calendar.EventAttendee{Email: fmt.Sprintf("person%d@example.com", i), ResponseStatus: "accepted"})
+	}
+	attendees[1].Email = testUser
+	grown := fake.AddEvent(testUser, &calendar.Event{
+		Summary:            "All hands",
+		Start:              at(start),
+		End:                at(start.Add(time.Hour)),
+		Attendees:          attendees,
+		ExtendedProperties: &calendar.EventExtendedProperties{Private: map[string]string{roomProperty: "room-a@resource.example.com"}},
+	})
+
+	book(context.Background())
+
+	var rooms []string
+	for _, a := range fake.Event(testUser, grown).Attendees {
+		if a.Resource {
+			rooms = append(rooms, a.Email)
+		}
+	}
+	if len(rooms) != 1 || rooms[0] != "room-c@resource.example.com" {
+		t.Errorf("outgrown meeting has rooms %v, want only room-c, the one big enough", rooms)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "message"))
+	if err != nil || !strings.Contains(string(b), "from Room A to Room C") {
+		t.Errorf("notified %q (%v), want a message about the move", b, err)
+	}
+}
+
+func TestJSONReport(t *testing.T) {
+	fake := setupFake(t)
+	out, err := os.Create(filepath.Join(t.TempDir(), "report.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	setFlag(t, "dryrun", "true")
+	setFlag(t, "output", "json")
+	oldStdout := os.Stdout
+	os.Stdout = out
+	t.Cleanup(func() { os.Stdout = oldStdout })
+
+	start := hoursAhead(2)
+	event := func(summary string, start time.Time, room string) string {
+		attendees := []*calendar.EventAttendee{
+			{Email: testUser, ResponseStatus: "accepted"},
+			{Email: "other@example.com", ResponseStatus: "accepted"},
+		}
+		if room != "" {
+			attendees = append(attendees, &calendar.EventAttendee{Email: room, Resource: true, ResponseStatus: "accepted"})
+		}
+		return fake.AddEvent(testUser, &calendar.Event{
+			Summary:   summary,
+			Start:     at(start),
+			End:       at(start.Add(30 * time.Minute)),
+			Attendees: attendees,
+		})
+	}
+	booked := event("Booked", start, "room-b@resource.example.com")
+	tagged := event("Tagged #room:room-b@resource.example.com", start.Add(time.Hour), "")
+	free := event("Free", start.Add(2*time.Hour), "")
+
+	book(context.Background())
+
+	b, err := os.ReadFile(out.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var r planReport
+	if err := json.Unmarshal(b, &r); err != nil {
+		t.Fatalf("report %s: %v", b, err)
+	}
+	if len(r.Events) != 3 || !r.DryRun {
+		t.Fatalf("got report %s, want a dry run's with 3 events", b)
+	}
+	for i, want := range []struct {
+		id, action, room string
+		reasons          []string
+		alternatives     int
+	}{
+		{booked, "keep", "room-b@resource.example.com", []string{reasonAlreadyBooked}, 0},
+		{tagged, "book", "room-b@resource.example.com", []string{reasonRequestedRoom}, 0},
+		{free, "book", "room-b@resource.example.com", []string{reasonRanked}, 1},
+	} {
+		got := r.Events[i]
+		if got.EventID != want.id || got.Action != want.action || got.Room == nil || got.Room.Email != want.room ||
+			!reflect.DeepEqual(got.Reasons, want.reasons) || len(got.Alternatives) != want.alternatives {
+			t.Errorf("event %d: got %+v, want %+v", i, got, want)
+		}
+	}
+	if e := r.Events[2]; e.Room == nil || e.Room.Score == nil || len(e.Alternatives) != 1 || e.Alternatives[0].Email != "room-a@resource.example.com" {
+		t.Errorf("got booking %+v, want it scored with Room A as the alternative", e)
+	}
+	if n := len(fake.Event(testUser, free).Attendees); n != 2 {
+		t.Errorf("dry run booked a room")
+	}
+}
+
+func TestDowngradeRooms(t *testing.T) {
+	for _, mode := range []string{"offer", "move"} {
+		t.Run(mode, func(t *testing.T) {
+			fake := setupFake(t)
+			fake.AddResource(&directory.CalendarResource{
+				ResourceEmail: "room-big@resource.example.com", GeneratedResourceName: "Big room", ResourceCategory: "CONFERENCE_ROOM",
+				BuildingId: "tst-1", FloorName: "1", FloorSection: "1", Capacity: 12,
+			})
+			dir := t.TempDir()
+			script := filepath.Join(dir, "notify.sh")
+			if err := os.WriteFile(script, []byte(`printf '%s\n' "$1" >> "$(dirname "$0")/messages"`), 0700); err != nil {
+				t.Fatal(err)
+			}
+			setFlag(t, "daemon", "true")
+			setFlag(t, "notify", "sh "+script)
+			setFlag(t, "downgrade-rooms", mode)
+			offeredDowngrades = make(map[string]bool)
+
+			start := hoursAhead(2)
+			event := func(summary string, start time.Time, room string, others string) string {
+				return fake.AddEvent(testUser, &calendar.Event{
+					Summary:  summary,
+					Location: "Building 1; Big room",
+					Start:    at(start),
+					End:      at(start.Add(time.Hour)),
+					Attendees: []*calendar.EventAttendee{
+						{Email: testUser, ResponseStatus: "accepted"},
+						{Email: "other@example.com", ResponseStatus: others},
+						{Email: room, Resource: true},
+					},
+					ExtendedProperties: &calendar.EventExtendedProperties{Private: map[string]string{roomProperty: room}},
+				})
+			}
+			shrunk := event("Shrunk", start, "room-big@resource.example.com", "accepted")
+			deserted := event("Deserted", start.Add(2*time.Hour), "room-big@resource.example.com", "declined")
+
+			book(context.Background())
+			book(context.Background())
+
+			rooms := func(id string) []string {
+				var ret []string
+				for _, a := range fake.Event(testUser, id).Attendees {
+					if a.Resource {
+						ret = append(ret, a.Email)
+					}
+				}
+				return ret
+			}
+			b, _ := os.ReadFile(filepath.Join(dir, "messages"))
+			messages := strings.Split(strings.TrimSpace(string(b)), "\n")
+			if mode == "offer" {
+				if r := rooms(shrunk); len(r) != 1 || r[0] != "room-big@resource.example.com" {
+					t.Errorf("offering moved the meeting to %v", r)
+				}
+				if r := rooms(deserted); len(r) != 1 {
+					t.Errorf("offering released the room of the deserted meeting")
+				}
+				if len(messages) != 2 || !strings.Contains(messages[0], "-downgrade-rooms=move") {
+					t.Errorf("got messages %q, want one offer for each meeting", messages)
+				}
+				return
+			}
+			if r := rooms(shrunk); len(r) != 1 || r[0] == "room-big@resource.example.com" {
+				t.Errorf("shrunk meeting has rooms %v, want only a smaller one", r)
+			}
+			if loc := fake.Event(testUser, shrunk).Location; strings.Contains(loc, "Big room") || !strings.HasPrefix(loc, "Building 1; Room ") {
+				t.Errorf("shrunk meeting has location %q, want the smaller room in place of the big one", loc)
+			}
+			if r := rooms(deserted); len(r) != 0 {
+				t.Errorf("deserted meeting has rooms %v, want none", r)
+			}
+			if len(messages) != 2 {
+				t.Errorf("got messages %q, want one for each change", messages)
+			}
+		})
+	}
+}
+
+func TestOverflowRooms(t *testing.T) {
+	fake := setupFake(t)
+	vc := []interface{}{map[string]interface{}{"feature": map[string]interface{}{"name": "Video conference"}}}
+	for _, r := range []*directory.CalendarResource{
+		{ResourceEmail: "room-c@resource.example.com", GeneratedResourceName: "Room C", FloorSection: "3", FeatureInstances: vc},
+		{ResourceEmail: "room-d@resource.example.com", GeneratedResourceName: "Room D", FloorSection: "4", FeatureInstances: vc},
+		{ResourceEmail: "room-e@resource.example.com", GeneratedResourceName: "Room E", FloorSection: "5", FeatureInstances: vc},
+	} {
+		r.ResourceCategory, r.BuildingId, r.FloorName, r.Capacity = "CONFERENCE_ROOM", "tst-1", "1", 4
+		fake.AddResource(r)
+	}
+	setFlag(t, "overflow", "true")
+
+	start := hoursAhead(2)
+	attendees := []*calendar.EventAttendee{{Email: testUser, ResponseStatus: "accepted"}}
+	for i := 0; i < 9; i++ {
+		attendees = append(attendees, &calendar.EventAttendee{Email: fmt.Sprintf("person%d@example.com", i), ResponseStatus: "accepted"})
+	}
+	allHands := fake.AddEvent(testUser, &calendar.Event{
+		Summary:        "All hands",
+		Start:          at(start),
+		End:            at(start.Add(time.Hour)),
+		Attendees:      attendees,
+		HangoutLink:    "https://meet.example.com/all-hands",
+		ConferenceData: &calendar.ConferenceData{ConferenceId: "all-hands"},
+	})
+
+	book(context.Background())
+
+	booked := 0
+	for _, a := range fake.Event(testUser, allHands).Attendees {
+		if a.Resource {
+			booked++
+		}
+	}
+	var overflowed []string
+	for _, e := range fake.Events(testUser) {
+		if privateProperty(e, overflowProperty) == "" {
+			continue
+		}
+		if privateProperty(e, sourceEventIdProperty) != allHands || e.HangoutLink == "" || !strings.HasPrefix(e.Summary, "Overflow room") {
+			t.Errorf("overflow hold %+v isn't labeled and linked to the meeting", e)
+		}
+		overflowed = append(overflowed, e.Attendees[0].Email)
+	}
+	if booked != 1 || len(overflowed) != 2 {
+		t.Errorf("booked %d rooms and overflow rooms %v for 10 people, want 1 room and 2 overflow rooms", booked, overflowed)
+	}
+	for _, r := range overflowed {
+		if r == "room-a@resource.example.com" || r == "room-b@resource.example.com" {
+			t.Errorf("booked %s, without video conferencing, as an overflow room", r)
+		}
+	}
+}
+
+func TestConfirm(t *testing.T) {
+	fake := setupFake(t)
+	out, err := os.Create(filepath.Join(t.TempDir(), "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	setFlag(t, "confirm", "true")
+	oldStdin, oldStdout := stdin, os.Stdout
+	stdin, os.Stdout = bufio.NewScanner(strings.NewReader("3\n2\ns\n")), out
+	t.Cleanup(func() { stdin, os.Stdout = oldStdin, oldStdout })
+
+	start := hoursAhead(2)
+	event := func(summary string, start time.Time) string {
+		return fake.AddEvent(testUser, &calendar.Event{
+			Summary: summary,
+			Start:   at(start),
+			End:     at(start.Add(30 * time.Minute)),
+			Attendees: []*calendar.EventAttendee{
+				{Email: testUser, ResponseStatus: "accepted"},
+				{Email: "other@example.com", ResponseStatus: "accepted"},
+			},
+		})
+	}
+	picked := event("Picked", start)
+	skipped := event("Skipped", start.Add(time.Hour))
+
+	book(context.Background())
+
+	rooms := func(id string) []string {
+		var ret []string
+		for _, a := range fake.Event(testUser, id).Attendees {
+			if a.Resource {
+				ret = append(ret, a.Email)
+			}
+		}
+		return ret
+	}
+	if r := rooms(picked); len(r) != 1 || r[0] != "room-b@resource.example.com" {
+		t.Errorf("booked %v, want Room B, the second choice", r)
+	}
+	if r := rooms(skipped); len(r) != 0 {
+		t.Errorf("booked %v for a skipped event", r)
+	}
+	b, _ := os.ReadFile(out.Name())
+	if !strings.Contains(string(b), "1) Room A") || !strings.Contains(string(b), "'3' is not one of the rooms") {
+		t.Errorf("prompted with:\n%s\nwant Room A first, and 3 rejected", b)
+	}
+}
+
+func TestColocated(t *testing.T) {
+	fake := setupFake(t)
+	for _, email := range []string{testUser, "teammate@example.com"} {
+		fake.AddUser(&directory.User{PrimaryEmail: email, Locations: []interface{}{
+			map[string]interface{}{"type": "desk", "buildingId": "tst-1", "floorName": "1", "floorSection": "2"},
+		}})
+	}
+	setFlag(t, "colocated-max", "30m")
+
+	start := hoursAhead(2)
+	event := func(summary string, start time.Time, length time.Duration, others ...string) string {
+		attendees := []*calendar.EventAttendee{{Email: testUser, ResponseStatus: "accepted"}}
+		for _, o := range others {
+			attendees = append(attendees, &calendar.EventAttendee{Email: o, ResponseStatus: "accepted"})
+		}
+		return fake.AddEvent(testUser, &calendar.Event{
+			Summary:   summary,
+			Start:     at(start),
+			End:       at(start.Add(length)),
+			Attendees: attendees,
+		})
+	}
+	standup := event("Stand-up", start, 15*time.Minute, "teammate@example.com")
+	long := event("Planning", start.Add(time.Hour), time.Hour, "teammate@example.com")
+	guest := event("Chat", start.Add(3*time.Hour), 15*time.Minute, "teammate@example.com", "guest@example.org")
+
+	book(context.Background())
+
+	for _, c := range []struct {
+		id, summary string
+		wantRoom    bool
+	}{
+		{standup, "short meeting of people sitting together", false},
+		{long, "long meeting", true},
+		{guest, "meeting with a guest whose desk is unknown", true},
+	} {
+		rooms := 0
+		for _, a := range fake.Event(testUser, c.id).Attendees {
+			if a.Resource {
+				rooms++
+			}
+		}
+		if got := rooms > 0; got != c.wantRoom {
+			t.Errorf("%s: booked %d rooms, want room %t", c.summary, rooms, c.wantRoom)
+		}
+	}
+}
+
+func TestWalking(t *testing.T) {
+	fake := setupFake(t)
+	fake.AddResource(&directory.CalendarResource{
+		ResourceEmail: "room-far@resource.example.com", GeneratedResourceName: "Far room", ResourceCategory: "CONFERENCE_ROOM",
+		BuildingId: "tst-1", FloorName: "1", FloorSection: "9", Capacity: 4,
+	})
+	out, err := os.Create(filepath.Join(t.TempDir(), "report.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	setFlag(t, "output", "json")
+	oldStdout := os.Stdout
+	os.Stdout = out
+	t.Cleanup(func() { os.Stdout = oldStdout })
+
+	start := hoursAhead(2)
+	event := func(summary string, start time.Time, room string) {
+		attendees := []*calendar.EventAttendee{
+			{Email: testUser, ResponseStatus: "accepted"},
+			{Email: "other@example.com", ResponseStatus: "accepted"},
+		}
+		if room != "" {
+			attendees = append(attendees, &calendar.EventAttendee{Email: room, Resource: true, ResponseStatus: "accepted"})
+		}
+		fake.AddEvent(testUser, &calendar.Event{
+			Summary:   summary,
+			Start:     at(start),
+			End:       at(start.Add(30 * time.Minute)),
+			Attendees: attendees,
+		})
+	}
+	// The nearest free room to the desk is Room A, but the meeting after one
+	// in the far room is better held next to it.
+	event("Far away", start, "room-far@resource.example.com")
+	event("Next", start.Add(30*time.Minute), "")
+
+	book(context.Background())
+
+	b, err := os.ReadFile(out.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var r planReport
+	if err := json.Unmarshal(b, &r); err != nil {
+		t.Fatalf("report %s: %v", b, err)
+	}
+	if r.WalkingMeters == 0 || r.WalkingMeters >= r.BaselineWalkingMeters {
+		t.Errorf("walked %dm, and %dm booking the nearest free rooms, want less but more than none", r.WalkingMeters, r.BaselineWalkingMeters)
+	}
+}
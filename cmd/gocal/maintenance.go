@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/vsekhar/gocal/internal/itercal"
+	"github.com/vsekhar/gocal/internal/timeutil"
+	"google.golang.org/api/calendar/v3"
+)
+
+// eventTimes returns the start and end times of an event as RFC3339 strings.
+// All-day events span from midnight to midnight in zone.
+func eventTimes(e *calendar.Event) (start, end string) {
+	if e.Start.DateTime != "" {
+		return e.Start.DateTime, e.End.DateTime
+	}
+	day := func(s string) string {
+		t, err := timeutil.ParseDate(s, zone)
+		if err != nil {
+			log.Fatalf("'%s' cannot be converted to date: %v", s, err)
+		}
+		return timeutil.Format(t, zone)
+	}
+	return day(e.Start.Date), day(e.End.Date)
+}
+
+// addOutages marks rooms as busy in freeBusy during any events on the
+// maintenance calendar that name them.
+func addOutages(ctx context.Context, calSrv *calendar.Service, resources []*itercal.Resource, freeBusy map[string]calendar.FreeBusyCalendar, start, end time.Time) error {
+	if *maintenanceCalendarId == "" {
+		return nil
+	}
+	err := itercal.ForEachEvent(ctx, calSrv, *maintenanceCalendarId, start, end, zone, func(e *calendar.Event) error {
+		if e.Status == "cancelled" {
+			return nil
+		}
+		summary := strings.ToLower(e.Summary)
+		s, en := eventTimes(e)
+		for _, r := range resources {
+			if !mentions(summary, r) {
+				continue
+			}
+			fb, ok := freeBusy[r.ResourceEmail]
+			if !ok {
+				continue
+			}
+			log.Printf("%s out of service: %s", r.GeneratedResourceName, e.Summary)
+			fb.Busy = append(fb.Busy, &calendar.TimePeriod{Start: s, End: en})
+			freeBusy[r.ResourceEmail] = fb
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("reading maintenance calendar %s: %v", *maintenanceCalendarId, err)
+	}
+	return nil
+}
+
+// mentions returns true if the lower-cased string s contains the email or
+// name of r.
+func mentions(s string, r *itercal.Resource) bool {
+	for _, n := range []string{r.ResourceEmail, r.ResourceName, r.GeneratedResourceName} {
+		if n != "" && strings.Contains(s, strings.ToLower(n)) {
+			return true
+		}
+	}
+	return false
+}
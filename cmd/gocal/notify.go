@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/vsekhar/gocal/internal/cache"
+)
+
+// With -channel-token, 'gocal serve' books rooms when notified of changes to
+// -calendar by Calendar push notifications. Watch the calendar with the
+// Events.watch API, giving the channel serve's /notify address and
+// -channel-token as its token. Notifications are acknowledged at once, and
+// changes start a quick booking pass (see -pass) in the background, which also
+// catches up on changes missed while serve was down. Changes notified during
+// a pass are coalesced into one more pass.
+//
+// Notifications without the channel's token are rejected. Message numbers
+// increase on each channel, so notifications numbered no higher than the last
+// one processed are acknowledged without being processed again, even after a
+// restart. Messages are only recorded as processed once a pass covering them
+// succeeds.
+
+var channelToken *string
+
+// notifyStateFile records the last message number processed on each channel.
+const notifyStateFile = "notify-state.json"
+
+// notifyState maps channel IDs to the last message number processed on them.
+type notifyState map[string]int64
+
+// loadNotifyState returns the notification state recorded in s or -state-db,
+// which is empty if there is none.
+func loadNotifyState(s *cache.Space) notifyState {
+	if db := openStateDB(); db != nil {
+		ret, err := loadNotifyStateDB(db)
+		if err != nil {
+			log.Printf("warning: ignoring notification state: %v", err)
+			return make(notifyState)
+		}
+		return ret
+	}
+	ret := make(notifyState)
+	b, err := s.ReadFile(s.Path(notifyStateFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return ret
+	}
+	if err == nil {
+		err = json.Unmarshal(b, &ret)
+	}
+	if err != nil {
+		log.Printf("warning: ignoring notification state: %v", err)
+		return make(notifyState)
+	}
+	return ret
+}
+
+func (n notifyState) save(s *cache.Space) {
+	var err error
+	if db := openStateDB(); db != nil {
+		err = inStateTx(db, func(tx *sql.Tx) error { return saveNotifyStateTx(tx, n) })
+	} else {
+		var b []byte
+		if b, err = json.Marshal(n); err == nil {
+			err = s.WriteFile(s.Path(notifyStateFile), b)
+		}
+	}
+	if err != nil {
+		log.Printf("warning: recording notification state: %v", err)
+	}
+}
+
+func (s *roomServer) notify(rw http.ResponseWriter, r *http.Request) {
+	if *channelToken == "" {
+		http.NotFound(rw, r)
+		return
+	}
+	token := r.Header.Get("X-Goog-Channel-Token")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(*channelToken)) != 1 {
+		log.Printf("rejecting notification with wrong channel token")
+		http.Error(rw, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	channel := r.Header.Get("X-Goog-Channel-ID")
+	num, err := strconv.ParseInt(r.Header.Get("X-Goog-Message-Number"), 10, 64)
+	if channel == "" || err != nil {
+		http.Error(rw, "missing channel ID or message number", http.StatusBadRequest)
+		return
+	}
+
+	s.notifyMu.Lock()
+	defer s.notifyMu.Unlock()
+	if s.notified == nil {
+		s.notified = loadNotifyState(s.w.cacheSpace)
+	}
+	if num <= s.notified[channel] || num <= s.pending[channel] {
+		log.Printf("Ignoring repeated notification %d on channel %s", num, channel)
+		return
+	}
+	// The first message on a channel only confirms that it is set up.
+	if r.Header.Get("X-Goog-Resource-State") == "sync" {
+		if _, ok := s.pending[channel]; !ok {
+			s.notified[channel] = num
+			s.notified.save(s.w.cacheSpace)
+		}
+		return
+	}
+	log.Printf("Notified of changes on channel %s", channel)
+	if s.pending == nil {
+		s.pending = make(notifyState)
+	}
+	s.pending[channel] = num
+	if !s.passing {
+		s.passing = true
+		s.passes.Add(1)
+		go s.notifiedPasses()
+	}
+}
+
+// notifiedPasses makes quick booking passes until no notifications are
+// pending, recording those each pass covers once it succeeds. Notifications
+// received during a pass are covered by a single further pass.
+func (s *roomServer) notifiedPasses() {
+	defer s.passes.Done()
+	for {
+		s.notifyMu.Lock()
+		covered := s.pending
+		s.pending = nil
+		if len(covered) == 0 {
+			s.passing = false
+			s.notifyMu.Unlock()
+			return
+		}
+		s.notifyMu.Unlock()
+
+		s.bookMu.Lock()
+		oldPass := *pass
+		*pass = "quick"
+		_, err := s.w.bookRooms(context.Background(), false)
+		*pass = oldPass
+		s.bookMu.Unlock()
+		if err != nil {
+			// The messages stay unrecorded, and the next quick pass
+			// catches up on their changes.
+			log.Printf("warning: booking rooms after notification: %v", err)
+			continue
+		}
+
+		s.notifyMu.Lock()
+		for channel, num := range covered {
+			if num > s.notified[channel] {
+				s.notified[channel] = num
+			}
+		}
+		s.notified.save(s.w.cacheSpace)
+		s.notifyMu.Unlock()
+	}
+}
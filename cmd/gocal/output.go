@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/vsekhar/gocal/internal/itercal"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+	"google.golang.org/api/calendar/v3"
+)
+
+// printer formats user-facing messages in the user's language, from LC_ALL,
+// LC_MESSAGES or LANG.
+var printer = message.NewPrinter(userLanguage())
+
+func userLanguage() language.Tag {
+	for _, v := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+		s := os.Getenv(v)
+		if s == "" {
+			continue
+		}
+		// e.g. "fr_CA.UTF-8"
+		s, _, _ = strings.Cut(s, ".")
+		if t, err := language.Parse(strings.ReplaceAll(s, "_", "-")); err == nil {
+			return t
+		}
+	}
+	return language.English
+}
+
+// msg formats a user-facing message, translated if there is a translation in
+// the message catalog.
+func msg(key string, args ...interface{}) string {
+	return printer.Sprintf(key, args...)
+}
+
+// useColor returns true if output to f should be colored, going by -color and
+// the NO_COLOR convention (https://no-color.org).
+func useColor(f *os.File) bool {
+	switch *colorOutput {
+	case "always":
+		return true
+	case "never":
+		return false
+	}
+	if _, ok := os.LookupEnv("NO_COLOR"); ok || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+const (
+	ansiRed   = "\x1b[31m"
+	ansiGreen = "\x1b[32m"
+	ansiReset = "\x1b[0m"
+)
+
+// colorize wraps s in an ANSI color code if color is true.
+func colorize(s, code string, color bool) string {
+	if !color {
+		return s
+	}
+	return code + s + ansiReset
+}
+
+// logTable logs a table of events and their rooms under title, with aligned
+// columns.
+func logTable(title string, events []*calendar.Event, rooms []*itercal.Resource) {
+	color := useColor(os.Stderr)
+	var b bytes.Buffer
+	w := tabwriter.NewWriter(&b, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "  #\t%s\t%s\t%s\n", msg("Start"), msg("Room"), msg("Event"))
+	for i, e := range events {
+		room := colorize(msg("(none)"), ansiRed, color)
+		if rooms[i] != nil {
+			room = colorize(rooms[i].GeneratedResourceName, ansiGreen, color)
+		}
+		summary := e.Summary
+		if e.AttendeesOmitted {
+			summary += "*"
+		}
+		fmt.Fprintf(w, "  %d\t%s\t%s\t%s\n", i+1, e.Start.DateTime, room, summary)
+	}
+	w.Flush()
+	log.Printf("%s\n%s", title, strings.TrimRight(b.String(), "\n"))
+}
+
+// reportFailedCalendars logs the calendars that couldn't be read, whose events
+// were skipped, and why.
+func reportFailedCalendars(failed map[string]error) {
+	ids := make([]string, 0, len(failed))
+	for id := range failed {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	var b strings.Builder
+	for _, id := range ids {
+		fmt.Fprintf(&b, "\n  %s: %v", id, failed[id])
+	}
+	log.Printf("%s%s", msg("Skipped %d calendars that could not be read:", len(failed)), b.String())
+}
+
+// logMemory logs the memory used by gocal, e.g. to size runs for buildings
+// with many resources.
+func logMemory() {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	const mib = 1 << 20
+	log.Printf("Memory: %d MiB in use, %d MiB peak from the OS, %d MiB allocated in total",
+		m.HeapAlloc/mib, m.Sys/mib, m.TotalAlloc/mib)
+}
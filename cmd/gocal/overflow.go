@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync/atomic"
+
+	"github.com/vsekhar/gocal/internal/interval"
+	"github.com/vsekhar/gocal/internal/itercal"
+	"github.com/vsekhar/gocal/internal/rank"
+	"google.golang.org/api/calendar/v3"
+)
+
+// Meetings bigger than any free room, e.g. all-hands, can spill into overflow
+// rooms joined by video conference. With -overflow, when the room booked for
+// a meeting seats fewer than its attendees, gocal also books up to
+// maxOverflowRooms free rooms with -overflow-feature, nearest the room first,
+// until there are seats for everyone. Each is booked in a hold titled
+// "Overflow room N for '<summary>'" carrying the meeting's conference link.
+// Overflow rooms are booked once, with the meeting's room.
+
+// maxOverflowRooms is the most overflow rooms booked for a meeting.
+const maxOverflowRooms = 3
+
+// overflowProperty numbers an overflow room's hold among its meeting's.
+const overflowProperty = "gocalOverflow"
+
+// overflowRooms returns the rooms of resources, other than room, to book as
+// overflow rooms for event. usable and freeBusy are as in booking.
+func overflowRooms(event *calendar.Event, room *itercal.Resource, resources []*itercal.Resource, usable func(int) bool, freeBusy map[string]calendar.FreeBusyCalendar) []*itercal.Resource {
+	need := attendeeCount(event) - room.Capacity
+	if !*overflow || need <= 0 {
+		return nil
+	}
+	e := interval.OrDie(event.Start.DateTime, event.End.DateTime)
+	var candidates []*itercal.Resource
+	for i, r := range resources {
+		if r.ResourceEmail != room.ResourceEmail && r.Capacity > 0 && usable(i) &&
+			rank.HasFeature(r.Features, *overflowFeature) && isFree(freeBusy, r.ResourceEmail, e) {
+			candidates = append(candidates, r)
+		}
+	}
+	// Nearest first, and rooms whose location is unknown last.
+	at, known := roomLocation(room)
+	distance := func(r *itercal.Resource) int {
+		l, ok := roomLocation(r)
+		if !known || !ok {
+			return 1 << 30
+		}
+		return rank.Distance(at, l)
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return distance(candidates[i]) < distance(candidates[j]) })
+
+	var ret []*itercal.Resource
+	for _, r := range candidates {
+		if need <= 0 || len(ret) == maxOverflowRooms {
+			break
+		}
+		ret = append(ret, r)
+		need -= r.Capacity
+	}
+	if need > 0 {
+		log.Printf("warning: overflow rooms for %s seat %d too few", event.Summary, need)
+	}
+	return ret
+}
+
+// bookOverflow books rooms as overflow rooms for event, on calendar calId with
+// cal, in holds, marking them busy in freeBusy. It returns the rooms booked.
+func bookOverflow(ctx context.Context, cal itercal.CalendarProvider, calId string, event *calendar.Event, rooms []*itercal.Resource, freeBusy map[string]calendar.FreeBusyCalendar) []*itercal.Resource {
+	var ret []*itercal.Resource
+	for n, room := range rooms {
+		if !withinBudget(ctx, 1) {
+			break
+		}
+		hold := &calendar.Event{
+			Summary:        fmt.Sprintf("Overflow room %d for '%s'", n+1, markBooked(event.Summary)),
+			Attendees:      []*calendar.EventAttendee{{Email: room.ResourceEmail}},
+			ColorId:        event.ColorId,
+			ConferenceData: event.ConferenceData,
+			Description:    markBooked(event.Description),
+			HangoutLink:    event.HangoutLink,
+			Location:       room.GeneratedResourceName,
+			Visibility:     event.Visibility,
+		}
+		if holdPrivacyFor(event) == "private" {
+			hold.Visibility = "private"
+		}
+		hold.Start, hold.End = holdTimes(event)
+		linkToSource(hold, event)
+		setPrivateProperty(hold, overflowProperty, fmt.Sprint(n+1))
+		log.Printf("Creating %s - %s", hold.Summary, room.GeneratedResourceName)
+		if !*dryRun {
+			atomic.AddInt64(&mutations, 1)
+			if _, err := cal.InsertEvent(ctx, calId, hold); err != nil {
+				log.Printf("warning: booking %s as an overflow room: %v", room.GeneratedResourceName, err)
+				continue
+			}
+		}
+		fb := freeBusy[room.ResourceEmail]
+		fb.Busy = append(fb.Busy, &calendar.TimePeriod{Start: event.Start.DateTime, End: event.End.DateTime})
+		freeBusy[room.ResourceEmail] = fb
+		ret = append(ret, room)
+	}
+	return ret
+}
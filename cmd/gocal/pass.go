@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/vsekhar/gocal/internal/cache"
+	"google.golang.org/api/calendar/v3"
+)
+
+// lastPassFile records when the last booking pass started, so that quick
+// passes can skip events that haven't changed since.
+const lastPassFile = "last-pass"
+
+// lastPass returns the start time of the last booking pass, or the zero time
+// if there hasn't been one.
+func lastPass(s *cache.Space) (time.Time, error) {
+	if db := openStateDB(); db != nil {
+		t, err := lastPassDB(db)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("reading last pass time: %v", err)
+		}
+		return t, nil
+	}
+	b, err := s.ReadFile(s.Path(lastPassFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("reading last pass time: %v", err)
+	}
+	t, err := time.Parse(time.RFC3339Nano, string(b))
+	if err != nil {
+		log.Printf("warning: ignoring last pass time: %v", err)
+		return time.Time{}, nil
+	}
+	return t, nil
+}
+
+// recordPass records t as the start time of the last booking pass.
+func recordPass(s *cache.Space, t time.Time) {
+	if db := openStateDB(); db != nil {
+		if err := recordPassDB(db, t); err != nil {
+			log.Printf("warning: recording last pass time: %v", err)
+		}
+		return
+	}
+	if err := s.WriteFile(s.Path(lastPassFile), []byte(t.UTC().Format(time.RFC3339Nano))); err != nil {
+		log.Printf("warning: recording last pass time: %v", err)
+	}
+}
+
+// changedSince returns true if e was created or modified at or after t. Events
+// with an unknown modification time are assumed to have changed.
+func changedSince(e *calendar.Event, t time.Time) bool {
+	updated, err := time.Parse(time.RFC3339Nano, e.Updated)
+	return err != nil || !updated.Before(t)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/vsekhar/gocal/internal/interval"
+	"github.com/vsekhar/gocal/internal/itercal"
+	"github.com/vsekhar/gocal/internal/rank"
+	"google.golang.org/api/calendar/v3"
+)
+
+// walkingSpeed is the assumed walking speed in meters per second, used to flag
+// transitions between rooms that leave too little time to walk.
+const walkingSpeed = 1.2
+
+// afterPlan, if non-nil, is called by book with the events, the calendars they
+// are on, their rooms and whether each room was proposed during the run, e.g.
+// to print the schedule.
+var afterPlan func(events []*calendar.Event, calendars []string, rooms []*itercal.Resource, proposed []bool)
+
+var planOut *string
+
+func planFlags(fs *flag.FlagSet) {
+	planOut = fs.String("out", "", "file to which to write the planned bookings, for review and 'gocal apply'")
+}
+
+// previewPlan plans rooms as book does, without making changes, and prints
+// the resulting schedule, optionally saving the plan with -out.
+func previewPlan(ctx context.Context) {
+	if *planOut != "" && len(splitList(*buildingId)) > 1 {
+		log.Fatalf("-out needs a single -building, in which 'gocal apply' books the plan")
+	}
+	*dryRun = true
+	afterPlan = func(events []*calendar.Event, calendars []string, rooms []*itercal.Resource, proposed []bool) {
+		printSchedule(os.Stdout, events, rooms, proposed)
+		if *planOut != "" {
+			if err := writePlan(*planOut, events, calendars, rooms, proposed); err != nil {
+				log.Fatalf("writing plan: %v", err)
+			}
+			log.Printf("Wrote plan to %s; run 'gocal apply %s' to book it", *planOut, *planOut)
+		}
+	}
+	book(ctx)
+}
+
+// roomLocation returns the location of r, or false if its floor is unknown or
+// its section is not a number.
+func roomLocation(r *itercal.Resource) (rank.Location, bool) {
+	f, ok := floorLevels.level(r.FloorName)
+	if !ok {
+		return rank.Location{}, false
+	}
+	s, err := strconv.Atoi(r.FloorSection)
+	if err != nil {
+		return rank.Location{}, false
+	}
+	return rank.Location{Floor: f, Section: s}, true
+}
+
+// printSchedule writes a per-day schedule of events, ordered by start time,
+// with their rooms, the walks between them, and conflicts. rooms holds the
+// room booked or proposed for each event, and proposed whether it is proposed.
+func printSchedule(w io.Writer, events []*calendar.Event, rooms []*itercal.Resource, proposed []bool) {
+	for d, day := range days(events) {
+		if d > 0 {
+			fmt.Fprintln(w)
+		}
+		first := interval.OrDie(events[day[0]].Start.DateTime, events[day[0]].End.DateTime)
+		fmt.Fprintln(w, first.Start.In(zone).Format("Monday 2006-01-02"))
+		prev := -1 // previous event with a room
+		var latestEnd time.Time
+		var latest *calendar.Event
+		for _, i := range day {
+			e := events[i]
+			span := interval.OrDie(e.Start.DateTime, e.End.DateTime)
+			if prev >= 0 && rooms[i] != nil {
+				printWalk(w, rooms[prev], rooms[i], span.Start.Sub(interval.OrDie(events[prev].Start.DateTime, events[prev].End.DateTime).End))
+			}
+			room := "NO ROOM"
+			switch {
+			case rooms[i] != nil && proposed[i]:
+				room = rooms[i].GeneratedResourceName + " (proposed)"
+			case rooms[i] != nil:
+				room = rooms[i].GeneratedResourceName
+			}
+			fmt.Fprintf(w, "  %s-%s  %-30s %s\n", span.Start.In(zone).Format("15:04"), span.End.In(zone).Format("15:04"), room, e.Summary)
+			if latest != nil && span.Start.Before(latestEnd) {
+				fmt.Fprintf(w, "    ! conflicts with %s\n", latest.Summary)
+			}
+			if span.End.After(latestEnd) {
+				latestEnd, latest = span.End, e
+			}
+			if rooms[i] != nil {
+				prev = i
+			}
+		}
+	}
+}
+
+// printWalk describes the walk from room a to room b, given gap between the
+// meetings in them.
+func printWalk(w io.Writer, a, b *itercal.Resource, gap time.Duration) {
+	if a.ResourceEmail == b.ResourceEmail {
+		return
+	}
+	la, oka := roomLocation(a)
+	lb, okb := roomLocation(b)
+	if !oka || !okb {
+		fmt.Fprintf(w, "      -> walk to %s\n", b.GeneratedResourceName)
+		return
+	}
+	dist := rank.Distance(la, lb)
+	if *accessible {
+		dist = rank.ElevatorDistance(la, lb)
+	}
+	walk := time.Duration(float64(dist) / walkingSpeed * float64(time.Second)).Round(time.Minute)
+	tight := ""
+	if gap < walk {
+		tight = " (tight)"
+	}
+	fmt.Fprintf(w, "      -> walk %dm, about %s, with %s between meetings%s\n", dist, walk, gap, tight)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// A policy is an external command that 'gocal apply' runs on a plan before
+// booking it, e.g. to enforce rules such as "never book boardrooms for fewer
+// than 4 people". It reads the plan as JSON on stdin and writes the plan to
+// apply on stdout, with any bookings it rejects removed or their rooms
+// changed. Exiting with a non-zero status vetoes the whole plan. An OPA
+// policy can be used through a script wrapping 'opa eval'.
+
+var policyCommand *string
+
+// applyPolicy returns the plan p as amended by -policy, logging the bookings
+// it removed or changed.
+func applyPolicy(ctx context.Context, p *savedPlan) (*savedPlan, error) {
+	args := strings.Fields(*policyCommand)
+	if len(args) == 0 {
+		return p, nil
+	}
+	in, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdin = bytes.NewReader(in)
+	cmd.Stdout = &out
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("policy %s vetoed the plan: %v", args[0], err)
+	}
+	ret := new(savedPlan)
+	if err := json.Unmarshal(out.Bytes(), ret); err != nil {
+		return nil, fmt.Errorf("parsing output of policy %s: %v", args[0], err)
+	}
+
+	kept := make(map[string]plannedBooking)
+	for _, b := range ret.Bookings {
+		kept[b.Calendar+"/"+b.EventID] = b
+	}
+	for _, b := range p.Bookings {
+		k, ok := kept[b.Calendar+"/"+b.EventID]
+		switch {
+		case !ok:
+			log.Printf("Policy removed %s in %s", b.Summary, b.RoomName)
+		case k.Room != b.Room:
+			log.Printf("Policy moved %s from %s to %s", b.Summary, b.RoomName, k.RoomName)
+		}
+	}
+	return ret, nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/vsekhar/gocal/internal/itercal"
+	"google.golang.org/api/calendar/v3"
+)
+
+// 'gocal prefer' pins an ordered list of rooms to a recurring meeting, e.g.
+//
+//	gocal prefer "Team sync" Phoenix Atlas
+//
+// Each week, gocal books the first of the rooms that is free for the meeting,
+// and only ranks other rooms if none is. The meeting is given by an event ID,
+// or by text in the summary of a recurring meeting on -calendar in the next
+// preferSearchPeriod, and rooms by names, emails or aliases (see
+// roomnames.go). The list is kept in a private property of the series, which
+// its instances inherit. Without rooms, the command prints the list, and with
+// -clear it removes it. A '#room:name' tag on an instance still overrides the
+// list.
+
+// preferredRoomsProperty holds the emails of a series' preferred rooms,
+// separated by commas.
+const preferredRoomsProperty = "gocalPreferredRooms"
+
+// preferSearchPeriod is how far ahead meetings are searched for by summary.
+const preferSearchPeriod = 28 * 24 * time.Hour
+
+var preferFlagSet *flag.FlagSet
+var preferClear *bool
+
+func preferFlags(fs *flag.FlagSet) {
+	preferFlagSet = fs
+	preferClear = fs.Bool("clear", false, "remove the meeting's preferred rooms")
+}
+
+// preferRooms sets, prints or clears the preferred rooms of the recurring
+// meeting given as the first argument.
+func preferRooms(ctx context.Context) {
+	if preferFlagSet.NArg() < 1 {
+		log.Fatalf("usage: gocal prefer [-clear] meeting [room ...]")
+	}
+	dirSrv, calSrv := newServices(ctx)
+	series := findSeries(ctx, calSrv, preferFlagSet.Arg(0))
+	names := preferFlagSet.Args()[1:]
+
+	var emails []string
+	switch {
+	case *preferClear:
+		log.Printf("Clearing the preferred rooms of %s", series.Summary)
+	case len(names) == 0:
+		if p := privateProperty(series, preferredRoomsProperty); p != "" {
+			fmt.Println(strings.ReplaceAll(p, ",", "\n"))
+		}
+		return
+	default:
+		cacheSpace := openCache()
+		inferLocation(ctx, dirSrv, calSrv)
+		oldBuilding := *buildingId
+		defer func() { *buildingId = oldBuilding }()
+		var rooms itercal.Resources
+		for _, id := range splitList(oldBuilding) {
+			*buildingId = id
+			if err := resolveBuilding(ctx, cacheSpace, dirSrv); err != nil {
+				log.Fatal(err)
+			}
+			resources, err := loadResources(ctx, cacheSpace, dirSrv)
+			if err != nil {
+				log.Fatalf("loading resources for building %s: %v", *buildingId, err)
+			}
+			rooms = append(rooms, resources.ConferenceRooms()...)
+		}
+		aliases := loadRoomAliases()
+		for _, name := range names {
+			k := findRoom(name, aliases, rooms)
+			if k < 0 {
+				log.Fatalf("no single room named '%s'", name)
+			}
+			emails = append(emails, rooms[k].ResourceEmail)
+			log.Printf("%d. %s", len(emails), rooms[k].GeneratedResourceName)
+		}
+		log.Printf("Preferring these rooms for %s", series.Summary)
+	}
+	patch := new(calendar.Event)
+	setPrivateProperty(patch, preferredRoomsProperty, strings.Join(emails, ","))
+	if *dryRun {
+		return
+	}
+	atomic.AddInt64(&mutations, 1)
+	if _, err := calSrv.Events.Patch(*calendarId, series.Id, patch).SendUpdates("none").Context(ctx).Do(); err != nil {
+		log.Fatalf("updating %s: %v", series.Summary, err)
+	}
+}
+
+// findSeries returns the recurring event on -calendar with ID s, or of which
+// an instance has ID s, or the one of which an upcoming instance mentions s in
+// its summary.
+func findSeries(ctx context.Context, calSrv *calendar.Service, s string) *calendar.Event {
+	id := ""
+	if e, err := calSrv.Events.Get(*calendarId, s).Context(ctx).Do(); err == nil {
+		id = e.RecurringEventId
+		if len(e.Recurrence) > 0 {
+			id = e.Id
+		}
+		if id == "" {
+			log.Fatalf("%s is not a recurring meeting", e.Summary)
+		}
+	} else {
+		summaries := make(map[string]string) // by series ID
+		now := time.Now()
+		err := itercal.ForEachEvent(ctx, calSrv, *calendarId, now, now.Add(preferSearchPeriod), zone, func(e *calendar.Event) error {
+			if e.RecurringEventId != "" && strings.Contains(strings.ToLower(e.Summary), strings.ToLower(s)) {
+				summaries[e.RecurringEventId] = e.Summary
+			}
+			return nil
+		})
+		if err != nil {
+			log.Fatalf("searching for '%s': %v", s, err)
+		}
+		var ids []string
+		for id := range summaries {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		switch len(ids) {
+		case 0:
+			log.Fatalf("no upcoming recurring meeting matches '%s'", s)
+		case 1:
+			id = ids[0]
+		default:
+			for _, id := range ids {
+				log.Printf("  %s (%s)", summaries[id], id)
+			}
+			log.Fatalf("several recurring meetings match '%s'; use more of the summary or an ID", s)
+		}
+	}
+	series, err := calSrv.Events.Get(*calendarId, id).Context(ctx).Do()
+	if err != nil {
+		log.Fatalf("looking up recurring meeting %s: %v", id, err)
+	}
+	return series
+}
+
+// preferredRooms returns the indexes in resources of the preferred rooms of
+// event's series, in order. Resources must be sorted by email.
+func preferredRooms(event *calendar.Event, resources []*itercal.Resource) []int {
+	var ret []int
+	for _, email := range splitList(privateProperty(event, preferredRoomsProperty)) {
+		k := sort.Search(len(resources), func(k int) bool { return resources[k].ResourceEmail >= email })
+		if k < len(resources) && resources[k].ResourceEmail == email {
+			ret = append(ret, k)
+		}
+	}
+	return ret
+}
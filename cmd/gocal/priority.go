@@ -0,0 +1,34 @@
+package main
+
+import (
+	"log"
+	"strconv"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+// When overlapping events compete for the last suitable room, a
+// '#priority:high' (or ':low', or a number) tag on one of them decides which
+// gets it. Untagged events have priority 0, 'high' is 1 and 'low' is -1.
+// Between events of equal priority, the one with more attendees wins, then
+// the earlier one.
+const priorityTag = "#priority"
+
+// eventPriority returns the priority given by a '#priority' tag on event, or
+// 0 if there is none or it can't be parsed.
+func eventPriority(event *calendar.Event) int {
+	switch v := tagValue(event, priorityTag); v {
+	case "":
+		return 0
+	case "high":
+		return 1
+	case "low":
+		return -1
+	default:
+		p, err := strconv.Atoi(v)
+		if err != nil {
+			log.Printf("warning: ignoring priority '%s' for %s", v, event.Summary)
+		}
+		return p
+	}
+}
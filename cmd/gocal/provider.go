@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/vsekhar/gocal/internal/cache"
+	"github.com/vsekhar/gocal/internal/itercal"
+	directory "google.golang.org/api/admin/directory/v1"
+	"google.golang.org/api/calendar/v3"
+)
+
+// A roomProvider manages rooms other than the Workspace calendar resources
+// gocal books by default, e.g. those in a -room-inventory or a workplace
+// management system. Meetings stay in Google Calendar: the provider supplies
+// the building and its rooms, their availability, and their bookings, which
+// are noted on the meetings they are for.
+type roomProvider interface {
+	// building returns the building whose rooms the provider manages.
+	building(ctx context.Context) *directory.Building
+
+	// zone returns the building's time zone.
+	zone(ctx context.Context) *time.Location
+
+	// resources returns the rooms in the building. Their ResourceEmails
+	// identify them to the provider and needn't be email addresses.
+	resources(ctx context.Context) (itercal.Resources, error)
+
+	// freeBusy returns the busy times of the rooms identified by ids
+	// between start and end.
+	freeBusy(ctx context.Context, calSrv *calendar.Service, ids []string, start, end time.Time) (map[string]calendar.FreeBusyCalendar, error)
+
+	// book books room for the period of event and returns an ID for the
+	// booking.
+	book(ctx context.Context, calSrv *calendar.Service, event *calendar.Event, room *itercal.Resource) (string, error)
+}
+
+// Private extended properties noting the room gocal booked for an event,
+// itself or through a roomProvider, and the provider's ID for the booking.
+const (
+	roomProperty        = "gocalRoom"
+	roomBookingProperty = "gocalRoomBooking"
+)
+
+// providerLoaded and provider hold the provider selected by flags, if any.
+var (
+	providerLoaded bool
+	provider       roomProvider
+)
+
+// externalProvider returns the roomProvider selected by -room-inventory or
+// -robin-location, or nil if rooms are Workspace calendar resources.
+func externalProvider() roomProvider {
+	if providerLoaded {
+		return provider
+	}
+	switch {
+	case *roomInventoryFile != "" && *robinLocation != "":
+		log.Fatalf("-room-inventory and -robin-location are mutually exclusive")
+	case *roomInventoryFile != "":
+		provider = loadInventory()
+	case *robinLocation != "":
+		provider = newRobinProvider()
+	}
+	providerLoaded = true
+	return provider
+}
+
+// loadResources returns the resources in -building, from the external
+// provider if there is one, without the features noted as broken.
+func loadResources(ctx context.Context, cacheSpace *cache.Space, dirSrv *directory.Service) (itercal.Resources, error) {
+	var ret itercal.Resources
+	var err error
+	if p := externalProvider(); p != nil {
+		ret, err = p.resources(ctx)
+	} else {
+		ret, err = itercal.ResourcesInBuilding(ctx, cacheSpace, dirSrv, *customer, *buildingId)
+	}
+	if err != nil {
+		return nil, err
+	}
+	removeBrokenFeatures(loadEquipmentNotes(cacheSpace, time.Now()), ret)
+	return ret, nil
+}
+
+// newCalendarProvider returns the calendar backend using calSrv, in which
+// passes list events and rooms' busy times and book rooms. Tests replace it
+// with fakes.
+var newCalendarProvider = func(calSrv *calendar.Service) itercal.CalendarProvider {
+	return itercal.NewGoogle(calSrv)
+}
+
+// roomFreeBusy returns the busy times of the rooms identified by ids between
+// start and end, from the external provider if there is one.
+func roomFreeBusy(ctx context.Context, calSrv *calendar.Service, ids []string, start, end time.Time) (map[string]calendar.FreeBusyCalendar, error) {
+	if p := externalProvider(); p != nil {
+		return p.freeBusy(ctx, calSrv, ids, start, end)
+	}
+	return newCalendarProvider(calSrv).FreeBusy(ctx, ids, start, end, zone)
+}
+
+// notedRoom returns the resource in resources of the room noted on e by
+// reserveProvided, or nil if there is none. Resources must be sorted by email.
+func notedRoom(e *calendar.Event, resources []*itercal.Resource) *itercal.Resource {
+	email := privateProperty(e, roomProperty)
+	if email == "" {
+		return nil
+	}
+	i := sort.Search(len(resources), func(i int) bool { return resources[i].ResourceEmail >= email })
+	if i < len(resources) && resources[i].ResourceEmail == email {
+		return resources[i]
+	}
+	return nil
+}
+
+// reserveProvided books room with provider p for event on calendar calId and
+// notes the room on event.
+func reserveProvided(p roomProvider, calSrv *calendar.Service, calId string, event *calendar.Event, room *itercal.Resource) error {
+	log.Printf("Booking %s for %s", room.GeneratedResourceName, event.Summary)
+	patch := new(calendar.Event)
+	if isTagged(event) && !event.AttendeesOmitted {
+		patch.Summary = markBooked(event.Summary)
+		patch.Description = markBooked(event.Description)
+	}
+	setLocation(patch, event, room)
+	setPrivateProperty(patch, roomProperty, room.ResourceEmail)
+	if *dryRun {
+		return nil
+	}
+	atomic.AddInt64(&mutations, 1)
+	id, err := p.book(context.Background(), calSrv, event, room)
+	if err != nil {
+		return fmt.Errorf("booking %s for %s: %v", room.GeneratedResourceName, event.Summary, err)
+	}
+	setPrivateProperty(patch, roomBookingProperty, id)
+	atomic.AddInt64(&mutations, 1)
+	if _, err := calSrv.Events.Patch(calId, event.Id, patch).SendUpdates("none").Do(); err != nil {
+		return fmt.Errorf("linking %s to its room booking: %v", event.Summary, err)
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/vsekhar/gocal/internal/itercal"
+	"github.com/vsekhar/gocal/internal/timeutil"
+	"google.golang.org/api/calendar/v3"
+)
+
+var quickFlagSet *flag.FlagSet
+var quickBook *bool
+var quickTitle *string
+
+func quickFlags(fs *flag.FlagSet) {
+	quickFlagSet = fs
+	quickBook = fs.Bool("book", false, "book the room found, in a new event on -calendar")
+	quickTitle = fs.String("title", "Meeting", "title of the event created with -book")
+}
+
+// parseQuickQuery parses a query such as "free room near 5-2 for 30m at 14:00
+// for 4 people" into a roomQuery. Unrecognized words are ignored. Queries
+// start now (or at the next occurrence of the given time of day) and last 30
+// minutes by default.
+func parseQuickQuery(s string, now time.Time) (roomQuery, error) {
+	q := roomQuery{Start: now.Truncate(time.Minute), Limit: 1}
+	d := 30 * time.Minute
+	words := strings.Fields(strings.ToLower(s))
+	for i := 0; i < len(words); i++ {
+		next := ""
+		if i+1 < len(words) {
+			next = words[i+1]
+		}
+		switch w := words[i]; {
+		case w == "near" && next != "":
+			q.Near = strings.Replace(next, "-", "/", 1)
+			i++
+		case w == "at" && next != "":
+			t, err := parseClock(next, now)
+			if err != nil {
+				return roomQuery{}, err
+			}
+			q.Start = t
+			i++
+		case w == "for" && next != "":
+			if v, err := time.ParseDuration(next); err == nil {
+				d = v
+				i++
+			} else if n, err := strconv.ParseInt(next, 10, 64); err == nil {
+				q.Attendees = n
+				i++
+			}
+		case strings.HasPrefix(next, "people") || strings.HasPrefix(next, "person"):
+			if n, err := strconv.ParseInt(w, 10, 64); err == nil {
+				q.Attendees = n
+				i++
+			}
+		}
+	}
+	if d <= 0 {
+		return roomQuery{}, fmt.Errorf("duration must be positive")
+	}
+	q.End = q.Start.Add(d)
+	return q, nil
+}
+
+// parseClock returns the next time at or after now with the time of day s,
+// e.g. "14:00", "2pm" or "2:30pm".
+func parseClock(s string, now time.Time) (time.Time, error) {
+	var t time.Time
+	var err error
+	for _, layout := range []string{"15:04", "3pm", "3:04pm"} {
+		if t, err = time.Parse(layout, s); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("'%s' is not a time of day", s)
+	}
+	ret := time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), 0, 0, now.Location())
+	if ret.Before(now.Truncate(time.Minute)) {
+		ret = ret.AddDate(0, 0, 1)
+	}
+	return ret, nil
+}
+
+// quick answers a query for a free room in one line, e.g. for launchers.
+func quick(ctx context.Context) {
+	query := strings.Join(quickFlagSet.Args(), " ")
+	w := newWorker(ctx)
+	q, err := parseQuickQuery(query, time.Now().In(zone))
+	if err != nil {
+		log.Fatal(err)
+	}
+	rooms, err := w.freeRooms(ctx, q)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(rooms) == 0 {
+		fmt.Println(msg("No free rooms %s-%s", q.Start.Format("15:04"), q.End.Format("15:04")))
+		return
+	}
+	r := rooms[0]
+	line := msg("%s (floor %s, section %s, seats %d) is free %s-%s", r.Name, r.Floor, r.Section, r.Capacity, q.Start.Format("15:04"), q.End.Format("15:04"))
+	if *quickBook {
+		if err := bookQuick(w, q, r); err != nil {
+			log.Fatal(err)
+		}
+		line = msg("Booked %s", line)
+	}
+	fmt.Println(line)
+}
+
+// bookQuick creates an event on -calendar for the period in q in room r.
+func bookQuick(w *worker, q roomQuery, r freeRoom) error {
+	var room *itercal.Resource
+	for _, res := range w.rooms {
+		if res.ResourceEmail == r.Email {
+			room = res
+		}
+	}
+	event := &calendar.Event{
+		Summary:   *quickTitle,
+		Start:     &calendar.EventDateTime{DateTime: timeutil.Format(q.Start, zone)},
+		End:       &calendar.EventDateTime{DateTime: timeutil.Format(q.End, zone)},
+		Attendees: []*calendar.EventAttendee{{Email: r.Email}},
+	}
+	if loc, ok := locationWithRoom("", room); ok && *setLocationFlag {
+		event.Location = loc
+	}
+	if *dryRun {
+		return nil
+	}
+	atomic.AddInt64(&mutations, 1)
+	_, err := w.calSrv.Events.Insert(*calendarId, event).SendUpdates("none").Do()
+	return err
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"time"
+)
+
+// clockWindow is a flag.Value for a daily window of wall-clock time of the
+// form "22:00-07:00". Windows ending before they start wrap past midnight.
+// The zero value is an empty window.
+type clockWindow struct {
+	start, end time.Duration // since midnight
+	set        bool
+}
+
+func (w *clockWindow) String() string {
+	if w == nil || !w.set {
+		return ""
+	}
+	return fmt.Sprintf("%s-%s", clock(w.start), clock(w.end))
+}
+
+func (w *clockWindow) Set(s string) error {
+	if s == "" {
+		*w = clockWindow{}
+		return nil
+	}
+	var h1, m1, h2, m2 int
+	if _, err := fmt.Sscanf(s, "%d:%d-%d:%d", &h1, &m1, &h2, &m2); err != nil {
+		return fmt.Errorf("window '%s' is not of the form hh:mm-hh:mm", s)
+	}
+	for _, hm := range [][2]int{{h1, m1}, {h2, m2}} {
+		if hm[0] < 0 || hm[0] > 24 || hm[1] < 0 || hm[1] > 59 {
+			return fmt.Errorf("window '%s' has an invalid time of day", s)
+		}
+	}
+	*w = clockWindow{
+		start: time.Duration(h1)*time.Hour + time.Duration(m1)*time.Minute,
+		end:   time.Duration(h2)*time.Hour + time.Duration(m2)*time.Minute,
+		set:   true,
+	}
+	return nil
+}
+
+// until returns the end of the window if t falls within it.
+func (w *clockWindow) until(t time.Time) (time.Time, bool) {
+	if !w.set || w.start == w.end {
+		return time.Time{}, false
+	}
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	since := t.Sub(midnight)
+	switch {
+	case w.start < w.end && since >= w.start && since < w.end:
+		return midnight.Add(w.end), true
+	case w.start > w.end && since >= w.start:
+		return midnight.AddDate(0, 0, 1).Add(w.end), true
+	case w.start > w.end && since < w.end:
+		return midnight.Add(w.end), true
+	}
+	return time.Time{}, false
+}
+
+func clock(d time.Duration) string {
+	return fmt.Sprintf("%02d:%02d", int(d.Hours()), int(d.Minutes())%60)
+}
+
+// windowFlag defines a clockWindow flag, empty by default.
+func windowFlag(name, usage string) *clockWindow {
+	w := new(clockWindow)
+	flag.Var(w, name, usage)
+	return w
+}
+
+// deferDuringQuietHours switches the run to planning only if it is within
+// -quiet-hours, so that other attendees aren't sent updates at night. The
+// first run after the window makes the deferred bookings.
+func deferDuringQuietHours(now time.Time) {
+	if *dryRun {
+		return
+	}
+	if until, ok := quietHours.until(now.In(zone)); ok {
+		log.Printf("Quiet hours until %s: planning without booking", until.Format("15:04"))
+		*dryRun = true
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/vsekhar/gocal/internal/cache"
+	"github.com/vsekhar/gocal/internal/itercal"
+	directory "google.golang.org/api/admin/directory/v1"
+)
+
+// With -daemon and serve, the cached building and rooms of each -building are
+// recreated in the background refreshLead before they expire, so that passes
+// and queries never wait for them to be fetched again. With -metrics-addr, the
+// refreshes are reported at /metrics in the Prometheus text format, e.g.:
+//
+//	gocal_cache_last_refresh_timestamp_seconds{entry="..."} 1.7e+09
+//	gocal_cache_next_refresh_timestamp_seconds{entry="..."} 1.7e+09
+//	gocal_cache_refresh_failing{entry="..."} 0
+//
+// Entries are labelled with their tenant too with -tenants.
+
+var metricsAddr = flag.String("metrics-addr", "", "with -daemon or serve, address on which to serve metrics at /metrics, e.g. of cache refreshes and, with -tenants, per-tenant counters (default: none)")
+
+// refreshLead is how long before cache entries expire they are refreshed.
+const refreshLead = time.Hour
+
+// startRefresher refreshes the building and rooms of each of buildingIds,
+// customer's, in cacheSpace until ctx is done. It returns nil with an external
+// room provider, whose rooms aren't cached.
+func startRefresher(ctx context.Context, cacheSpace *cache.Space, dirSrv *directory.Service, customer string, buildingIds []string) *cache.Refresher {
+	if externalProvider() != nil {
+		return nil
+	}
+	r := cache.NewRefresher(cacheSpace, refreshLead)
+	for _, id := range buildingIds {
+		r.Add(itercal.BuildingEntry(ctx, cacheSpace, dirSrv, customer, id))
+		r.Add(itercal.ResourcesEntry(ctx, cacheSpace, dirSrv, customer, id))
+	}
+	go r.Run(ctx)
+	return r
+}
+
+// resolvedBuildings returns the IDs of the buildings in -building, leaving out
+// those that can't be resolved, which passes report.
+func resolvedBuildings(ctx context.Context, cacheSpace *cache.Space, dirSrv *directory.Service) []string {
+	old := *buildingId
+	defer func() { *buildingId = old }()
+	var ret []string
+	for _, id := range splitList(old) {
+		*buildingId = id
+		if err := resolveBuilding(ctx, cacheSpace, dirSrv); err != nil {
+			log.Printf("warning: not refreshing building %s: %v", id, err)
+			continue
+		}
+		ret = append(ret, *buildingId)
+	}
+	return ret
+}
+
+// serveMetrics serves metrics on -metrics-addr, if set, with h.
+func serveMetrics(h http.HandlerFunc) {
+	if *metricsAddr == "" {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", h)
+	go func() { log.Fatal(http.ListenAndServe(*metricsAddr, mux)) }()
+}
+
+// refreshMetrics returns a handler of /metrics reporting the status of r.
+func refreshMetrics(r *cache.Refresher) http.HandlerFunc {
+	return func(rw http.ResponseWriter, _ *http.Request) {
+		rw.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		writeRefreshMetrics(rw, map[string]*cache.Refresher{"": r})
+	}
+}
+
+// writeRefreshMetrics writes the status of refreshers, by tenant or "" for
+// none, in the Prometheus text format. Nil refreshers are left out.
+func writeRefreshMetrics(w io.Writer, refreshers map[string]*cache.Refresher) {
+	tenants := make([]string, 0, len(refreshers))
+	for t, r := range refreshers {
+		if r != nil {
+			tenants = append(tenants, t)
+		}
+	}
+	sort.Strings(tenants)
+	type line struct {
+		labels string
+		s      cache.RefreshStatus
+	}
+	var lines []line
+	for _, t := range tenants {
+		for _, s := range refreshers[t].Status() {
+			labels := fmt.Sprintf("entry=%q", s.ID)
+			if t != "" {
+				labels = fmt.Sprintf("tenant=%q,%s", t, labels)
+			}
+			lines = append(lines, line{labels, s})
+		}
+	}
+	metric := func(name string, v func(s cache.RefreshStatus) float64) {
+		fmt.Fprintf(w, "# TYPE %s gauge\n", name)
+		for _, l := range lines {
+			fmt.Fprintf(w, "%s{%s} %g\n", name, l.labels, v(l.s))
+		}
+	}
+	seconds := func(t time.Time) float64 {
+		if t.IsZero() {
+			return 0
+		}
+		return float64(t.UnixNano()) / 1e9
+	}
+	metric("gocal_cache_last_refresh_timestamp_seconds", func(s cache.RefreshStatus) float64 { return seconds(s.LastRefresh) })
+	metric("gocal_cache_next_refresh_timestamp_seconds", func(s cache.RefreshStatus) float64 { return seconds(s.NextRefresh) })
+	metric("gocal_cache_refresh_failing", func(s cache.RefreshStatus) float64 {
+		if s.LastError != "" {
+			return 1
+		}
+		return 0
+	})
+}
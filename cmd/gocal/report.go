@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/vsekhar/gocal/internal/itercal"
+	"github.com/vsekhar/gocal/internal/rank"
+	"google.golang.org/api/calendar/v3"
+)
+
+// '-dryrun -output json' prints what gocal would do as a JSON report on
+// stdout, instead of logging it, e.g. for a script to review before a real
+// run. The report lists each event considered, in order, with:
+//
+//	action        "book" a room, "keep" its room, "wait" for a room's owner to
+//	              approve it, "skip" it or book "none", as no room is free
+//	room          the room to book or kept
+//	alternatives  the next best rooms that are free, with their scores
+//	reasons       codes explaining the action, e.g. "ranked" or
+//	              "preferred-room" (see the reason constants)
+//
+// Log lines are dropped unless -v is also given, in which case they still go
+// to stderr, e.g. to see why a run failed.
+
+// Reasons for the actions in reports.
+const (
+	reasonAlreadyBooked    = "already-booked"
+	reasonAwaitingApproval = "awaiting-approval"
+	reasonUnchanged        = "unchanged-since-last-pass"
+	reasonOutOfBudget      = "out-of-budget"
+	reasonRanked           = "ranked"
+	reasonPreferredRoom    = "preferred-room"
+	reasonRequestedRoom    = "requested-room"
+	reasonUnknownRoom      = "requested-room-not-found"
+	reasonTemplate         = "template"
+	reasonFeatures         = "required-features"
+	reasonOutgrown         = "outgrown"
+	reasonOversized        = "oversized"
+	reasonDowngradeOffered = "downgrade-offered"
+	reasonOverflow         = "overflow"
+	reasonNoRoomFree       = "no-room-free"
+	reasonWaitlisted       = "waitlisted"
+	reasonReserveFailed    = "reserve-failed"
+)
+
+// alternativesReported is the number of alternatives reported for each
+// booking.
+const alternativesReported = 3
+
+// report, if non-nil, collects the report of the run for -output json.
+var report *planReport
+
+// planReport is the format of -output json.
+type planReport struct {
+	Created time.Time       `json:"created"`
+	DryRun  bool            `json:"dryRun"`
+	Events  []reportedEvent `json:"events"`
+
+	// WalkingMeters is the distance walked between the rooms booked, and
+	// BaselineWalkingMeters that if the free room nearest the user's desk
+	// were booked for each event (see walking.go).
+	WalkingMeters         int `json:"walkingMeters,omitempty"`
+	BaselineWalkingMeters int `json:"baselineWalkingMeters,omitempty"`
+}
+
+// reportedEvent is what a run does about an event.
+type reportedEvent struct {
+	Calendar     string         `json:"calendar"`
+	EventID      string         `json:"eventId"`
+	Summary      string         `json:"summary"`
+	Start        string         `json:"start"`
+	End          string         `json:"end"`
+	Attendees    int64          `json:"attendees"`
+	Action       string         `json:"action"`
+	Room         *reportedRoom  `json:"room,omitempty"`
+	Alternatives []reportedRoom `json:"alternatives,omitempty"`
+	Reasons      []string       `json:"reasons"`
+}
+
+// reportedRoom is a room in a report, with its score if it was ranked.
+type reportedRoom struct {
+	Email    string      `json:"email"`
+	Name     string      `json:"name"`
+	Capacity int64       `json:"capacity"`
+	Score    *rank.Score `json:"score,omitempty"`
+}
+
+// add reports the action taken about event on calendar calId, with room, if
+// any, and reasons. It does nothing if r is nil.
+func (r *planReport) add(calId string, event *calendar.Event, action string, room *reportedRoom, alternatives []reportedRoom, reasons ...string) {
+	if r == nil {
+		return
+	}
+	r.Events = append(r.Events, reportedEvent{
+		Calendar:     calId,
+		EventID:      event.Id,
+		Summary:      event.Summary,
+		Start:        event.Start.DateTime,
+		End:          event.End.DateTime,
+		Attendees:    attendeeCount(event),
+		Action:       action,
+		Room:         room,
+		Alternatives: alternatives,
+		Reasons:      append([]string{}, reasons...),
+	})
+}
+
+// bookingReasons returns the reasons why, given while planning, for booking
+// a room, adding that it was ranked best unless it was preferred or requested.
+func bookingReasons(why []string) []string {
+	for _, r := range why {
+		if r == reasonPreferredRoom || r == reasonRequestedRoom {
+			return why
+		}
+	}
+	return append(why, reasonRanked)
+}
+
+// write writes the report as indented JSON to w.
+func (r *planReport) write(w io.Writer) error {
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+// newReportedRoom returns r as reported, with score if non-nil.
+func newReportedRoom(r *itercal.Resource, score *rank.Score) *reportedRoom {
+	if r == nil {
+		return nil
+	}
+	return &reportedRoom{Email: r.ResourceEmail, Name: r.GeneratedResourceName, Capacity: r.Capacity, Score: score}
+}
+
+// reportedAlternatives returns the best rooms, other than the one planned,
+// that are free for slots[j], with their scores, and the score of the planned
+// room.
+func reportedAlternatives(w rank.Weights, slots []rank.Slot, j int, plan []int, rooms []rank.Room, resources []*itercal.Resource) ([]reportedRoom, *rank.Score) {
+	idxs, scores := rankCandidates(w, slots, j, plan, rooms)
+	var ret []reportedRoom
+	var chosen *rank.Score
+	for _, idx := range idxs {
+		score := scores[idx]
+		if idx == plan[j] {
+			chosen = &score
+			continue
+		}
+		if len(ret) < alternativesReported && slots[j].Available(idx) {
+			ret = append(ret, *newReportedRoom(resources[idx], &score))
+		}
+	}
+	return ret, chosen
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vsekhar/gocal/internal/interval"
+	"github.com/vsekhar/gocal/internal/itercal"
+	"github.com/vsekhar/gocal/internal/robin"
+	"github.com/vsekhar/gocal/internal/timeutil"
+	directory "google.golang.org/api/admin/directory/v1"
+	"google.golang.org/api/calendar/v3"
+)
+
+// With -robin-location, gocal books the spaces of a location in Robin rather
+// than Workspace calendar resources, authenticating with the access token in
+// -robin-token. Meetings stay in Google Calendar, and the space booked for
+// each is noted on it (see provider.go). Robin has no sections within floors,
+// so rooms are near each other if they are on the same level.
+
+// robinIDPrefix starts the ResourceEmails standing for Robin spaces.
+const robinIDPrefix = "robin:"
+
+// robinProvider provides the spaces of a Robin location.
+type robinProvider struct {
+	client *robin.Client
+	loc    *robin.Location
+	levels []robin.Level
+}
+
+func newRobinProvider() *robinProvider {
+	token, err := ioutil.ReadFile(*robinTokenFile)
+	if err != nil {
+		log.Fatalf("reading Robin token: %v", err)
+	}
+	client := robin.NewClient(*robinEndpoint, strings.TrimSpace(string(token)), httpClient())
+	*buildingId = *robinLocation
+	return &robinProvider{client: client}
+}
+
+// load looks up the location and its levels, once.
+func (p *robinProvider) load(ctx context.Context) {
+	if p.loc != nil {
+		return
+	}
+	loc, err := p.client.Location(ctx, *robinLocation)
+	if err != nil {
+		log.Fatalf("looking up Robin location %s: %v", *robinLocation, err)
+	}
+	levels, err := p.client.Levels(ctx, *robinLocation)
+	if err != nil {
+		log.Fatalf("looking up levels of Robin location %s: %v", *robinLocation, err)
+	}
+	sort.Slice(levels, func(i, j int) bool { return levels[i].Level < levels[j].Level })
+	p.loc, p.levels = loc, levels
+}
+
+func (p *robinProvider) building(ctx context.Context) *directory.Building {
+	p.load(ctx)
+	b := &directory.Building{BuildingId: *robinLocation, BuildingName: p.loc.Name}
+	for _, l := range p.levels {
+		b.FloorNames = append(b.FloorNames, l.Name)
+	}
+	return b
+}
+
+func (p *robinProvider) zone(ctx context.Context) *time.Location {
+	p.load(ctx)
+	if p.loc.TimeZone == "" {
+		log.Printf("Robin location %s has no time zone, using local time zone", *robinLocation)
+		return time.Local
+	}
+	loc, err := time.LoadLocation(p.loc.TimeZone)
+	if err != nil {
+		log.Fatalf("loading time zone of Robin location %s: %v", *robinLocation, err)
+	}
+	return loc
+}
+
+// resources returns the location's enabled spaces.
+func (p *robinProvider) resources(ctx context.Context) (itercal.Resources, error) {
+	p.load(ctx)
+	levelNames := make(map[int64]string)
+	for _, l := range p.levels {
+		levelNames[l.ID] = l.Name
+	}
+	spaces, err := p.client.Spaces(ctx, *robinLocation)
+	if err != nil {
+		return nil, err
+	}
+	var ret itercal.Resources
+	for _, s := range spaces {
+		if s.IsDisabled {
+			continue
+		}
+		r := &itercal.Resource{
+			ResourceEmail:         fmt.Sprintf("%s%d", robinIDPrefix, s.ID),
+			ResourceName:          s.Name,
+			GeneratedResourceName: s.Name,
+			ResourceCategory:      "CONFERENCE_ROOM",
+			BuildingId:            *robinLocation,
+			FloorName:             levelNames[s.LevelID],
+			FloorSection:          "1",
+			Capacity:              s.Capacity,
+		}
+		for _, a := range s.Amenities {
+			r.Features = append(r.Features, a.Name)
+		}
+		ret = append(ret, r)
+	}
+	return ret, nil
+}
+
+// spaceID returns the ID of the Robin space identified by a ResourceEmail.
+func spaceID(email string) (int64, error) {
+	id, err := strconv.ParseInt(strings.TrimPrefix(email, robinIDPrefix), 10, 64)
+	if err != nil || !strings.HasPrefix(email, robinIDPrefix) {
+		return 0, fmt.Errorf("%s is not a Robin space", email)
+	}
+	return id, nil
+}
+
+// freeBusy returns the times the spaces are booked, from their events.
+func (p *robinProvider) freeBusy(ctx context.Context, _ *calendar.Service, ids []string, start, end time.Time) (map[string]calendar.FreeBusyCalendar, error) {
+	ret := make(map[string]calendar.FreeBusyCalendar)
+	for _, email := range ids {
+		id, err := spaceID(email)
+		if err != nil {
+			return nil, err
+		}
+		events, err := p.client.Events(ctx, id, start, end)
+		if err != nil {
+			return nil, fmt.Errorf("reading events of %s: %v", email, err)
+		}
+		fb := calendar.FreeBusyCalendar{Busy: []*calendar.TimePeriod{}}
+		for _, e := range events {
+			s, err1 := time.Parse(time.RFC3339, e.Start.DateTime)
+			en, err2 := time.Parse(time.RFC3339, e.End.DateTime)
+			if err1 != nil || err2 != nil {
+				log.Printf("warning: ignoring event %s of %s with unparseable times", e.ID, email)
+				continue
+			}
+			fb.Busy = append(fb.Busy, &calendar.TimePeriod{Start: timeutil.Format(s, zone), End: timeutil.Format(en, zone)})
+		}
+		ret[email] = fb
+	}
+	return ret, nil
+}
+
+// book creates an event booking room for the period of event.
+func (p *robinProvider) book(ctx context.Context, _ *calendar.Service, event *calendar.Event, room *itercal.Resource) (string, error) {
+	id, err := spaceID(room.ResourceEmail)
+	if err != nil {
+		return "", err
+	}
+	span := interval.OrDie(event.Start.DateTime, event.End.DateTime)
+	created, err := p.client.Book(ctx, id, markBooked(event.Summary), span.Start.In(zone), span.End.In(zone))
+	if err != nil {
+		return "", err
+	}
+	return created.ID.String(), nil
+}
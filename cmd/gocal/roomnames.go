@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"github.com/vsekhar/gocal/internal/itercal"
+	"google.golang.org/api/calendar/v3"
+)
+
+// The room aliases file is a JSON object mapping names used in '#room:name'
+// tags to the names or emails of rooms, e.g.:
+//
+//	{
+//	  "big": "TOR-111-5-012 Phoenix (8) [VC]",
+//	  "quiet": "room-a@resource.example.com"
+//	}
+//
+// Aliases and room names are compared after normalization, so "phoenix" and
+// "Phoenix" match that room without an alias.
+type roomAliases map[string]string
+
+func defaultAliasesFile() string {
+	return filepath.Join(filepath.Dir(defaultConfigFile()), "aliases.json")
+}
+
+// loadRoomAliases loads the -room-aliases file, which need not exist.
+func loadRoomAliases() roomAliases {
+	b, err := os.ReadFile(*roomAliasesFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		log.Fatalf("reading room aliases: %v", err)
+	}
+	var raw roomAliases
+	if err := json.Unmarshal(b, &raw); err != nil {
+		log.Fatalf("parsing room aliases %s: %v", *roomAliasesFile, err)
+	}
+	ret := make(roomAliases, len(raw))
+	for k, v := range raw {
+		ret[normalizeRoomName(k)] = v
+	}
+	return ret
+}
+
+// normalizeRoomName returns the distinctive part of a room name in lower case,
+// dropping location codes, capacities and equipment, e.g. "phoenix" for
+// "TOR-111-5-012 Phoenix (8) [VC]".
+func normalizeRoomName(name string) string {
+	var words []string
+	depth := 0
+	for _, w := range strings.Fields(name) {
+		opens := strings.Count(w, "(") + strings.Count(w, "[")
+		closes := strings.Count(w, ")") + strings.Count(w, "]")
+		inside := depth > 0 || opens > 0
+		depth += opens - closes
+		if depth < 0 {
+			depth = 0
+		}
+		if inside || strings.IndexFunc(w, unicode.IsDigit) >= 0 {
+			continue
+		}
+		for _, part := range strings.FieldsFunc(w, func(r rune) bool { return r == '-' || r == '_' }) {
+			words = append(words, strings.ToLower(part))
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// requestedRoomName returns the name in a '#room:name' tag, or that of
+// another of -room-tags, on event, or "" if there is none.
+func requestedRoomName(event *calendar.Event) string {
+	for _, tag := range roomTags() {
+		if v := tagValue(event, tag); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// tagValue returns the value in a 'tag:value' tag on event, or "" if there is
+// none.
+func tagValue(event *calendar.Event, tag string) string {
+	for _, s := range []string{event.Summary, event.Description} {
+		for _, w := range strings.Fields(s) {
+			if strings.HasPrefix(w, tag+":") {
+				return strings.TrimPrefix(w, tag+":")
+			}
+		}
+	}
+	return ""
+}
+
+// findRoom returns the index in rooms of the room identified by name, which
+// may be an alias, an email or a room name, or -1 if there isn't exactly one
+// such room.
+func findRoom(name string, aliases roomAliases, rooms []*itercal.Resource) int {
+	if target, ok := aliases[normalizeRoomName(name)]; ok {
+		name = target
+	}
+	want := normalizeRoomName(name)
+	found := -1
+	for i, r := range rooms {
+		if strings.EqualFold(r.ResourceEmail, name) {
+			return i
+		}
+		if want != "" && (normalizeRoomName(r.GeneratedResourceName) == want || normalizeRoomName(r.ResourceName) == want) {
+			if found >= 0 {
+				return -1
+			}
+			found = i
+		}
+	}
+	return found
+}
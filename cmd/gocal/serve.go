@@ -0,0 +1,379 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	directory "google.golang.org/api/admin/directory/v1"
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/idtoken"
+)
+
+// 'gocal serve' answers free room queries over HTTP for any user in -domain,
+// e.g.:
+//
+//	GET /rooms/free?near=5/2&attendees=4&start=2022-04-01T09:00:00-04:00&duration=30m
+//
+// Requests authenticate with a Google ID token for -audience in an
+// "Authorization: Bearer" header. Any user in -domain may query rooms, but
+// only users listed in -admins may book them. Rooms are ranked near the
+// location in the requesting user's Directory profile unless the query gives
+// one.
+//
+// With -service-account, gocal reads the Directory and free/busy information
+// as -impersonate using domain-wide delegation, so the server needn't run as
+// any particular user. The building's rooms and gocal's caches are shared by
+// all requests.
+//
+// Endpoints:
+//
+//	/rooms: the conference rooms in -building
+//	/rooms/free {start, end, duration, attendees, near, limit}: free rooms,
+//	  best first
+//	POST /book {dryrun}: (admins) book rooms for -calendar as gocal does
+//	  without serve, returning the events and their rooms
+//	POST /notify: Calendar push notifications for -calendar (see notify.go)
+//
+// With -waitlist-interval, serve also books rooms for waitlisted events as
+// they free up (see waitlist.go).
+//
+// With -tenants, serve hosts rooms for several Workspace domains at once (see
+// tenants.go).
+
+var serveAddr *string
+var serveDomain *string
+var serveAudience *string
+var serviceAccountFile *string
+var impersonate *string
+var serveAdmins *string
+
+func serveFlags(fs *flag.FlagSet) {
+	serveAddr = fs.String("addr", "localhost:8080", "address on which to serve HTTP")
+	serveDomain = fs.String("domain", "", "domain whose users may query rooms, e.g. 'example.com'")
+	serveAudience = fs.String("audience", "", "OAuth client ID for which requests' ID tokens must be issued")
+	serviceAccountFile = fs.String("service-account", "", "service account key file with domain-wide delegation, which must grant calendar.events if the server books rooms, with -admins, -waitlist-interval or -channel-token (default: use -credentials as the user)")
+	impersonate = fs.String("impersonate", "", "user the service account acts as, e.g. an administrator who can read the building's rooms")
+	serveAdmins = fs.String("admins", "", "comma-separated email addresses of users allowed to book rooms")
+	waitlistInterval = fs.Duration("waitlist-interval", 0, "how often to check whether rooms have freed up for events on the -waitlist (default: never)")
+	channelToken = fs.String("channel-token", "", "token of the Calendar notification channels watching -calendar, which enables /notify")
+	tenantsFile = fs.String("tenants", "", "JSON file listing the Workspace domains to serve rooms for and their credentials, instead of -domain (see tenants.go)")
+}
+
+// validateIDToken returns the verified email address of the user identified
+// by an ID token for audience, and the user's hosted domain.
+var validateIDToken = func(ctx context.Context, token, audience string) (email, domain string, err error) {
+	p, err := idtoken.Validate(ctx, token, audience)
+	if err != nil {
+		return "", "", err
+	}
+	email, _ = p.Claims["email"].(string)
+	if verified, _ := p.Claims["email_verified"].(bool); !verified || email == "" {
+		return "", "", errors.New("ID token has no verified email address")
+	}
+	domain, _ = p.Claims["hd"].(string)
+	return email, domain, nil
+}
+
+// serviceAccountServices returns the Directory and Calendar services acting
+// as subject with the service account key in keyFile, e.g. -impersonate and
+// -service-account. Unless write is true, they can only read calendars. Their
+// API calls are counted with quota, if non-nil (see apiQuota).
+func serviceAccountServices(ctx context.Context, keyFile, subject string, write bool, quota *apiQuota) (*directory.Service, *calendar.Service) {
+	key, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		log.Fatalf("reading service account key: %v", err)
+	}
+	scopes := []string{
+		calendar.CalendarReadonlyScope,
+		directory.AdminDirectoryResourceCalendarReadonlyScope,
+		directory.AdminDirectoryUserReadonlyScope,
+	}
+	if write {
+		scopes = append(scopes, calendar.CalendarEventsScope) // booking rooms
+	}
+	config, err := google.JWTConfigFromJSON(key, scopes...)
+	if err != nil {
+		log.Fatalf("parsing service account key: %v", err)
+	}
+	config.Subject = subject
+	client := config.Client(context.WithValue(ctx, oauth2.HTTPClient, newHTTPClient(true, quota)))
+	client.Timeout = *httpTimeout
+	return clientServices(ctx, client)
+}
+
+// serveBooks returns true if the server may book rooms: for -admins, the
+// -waitlist or notifications.
+func serveBooks() bool {
+	return *serveAdmins != "" || *waitlistInterval > 0 || *channelToken != ""
+}
+
+// serve answers room queries over HTTP until the server fails.
+func serve(ctx context.Context) {
+	if *tenantsFile != "" {
+		serveTenants(ctx)
+		return
+	}
+	if *serveDomain == "" || *serveAudience == "" {
+		log.Fatalf("serve needs -domain and -audience to authenticate users")
+	}
+	var w *worker
+	if *serviceAccountFile != "" {
+		dirSrv, calSrv := serviceAccountServices(ctx, *serviceAccountFile, *impersonate, serveBooks(), nil)
+		w = loadWorker(ctx, dirSrv, calSrv, openCache())
+	} else {
+		w = newWorker(ctx)
+	}
+	serveMetrics(refreshMetrics(startRefresher(ctx, w.cacheSpace, w.dirSrv, *customer, []string{*buildingId})))
+	s := newRoomServer(w)
+	if *waitlistInterval > 0 {
+		go s.watchWaitlist(ctx, *waitlistInterval)
+	}
+	log.Printf("Serving rooms in %s on %s", *buildingId, *serveAddr)
+	log.Fatal(http.ListenAndServe(*serveAddr, s))
+}
+
+// A role determines which endpoints a user may call.
+type role int
+
+const (
+	// roleAnyone is for endpoints that authenticate requests themselves.
+	roleAnyone role = iota
+	roleUser
+	roleAdmin
+)
+
+// roomServer serves room queries from users in -domain.
+type roomServer struct {
+	w      *worker
+	mux    *http.ServeMux
+	admins map[string]bool
+
+	// domain, if set, is the domain of the server's users instead of
+	// -domain.
+	domain string
+
+	// bookMu serializes booking passes, which share global state, e.g. the
+	// building's zone and floors. Queries, which read it, hold it shared.
+	bookMu sync.RWMutex
+
+	// notifyMu guards notified, pending, the last message number received
+	// on each channel but not yet covered by a pass, and passing, which is
+	// true while notifiedPasses runs. passes waits for notifiedPasses.
+	notifyMu sync.Mutex
+	notified notifyState
+	pending  notifyState
+	passing  bool
+	passes   sync.WaitGroup
+
+	mu sync.Mutex
+	// near caches the location from each user's profile, or "" if it is
+	// not in -building.
+	near map[string]string
+}
+
+func newRoomServer(w *worker) *roomServer {
+	s := &roomServer{w: w, mux: http.NewServeMux(), admins: make(map[string]bool), near: make(map[string]string)}
+	for _, a := range strings.Split(*serveAdmins, ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			s.admins[strings.ToLower(a)] = true
+		}
+	}
+	s.handle("/rooms", http.MethodGet, roleUser, s.rooms)
+	s.handle("/rooms/free", http.MethodGet, roleUser, s.freeRooms)
+	s.handle("/book", http.MethodPost, roleAdmin, s.book)
+	s.handle("/notify", http.MethodPost, roleAnyone, s.notify)
+	return s
+}
+
+// handle registers h for requests to pattern with method from users with at
+// least role r. Users are authenticated unless r is roleAnyone.
+func (s *roomServer) handle(pattern, method string, r role, h http.HandlerFunc) {
+	s.mux.HandleFunc(pattern, func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method != method {
+			http.Error(rw, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		if r > roleAnyone {
+			user, err := s.authenticate(req)
+			if err == nil && s.role(user) < r {
+				err = errors.New("not permitted")
+			}
+			if err != nil {
+				log.Printf("rejecting %s %s: %v", req.Method, req.URL.Path, err)
+				code := http.StatusUnauthorized
+				if user != "" {
+					code = http.StatusForbidden
+				}
+				http.Error(rw, http.StatusText(code), code)
+				return
+			}
+			req = req.WithContext(withUser(req.Context(), user))
+		}
+		h(rw, req)
+	})
+}
+
+// role returns the role of an authenticated user.
+func (s *roomServer) role(user string) role {
+	if s.admins[strings.ToLower(user)] {
+		return roleAdmin
+	}
+	return roleUser
+}
+
+func (s *roomServer) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(rw, r)
+}
+
+// authenticate returns the email address of the user making request r. It
+// returns the address with an error if the user is not in the server's
+// domain (by default -domain).
+func (s *roomServer) authenticate(r *http.Request) (string, error) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return "", errors.New("no bearer token")
+	}
+	email, domain, err := validateIDToken(r.Context(), strings.TrimPrefix(auth, "Bearer "), *serveAudience)
+	if err != nil {
+		return "", err
+	}
+	want := s.domain
+	if want == "" {
+		want = *serveDomain
+	}
+	if !strings.EqualFold(domain, want) {
+		return email, fmt.Errorf("%s is not in domain %s", email, want)
+	}
+	return email, nil
+}
+
+type userKey struct{}
+
+func withUser(ctx context.Context, user string) context.Context {
+	return context.WithValue(ctx, userKey{}, user)
+}
+
+func userFrom(ctx context.Context) string {
+	user, _ := ctx.Value(userKey{}).(string)
+	return user
+}
+
+// userNear returns the location of user from their Directory profile as
+// "floor/section", or "" if they have none in -building.
+func (s *roomServer) userNear(ctx context.Context, user string) string {
+	s.mu.Lock()
+	near, ok := s.near[user]
+	s.mu.Unlock()
+	if ok {
+		return near
+	}
+	if loc := profileLocation(ctx, s.w.dirSrv, user); loc != nil && loc.BuildingId == *buildingId {
+		f, fok := floorLevels.level(loc.FloorName)
+		sec, err := strconv.Atoi(loc.FloorSection)
+		if fok && err == nil {
+			near = fmt.Sprintf("%d/%d", f, sec)
+		}
+	}
+	s.mu.Lock()
+	s.near[user] = near
+	s.mu.Unlock()
+	return near
+}
+
+func (s *roomServer) rooms(rw http.ResponseWriter, r *http.Request) {
+	writeJSON(rw, s.w.rooms, nil)
+}
+
+func (s *roomServer) freeRooms(rw http.ResponseWriter, r *http.Request) {
+	s.bookMu.RLock()
+	defer s.bookMu.RUnlock()
+	q, err := parseRoomQuery(r, time.Now().In(zone))
+	if err != nil {
+		writeJSON(rw, nil, err)
+		return
+	}
+	if q.Near == "" {
+		q.Near = s.userNear(r.Context(), userFrom(r.Context()))
+	}
+	rooms, err := s.w.freeRooms(r.Context(), q)
+	writeJSON(rw, rooms, err)
+}
+
+func (s *roomServer) book(rw http.ResponseWriter, r *http.Request) {
+	plan, err := strconv.ParseBool(r.URL.Query().Get("dryrun"))
+	if err != nil && r.URL.Query().Get("dryrun") != "" {
+		writeJSON(rw, nil, invalidParams{err})
+		return
+	}
+	s.bookMu.Lock()
+	defer s.bookMu.Unlock()
+	log.Printf("Booking rooms at the request of %s", userFrom(r.Context()))
+	booked, err := s.w.bookRooms(r.Context(), plan)
+	writeJSON(rw, booked, err)
+}
+
+// parseRoomQuery returns the roomQuery in the parameters of request r. The
+// period starts now and lasts 30 minutes by default.
+func parseRoomQuery(r *http.Request, now time.Time) (roomQuery, error) {
+	v := r.URL.Query()
+	q := roomQuery{Start: now.Truncate(time.Minute), Near: v.Get("near")}
+	var err error
+	if s := v.Get("start"); s != "" {
+		if q.Start, err = time.Parse(time.RFC3339, s); err != nil {
+			return roomQuery{}, invalidParams{err}
+		}
+	}
+	q.End = q.Start.Add(30 * time.Minute)
+	if s := v.Get("duration"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return roomQuery{}, invalidParams{err}
+		}
+		q.End = q.Start.Add(d)
+	}
+	if s := v.Get("end"); s != "" {
+		if q.End, err = time.Parse(time.RFC3339, s); err != nil {
+			return roomQuery{}, invalidParams{err}
+		}
+	}
+	if s := v.Get("attendees"); s != "" {
+		if q.Attendees, err = strconv.ParseInt(s, 10, 64); err != nil {
+			return roomQuery{}, invalidParams{err}
+		}
+	}
+	if s := v.Get("limit"); s != "" {
+		if q.Limit, err = strconv.Atoi(s); err != nil {
+			return roomQuery{}, invalidParams{err}
+		}
+	}
+	return q, nil
+}
+
+// writeJSON writes v as the response, or err with a status code reflecting
+// whether it is the client's fault.
+func writeJSON(rw http.ResponseWriter, v interface{}, err error) {
+	if err != nil {
+		code := http.StatusInternalServerError
+		if _, ok := err.(invalidParams); ok {
+			code = http.StatusBadRequest
+		}
+		http.Error(rw, err.Error(), code)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(rw).Encode(v); err != nil {
+		log.Printf("writing response: %v", err)
+	}
+}
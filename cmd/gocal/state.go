@@ -0,0 +1,282 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/vsekhar/gocal/internal/cache"
+)
+
+// 'gocal state export state.tar.gz' writes gocal's config, room aliases,
+// cache and -state-db to a portable archive, e.g. to move to another machine
+// or to reproduce a user's report. 'gocal state import state.tar.gz' restores
+// them, refusing to replace an existing config, aliases file or -state-db
+// without -force.
+//
+// Archives never contain credentials: OAuth tokens, client secrets and API
+// keys live in files outside the cache, which are left out, and secret flag
+// values are removed from the config. Cache files encrypted by -encrypt-cache
+// are decrypted on export and encrypted with the importing machine's key if it
+// uses -encrypt-cache too.
+
+var stateFlagSet *flag.FlagSet
+var stateForce *bool
+
+func stateFlags(fs *flag.FlagSet) {
+	stateFlagSet = fs
+	stateForce = fs.Bool("force", false, "on import, replace an existing config, room aliases file or -state-db")
+}
+
+// secretFlags are flags whose values are secrets, left out of archives.
+var secretFlags = map[string]bool{
+	"channel-token": true,
+}
+
+// Names of the parts of an archive.
+const (
+	archiveManifest = "manifest.json"
+	archiveConfig   = "config.json"
+	archiveAliases  = "aliases.json"
+	archiveStateDB  = "state.db"
+	archiveCacheDir = "cache/"
+)
+
+// stateManifest describes an archive.
+type stateManifest struct {
+	Created  time.Time `json:"created"`
+	Building string    `json:"building,omitempty"`
+}
+
+// manageState exports or imports gocal's state.
+func manageState(ctx context.Context) {
+	if stateFlagSet.NArg() != 2 || (stateFlagSet.Arg(0) != "export" && stateFlagSet.Arg(0) != "import") {
+		log.Fatalf("usage: gocal state export|import state.tar.gz")
+	}
+	var err error
+	if archive := stateFlagSet.Arg(1); stateFlagSet.Arg(0) == "export" {
+		err = exportState(archive)
+	} else {
+		err = importState(archive)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// exportState writes gocal's state to an archive at p.
+func exportState(p string) error {
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	add := func(name string, b []byte) error {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0600, Size: int64(len(b)), ModTime: time.Now()}); err != nil {
+			return err
+		}
+		_, err := tw.Write(b)
+		return err
+	}
+	err = func() error {
+		b, err := json.MarshalIndent(stateManifest{Created: time.Now().UTC(), Building: *buildingId}, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := add(archiveManifest, b); err != nil {
+			return err
+		}
+
+		c, err := loadConfig(*configFile)
+		if err != nil {
+			return fmt.Errorf("loading config: %v", err)
+		}
+		for name := range c {
+			if secretFlags[name] {
+				log.Printf("Leaving secret '%s' out of the config", name)
+				delete(c, name)
+			}
+		}
+		if b, err = json.MarshalIndent(c, "", "  "); err != nil {
+			return err
+		}
+		if err := add(archiveConfig, b); err != nil {
+			return err
+		}
+
+		if b, err := os.ReadFile(*roomAliasesFile); err == nil {
+			if err := add(archiveAliases, b); err != nil {
+				return err
+			}
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+
+		if db := openStateDB(); db != nil {
+			// Copy the database consistently even if it is in use.
+			tmp := filepath.Join(filepath.Dir(*stateDBFile), fmt.Sprintf(".export-%d.db", os.Getpid()))
+			defer os.Remove(tmp)
+			if _, err := db.Exec(`VACUUM INTO ?`, tmp); err != nil {
+				return fmt.Errorf("copying state database: %v", err)
+			}
+			b, err := os.ReadFile(tmp)
+			if err != nil {
+				return err
+			}
+			if err := add(archiveStateDB, b); err != nil {
+				return err
+			}
+		}
+
+		cacheSpace := openCache()
+		n := 0
+		err = filepath.WalkDir(cacheSpace.Path(""), func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() && strings.HasSuffix(d.Name(), ".tmp") {
+				return filepath.SkipDir
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+			rel, err := filepath.Rel(cacheSpace.Path(""), p)
+			if err != nil {
+				return err
+			}
+			b, err := readCacheFile(cacheSpace, p)
+			if err != nil {
+				return err
+			}
+			n++
+			return add(archiveCacheDir+filepath.ToSlash(rel), b)
+		})
+		if err != nil {
+			return fmt.Errorf("exporting cache: %v", err)
+		}
+		log.Printf("Exported config and %d cache files to %s", n, p)
+		return nil
+	}()
+	if err == nil {
+		err = tw.Close()
+	}
+	if err == nil {
+		err = gz.Close()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// readCacheFile returns the contents of the file at p in s, decrypted if
+// necessary.
+func readCacheFile(s *cache.Space, p string) ([]byte, error) {
+	b, err := os.ReadFile(p)
+	if err != nil || !cache.IsEncrypted(b) {
+		return b, err
+	}
+	return s.ReadFile(p)
+}
+
+// importState restores the state in the archive at p.
+func importState(p string) error {
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	cacheSpace := openCache()
+	tr := tar.NewReader(gz)
+	n := 0
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		name := path.Clean(h.Name)
+		if h.Typeflag != tar.TypeReg || path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return fmt.Errorf("unexpected entry '%s' in %s", h.Name, p)
+		}
+		b, err := io.ReadAll(tr)
+		if err != nil {
+			return err
+		}
+		switch {
+		case name == archiveManifest:
+			var m stateManifest
+			if err := json.Unmarshal(b, &m); err != nil {
+				return fmt.Errorf("reading manifest: %v", err)
+			}
+			log.Printf("Importing state exported at %s", m.Created.Format(time.RFC3339))
+		case name == archiveConfig:
+			err = importFile(*configFile, b)
+		case name == archiveAliases:
+			err = importFile(*roomAliasesFile, b)
+		case name == archiveStateDB:
+			if *stateDBFile == "" {
+				log.Printf("warning: not importing the state database without -state-db")
+				continue
+			}
+			closeStateDB()
+			err = importFile(*stateDBFile, b)
+		case strings.HasPrefix(name, archiveCacheDir):
+			dst := cacheSpace.Path(filepath.FromSlash(strings.TrimPrefix(name, archiveCacheDir)))
+			if err = os.MkdirAll(filepath.Dir(dst), 0700); err == nil {
+				if path.Base(name) == cache.MetadataFilename {
+					err = os.WriteFile(dst, b, 0600)
+				} else {
+					err = cacheSpace.WriteFile(dst, b)
+				}
+			}
+			n++
+		default:
+			log.Printf("warning: ignoring unknown entry '%s' in %s", h.Name, p)
+		}
+		if err != nil {
+			return fmt.Errorf("importing %s: %v", name, err)
+		}
+	}
+	log.Printf("Imported %d cache files from %s", n, p)
+	return nil
+}
+
+// importFile writes b to the file at p, unless it exists with other contents
+// and -force isn't set.
+func importFile(p string, b []byte) error {
+	old, err := os.ReadFile(p)
+	switch {
+	case err == nil && bytes.Equal(old, b):
+		return nil
+	case err == nil && !*stateForce:
+		return fmt.Errorf("%s exists; use -force to replace it", p)
+	case err != nil && !errors.Is(err, os.ErrNotExist):
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+		return err
+	}
+	log.Printf("Writing %s", p)
+	return os.WriteFile(p, b, 0600)
+}
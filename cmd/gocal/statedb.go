@@ -0,0 +1,322 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/vsekhar/gocal/internal/cache"
+	_ "modernc.org/sqlite" // registers the "sqlite" driver
+)
+
+// With -state-db, gocal keeps its state in a SQLite database rather than JSON
+// files in the cache: the start of each booking pass, the waitlist, bookings
+// awaiting approval, and the last notification processed on each channel.
+// Each update is a transaction, so a crash can't leave the state half
+// written, and the state can be queried, e.g.:
+//
+//	sqlite3 state.db 'SELECT summary, rooms FROM waitlist'
+//
+// Opening the database applies any stateMigrations it lacks, tracked by its
+// user_version. The first imports the state from the JSON files, if any.
+
+// stateTimeLayout is the layout of times in the database, in UTC, which
+// SQLite's date and time functions understand and which sorts as text.
+const stateTimeLayout = "2006-01-02 15:04:05.000000000"
+
+// stateMigrations upgrade the database from each version to the next.
+var stateMigrations = []func(tx *sql.Tx) error{
+	// 1: tables for each kind of state, imported from the JSON files.
+	func(tx *sql.Tx) error {
+		for _, stmt := range []string{
+			`CREATE TABLE passes (started TEXT NOT NULL)`,
+			`CREATE TABLE waitlist (
+				key TEXT PRIMARY KEY,
+				calendar TEXT NOT NULL,
+				event_id TEXT NOT NULL,
+				summary TEXT NOT NULL,
+				start_time TEXT NOT NULL,
+				end_time TEXT NOT NULL,
+				since TEXT NOT NULL,
+				rooms TEXT NOT NULL -- JSON array of emails, best first
+			)`,
+			`CREATE TABLE approvals (
+				key TEXT PRIMARY KEY,
+				summary TEXT NOT NULL,
+				room TEXT NOT NULL,
+				room_name TEXT NOT NULL,
+				requested TEXT NOT NULL
+			)`,
+			`CREATE TABLE notify_channels (
+				channel TEXT PRIMARY KEY,
+				last_message INTEGER NOT NULL
+			)`,
+		} {
+			if _, err := tx.Exec(stmt); err != nil {
+				return err
+			}
+		}
+		return importStateFiles(tx, openCache())
+	},
+}
+
+// stateDBMu guards stateDBLoaded and stateDB, which hold the database opened
+// for -state-db, if any. Servers first reach it from goroutines holding
+// different locks.
+var (
+	stateDBMu     sync.Mutex
+	stateDBLoaded bool
+	stateDB       *sql.DB
+)
+
+// openStateDB returns the database in -state-db, or nil if state is kept in
+// JSON files.
+func openStateDB() *sql.DB {
+	stateDBMu.Lock()
+	defer stateDBMu.Unlock()
+	if stateDBLoaded {
+		return stateDB
+	}
+	if *stateDBFile == "" {
+		stateDBLoaded = true
+		return nil
+	}
+	db, err := sql.Open("sqlite", *stateDBFile)
+	if err != nil {
+		log.Fatalf("opening state database: %v", err)
+	}
+	// SQLite allows one writer at a time.
+	db.SetMaxOpenConns(1)
+	if err := migrateState(db); err != nil {
+		log.Fatalf("migrating state database %s: %v", *stateDBFile, err)
+	}
+	stateDB, stateDBLoaded = db, true
+	return stateDB
+}
+
+// closeStateDB closes the database opened for -state-db, if any, for
+// openStateDB to open it again.
+func closeStateDB() {
+	stateDBMu.Lock()
+	defer stateDBMu.Unlock()
+	if stateDB != nil {
+		stateDB.Close()
+	}
+	stateDB, stateDBLoaded = nil, false
+}
+
+// migrateState applies the stateMigrations that db lacks, each in a
+// transaction.
+func migrateState(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return err
+	}
+	if version > len(stateMigrations) {
+		return fmt.Errorf("database version %d is newer than this gocal's %d", version, len(stateMigrations))
+	}
+	for ; version < len(stateMigrations); version++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if err := stateMigrations[version](tx); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %v", version+1, err)
+		}
+		if _, err := tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, version+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// importStateFiles copies the state in the JSON files in s into tx.
+func importStateFiles(tx *sql.Tx, s *cache.Space) error {
+	read := func(name string, v interface{}) error {
+		b, err := s.ReadFile(s.Path(name))
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err == nil {
+			err = json.Unmarshal(b, v)
+		}
+		if err != nil {
+			log.Printf("warning: not importing %s: %v", name, err)
+		}
+		return nil
+	}
+	wl, a, n := make(waitlist), make(approvals), make(notifyState)
+	read(waitlistFile, &wl)
+	read(approvalsFile, &a)
+	read(notifyStateFile, &n)
+	if err := saveWaitlistTx(tx, wl); err != nil {
+		return err
+	}
+	if err := saveApprovalsTx(tx, a); err != nil {
+		return err
+	}
+	if err := saveNotifyStateTx(tx, n); err != nil {
+		return err
+	}
+	b, err := s.ReadFile(s.Path(lastPassFile))
+	if err != nil {
+		return nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, string(b)); err == nil {
+		_, err := tx.Exec(`INSERT INTO passes (started) VALUES (?)`, t.UTC().Format(stateTimeLayout))
+		return err
+	}
+	return nil
+}
+
+// inStateTx runs f in a transaction on db, committing it if f succeeds.
+func inStateTx(db *sql.DB, f func(tx *sql.Tx) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := f(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func parseStateTime(s string) (time.Time, error) {
+	return time.Parse(stateTimeLayout, s)
+}
+
+func lastPassDB(db *sql.DB) (time.Time, error) {
+	var s sql.NullString
+	if err := db.QueryRow(`SELECT MAX(started) FROM passes`).Scan(&s); err != nil || !s.Valid {
+		return time.Time{}, err
+	}
+	return parseStateTime(s.String)
+}
+
+// recordPassDB records a pass started at t, forgetting those older than
+// historyPeriod.
+func recordPassDB(db *sql.DB, t time.Time) error {
+	return inStateTx(db, func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`INSERT INTO passes (started) VALUES (?)`, t.UTC().Format(stateTimeLayout)); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`DELETE FROM passes WHERE started < ?`, t.Add(-historyPeriod).UTC().Format(stateTimeLayout))
+		return err
+	})
+}
+
+func loadWaitlistDB(db *sql.DB) (waitlist, error) {
+	rows, err := db.Query(`SELECT key, calendar, event_id, summary, start_time, end_time, since, rooms FROM waitlist`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ret := make(waitlist)
+	for rows.Next() {
+		var key, since, rooms string
+		var e waitlistEntry
+		if err := rows.Scan(&key, &e.Calendar, &e.EventID, &e.Summary, &e.Start, &e.End, &since, &rooms); err != nil {
+			return nil, err
+		}
+		if e.Since, err = parseStateTime(since); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(rooms), &e.Rooms); err != nil {
+			return nil, err
+		}
+		ret[key] = e
+	}
+	return ret, rows.Err()
+}
+
+func saveWaitlistTx(tx *sql.Tx, wl waitlist) error {
+	if _, err := tx.Exec(`DELETE FROM waitlist`); err != nil {
+		return err
+	}
+	for key, e := range wl {
+		rooms, err := json.Marshal(e.Rooms)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`INSERT INTO waitlist VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			key, e.Calendar, e.EventID, e.Summary, e.Start, e.End, e.Since.UTC().Format(stateTimeLayout), string(rooms)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func loadApprovalsDB(db *sql.DB) (approvals, error) {
+	rows, err := db.Query(`SELECT key, summary, room, room_name, requested FROM approvals`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ret := make(approvals)
+	for rows.Next() {
+		var key, requested string
+		var p pendingApproval
+		if err := rows.Scan(&key, &p.Summary, &p.Room, &p.RoomName, &requested); err != nil {
+			return nil, err
+		}
+		if p.Requested, err = parseStateTime(requested); err != nil {
+			return nil, err
+		}
+		ret[key] = p
+	}
+	return ret, rows.Err()
+}
+
+func saveApprovalsTx(tx *sql.Tx, a approvals) error {
+	if _, err := tx.Exec(`DELETE FROM approvals`); err != nil {
+		return err
+	}
+	for key, p := range a {
+		if _, err := tx.Exec(`INSERT INTO approvals VALUES (?, ?, ?, ?, ?)`,
+			key, p.Summary, p.Room, p.RoomName, p.Requested.UTC().Format(stateTimeLayout)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func loadNotifyStateDB(db *sql.DB) (notifyState, error) {
+	rows, err := db.Query(`SELECT channel, last_message FROM notify_channels`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ret := make(notifyState)
+	for rows.Next() {
+		var channel string
+		var n int64
+		if err := rows.Scan(&channel, &n); err != nil {
+			return nil, err
+		}
+		ret[channel] = n
+	}
+	return ret, rows.Err()
+}
+
+func saveNotifyStateTx(tx *sql.Tx, n notifyState) error {
+	if _, err := tx.Exec(`DELETE FROM notify_channels`); err != nil {
+		return err
+	}
+	for channel, num := range n {
+		if _, err := tx.Exec(`INSERT INTO notify_channels VALUES (?, ?)`, channel, num); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/vsekhar/gocal/internal/cache"
+	"github.com/vsekhar/gocal/internal/interval"
+	"github.com/vsekhar/gocal/internal/itercal"
+	"github.com/vsekhar/gocal/internal/rank"
+	directory "google.golang.org/api/admin/directory/v1"
+	"google.golang.org/api/calendar/v3"
+)
+
+// With -stdio, gocal stays running and answers JSON-RPC 2.0 requests, one JSON
+// object per line on stdin, with responses on stdout, e.g.:
+//
+//	{"jsonrpc": "2.0", "id": 1, "method": "freeRooms", "params": {"start": "2022-04-01T09:00:00-04:00", "end": "2022-04-01T09:30:00-04:00"}}
+//
+// Credentials, caches and the building's rooms are loaded once, so that
+// editors and launchers can query a warm process. Logs go to stderr.
+//
+// Methods:
+//
+//	searchBuildings {query, city}: buildings matching query, best first
+//	rooms {}: the conference rooms in -building
+//	freeRooms {start, end, attendees, near, limit}: free rooms, best first
+//	book {dryrun}: book rooms as gocal does without -stdio, returning the
+//	  events and their rooms
+
+// rpcRequest is a JSON-RPC 2.0 request. Requests without an ID are
+// notifications, which get no response.
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// JSON-RPC 2.0 error codes.
+const (
+	rpcParseError     = -32700
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcInternalError  = -32603
+)
+
+// worker holds the state kept warm between requests.
+type worker struct {
+	dirSrv     *directory.Service
+	calSrv     *calendar.Service
+	cacheSpace *cache.Space
+
+	// rooms are the conference rooms in -building, sorted by email.
+	rooms []*itercal.Resource
+}
+
+// newWorker authenticates the user and loads the rooms in -building.
+func newWorker(ctx context.Context) *worker {
+	dirSrv, calSrv := newServices(ctx)
+	return loadWorker(ctx, dirSrv, calSrv, openCache())
+}
+
+// loadWorker loads the rooms in -building using the given services and cache.
+func loadWorker(ctx context.Context, dirSrv *directory.Service, calSrv *calendar.Service, cacheSpace *cache.Space) *worker {
+	w := &worker{dirSrv: dirSrv, calSrv: calSrv, cacheSpace: cacheSpace}
+	inferLocation(ctx, w.dirSrv, w.calSrv)
+	if err := resolveBuilding(ctx, w.cacheSpace, w.dirSrv); err != nil {
+		log.Fatal(err)
+	}
+	building, err := loadBuilding(ctx, w.cacheSpace, w.dirSrv)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if zone, err = buildingZone(ctx, building); err != nil {
+		log.Fatal(err)
+	}
+	floorLevels = newFloorOrder(building.FloorNames)
+	resources, err := loadResources(ctx, w.cacheSpace, w.dirSrv)
+	if err != nil {
+		log.Fatalf("loading resources for building %s: %v", *buildingId, err)
+	}
+	w.rooms = resources.ConferenceRooms()
+	sort.Slice(w.rooms, func(i, j int) bool { return w.rooms[i].ResourceEmail < w.rooms[j].ResourceEmail })
+	return w
+}
+
+// serveStdio answers requests on stdin until it is closed.
+func serveStdio(ctx context.Context) {
+	w := newWorker(ctx)
+	log.Printf("Ready for requests on stdin")
+	if err := w.serve(ctx, os.Stdin, os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// serve answers the requests read from r, writing responses to out.
+func (w *worker) serve(ctx context.Context, r io.Reader, out io.Writer) error {
+	methods := map[string]func(context.Context, json.RawMessage) (interface{}, error){
+		"searchBuildings": w.searchBuildings,
+		"rooms":           func(context.Context, json.RawMessage) (interface{}, error) { return w.rooms, nil },
+		"freeRooms":       w.freeRoomsMethod,
+		"book":            w.book,
+	}
+	enc := json.NewEncoder(out)
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		var req rpcRequest
+		resp := rpcResponse{JSONRPC: "2.0", ID: json.RawMessage("null")}
+		if err := json.Unmarshal(sc.Bytes(), &req); err != nil {
+			resp.Error = &rpcError{Code: rpcParseError, Message: err.Error()}
+		} else if m, ok := methods[req.Method]; !ok {
+			resp.Error = &rpcError{Code: rpcMethodNotFound, Message: fmt.Sprintf("unknown method '%s'", req.Method)}
+		} else {
+			var err error
+			resp.Result, err = m(ctx, req.Params)
+			if err != nil {
+				resp.Error = rpcErrorOf(err)
+			}
+		}
+		if req.ID == nil && resp.Error == nil {
+			continue
+		}
+		if req.ID != nil {
+			resp.ID = req.ID
+		}
+		if err := enc.Encode(resp); err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
+
+// invalidParams marks errors in a request's parameters.
+type invalidParams struct{ error }
+
+func rpcErrorOf(err error) *rpcError {
+	if _, ok := err.(invalidParams); ok {
+		return &rpcError{Code: rpcInvalidParams, Message: err.Error()}
+	}
+	return &rpcError{Code: rpcInternalError, Message: err.Error()}
+}
+
+// decodeParams decodes params into v, if there are any.
+func decodeParams(params json.RawMessage, v interface{}) error {
+	if len(params) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(params, v); err != nil {
+		return invalidParams{err}
+	}
+	return nil
+}
+
+func (w *worker) searchBuildings(ctx context.Context, params json.RawMessage) (interface{}, error) {
+	var p struct {
+		Query string `json:"query"`
+		City  string `json:"city"`
+	}
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	idx, err := itercal.Buildings(ctx, w.cacheSpace, w.dirSrv, *customer)
+	if err != nil {
+		return nil, err
+	}
+	defer idx.Close()
+	m, err := itercal.SearchBuildings(idx, p.Query, p.City, *searchMargin)
+	var ambiguous *itercal.AmbiguousError
+	if errors.As(err, &ambiguous) {
+		return ambiguous.Candidates, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []itercal.BuildingMatch{m}, nil
+}
+
+// roomQuery asks for rooms free for a period.
+type roomQuery struct {
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+	Attendees int64     `json:"attendees"`
+
+	// Near is a location of the form "floor/section" (default: -floor and
+	// -section).
+	Near string `json:"near"`
+
+	// Limit is the maximum number of rooms to return (default 5).
+	Limit int `json:"limit"`
+}
+
+// freeRoom is a room returned for a roomQuery.
+type freeRoom struct {
+	Email    string  `json:"email"`
+	Name     string  `json:"name"`
+	Floor    string  `json:"floor"`
+	Section  string  `json:"section"`
+	Capacity int64   `json:"capacity"`
+	Distance int     `json:"distance"`
+	Score    float64 `json:"score"`
+}
+
+func (w *worker) freeRoomsMethod(ctx context.Context, params json.RawMessage) (interface{}, error) {
+	var q roomQuery
+	if err := decodeParams(params, &q); err != nil {
+		return nil, err
+	}
+	return w.freeRooms(ctx, q)
+}
+
+// freeRooms returns the rooms free throughout the period in q, best first.
+func (w *worker) freeRooms(ctx context.Context, q roomQuery) ([]freeRoom, error) {
+	if q.Start.IsZero() || !q.End.After(q.Start) {
+		return nil, invalidParams{errors.New("need a start before the end")}
+	}
+	if q.Limit <= 0 {
+		q.Limit = 5
+	}
+	req := rank.Request{Attendees: q.Attendees, Elevator: *accessible}
+	switch {
+	case q.Near != "":
+		l, err := rank.ParseLocation(q.Near)
+		if err != nil {
+			return nil, invalidParams{err}
+		}
+		req.Near = []rank.Location{l}
+	case *floor != 0 && *section != 0:
+		req.Near = []rank.Location{{Floor: *floor, Section: *section}}
+	}
+
+	var candidates []*itercal.Resource
+	var rooms []rank.Room
+	for _, r := range w.rooms {
+		l, ok := roomLocation(r)
+		if !ok {
+			continue
+		}
+		candidates = append(candidates, r)
+		rooms = append(rooms, rank.Room{Email: r.ResourceEmail, Location: l, Capacity: r.Capacity, Features: r.Features})
+	}
+	ids := make([]string, len(candidates))
+	for i, r := range candidates {
+		ids[i] = r.ResourceEmail
+	}
+	freeBusy, err := roomFreeBusy(ctx, w.calSrv, ids, q.Start, q.End)
+	if err != nil {
+		return nil, err
+	}
+	isUsable := usableRoom(candidates)
+	period := interval.Interval{Start: q.Start, End: q.End}
+	order, scores := rank.Rank(scoringWeights(), req, rooms)
+	var ret []freeRoom
+	for _, i := range order {
+		if len(ret) == q.Limit {
+			break
+		}
+		r := candidates[i]
+		if scores[i].TooSmall || !isUsable(i) || !isFree(freeBusy, r.ResourceEmail, period) {
+			continue
+		}
+		ret = append(ret, freeRoom{
+			Email:    r.ResourceEmail,
+			Name:     r.GeneratedResourceName,
+			Floor:    r.FloorName,
+			Section:  r.FloorSection,
+			Capacity: r.Capacity,
+			Distance: scores[i].Distance,
+			Score:    scores[i].Total,
+		})
+	}
+	return ret, nil
+}
+
+// bookedEvent is an event returned by the book method.
+type bookedEvent struct {
+	Summary  string `json:"summary"`
+	Start    string `json:"start"`
+	Room     string `json:"room,omitempty"`
+	Proposed bool   `json:"proposed"`
+}
+
+func (w *worker) book(ctx context.Context, params json.RawMessage) (interface{}, error) {
+	var p struct {
+		DryRun bool `json:"dryrun"`
+	}
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	return w.bookRooms(ctx, p.DryRun)
+}
+
+// bookRooms books rooms as gocal does without -stdio, with the worker's
+// services and cache, only planning them if plan is true, and returns the
+// events and their rooms.
+func (w *worker) bookRooms(ctx context.Context, plan bool) ([]bookedEvent, error) {
+	oldDryRun, oldAfterPlan, oldServices := *dryRun, afterPlan, passServices
+	defer func() { *dryRun, afterPlan, passServices = oldDryRun, oldAfterPlan, oldServices }()
+	passServices.dirSrv, passServices.calSrv, passServices.cacheSpace = w.dirSrv, w.calSrv, w.cacheSpace
+	*dryRun = *dryRun || plan
+	var ret []bookedEvent
+	afterPlan = func(events []*calendar.Event, _ []string, rooms []*itercal.Resource, proposed []bool) {
+		for i, e := range events {
+			b := bookedEvent{Summary: e.Summary, Start: e.Start.DateTime, Proposed: proposed[i]}
+			if rooms[i] != nil {
+				b.Room = rooms[i].GeneratedResourceName
+			}
+			ret = append(ret, b)
+		}
+	}
+	if err := bookPass(ctx); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/vsekhar/gocal/internal/interval"
+	"github.com/vsekhar/gocal/internal/itercal"
+	"github.com/vsekhar/gocal/internal/rank"
+	"github.com/vsekhar/gocal/internal/timeutil"
+	"google.golang.org/api/calendar/v3"
+)
+
+// Meetings that can't get a room are checked for small changes, moving or
+// shortening them by up to maxAdjustment, that would free one up. Failing
+// that, other times on the same day when rooms are free are suggested.
+const (
+	maxAdjustment  = 15 * time.Minute
+	adjustmentStep = 5 * time.Minute
+
+	// minShortened is the shortest a meeting is suggested to become.
+	minShortened = 15 * time.Minute
+
+	maxAlternatives = 3
+	alternativeStep = 15 * time.Minute
+)
+
+// suggestion is a change to an event's time for which a room is free.
+type suggestion struct {
+	event  *calendar.Event
+	period interval.Interval
+	room   *itercal.Resource
+}
+
+func (s suggestion) String() string {
+	e := interval.OrDie(s.event.Start.DateTime, s.event.End.DateTime)
+	var change string
+	switch {
+	case s.period.Duration() == e.Duration() && s.period.Start.Before(e.Start):
+		change = fmt.Sprintf("move %s earlier", shift(e.Start.Sub(s.period.Start)))
+	case s.period.Duration() == e.Duration():
+		change = fmt.Sprintf("move %s later", shift(s.period.Start.Sub(e.Start)))
+	case s.period.Start.Equal(e.Start):
+		change = fmt.Sprintf("end %s earlier", shift(e.End.Sub(s.period.End)))
+	default:
+		change = fmt.Sprintf("start %s later", shift(s.period.Start.Sub(e.Start)))
+	}
+	ret := fmt.Sprintf("%s (%s-%s): %s, at %s-%s, to get %s", s.event.Summary,
+		e.Start.In(zone).Format("15:04"), e.End.In(zone).Format("15:04"), change,
+		s.period.Start.In(zone).Format("15:04"), s.period.End.In(zone).Format("15:04"),
+		s.room.GeneratedResourceName)
+	if s.event.HtmlLink != "" {
+		ret += " " + s.event.HtmlLink
+	}
+	return ret
+}
+
+// shift formats a change in time, e.g. "15 min", "2h" or "2h30m".
+func shift(d time.Duration) string {
+	if d < time.Hour {
+		return fmt.Sprintf("%d min", int(d.Minutes()))
+	}
+	s := strings.TrimSuffix(d.Round(time.Minute).String(), "0s")
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
+
+// adjustments returns the periods to try instead of e, smallest changes
+// first, within window.
+func adjustments(e, window interval.Interval) []interval.Interval {
+	var ret []interval.Interval
+	for d := adjustmentStep; d <= maxAdjustment; d += adjustmentStep {
+		candidates := []interval.Interval{
+			{Start: e.Start, End: e.End.Add(-d)},         // end early
+			{Start: e.Start.Add(d), End: e.End},          // start late
+			{Start: e.Start.Add(-d), End: e.End.Add(-d)}, // move earlier
+			{Start: e.Start.Add(d), End: e.End.Add(d)},   // move later
+		}
+		for _, c := range candidates {
+			if c.Duration() < minShortened || c.Start.Before(window.Start) || c.End.After(window.End) {
+				continue
+			}
+			ret = append(ret, c)
+		}
+	}
+	return ret
+}
+
+// suggestAdjustment returns the smallest change to the time of event, within
+// window, for which a room that suits req is free, choosing the best such
+// room.
+func suggestAdjustment(w rank.Weights, event *calendar.Event, req rank.Request, window interval.Interval, rooms []rank.Room, resources []*itercal.Resource, usable func(int) bool, freeBusy map[string]calendar.FreeBusyCalendar) (suggestion, bool) {
+	order, scores := rank.Rank(w, req, rooms)
+	e := interval.OrDie(event.Start.DateTime, event.End.DateTime)
+	for _, p := range adjustments(e, window) {
+		if r := bestFreeRoom(order, scores, p, resources, usable, freeBusy); r != nil {
+			return suggestion{event: event, period: p, room: r}, true
+		}
+	}
+	return suggestion{}, false
+}
+
+// alternativeTimes returns up to maxAlternatives periods as long as event on
+// the same day and within window, nearest its start first and not
+// overlapping each other, in which a room that suits req is free, with the
+// best such room.
+func alternativeTimes(w rank.Weights, event *calendar.Event, req rank.Request, window interval.Interval, rooms []rank.Room, resources []*itercal.Resource, usable func(int) bool, freeBusy map[string]calendar.FreeBusyCalendar) []suggestion {
+	order, scores := rank.Rank(w, req, rooms)
+	e := interval.OrDie(event.Start.DateTime, event.End.DateTime)
+	day := timeutil.Date(e.Start, zone)
+	var ret []suggestion
+	for k := 1; len(ret) < maxAlternatives; k++ {
+		inDay := false
+		for _, d := range []time.Duration{-time.Duration(k) * alternativeStep, time.Duration(k) * alternativeStep} {
+			p := interval.Interval{Start: e.Start.Add(d), End: e.End.Add(d)}
+			if timeutil.Date(p.Start, zone) != day || timeutil.Date(p.End.Add(-time.Nanosecond), zone) != day {
+				continue
+			}
+			inDay = true
+			if p.Start.Before(window.Start) || p.End.After(window.End) {
+				continue
+			}
+			overlaps := false
+			for _, s := range ret {
+				overlaps = overlaps || s.period.Overlaps(p)
+			}
+			if overlaps {
+				continue
+			}
+			if r := bestFreeRoom(order, scores, p, resources, usable, freeBusy); r != nil {
+				ret = append(ret, suggestion{event: event, period: p, room: r})
+			}
+		}
+		if !inDay {
+			break
+		}
+	}
+	if len(ret) > maxAlternatives {
+		ret = ret[:maxAlternatives]
+	}
+	return ret
+}
+
+// bestFreeRoom returns the first room in order that suits the request scored
+// in scores and is free during p, or nil if there is none.
+func bestFreeRoom(order []int, scores []rank.Score, p interval.Interval, resources []*itercal.Resource, usable func(int) bool, freeBusy map[string]calendar.FreeBusyCalendar) *itercal.Resource {
+	for _, r := range order {
+		if scores[r].TooSmall || scores[r].MissingFeatures > 0 || !usable(r) {
+			continue
+		}
+		if isFree(freeBusy, resources[r].ResourceEmail, p) {
+			return resources[r]
+		}
+	}
+	return nil
+}
+
+// reportSuggestions logs the suggested changes for meetings without rooms.
+// Other times for the same meeting are listed together.
+func reportSuggestions(suggestions []suggestion) {
+	if len(suggestions) == 0 {
+		return
+	}
+	lines := make([]string, len(suggestions))
+	for i, s := range suggestions {
+		lines[i] = "  " + s.String()
+	}
+	log.Printf("%s\n%s", msg("Meetings without rooms could get one if changed:"), strings.Join(lines, "\n"))
+}
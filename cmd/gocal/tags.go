@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// A tag in an event's summary or description asks for a room even if the user
+// is its only attendee. The tags are '#room' unless -room-tags names others,
+// e.g. '#book' where other automation already uses '#room', or both while
+// people get used to the new one. Once a room is booked, the tag is replaced
+// by -room-tag-done so that the event isn't booked again. Tags also take
+// values, as in '#room:phoenix' (see roomnames.go).
+
+// roomTags returns the tags of -room-tags, longest first so that tags which
+// contain others are replaced whole.
+func roomTags() []string {
+	tags := splitList(*roomTagsFlag)
+	sort.SliceStable(tags, func(i, j int) bool { return len(tags[i]) > len(tags[j]) })
+	return tags
+}
+
+// checkRoomTags returns an error if -room-tags and -room-tag-done can't be
+// used to tag events.
+func checkRoomTags() error {
+	tags := roomTags()
+	if len(tags) == 0 {
+		return fmt.Errorf("-room-tags is empty")
+	}
+	if *roomTagDone == "" || strings.ContainsAny(*roomTagDone, " \t\n") {
+		return fmt.Errorf("-room-tag-done '%s' is not a single word", *roomTagDone)
+	}
+	for _, tag := range tags {
+		if strings.ContainsAny(tag, " \t\n:") {
+			return fmt.Errorf("room tag '%s' contains a space or ':'", tag)
+		}
+		if strings.Contains(*roomTagDone, tag) {
+			// Booked events would be booked again.
+			return fmt.Errorf("-room-tag-done '%s' contains room tag '%s'", *roomTagDone, tag)
+		}
+	}
+	return nil
+}
+
+// hasRoomTag returns true if s contains one of -room-tags.
+func hasRoomTag(s string) bool {
+	for _, tag := range roomTags() {
+		if strings.Contains(s, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// markBooked returns s with -room-tags replaced by -room-tag-done.
+func markBooked(s string) string {
+	for _, tag := range roomTags() {
+		s = strings.ReplaceAll(s, tag, *roomTagDone)
+	}
+	return s
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// With -telemetry, gocal helps its maintainers see how it fares by reporting
+// anonymous counters to the given URL. Telemetry is off unless the flag is
+// set. Counters from each booking pass accumulate in the cache and are sent
+// at most once per telemetryPeriod, as a JSON telemetryReport. Reports
+// contain only counts and the platform, never names, emails, event details or
+// building IDs, nor any identifier of the user or installation. Failures to
+// send are ignored, and the counts are sent with the next report.
+
+// telemetryFile accumulates counters between reports.
+const telemetryFile = "telemetry.json"
+
+// telemetryPeriod is the minimum time between reports.
+const telemetryPeriod = 24 * time.Hour
+
+// telemetryReport is the content of a report.
+type telemetryReport struct {
+	// Since is the hour from which the counters have accumulated.
+	Since time.Time `json:"since"`
+
+	OS   string `json:"os"`
+	Arch string `json:"arch"`
+
+	// Passes is the number of booking passes.
+	Passes int64 `json:"passes"`
+
+	// Events is the number of events needing rooms, of which Booked got
+	// one during a pass and Unbooked were left without one.
+	Events   int64 `json:"events"`
+	Booked   int64 `json:"booked"`
+	Unbooked int64 `json:"unbooked"`
+
+	APICalls int64 `json:"apiCalls"`
+
+	// APIErrors counts failed API calls by HTTP status code, or "network"
+	// if there was no response.
+	APIErrors map[string]int64 `json:"apiErrors,omitempty"`
+}
+
+// passTelemetry accumulates the counters of a run's booking pass over its
+// buildings until they are recorded.
+var passTelemetry struct {
+	events, booked, unbooked int
+}
+
+// unrecordedAPICalls counts the API calls made since telemetry was last
+// recorded, unlike apiCalls, which -daemon resets for each scan.
+var unrecordedAPICalls int64
+
+// apiErrors counts failed API calls during the run by class.
+var apiErrors = struct {
+	sync.Mutex
+	m map[string]int64
+}{m: make(map[string]int64)}
+
+// countAPIError counts a failed API call, given its response or error.
+func countAPIError(resp *http.Response, err error) {
+	var class string
+	switch {
+	case err != nil:
+		class = "network"
+	case resp.StatusCode >= 400:
+		class = strconv.Itoa(resp.StatusCode)
+	default:
+		return
+	}
+	apiErrors.Lock()
+	apiErrors.m[class]++
+	apiErrors.Unlock()
+}
+
+// countTelemetry adds the counters of a pass in a building to passTelemetry.
+func countTelemetry(events, booked, unbooked int) {
+	passTelemetry.events += events
+	passTelemetry.booked += booked
+	passTelemetry.unbooked += unbooked
+}
+
+// recordTelemetry adds the counters of a run's booking pass, in all its
+// buildings, and the API calls since the last record to those accumulated in
+// the cache, and sends them if telemetryPeriod has passed since the last
+// report.
+func recordTelemetry(ctx context.Context) {
+	pass := passTelemetry
+	passTelemetry.events, passTelemetry.booked, passTelemetry.unbooked = 0, 0, 0
+	calls := atomic.SwapInt64(&unrecordedAPICalls, 0)
+	if *telemetryURL == "" {
+		return
+	}
+	// The cache of the pass, as from scanServices.
+	s := passServices.cacheSpace
+	if s == nil {
+		s = openCache()
+	}
+	var r telemetryReport
+	b, err := s.ReadFile(s.Path(telemetryFile))
+	if err == nil {
+		err = json.Unmarshal(b, &r)
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("warning: resetting telemetry counters: %v", err)
+		r = telemetryReport{}
+	}
+	now := time.Now().UTC()
+	if r.Since.IsZero() {
+		// Round to the hour so that the time doesn't identify the user.
+		r.Since = now.Truncate(time.Hour)
+	}
+	r.Passes++
+	r.Events += int64(pass.events)
+	r.Booked += int64(pass.booked)
+	r.Unbooked += int64(pass.unbooked)
+	r.APICalls += calls
+	apiErrors.Lock()
+	for class, n := range apiErrors.m {
+		if r.APIErrors == nil {
+			r.APIErrors = make(map[string]int64)
+		}
+		r.APIErrors[class] += n
+	}
+	apiErrors.m = make(map[string]int64)
+	apiErrors.Unlock()
+
+	if now.Sub(r.Since) >= telemetryPeriod {
+		r.OS, r.Arch = runtime.GOOS, runtime.GOARCH
+		if err := sendTelemetry(ctx, r); err != nil {
+			if *verbose {
+				log.Printf("sending telemetry: %v", err)
+			}
+		} else {
+			r = telemetryReport{}
+		}
+	}
+	if b, err = json.Marshal(r); err == nil {
+		err = s.WriteFile(s.Path(telemetryFile), b)
+	}
+	if err != nil {
+		log.Printf("warning: recording telemetry counters: %v", err)
+	}
+}
+
+// sendTelemetry posts r to -telemetry.
+func sendTelemetry(ctx context.Context, r telemetryReport) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, *telemetryURL, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	// Not counted as an API call.
+	resp, err := newHTTPClient(false, nil).Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	return nil
+}
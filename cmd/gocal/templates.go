@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/vsekhar/gocal/internal/rank"
+	"google.golang.org/api/calendar/v3"
+)
+
+// Booking templates bundle settings for a type of meeting, selected by tagging
+// events with '#tmpl:name'. They are defined under "templates" in the config
+// file, e.g.:
+//
+//	{
+//	  "templates": {
+//	    "interview": {
+//	      "features": ["VC"],
+//	      "near": "1/1",
+//	      "weights": "guest=20",
+//	      "hold": true,
+//	      "holdPrivacy": "private"
+//	    }
+//	  }
+//	}
+type bookingTemplate struct {
+	// Features are the features rooms must have.
+	Features []string `json:"features"`
+
+	// Near is a location, as "floor/section", rooms should be close to, e.g.
+	// reception.
+	Near string `json:"near"`
+
+	// Preset and Weights select scoring weights as -preset and -weights do.
+	Preset  string `json:"preset"`
+	Weights string `json:"weights"`
+
+	// Hold books rooms in separate holds, as for '#room' tags, and
+	// HoldPrivacy overrides -hold-privacy for them.
+	Hold        bool   `json:"hold"`
+	HoldPrivacy string `json:"holdPrivacy"`
+
+	near    *rank.Location
+	weights rank.Weights
+}
+
+const templateTag = "#tmpl"
+
+// bookingTemplates are the templates in the config file, loaded by
+// loadTemplates.
+var bookingTemplates map[string]*bookingTemplate
+
+// loadTemplates loads and checks the templates in the config file.
+func loadTemplates() {
+	c, err := loadConfig(*configFile)
+	if err != nil {
+		log.Fatalf("loading config %s: %v", *configFile, err)
+	}
+	bookingTemplates = make(map[string]*bookingTemplate)
+	if c["templates"] == nil {
+		return
+	}
+	// The config is decoded generically, so round-trip templates through JSON.
+	b, err := json.Marshal(c["templates"])
+	if err == nil {
+		err = json.Unmarshal(b, &bookingTemplates)
+	}
+	if err != nil {
+		log.Fatalf("parsing templates in %s: %v", *configFile, err)
+	}
+	for name, t := range bookingTemplates {
+		if err := t.check(); err != nil {
+			log.Fatalf("template '%s': %v", name, err)
+		}
+	}
+}
+
+// check validates t and fills in its parsed fields.
+func (t *bookingTemplate) check() error {
+	if t.Near != "" {
+		l, err := rank.ParseLocation(t.Near)
+		if err != nil {
+			return err
+		}
+		t.near = &l
+	}
+	t.weights = scoringWeights()
+	if t.Preset != "" {
+		base, ok := rank.Presets[t.Preset]
+		if !ok {
+			return fmt.Errorf("unknown preset '%s'", t.Preset)
+		}
+		t.weights = base
+	}
+	var err error
+	if t.weights, err = rank.ParseWeights(t.Weights, t.weights); err != nil {
+		return err
+	}
+	if t.HoldPrivacy != "" && t.HoldPrivacy != "default" && t.HoldPrivacy != "private" {
+		return fmt.Errorf("unknown holdPrivacy '%s'", t.HoldPrivacy)
+	}
+	return nil
+}
+
+// eventTemplate returns the template selected by a '#tmpl:name' tag on event,
+// or nil if there is none. Unknown templates are ignored with a warning.
+func eventTemplate(event *calendar.Event) *bookingTemplate {
+	name := tagValue(event, templateTag)
+	if name == "" {
+		return nil
+	}
+	if bookingTemplates == nil {
+		loadTemplates()
+	}
+	t, ok := bookingTemplates[name]
+	if !ok {
+		log.Printf("warning: unknown template '%s' for %s", name, event.Summary)
+		bookingTemplates[name] = nil // warn once
+	}
+	return t
+}
+
+// holdPrivacyFor returns the visibility of a room hold for event: that of its
+// template, if set, or else -hold-privacy.
+func holdPrivacyFor(event *calendar.Event) string {
+	if t := eventTemplate(event); t != nil && t.HoldPrivacy != "" {
+		return t.HoldPrivacy
+	}
+	return *holdPrivacy
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vsekhar/gocal/internal/cache"
+)
+
+// With -tenants, 'gocal serve' hosts room queries for several Workspace
+// domains in one deployment. The file lists each domain with its own service
+// account and building, e.g.:
+//
+//	[{"domain": "example.com", "serviceAccount": "example-key.json",
+//	  "impersonate": "admin@example.com", "building": "NYC-1",
+//	  "maxAPICallsPerDay": 50000}]
+//
+// Requests authenticate with ID tokens for -audience as without -tenants, and
+// are answered by the tenant of the user's domain. Each tenant has its own
+// cache space and daily API call quota, and its requests and API calls are
+// counted under its domain at /metrics on -metrics-addr, with the refreshes of
+// its cache (see refresh.go).
+//
+// Tenants share gocal's building state, so requests are served one
+// at a time. /book, which acts as the -credentials user, and the
+// -waitlist-interval and -channel-token features are not available to tenants.
+
+var tenantsFile *string
+
+// tenantConfig configures a tenant in -tenants.
+type tenantConfig struct {
+	Domain         string `json:"domain"`
+	ServiceAccount string `json:"serviceAccount"`
+	Impersonate    string `json:"impersonate"`
+	Building       string `json:"building"`
+
+	// Customer is the Directory customer ID (default: that of Impersonate).
+	Customer string `json:"customer"`
+
+	// MaxAPICallsPerDay limits the API calls made for the tenant each day
+	// (default: no limit).
+	MaxAPICallsPerDay int64 `json:"maxAPICallsPerDay"`
+}
+
+// tenant is a domain served with -tenants.
+type tenant struct {
+	tenantConfig
+	s           *roomServer
+	zone        *time.Location
+	floorLevels floorOrder
+	refresher   *cache.Refresher
+
+	// floor and section are the impersonated user's, if in the building.
+	floor, section int
+
+	// quota counts the API calls made with the tenant's services, including
+	// the refresher's, and limits them to MaxAPICallsPerDay.
+	quota *apiQuota
+
+	// requests counts requests by status code for /metrics.
+	requests map[int]int64
+}
+
+// tenantServer routes requests to the tenant of the requesting user.
+type tenantServer struct {
+	// mu serializes requests, during which the tenant's state is installed
+	// in gocal's globals.
+	mu      sync.Mutex
+	tenants map[string]*tenant
+}
+
+// loadTenants reads the tenants in -tenants.
+func loadTenants() []tenantConfig {
+	b, err := ioutil.ReadFile(*tenantsFile)
+	if err != nil {
+		log.Fatalf("reading tenants: %v", err)
+	}
+	var ret []tenantConfig
+	if err := json.Unmarshal(b, &ret); err != nil {
+		log.Fatalf("parsing tenants in %s: %v", *tenantsFile, err)
+	}
+	for _, c := range ret {
+		if c.Domain == "" || c.ServiceAccount == "" || c.Impersonate == "" || c.Building == "" {
+			log.Fatalf("tenant '%s' in %s needs a domain, serviceAccount, impersonate and building", c.Domain, *tenantsFile)
+		}
+	}
+	return ret
+}
+
+// newTenantServer loads the rooms of each tenant in configs.
+func newTenantServer(ctx context.Context, configs []tenantConfig) *tenantServer {
+	m := &tenantServer{tenants: make(map[string]*tenant)}
+	oldBuilding, oldCustomer, oldFloor, oldSection := *buildingId, *customer, *floor, *section
+	oldZone, oldLevels := zone, floorLevels
+	defer func() {
+		*buildingId, *customer, *floor, *section = oldBuilding, oldCustomer, oldFloor, oldSection
+		zone, floorLevels = oldZone, oldLevels
+	}()
+	for _, c := range configs {
+		domain := strings.ToLower(c.Domain)
+		if m.tenants[domain] != nil {
+			log.Fatalf("tenant %s listed twice in %s", domain, *tenantsFile)
+		}
+		*buildingId, *customer, *floor, *section = c.Building, oldCustomer, oldFloor, oldSection
+		if c.Customer != "" {
+			*customer = c.Customer
+		}
+		quota := &apiQuota{perDay: c.MaxAPICallsPerDay}
+		dirSrv, calSrv := serviceAccountServices(ctx, c.ServiceAccount, c.Impersonate, false, quota)
+		w := loadWorker(ctx, dirSrv, calSrv, openCacheFor(filepath.Join("gocal", "tenants", domain)))
+		t := &tenant{tenantConfig: c, zone: zone, floorLevels: floorLevels, floor: *floor, section: *section, quota: quota, requests: make(map[int]int64)}
+		t.Building, t.Customer = *buildingId, *customer
+		t.s = newRoomServer(w)
+		t.s.domain, t.s.admins = domain, nil
+		t.refresher = startRefresher(ctx, w.cacheSpace, dirSrv, t.Customer, []string{t.Building})
+		m.tenants[domain] = t
+		log.Printf("Serving rooms in %s for %s", t.Building, domain)
+	}
+	return m
+}
+
+// serveTenants answers room queries for the tenants in -tenants over HTTP
+// until the server fails.
+func serveTenants(ctx context.Context) {
+	if *serveAudience == "" {
+		log.Fatalf("serve needs -audience to authenticate users")
+	}
+	if *serveDomain != "" || *waitlistInterval > 0 || *channelToken != "" {
+		log.Fatalf("-tenants can't be combined with -domain, -waitlist-interval or -channel-token")
+	}
+	m := newTenantServer(ctx, loadTenants())
+	serveMetrics(m.metrics)
+	log.Printf("Serving %d tenants on %s", len(m.tenants), *serveAddr)
+	log.Fatal(http.ListenAndServe(*serveAddr, m))
+}
+
+// tenantOf returns the tenant of the user making request r, or the status
+// with which to reject the request.
+func (m *tenantServer) tenantOf(r *http.Request) (*tenant, int, error) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return nil, http.StatusUnauthorized, errors.New("no bearer token")
+	}
+	email, domain, err := validateIDToken(r.Context(), strings.TrimPrefix(auth, "Bearer "), *serveAudience)
+	if err != nil {
+		return nil, http.StatusUnauthorized, err
+	}
+	t := m.tenants[strings.ToLower(domain)]
+	if t == nil {
+		return nil, http.StatusForbidden, fmt.Errorf("%s is not in a tenant's domain", email)
+	}
+	return t, 0, nil
+}
+
+func (m *tenantServer) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	// The tenant's server authenticates the request again, checking the
+	// user's role.
+	t, code, err := m.tenantOf(r)
+	if err != nil {
+		log.Printf("rejecting %s %s: %v", r.Method, r.URL.Path, err)
+		http.Error(rw, http.StatusText(code), code)
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	restore := t.install()
+	defer restore()
+	rec := &statusRecorder{ResponseWriter: rw, code: http.StatusOK}
+	if t.quota.exhausted(time.Now()) {
+		log.Printf("rejecting %s %s for %s: daily API quota exhausted", r.Method, r.URL.Path, t.Domain)
+		http.Error(rec, "daily API quota exhausted", http.StatusTooManyRequests)
+	} else {
+		t.s.ServeHTTP(rec, r)
+	}
+	t.requests[rec.code]++
+}
+
+// install installs t's state in gocal's globals, returning a function that
+// restores them. Its API calls are counted by its services, not the globals.
+func (t *tenant) install() (restore func()) {
+	old := struct {
+		building, customer string
+		floor, section     int
+		zone               *time.Location
+		floorLevels        floorOrder
+	}{*buildingId, *customer, *floor, *section, zone, floorLevels}
+	*buildingId, *customer, *floor, *section = t.Building, t.Customer, t.floor, t.section
+	zone, floorLevels = t.zone, t.floorLevels
+	return func() {
+		*buildingId, *customer, *floor, *section = old.building, old.customer, old.floor, old.section
+		zone, floorLevels = old.zone, old.floorLevels
+	}
+}
+
+// metrics writes the tenants' counters in the Prometheus text format.
+func (m *tenantServer) metrics(rw http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	domains := make([]string, 0, len(m.tenants))
+	for d := range m.tenants {
+		domains = append(domains, d)
+	}
+	sort.Strings(domains)
+	rw.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintln(rw, "# TYPE gocal_requests_total counter")
+	for _, d := range domains {
+		t := m.tenants[d]
+		codes := make([]int, 0, len(t.requests))
+		for c := range t.requests {
+			codes = append(codes, c)
+		}
+		sort.Ints(codes)
+		for _, c := range codes {
+			fmt.Fprintf(rw, "gocal_requests_total{tenant=%q,code=\"%d\"} %d\n", d, c, t.requests[c])
+		}
+	}
+	fmt.Fprintln(rw, "# TYPE gocal_api_calls_total counter")
+	for _, d := range domains {
+		fmt.Fprintf(rw, "gocal_api_calls_total{tenant=%q} %d\n", d, m.tenants[d].quota.calls())
+	}
+	refreshers := make(map[string]*cache.Refresher)
+	for _, d := range domains {
+		refreshers[d] = m.tenants[d].refresher
+	}
+	writeRefreshMetrics(rw, refreshers)
+}
+
+// statusRecorder records the status code of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.code = code
+	r.ResponseWriter.WriteHeader(code)
+}
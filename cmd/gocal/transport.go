@@ -0,0 +1,110 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"regexp"
+
+	"google.golang.org/api/option"
+	"googlemaps.github.io/maps"
+)
+
+// httpClient returns the client underlying all API clients, configured with
+// -proxy, -http-timeout and -inject-failures. Without -proxy, the HTTPS_PROXY
+// and NO_PROXY environment variables are used. Its requests are counted as API
+// calls (see budget.go).
+func httpClient() *http.Client {
+	return newHTTPClient(true, nil)
+}
+
+// newHTTPClient returns a client configured as by httpClient, which counts its
+// requests only if counted is true: with quota, if non-nil, or else as the
+// process's API calls.
+func newHTTPClient(counted bool, quota *apiQuota) *http.Client {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	if *proxyURL != "" {
+		u, err := url.Parse(*proxyURL)
+		if err != nil {
+			log.Fatalf("parsing -proxy: %v", err)
+		}
+		t.Proxy = http.ProxyURL(u)
+	}
+	var rt http.RoundTripper = t
+	if len(injectFailures) > 0 {
+		if err := checkFakeBackend(); err != nil {
+			log.Fatal(err)
+		}
+		rt = newFailingTransport(rt, injectFailures)
+	}
+	if counted {
+		rt = countingTransport{rt, quota}
+	}
+	if *debugHTTP {
+		rt = loggingTransport{rt}
+	}
+	return &http.Client{Transport: rt, Timeout: *httpTimeout}
+}
+
+// loggingTransport logs requests and responses, redacting credentials and
+// email addresses.
+type loggingTransport struct {
+	base http.RoundTripper
+}
+
+var redactions = []struct {
+	re   *regexp.Regexp
+	repl string
+}{
+	{regexp.MustCompile(`(?im)^(Authorization|Cookie|Set-Cookie):.*$`), "$1: REDACTED"},
+	{regexp.MustCompile(`("(?:access_token|refresh_token|id_token|client_secret|code)"\s*:\s*)"[^"]*"`), `$1"REDACTED"`},
+	{regexp.MustCompile(`\b((?:key|code|client_secret|refresh_token|access_token)=)[^&\s]*`), "${1}REDACTED"},
+	{regexp.MustCompile(`[A-Za-z0-9._%+-]+(?:@|%40)[A-Za-z0-9.-]+\.[A-Za-z]{2,}`), "REDACTED@EMAIL"},
+}
+
+// redact removes credentials and email addresses from b.
+func redact(b []byte) []byte {
+	for _, r := range redactions {
+		b = r.re.ReplaceAll(b, []byte(r.repl))
+	}
+	return b
+}
+
+func (t loggingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if b, err := httputil.DumpRequestOut(req, true); err == nil {
+		log.Printf("HTTP request:\n%s", redact(b))
+	} else {
+		log.Printf("HTTP request %s %s: dumping: %v", req.Method, redact([]byte(req.URL.String())), err)
+	}
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		log.Printf("HTTP error: %s", redact([]byte(err.Error())))
+		return nil, err
+	}
+	if b, err := httputil.DumpResponse(resp, true); err == nil {
+		log.Printf("HTTP response:\n%s", redact(b))
+	} else {
+		log.Printf("HTTP response %s: dumping: %v", resp.Status, err)
+	}
+	return resp, nil
+}
+
+// endpointOptions returns the options for a Google API client whose endpoint
+// is overridden by endpoint, if set.
+func endpointOptions(endpoint string, client *http.Client) []option.ClientOption {
+	opts := []option.ClientOption{option.WithHTTPClient(client)}
+	if endpoint != "" {
+		opts = append(opts, option.WithEndpoint(endpoint))
+	}
+	return opts
+}
+
+// newMapsClient returns a Maps client using key and -maps-endpoint.
+func newMapsClient(key string) (*maps.Client, error) {
+	opts := []maps.ClientOption{maps.WithAPIKey(key), maps.WithHTTPClient(httpClient())}
+	if *mapsEndpoint != "" {
+		opts = append(opts, maps.WithBaseURL(*mapsEndpoint))
+	}
+	return maps.NewClient(opts...)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/vsekhar/gocal/internal/itercal"
+	"google.golang.org/api/calendar/v3"
+)
+
+// Meetings grow: attendees are added after gocal has booked a room. With
+// -daemon, each scan checks the rooms gocal added to events, and if a
+// meeting's attendees outnumber its room's capacity, books a bigger room near
+// the old one and then releases the old one. If no bigger room is free, the
+// meeting keeps its room. Each move is logged and, with -notify, reported by
+// running the given command with a message as its last argument, e.g.
+//
+//	-notify 'notify-send gocal'
+//
+// Rooms gocal didn't book, e.g. those the organizer chose, and rooms in
+// holds, are never moved. -upgrade-rooms=false turns upgrades off.
+
+// outgrownRooms returns, for each of events, the room gocal booked for it if
+// its attendees no longer fit, clearing that room from rooms so that another
+// is booked. rooms holds the room booked for each event.
+func outgrownRooms(events []*calendar.Event, rooms []*itercal.Resource, now time.Time) []*itercal.Resource {
+	ret := make([]*itercal.Resource, len(events))
+	if !*daemon || !*upgradeRooms || externalProvider() != nil {
+		return ret
+	}
+	for i, e := range events {
+		r := rooms[i]
+		if r == nil || r.Capacity <= 0 || privateProperty(e, roomProperty) != r.ResourceEmail {
+			continue
+		}
+		if n := attendeeCount(e); n > r.Capacity && tooLate(e, now) == "" {
+			log.Printf("%s has outgrown %s (%d attendees, capacity %d); looking for a bigger room", e.Summary, r.GeneratedResourceName, n, r.Capacity)
+			ret[i], rooms[i] = r, nil
+		}
+	}
+	return ret
+}
+
+// releaseOutgrown releases old, the room event has outgrown, from event on
+// calendar calId once room has been booked for it, and notifies the user.
+func releaseOutgrown(calSrv *calendar.Service, calId string, event *calendar.Event, old, room *itercal.Resource) {
+	withdrawRoom(calSrv, calId, event, old)
+	if *dryRun {
+		return
+	}
+	notifyUser(fmt.Sprintf("Moved %s (%s) from %s to %s: its %d attendees outgrew the room's capacity of %d",
+		event.Summary, event.Start.DateTime, old.GeneratedResourceName, room.GeneratedResourceName, attendeeCount(event), old.Capacity))
+}
+
+// notifyUser logs message and runs -notify with it.
+func notifyUser(message string) {
+	log.Print(message)
+	args := strings.Fields(*notifyCommand)
+	if len(args) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, args[0], append(args[1:], message)...)
+	cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+	if err := cmd.Run(); err != nil {
+		log.Printf("warning: running -notify %s: %v", args[0], err)
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/vsekhar/gocal/internal/cache"
+	"github.com/vsekhar/gocal/internal/interval"
+	"github.com/vsekhar/gocal/internal/itercal"
+	"github.com/vsekhar/gocal/internal/rank"
+	"google.golang.org/api/calendar/v3"
+)
+
+// With -waitlist, events for which no room is free are kept on a waitlist in
+// the cache along with the best rooms for them, and removed once they have a
+// room or are over. 'gocal serve -waitlist-interval 1m' checks the free/busy
+// of those rooms that often and books one as soon as a cancellation frees it,
+// rather than waiting for the next booking pass.
+
+// waitlistFile holds the waitlist.
+const waitlistFile = "waitlist.json"
+
+// waitlistRooms is the number of rooms watched for each waitlisted event.
+const waitlistRooms = 5
+
+var waitlistInterval *time.Duration
+
+// waitlistEntry is an event waiting for a room.
+type waitlistEntry struct {
+	Calendar string    `json:"calendar"`
+	EventID  string    `json:"eventId"`
+	Summary  string    `json:"summary"`
+	Start    string    `json:"start"`
+	End      string    `json:"end"`
+	Since    time.Time `json:"since"`
+
+	// Rooms are the emails of the rooms to watch, best first.
+	Rooms []string `json:"rooms"`
+}
+
+// waitlist maps the keys of waiting events to their entries.
+type waitlist map[string]waitlistEntry
+
+// eventKey identifies the event with eventId on calendar calId.
+func eventKey(calId, eventId string) string {
+	return calId + "/" + eventId
+}
+
+// loadWaitlist returns the waitlist recorded in s or -state-db, which is
+// empty if there is none.
+func loadWaitlist(s *cache.Space) waitlist {
+	if db := openStateDB(); db != nil {
+		ret, err := loadWaitlistDB(db)
+		if err != nil {
+			log.Printf("warning: ignoring waitlist: %v", err)
+			return make(waitlist)
+		}
+		return ret
+	}
+	ret := make(waitlist)
+	b, err := s.ReadFile(s.Path(waitlistFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return ret
+	}
+	if err == nil {
+		err = json.Unmarshal(b, &ret)
+	}
+	if err != nil {
+		log.Printf("warning: ignoring waitlist: %v", err)
+		return make(waitlist)
+	}
+	return ret
+}
+
+func (wl waitlist) save(s *cache.Space) {
+	var err error
+	if db := openStateDB(); db != nil {
+		err = inStateTx(db, func(tx *sql.Tx) error { return saveWaitlistTx(tx, wl) })
+	} else {
+		var b []byte
+		if b, err = json.Marshal(wl); err == nil {
+			err = s.WriteFile(s.Path(waitlistFile), b)
+		}
+	}
+	if err != nil {
+		log.Printf("warning: recording waitlist: %v", err)
+	}
+}
+
+// add puts event on the waitlist, watching the best rooms for req that are
+// usable and big enough, unless it is already there.
+func (wl waitlist) add(w rank.Weights, calId string, event *calendar.Event, req rank.Request, rooms []rank.Room, resources []*itercal.Resource, usable func(int) bool) {
+	key := eventKey(calId, event.Id)
+	if _, ok := wl[key]; ok {
+		return
+	}
+	entry := waitlistEntry{
+		Calendar: calId,
+		EventID:  event.Id,
+		Summary:  event.Summary,
+		Start:    event.Start.DateTime,
+		End:      event.End.DateTime,
+		Since:    time.Now().UTC(),
+	}
+	order, scores := rank.Rank(w, req, rooms)
+	for _, r := range order {
+		if len(entry.Rooms) == waitlistRooms {
+			break
+		}
+		if scores[r].TooSmall || scores[r].MissingFeatures > 0 || !usable(r) {
+			continue
+		}
+		entry.Rooms = append(entry.Rooms, resources[r].ResourceEmail)
+	}
+	if len(entry.Rooms) == 0 {
+		return
+	}
+	log.Printf("Waitlisting %s for %d rooms", event.Summary, len(entry.Rooms))
+	wl[key] = entry
+}
+
+// prune removes the entries for events that ended before now.
+func (wl waitlist) prune(now time.Time) {
+	for k, e := range wl {
+		if !interval.OrDie(e.Start, e.End).End.After(now) {
+			delete(wl, k)
+		}
+	}
+}
+
+// grabWaitlisted books the first free room watched for each waitlisted event
+// that still needs one. Events that have changed time, been booked elsewhere
+// or that the user no longer attends leave the waitlist; the next booking pass
+// reconsiders them.
+func (w *worker) grabWaitlisted(ctx context.Context) {
+	resetDelegates()
+	wl := loadWaitlist(w.cacheSpace)
+	wl.prune(time.Now())
+	defer wl.save(w.cacheSpace)
+	if len(wl) == 0 {
+		return
+	}
+
+	keys := make([]string, 0, len(wl))
+	watched := make(map[string]bool)
+	var start, end time.Time
+	for k, e := range wl {
+		keys = append(keys, k)
+		for _, r := range e.Rooms {
+			watched[r] = true
+		}
+		span := interval.OrDie(e.Start, e.End)
+		if start.IsZero() || span.Start.Before(start) {
+			start = span.Start
+		}
+		if span.End.After(end) {
+			end = span.End
+		}
+	}
+	// Earlier events get the first pick of freed rooms.
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := wl[keys[i]], wl[keys[j]]
+		return interval.OrDie(a.Start, a.End).Start.Before(interval.OrDie(b.Start, b.End).Start)
+	})
+	ids := make([]string, 0, len(watched))
+	for r := range watched {
+		ids = append(ids, r)
+	}
+	freeBusy, err := roomFreeBusy(ctx, w.calSrv, ids, start, end)
+	if err != nil {
+		log.Printf("warning: checking waitlisted rooms: %v", err)
+		return
+	}
+
+	colorOK := colorFilter()
+	for _, k := range keys {
+		entry := wl[k]
+		span := interval.OrDie(entry.Start, entry.End)
+		var room *itercal.Resource
+		for _, email := range entry.Rooms {
+			if isFree(freeBusy, email, span) {
+				room = w.room(email)
+				break
+			}
+		}
+		if room == nil {
+			continue
+		}
+		event, err := w.calSrv.Events.Get(entry.Calendar, entry.EventID).Context(ctx).Do()
+		if err != nil {
+			log.Printf("warning: checking waitlisted %s: %v", entry.Summary, err)
+			continue
+		}
+		if event.Start.DateTime != entry.Start || event.End.DateTime != entry.End || !goingTo(event, colorOK) || bookedRoom(event, w.rooms) != nil {
+			log.Printf("Removing %s from the waitlist: changed since waitlisted", entry.Summary)
+			delete(wl, k)
+			continue
+		}
+		if !withinBudget(ctx, reserveMutations(ctx, w.calSrv, event)) {
+			log.Printf("warning: not booking waitlisted %s: budget exhausted", entry.Summary)
+			return
+		}
+		log.Printf("%s freed up for waitlisted %s", room.GeneratedResourceName, entry.Summary)
+		delete(wl, k)
+		if ok, err := reserve(ctx, newCalendarProvider(w.calSrv), w.calSrv, entry.Calendar, event, room); err != nil {
+			log.Printf("warning: booking waitlisted %s: %v", entry.Summary, err)
+			continue
+		} else if !ok {
+			continue
+		}
+
+		fb := freeBusy[room.ResourceEmail]
+		fb.Busy = append(fb.Busy, &calendar.TimePeriod{Start: entry.Start, End: entry.End})
+		freeBusy[room.ResourceEmail] = fb
+	}
+}
+
+// room returns the conference room with email, or nil if there is none.
+func (w *worker) room(email string) *itercal.Resource {
+	i := sort.Search(len(w.rooms), func(i int) bool { return w.rooms[i].ResourceEmail >= email })
+	if i < len(w.rooms) && w.rooms[i].ResourceEmail == email {
+		return w.rooms[i]
+	}
+	return nil
+}
+
+// watchWaitlist grabs rooms for waitlisted events every interval until ctx is
+// done.
+func (s *roomServer) watchWaitlist(ctx context.Context, every time.Duration) {
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		s.bookMu.Lock()
+		s.w.grabWaitlisted(ctx)
+		s.bookMu.Unlock()
+	}
+}
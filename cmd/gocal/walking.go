@@ -0,0 +1,105 @@
+package main
+
+import (
+	"log"
+
+	"github.com/vsekhar/gocal/internal/interval"
+	"github.com/vsekhar/gocal/internal/itercal"
+	"github.com/vsekhar/gocal/internal/rank"
+)
+
+// To show what planning rooms together is worth, and to help tune -weights,
+// gocal logs how far the user walks each day between the rooms it plans,
+// starting from their desk (-floor and -section), against a naive baseline:
+// booking, meeting by meeting, the free room nearest the desk that seats
+// everyone. Rooms already booked are the same in both. -output json reports
+// the totals for the run.
+
+// walk returns the distance walked from a to b.
+func walk(a, b rank.Location) int {
+	if *accessible {
+		return rank.ElevatorDistance(a, b)
+	}
+	return rank.Distance(a, b)
+}
+
+// walkingDistance returns the distance walked from desk through locs, in
+// order.
+func walkingDistance(desk rank.Location, locs []rank.Location) int {
+	total := 0
+	at := desk
+	for _, l := range locs {
+		total += walk(at, l)
+		at = l
+	}
+	return total
+}
+
+// nearestFreeRooms returns, for each of slots, the index in rooms of the room
+// a naive booker would choose: its Fixed room, or else the free room nearest
+// desk that seats its attendees, or the nearest free room if none does, or -1
+// if none is free. Rooms are chosen for slots in order, and not again for
+// overlapping slots.
+func nearestFreeRooms(slots []rank.Slot, rooms []rank.Room, desk rank.Location) []int {
+	ret := make([]int, len(slots))
+	taken := make(map[int][]interval.Interval)
+	for j, s := range slots {
+		ret[j] = s.Fixed
+		if s.Fixed >= 0 {
+			continue
+		}
+		span := interval.Interval{Start: s.Start, End: s.End}
+		best, bestFits := -1, false
+		for r, room := range rooms {
+			if !s.Available(r) || interval.NewSet(taken[r]...).Overlaps(span) {
+				continue
+			}
+			fits := room.Capacity >= s.Request.Attendees
+			if best < 0 || fits && !bestFits || fits == bestFits && walk(desk, room.Location) < walk(desk, rooms[best].Location) {
+				best, bestFits = r, fits
+			}
+		}
+		if best >= 0 {
+			taken[best] = append(taken[best], span)
+		}
+		ret[j] = best
+	}
+	return ret
+}
+
+// hasUnfixed returns true if any of slots has a room to choose.
+func hasUnfixed(slots []rank.Slot) bool {
+	for _, s := range slots {
+		if s.Fixed < 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// logWalking logs, and adds to the report, the distance walked on a day
+// between booked, the rooms booked for the day's events, and between the
+// rooms of baseline, indexes in rooms, from desk.
+func logWalking(day string, desk rank.Location, booked []*itercal.Resource, baseline []int, rooms []rank.Room) {
+	var planned, naive []rank.Location
+	for _, r := range booked {
+		if r == nil {
+			continue
+		}
+		if l, ok := roomLocation(r); ok {
+			planned = append(planned, l)
+		}
+	}
+	for _, k := range baseline {
+		if k >= 0 {
+			naive = append(naive, rooms[k].Location)
+		}
+	}
+	walked, naiveWalked := walkingDistance(desk, planned), walkingDistance(desk, naive)
+	log.Printf("Walking on %s: %dm between the rooms booked, vs %dm booking the free room nearest your desk for each meeting (%dm saved)",
+		day, walked, naiveWalked, naiveWalked-walked)
+	if report != nil {
+		report.WalkingMeters += walked
+		report.BaselineWalkingMeters += naiveWalked
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/vsekhar/gocal/internal/itercal"
+	"google.golang.org/api/calendar/v3"
+)
+
+// With -daemon and -watch, gocal books rooms within seconds of changes to the
+// scanned calendars rather than at the next scan. It serves Calendar push
+// notifications on -watch-addr, which Calendar must be able to reach at the
+// HTTPS address -watch, e.g. through a reverse proxy, and opens a channel on
+// each calendar with Events.watch, renewing channels before they expire. When
+// notified, it fetches the events changed since the last notification using
+// sync tokens, and if any needs a room, books it with a quick pass (see
+// -pass). Scans every -interval still catch changes whose notifications were
+// lost, so -interval can be longer than without -watch.
+//
+// Each channel has a random token, without which notifications are rejected.
+// Channels are stopped when the daemon exits. While backing off after API
+// errors, notifications wait for the next scan.
+
+// watchTTL is how long channels are requested for. Calendar may expire them
+// sooner.
+const watchTTL = 24 * time.Hour
+
+// watchRenewal is how long before a channel expires that it is replaced.
+const watchRenewal = time.Hour
+
+// watchSettle is how long to wait after a notification for further changes,
+// e.g. to several events, before fetching them.
+var watchSettle = 2 * time.Second
+
+// watchChannel is a channel watching a calendar's events.
+type watchChannel struct {
+	calId      string
+	channel    *calendar.Channel
+	token      string
+	expiration time.Time
+
+	// lastMessage is the number of the last notification received.
+	lastMessage int64
+}
+
+// watcher keeps channels open on the scanned calendars, and receives their
+// notifications.
+type watcher struct {
+	calSrv *calendar.Service
+
+	mu       sync.Mutex
+	channels map[string]*watchChannel // by channel ID
+
+	// syncTokens are the tokens for the next sync of each calendar. They are
+	// only used by the daemon's goroutine.
+	syncTokens map[string]string
+
+	// changed receives a value when calendars may have changed.
+	changed chan struct{}
+}
+
+// startWatching opens channels on the scanned calendars and serves their
+// notifications, or returns nil without -watch.
+func startWatching(ctx context.Context, calSrv *calendar.Service) *watcher {
+	if *watchURL == "" {
+		return nil
+	}
+	w := &watcher{
+		calSrv:     calSrv,
+		channels:   make(map[string]*watchChannel),
+		syncTokens: make(map[string]string),
+		changed:    make(chan struct{}, 1),
+	}
+	ln, err := net.Listen("tcp", *watchAddr)
+	if err != nil {
+		log.Fatalf("serving notifications: %v", err)
+	}
+	go func() { log.Fatal(http.Serve(ln, w)) }()
+	for _, calId := range scannedCalendars() {
+		if err := w.sync(ctx, calId, nil); err != nil {
+			log.Fatalf("syncing %s: %v", calId, err)
+		}
+		if err := w.open(ctx, calId); err != nil {
+			log.Fatalf("watching %s: %v", calId, err)
+		}
+	}
+	log.Printf("Watching %d calendars for changes", len(scannedCalendars()))
+	return w
+}
+
+// randomHex returns n random bytes in hex.
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatal(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// open opens a channel on the events of calendar calId.
+func (w *watcher) open(ctx context.Context, calId string) error {
+	c := &watchChannel{calId: calId, token: randomHex(32)}
+	ch, err := w.calSrv.Events.Watch(calId, &calendar.Channel{
+		Id:      "gocal-" + randomHex(16),
+		Type:    "web_hook",
+		Address: *watchURL,
+		Token:   c.token,
+		Params:  map[string]string{"ttl": strconv.Itoa(int(watchTTL.Seconds()))},
+	}).Context(ctx).Do()
+	if err != nil {
+		return err
+	}
+	c.channel, c.expiration = ch, time.UnixMilli(ch.Expiration)
+	w.mu.Lock()
+	w.channels[ch.Id] = c
+	w.mu.Unlock()
+	return nil
+}
+
+// close stops channel c.
+func (w *watcher) close(ctx context.Context, c *watchChannel) {
+	w.mu.Lock()
+	delete(w.channels, c.channel.Id)
+	w.mu.Unlock()
+	err := w.calSrv.Channels.Stop(&calendar.Channel{Id: c.channel.Id, ResourceId: c.channel.ResourceId}).Context(ctx).Do()
+	if err != nil {
+		log.Printf("warning: stopping channel on %s: %v", c.calId, err)
+	}
+}
+
+// expiring returns the channels expiring before t.
+func (w *watcher) expiring(t time.Time) []*watchChannel {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var ret []*watchChannel
+	for _, c := range w.channels {
+		if c.expiration.Before(t) {
+			ret = append(ret, c)
+		}
+	}
+	return ret
+}
+
+// renew replaces the channels expiring within watchRenewal, returning when
+// the next channel needs renewing.
+func (w *watcher) renew(ctx context.Context) time.Time {
+	for _, c := range w.expiring(time.Now().Add(watchRenewal)) {
+		// Open the new channel first, so that no changes are missed.
+		if err := w.open(ctx, c.calId); err != nil {
+			log.Printf("warning: renewing channel on %s: %v", c.calId, err)
+			continue
+		}
+		w.close(ctx, c)
+	}
+	next := time.Now().Add(watchTTL)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, c := range w.channels {
+		if r := c.expiration.Add(-watchRenewal); r.Before(next) {
+			next = r
+		}
+	}
+	if min := time.Now().Add(time.Minute); next.Before(min) {
+		// Retry failed renewals in a while.
+		next = min
+	}
+	return next
+}
+
+// stop stops all channels.
+func (w *watcher) stop(ctx context.Context) {
+	for _, c := range w.expiring(time.Unix(1<<40, 0)) {
+		w.close(ctx, c)
+	}
+}
+
+func (w *watcher) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	c := w.channels[r.Header.Get("X-Goog-Channel-ID")]
+	if c == nil {
+		// Calendar stops notifying channels that are not found.
+		http.NotFound(rw, r)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Goog-Channel-Token")), []byte(c.token)) != 1 {
+		log.Printf("rejecting notification with wrong channel token")
+		http.Error(rw, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	num, err := strconv.ParseInt(r.Header.Get("X-Goog-Message-Number"), 10, 64)
+	if err != nil {
+		http.Error(rw, "missing message number", http.StatusBadRequest)
+		return
+	}
+	// The first message on a channel only confirms that it is set up.
+	if num <= c.lastMessage || r.Header.Get("X-Goog-Resource-State") == "sync" {
+		return
+	}
+	c.lastMessage = num
+	select {
+	case w.changed <- struct{}{}:
+	default:
+		// Already pending.
+	}
+}
+
+// sync fetches the events of calendar calId changed since the last sync,
+// calling f for each if f isn't nil.
+func (w *watcher) sync(ctx context.Context, calId string, f func(*calendar.Event)) error {
+	next, err := itercal.SyncEvents(ctx, w.calSrv, calId, w.syncTokens[calId], func(e *calendar.Event) error {
+		if f != nil {
+			f(e)
+		}
+		return nil
+	})
+	if errors.Is(err, itercal.ErrSyncTokenExpired) {
+		log.Printf("Sync token for %s expired; syncing all events", calId)
+		delete(w.syncTokens, calId)
+		return w.sync(ctx, calId, f)
+	}
+	if err != nil {
+		return err
+	}
+	w.syncTokens[calId] = next
+	return nil
+}
+
+// needsRooms returns true if events that changed on the scanned calendars
+// since the last sync need rooms.
+func (w *watcher) needsRooms(ctx context.Context) bool {
+	colorOK := colorFilter()
+	now := time.Now()
+	ret := false
+	for _, calId := range scannedCalendars() {
+		err := w.sync(ctx, calId, func(e *calendar.Event) {
+			if ret || !goingTo(e, colorOK) {
+				return
+			}
+			for _, a := range e.Attendees {
+				if a.Resource && a.ResponseStatus != "declined" {
+					return
+				}
+			}
+			end, err := time.Parse(time.RFC3339, e.End.DateTime)
+			ret = err != nil || end.After(now)
+		})
+		if err != nil {
+			// Let the pass look at the calendar.
+			log.Printf("warning: syncing %s: %v", calId, err)
+			return true
+		}
+	}
+	return ret
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/vsekhar/gocal/internal/itercal"
+	"google.golang.org/api/calendar/v3"
+)
+
+// locationSourceProperty is the private extended property linking an event in
+// the -locations-calendar to the meeting it describes.
+const locationSourceProperty = "gocalLocationSourceId"
+
+// locationsCalendarId returns the ID of the -locations-calendar, which is
+// either a calendar ID or the name of a calendar to find or create.
+func locationsCalendarId(calSrv *calendar.Service) (string, error) {
+	if strings.Contains(*locationsCalendar, "@") {
+		return *locationsCalendar, nil
+	}
+	var id string
+	err := calSrv.CalendarList.List().Pages(context.Background(), func(l *calendar.CalendarList) error {
+		for _, c := range l.Items {
+			if c.Summary == *locationsCalendar && (c.AccessRole == "owner" || c.AccessRole == "writer") {
+				id = c.Id
+			}
+		}
+		return nil
+	})
+	if err != nil || id != "" {
+		return id, err
+	}
+	log.Printf("Creating calendar '%s'", *locationsCalendar)
+	if *dryRun {
+		return "", nil
+	}
+	c, err := calSrv.Calendars.Insert(&calendar.Calendar{
+		Summary:     *locationsCalendar,
+		Description: "Where I'll be, maintained by gocal",
+		TimeZone:    zone.String(),
+	}).Do()
+	if err != nil {
+		return "", err
+	}
+	return c.Id, nil
+}
+
+// locationEvent returns the event in the locations calendar for event, held in
+// room.
+func locationEvent(event *calendar.Event, room *itercal.Resource) *calendar.Event {
+	summary := room.GeneratedResourceName
+	if room.FloorName != "" {
+		summary = fmt.Sprintf("%s (floor %s)", room.GeneratedResourceName, room.FloorName)
+	}
+	return &calendar.Event{
+		Summary:      summary,
+		Location:     room.GeneratedResourceName,
+		Start:        event.Start,
+		End:          event.End,
+		Transparency: "transparent",
+		ExtendedProperties: &calendar.EventExtendedProperties{
+			Private: map[string]string{locationSourceProperty: event.Id},
+		},
+	}
+}
+
+// syncLocations updates the -locations-calendar between start and end to hold
+// an event stating the room of each of events that has one.
+func syncLocations(ctx context.Context, calSrv *calendar.Service, events []*calendar.Event, rooms []*itercal.Resource, start, end time.Time) error {
+	id, err := locationsCalendarId(calSrv)
+	if err != nil {
+		return fmt.Errorf("finding locations calendar: %v", err)
+	}
+	existing := make(map[string]*calendar.Event) // by source event ID
+	if id != "" {
+		err = itercal.ForEachEvent(ctx, calSrv, id, start, end, zone, func(e *calendar.Event) error {
+			if src := privateProperty(e, locationSourceProperty); src != "" {
+				existing[src] = e
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("reading locations calendar: %v", err)
+		}
+	}
+	for i, event := range events {
+		if rooms[i] == nil {
+			continue
+		}
+		want := locationEvent(event, rooms[i])
+		have, ok := existing[event.Id]
+		delete(existing, event.Id)
+		if ok && have.Summary == want.Summary && have.Start.DateTime == want.Start.DateTime && have.End.DateTime == want.End.DateTime {
+			continue
+		}
+		if *dryRun {
+			log.Printf("Would note '%s' at %s in locations calendar", want.Summary, want.Start.DateTime)
+			continue
+		}
+		atomic.AddInt64(&mutations, 1)
+		if ok {
+			_, err = calSrv.Events.Patch(id, have.Id, want).Do()
+		} else {
+			_, err = calSrv.Events.Insert(id, want).Do()
+		}
+		if err != nil {
+			return fmt.Errorf("updating locations calendar: %v", err)
+		}
+	}
+	for _, e := range existing {
+		if *dryRun {
+			log.Printf("Would remove '%s' at %s from locations calendar", e.Summary, e.Start.DateTime)
+			continue
+		}
+		atomic.AddInt64(&mutations, 1)
+		if err := calSrv.Events.Delete(id, e.Id).Do(); err != nil {
+			return fmt.Errorf("updating locations calendar: %v", err)
+		}
+	}
+	return nil
+}
@@ -18,10 +18,24 @@ package batch
 // Up does not itself close any channels. Typically the caller will need to close
 // batches in order to terminate any consuming goroutine.
 func Up[T any](values <-chan T, batches chan<- []T) {
+	UpFlush[T, struct{}](values, batches, nil)
+}
+
+// UpFlush is like Up, but also submits the current batch whenever a value is
+// received from flush, even if more values are immediately available. This
+// bounds the size of batches when values are produced faster than they are
+// gathered, e.g. by passing a time.Ticker's channel as flush.
+//
+// A nil flush channel is never ready, in which case UpFlush behaves like Up.
+func UpFlush[T, F any](values <-chan T, batches chan<- []T, flush <-chan F) {
 	for {
 		var batch []T
 		var v T
 		var ok bool
+		// flushing is nil, and so never ready, until the batch holds a value,
+		// so that a flush channel that is always ready, e.g. a closed one,
+		// can't starve the blocking receive below.
+		var flushing <-chan F
 		// gather up a batch via non-blocking receives
 	batch:
 		for {
@@ -34,7 +48,10 @@ func Up[T any](values <-chan T, batches chan<- []T) {
 					return
 				}
 				batch = append(batch, v)
+				flushing = flush
 				continue batch
+			case <-flushing:
+				break batch
 			default:
 				if len(batch) > 0 {
 					break batch
@@ -45,6 +62,7 @@ func Up[T any](values <-chan T, batches chan<- []T) {
 					return
 				}
 				batch = append(batch, v)
+				flushing = flush
 				continue batch
 			}
 		}
@@ -3,6 +3,7 @@ package batch_test
 import (
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/vsekhar/gocal/internal/batch"
 )
@@ -41,3 +42,117 @@ func TestBatch(t *testing.T) {
 		t.Errorf("expected batches with multiple values, got largest batch size %d", biggestBatch)
 	}
 }
+
+func TestBatchFlush(t *testing.T) {
+	const n = 1000
+	v := make(chan int, n)
+	b := make(chan []int)
+	flush := make(chan struct{})
+
+	// Fill values so that non-blocking receives always succeed until they
+	// are exhausted. A closed flush channel is always ready, so batches are
+	// repeatedly flushed.
+	for i := 0; i < n; i++ {
+		v <- i
+	}
+	close(v)
+	close(flush)
+
+	done := make(chan struct{})
+	var batches [][]int
+	go func() {
+		defer close(done)
+		for b := range b {
+			batches = append(batches, b)
+		}
+	}()
+	batch.UpFlush(v, b, flush)
+	close(b)
+	<-done
+
+	if len(batches) < 2 {
+		t.Errorf("expected flush to split values into multiple batches, got %d batch(es)", len(batches))
+	}
+	next := 0
+	for _, b := range batches {
+		for _, x := range b {
+			if x != next {
+				t.Fatalf("got value %d, want %d", x, next)
+			}
+			next++
+		}
+	}
+	if next != n {
+		t.Errorf("got %d values, want %d", next, n)
+	}
+}
+
+func TestBatchFlushIdle(t *testing.T) {
+	v := make(chan int)
+	b := make(chan []int, 1)
+
+	// A full flush channel is always ready, like a closed one, but counts
+	// the flushes received. None should be while there are no values to
+	// batch, lest UpFlush spin instead of waiting for values.
+	const n = 1000
+	flush := make(chan struct{}, n)
+	for i := 0; i < n; i++ {
+		flush <- struct{}{}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		batch.UpFlush(v, b, flush)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	close(v)
+	<-done
+
+	if got := n - len(flush); got != 0 {
+		t.Errorf("received %d flushes without values", got)
+	}
+}
+
+func TestByKey(t *testing.T) {
+	const n, max = 1000, 7
+	v := make(chan int, n)
+	b := make(chan []int)
+	for i := 0; i < n; i++ {
+		v <- i
+	}
+	close(v)
+
+	done := make(chan struct{})
+	next := make(map[int]int)
+	count := 0
+	go func() {
+		defer close(done)
+		for b := range b {
+			if len(b) == 0 || len(b) > max {
+				t.Errorf("got batch of size %d", len(b))
+				continue
+			}
+			k := b[0] % 3
+			if _, ok := next[k]; !ok {
+				next[k] = k
+			}
+			for _, x := range b {
+				if x%3 != k {
+					t.Errorf("batch %v has mixed keys", b)
+				}
+				if x != next[k] {
+					t.Errorf("got value %d, want %d", x, next[k])
+				}
+				next[k] = x + 3
+				count++
+			}
+		}
+	}()
+	batch.ByKey(v, b, func(x int) int { return x % 3 }, max)
+	close(b)
+	<-done
+	if count != n {
+		t.Errorf("got %d values, want %d", count, n)
+	}
+}
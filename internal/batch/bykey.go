@@ -0,0 +1,60 @@
+package batch
+
+// ByKey is like Up, but groups values into per-key batches. Each batch sent on
+// batches contains only values with the same key, as returned by key.
+//
+// A batch is sent as soon as it contains max values. Otherwise, batches are
+// sent when no more values are immediately available, in order of the first
+// value received for each key. If max is zero or negative, batch size is
+// unlimited.
+//
+// ByKey preserves the order of values having the same key.
+//
+// Like Up, ByKey terminates when values is closed and all batches have been
+// sent, and does not itself close any channels.
+func ByKey[T any, K comparable](values <-chan T, batches chan<- []T, key func(T) K, max int) {
+	pending := make(map[K][]T)
+	var order []K // keys in pending, in order of first value
+
+	add := func(v T) {
+		k := key(v)
+		b, ok := pending[k]
+		if !ok {
+			order = append(order, k)
+		}
+		b = append(b, v)
+		if max > 0 && len(b) >= max {
+			batches <- b
+			b = nil
+		}
+		pending[k] = b
+	}
+	sendAll := func() {
+		for _, k := range order {
+			if b := pending[k]; len(b) > 0 {
+				batches <- b
+			}
+		}
+		pending = make(map[K][]T)
+		order = order[:0]
+	}
+
+	for {
+		select {
+		case v, ok := <-values:
+			if !ok {
+				sendAll()
+				return
+			}
+			add(v)
+		default:
+			sendAll()
+			// blocking receive
+			v, ok := <-values
+			if !ok {
+				return
+			}
+			add(v)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package cache
 
 import (
+	"crypto/cipher"
 	"errors"
 	"log"
 	"os"
@@ -10,6 +11,13 @@ import (
 
 type Space struct {
 	path string
+
+	// aead, if non-nil, encrypts files written with WriteFile.
+	aead cipher.AEAD
+
+	// allowStale makes Entry.Get return stale entries that can't be
+	// recreated.
+	allowStale bool
 }
 
 func Application(appId string) (*Space, error) {
@@ -21,13 +29,28 @@ func Application(appId string) (*Space, error) {
 	if err := os.MkdirAll(p, 0700); err != nil {
 		return nil, err
 	}
-	return &Space{p}, nil
+	return &Space{path: p}, nil
 }
 
-func isFresh(dir string, maxAge time.Duration) bool {
+// AllowStale makes Entry.Get fall back to the existing contents of entries
+// that are stale but can't be recreated, e.g. because the API they come from
+// is unavailable, rather than failing.
+func (s *Space) AllowStale() {
+	s.allowStale = true
+}
+
+// Path returns the path of the named file in s, e.g. for use with
+// s.WriteFile.
+func (s *Space) Path(name string) string {
+	return filepath.Join(s.path, name)
+}
+
+// lastModified returns the latest modification time of dir and the files in
+// it, or false if dir doesn't exist.
+func lastModified(dir string) (time.Time, bool) {
 	dstat, err := os.Stat(dir)
 	if errors.Is(err, os.ErrNotExist) {
-		return false
+		return time.Time{}, false
 	}
 	if err != nil {
 		log.Fatal(err)
@@ -46,20 +69,164 @@ func isFresh(dir string, maxAge time.Duration) bool {
 			modTime = info.ModTime()
 		}
 	}
-	return time.Since(modTime) <= maxAge
+	return modTime, true
 }
 
-func GetOrCreate[T any](s *Space, id string, maxAge time.Duration, load, create func(dir string) (T, error)) (T, error) {
+func isFresh(dir string, maxAge time.Duration) bool {
+	modTime, ok := lastModified(dir)
+	return ok && time.Since(modTime) <= maxAge
+}
+
+// Version identifies the format of a cache entry. Increment an entry's Number
+// whenever the format of its contents changes.
+type Version struct {
+	Number int
+
+	// Migrate, if non-nil, upgrades the contents of dir in place from an
+	// older version number. Entries with other versions, or for which Migrate
+	// returns an error, are recreated.
+	Migrate func(dir string, from int) error
+}
+
+// Entry describes an entry in a cache Space.
+type Entry[T any] struct {
+	ID      string
+	Version Version
+	MaxAge  time.Duration
+
+	// Source describes where the contents of the entry come from, e.g. the
+	// API from which they were fetched.
+	Source string
+
+	// Load loads the entry from dir, and Create creates the entry in dir.
+	Load, Create func(dir string) (T, error)
+
+	// Count, if non-nil, returns the number of records in the entry.
+	Count func(T) int
+
+	// SkipChecksum disables checksum validation, for entries whose files are
+	// modified when loaded (e.g. indexes).
+	SkipChecksum bool
+}
+
+// Get loads the entry from s, creating it if it doesn't exist, is stale, has
+// an old version, fails validation, or fails to load. If s allows stale
+// entries, a stale entry that can't be recreated is loaded instead.
+func (e *Entry[T]) Get(s *Space) (T, error) {
+	p := filepath.Join(s.path, e.ID)
+	fresh := isFresh(p, e.MaxAge)
+	if fresh && e.valid(p) {
+		t, err := e.Load(p)
+		if err == nil {
+			return t, nil
+		}
+		log.Printf("cache: loading %s, recreating: %v", p, err)
+	}
+	t, err := e.create(p)
+	if err != nil && s.allowStale && !fresh {
+		if modTime, ok := lastModified(p); ok && e.valid(p) {
+			if stale, lerr := e.Load(p); lerr == nil {
+				log.Printf("WARNING: cache: can't recreate %s, using data from %s (%s old): %v",
+					e.ID, modTime.Format(time.RFC3339), time.Since(modTime).Round(time.Minute), err)
+				return stale, nil
+			}
+		}
+	}
+	return t, err
+}
+
+// Cached returns true if the entry is in s, fresh and valid, so that Get
+// loads it rather than creating it.
+func (e *Entry[T]) Cached(s *Space) bool {
+	p := filepath.Join(s.path, e.ID)
+	return isFresh(p, e.MaxAge) && e.valid(p)
+}
+
+// create creates the entry in a temporary directory and then moves it to p,
+// so that the entry is replaced atomically. Each call has its own temporary
+// directory, so that e.g. a background refresh and a pass can both create
+// the entry.
+func (e *Entry[T]) create(p string) (T, error) {
 	var t T
-	p := filepath.Join(s.path, id)
-	if isFresh(p, maxAge) {
-		return load(p)
+	if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+		return t, err
 	}
-	if err := os.RemoveAll(p); err != nil {
+	tmp, err := os.MkdirTemp(filepath.Dir(p), filepath.Base(p)+".tmp")
+	if err != nil {
 		return t, err
 	}
-	if err := os.MkdirAll(p, 0700); err != nil {
+	defer os.RemoveAll(tmp)
+	t, err = e.Create(tmp)
+	if err != nil {
 		return t, err
 	}
-	return create(p)
+	m := metadata{
+		Version:   e.Version.Number,
+		CreatedAt: time.Now(),
+		Source:    e.Source,
+		Records:   -1,
+	}
+	if e.Count != nil {
+		m.Records = e.Count(t)
+	}
+	if !e.SkipChecksum {
+		if m.Checksum, err = checksum(tmp); err != nil {
+			return t, err
+		}
+	}
+	if err := writeMetadata(tmp, m); err != nil {
+		return t, err
+	}
+	if err := os.RemoveAll(p); err != nil {
+		return t, err
+	}
+	return t, os.Rename(tmp, p)
+}
+
+// valid returns true if the entry in dir has the current version, migrating it
+// if possible, and its contents match its checksum.
+func (e *Entry[T]) valid(dir string) bool {
+	m, err := readMetadata(dir)
+	if err != nil {
+		log.Printf("cache: reading metadata of %s: %v", dir, err)
+		return false
+	}
+	if m.Version != e.Version.Number {
+		if m.Version > e.Version.Number || e.Version.Migrate == nil {
+			return false
+		}
+		if err := e.Version.Migrate(dir, m.Version); err != nil {
+			log.Printf("cache: migrating %s from version %d to %d: %v", dir, m.Version, e.Version.Number, err)
+			return false
+		}
+		m.Version = e.Version.Number
+		m.Checksum = ""
+		if !e.SkipChecksum {
+			if m.Checksum, err = checksum(dir); err != nil {
+				log.Printf("cache: %v", err)
+				return false
+			}
+		}
+		if err := writeMetadata(dir, m); err != nil {
+			log.Printf("cache: %v", err)
+			return false
+		}
+	}
+	if !e.SkipChecksum {
+		sum, err := checksum(dir)
+		if err != nil {
+			log.Printf("cache: %v", err)
+			return false
+		}
+		if sum != m.Checksum {
+			log.Printf("cache: checksum mismatch in %s, recreating", dir)
+			return false
+		}
+	}
+	return true
+}
+
+func GetOrCreate[T any](s *Space, id string, v Version, maxAge time.Duration, load, create func(dir string) (T, error)) (T, error) {
+	e := &Entry[T]{ID: id, Version: v, MaxAge: maxAge, Load: load, Create: create}
+	return e.Get(s)
 }
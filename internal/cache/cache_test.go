@@ -0,0 +1,320 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestVersion(t *testing.T) {
+	s := &Space{path: t.TempDir()}
+	const file = "data"
+	creates := 0
+	load := func(dir string) (string, error) {
+		b, err := os.ReadFile(filepath.Join(dir, file))
+		return string(b), err
+	}
+	create := func(dir string) (string, error) {
+		creates++
+		return "new", os.WriteFile(filepath.Join(dir, file), []byte("new"), 0600)
+	}
+	get := func(v Version) string {
+		t.Helper()
+		got, err := GetOrCreate(s, "entry", v, time.Hour, load, create)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+
+	get(Version{Number: 1})
+	get(Version{Number: 1})
+	if creates != 1 {
+		t.Errorf("got %d creates, want 1", creates)
+	}
+
+	migrated := Version{
+		Number: 2,
+		Migrate: func(dir string, from int) error {
+			if from != 1 {
+				t.Errorf("migrating from %d, want 1", from)
+			}
+			return os.WriteFile(filepath.Join(dir, file), []byte("migrated"), 0600)
+		},
+	}
+	if got := get(migrated); got != "migrated" {
+		t.Errorf("got %q, want %q", got, "migrated")
+	}
+	if creates != 1 {
+		t.Errorf("got %d creates, want 1", creates)
+	}
+
+	// Without a migration, or when downgrading, the entry is recreated.
+	if got := get(Version{Number: 3}); got != "new" {
+		t.Errorf("got %q, want %q", got, "new")
+	}
+	get(Version{Number: 1})
+	if creates != 3 {
+		t.Errorf("got %d creates, want 3", creates)
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	s := &Space{path: t.TempDir()}
+	creates := 0
+	e := &Entry[string]{
+		ID:      "entry",
+		Version: Version{Number: 1},
+		MaxAge:  time.Hour,
+		Source:  "test",
+		Load: func(dir string) (string, error) {
+			b, err := os.ReadFile(filepath.Join(dir, "data"))
+			return string(b), err
+		},
+		Create: func(dir string) (string, error) {
+			creates++
+			return "data", os.WriteFile(filepath.Join(dir, "data"), []byte("data"), 0600)
+		},
+		Count: func(string) int { return 1 },
+	}
+	if _, err := e.Get(s); err != nil {
+		t.Fatal(err)
+	}
+	m, err := readMetadata(filepath.Join(s.path, e.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Source != "test" || m.Records != 1 || m.Checksum == "" || m.CreatedAt.IsZero() {
+		t.Errorf("unexpected metadata %+v", m)
+	}
+
+	// Simulate truncation by a crash.
+	if err := os.WriteFile(filepath.Join(s.path, e.ID, "data"), []byte("da"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	got, err := e.Get(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "data" || creates != 2 {
+		t.Errorf("got %q after %d creates, want %q after 2", got, creates, "data")
+	}
+}
+
+func TestCreateConcurrently(t *testing.T) {
+	s := &Space{path: t.TempDir()}
+	const file = "data"
+	// Each create waits for the other to start, so that both are underway
+	// at once, as with a background refresh during a pass.
+	started := make(chan string, 2)
+	var wg sync.WaitGroup
+	e := &Entry[string]{
+		ID:      "entry",
+		Version: Version{Number: 1},
+		MaxAge:  time.Hour,
+		Load: func(dir string) (string, error) {
+			b, err := os.ReadFile(filepath.Join(dir, file))
+			return string(b), err
+		},
+		Create: func(dir string) (string, error) {
+			started <- dir
+			wg.Done()
+			wg.Wait()
+			return "data", os.WriteFile(filepath.Join(dir, file), []byte("data"), 0600)
+		},
+	}
+	wg.Add(2)
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := e.create(s.Path(e.ID))
+			errs <- err
+		}()
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("creating concurrently: %v", err)
+		}
+	}
+	if a, b := <-started, <-started; a == b {
+		t.Errorf("both creates used %s", a)
+	}
+	if got, err := e.Get(s); err != nil || got != "data" {
+		t.Errorf("got %q, %v, want %q", got, err, "data")
+	}
+}
+
+func TestRefresher(t *testing.T) {
+	s := &Space{path: t.TempDir()}
+	created := make(chan struct{}, 10)
+	e := &Entry[string]{
+		ID:      "entry",
+		Version: Version{Number: 1},
+		MaxAge:  100 * time.Millisecond,
+		Load:    func(string) (string, error) { return "", nil },
+		Create: func(string) (string, error) {
+			created <- struct{}{}
+			return "", nil
+		},
+	}
+	if _, err := e.Get(s); err != nil {
+		t.Fatal(err)
+	}
+	<-created
+
+	r := NewRefresher(s, 50*time.Millisecond)
+	r.Add(e)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Run(ctx)
+	select {
+	case <-created:
+	case <-time.After(5 * time.Second):
+		t.Fatal("entry not refreshed")
+	}
+	// Wait for status to be updated after the refresh.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		st := r.Status()
+		if len(st) != 1 {
+			t.Fatalf("got %d statuses, want 1", len(st))
+		}
+		if !st[0].LastRefresh.IsZero() {
+			if st[0].LastError != "" {
+				t.Errorf("unexpected error: %s", st[0].LastError)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("status not updated")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestEncrypt(t *testing.T) {
+	s := &Space{path: t.TempDir()}
+	p := filepath.Join(s.path, "data")
+	if err := s.WriteFile(p, []byte("plain")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Encrypt(make([]byte, KeySize)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ReadFile(p); err == nil {
+		t.Error("reading unencrypted file with key: got nil error")
+	}
+	if err := s.WriteFile(p, []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := os.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(raw), "secret") {
+		t.Errorf("file contains plaintext: %q", raw)
+	}
+	got, err := s.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "secret" {
+		t.Errorf("got %q, want %q", got, "secret")
+	}
+
+	key := make([]byte, KeySize)
+	key[0] = 1
+	if err := s.Encrypt(key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ReadFile(p); err == nil {
+		t.Error("reading with wrong key: got nil error")
+	}
+}
+
+func TestStream(t *testing.T) {
+	for _, encrypt := range []bool{false, true} {
+		s := &Space{path: t.TempDir()}
+		if encrypt {
+			if err := s.Encrypt(make([]byte, KeySize)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		p := filepath.Join(s.path, "data")
+		w, err := s.Create(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, line := range []string{"one\n", "two\n"} {
+			if _, err := io.WriteString(w, line); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		raw, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Contains(string(raw), "two"); got == encrypt {
+			t.Errorf("encrypt %t: file contains plaintext %t", encrypt, got)
+		}
+		r, err := s.Open(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != "one\ntwo\n" {
+			t.Errorf("encrypt %t: got %q", encrypt, got)
+		}
+	}
+}
+
+func TestAllowStale(t *testing.T) {
+	s := &Space{path: t.TempDir()}
+	unavailable := errors.New("unavailable")
+	var createErr error
+	e := &Entry[string]{
+		ID:      "entry",
+		Version: Version{Number: 1},
+		MaxAge:  time.Hour,
+		Load: func(dir string) (string, error) {
+			b, err := os.ReadFile(filepath.Join(dir, "data"))
+			return string(b), err
+		},
+		Create: func(dir string) (string, error) {
+			if createErr != nil {
+				return "", createErr
+			}
+			return "data", os.WriteFile(filepath.Join(dir, "data"), []byte("data"), 0600)
+		},
+	}
+	if _, err := e.Get(s); err != nil {
+		t.Fatal(err)
+	}
+
+	e.MaxAge = -time.Hour
+	createErr = unavailable
+	if _, err := e.Get(s); !errors.Is(err, unavailable) {
+		t.Errorf("got error %v for stale entry, want %v", err, unavailable)
+	}
+	s.AllowStale()
+	if got, err := e.Get(s); err != nil || got != "data" {
+		t.Errorf("got %q, %v with stale entries allowed, want stale data", got, err)
+	}
+	e.ID = "missing"
+	if _, err := e.Get(s); !errors.Is(err, unavailable) {
+		t.Errorf("got error %v for missing entry, want %v", err, unavailable)
+	}
+}
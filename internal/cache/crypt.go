@@ -0,0 +1,127 @@
+package cache
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// KeySize is the size in bytes of keys used to encrypt cache contents.
+const KeySize = 32
+
+// encryptedMagic prefixes encrypted files.
+var encryptedMagic = []byte("gocal-aes-gcm-1\n")
+
+// IsEncrypted returns true if data is the contents of a file encrypted by
+// WriteFile.
+func IsEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, encryptedMagic)
+}
+
+// Encrypt causes files subsequently written with s.WriteFile to be encrypted
+// with AES-GCM using key, and files read with s.ReadFile to be decrypted.
+// Files that entries write otherwise, e.g. bleve indexes, stay plaintext.
+func (s *Space) Encrypt(key []byte) error {
+	if len(key) != KeySize {
+		return fmt.Errorf("cache: key must be %d bytes, got %d", KeySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	s.aead, err = cipher.NewGCM(block)
+	return err
+}
+
+// WriteFile writes data to the named file, encrypting it if s.Encrypt has
+// been called.
+func (s *Space) WriteFile(name string, data []byte) error {
+	if s.aead != nil {
+		nonce := make([]byte, s.aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return err
+		}
+		out := append([]byte(nil), encryptedMagic...)
+		out = append(out, nonce...)
+		data = s.aead.Seal(out, nonce, data, encryptedMagic)
+	}
+	return os.WriteFile(name, data, 0600)
+}
+
+// ReadFile reads the named file, decrypting it if it is encrypted.
+func (s *Space) ReadFile(name string) ([]byte, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(data, encryptedMagic) {
+		if s.aead != nil {
+			return nil, fmt.Errorf("cache: %s is not encrypted", name)
+		}
+		return data, nil
+	}
+	if s.aead == nil {
+		return nil, fmt.Errorf("cache: %s is encrypted", name)
+	}
+	data = data[len(encryptedMagic):]
+	if len(data) < s.aead.NonceSize() {
+		return nil, errors.New("cache: encrypted file too short")
+	}
+	nonce, data := data[:s.aead.NonceSize()], data[s.aead.NonceSize():]
+	return s.aead.Open(nil, nonce, data, encryptedMagic)
+}
+
+// Create creates the named file for writing. If s.Encrypt has been called,
+// the data is buffered and encrypted when the file is closed, since AES-GCM
+// seals whole messages; otherwise it is written to the file as it arrives.
+func (s *Space) Create(name string) (io.WriteCloser, error) {
+	if s.aead != nil {
+		return &sealer{s: s, name: name}, nil
+	}
+	return os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+}
+
+// sealer buffers writes to an encrypted file.
+type sealer struct {
+	bytes.Buffer
+	s    *Space
+	name string
+}
+
+func (w *sealer) Close() error { return w.s.WriteFile(w.name, w.Bytes()) }
+
+// Open opens the named file for reading, decrypting it if it is encrypted.
+// Unencrypted files are read as they are consumed.
+func (s *Space) Open(name string) (io.ReadCloser, error) {
+	if s.aead == nil {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		magic := make([]byte, len(encryptedMagic))
+		n, err := io.ReadFull(f, magic)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			f.Close()
+			return nil, err
+		}
+		if !bytes.Equal(magic[:n], encryptedMagic) {
+			if _, err := f.Seek(0, io.SeekStart); err != nil {
+				f.Close()
+				return nil, err
+			}
+			return f, nil
+		}
+		f.Close()
+	}
+	// Encrypted files must be read whole to be authenticated.
+	data, err := s.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
@@ -0,0 +1,93 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// MetadataFilename is the name of the file in each entry holding its metadata,
+// which is never encrypted.
+const MetadataFilename = ".metadata.json"
+
+// metadata describes the contents of a cache entry.
+type metadata struct {
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"createdAt"`
+	Source    string    `json:"source,omitempty"`
+
+	// Records is the number of records in the entry, or -1 if unknown.
+	Records int `json:"records"`
+
+	// Checksum is the hex-encoded SHA-256 checksum of the entry's files, or
+	// empty if not computed.
+	Checksum string `json:"checksum,omitempty"`
+}
+
+// readMetadata returns the metadata of the entry in dir. Entries written
+// before metadata was introduced have version zero.
+func readMetadata(dir string) (metadata, error) {
+	var m metadata
+	b, err := os.ReadFile(filepath.Join(dir, MetadataFilename))
+	if errors.Is(err, os.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return m, err
+	}
+	err = json.Unmarshal(b, &m)
+	return m, err
+}
+
+func writeMetadata(dir string, m metadata) error {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, MetadataFilename), b, 0600)
+}
+
+// checksum returns the checksum of the names and contents of the files in dir,
+// excluding the metadata file.
+func checksum(dir string) (string, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() && d.Name() != MetadataFilename {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	sort.Strings(files)
+	h := sha256.New()
+	for _, path := range files {
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return "", err
+		}
+		io.WriteString(h, filepath.ToSlash(rel))
+		h.Write([]byte{0})
+		f, err := os.Open(path)
+		if err != nil {
+			return "", err
+		}
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,158 @@
+package cache
+
+import (
+	"context"
+	"expvar"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Refreshable is a cache entry that can be refreshed in the background. It is
+// implemented by *Entry[T].
+type Refreshable interface {
+	// expiry returns when the entry in s becomes stale.
+	expiry(s *Space) time.Time
+
+	// refresh recreates the entry in s.
+	refresh(s *Space) error
+
+	id() string
+}
+
+func (e *Entry[T]) id() string { return e.ID }
+
+func (e *Entry[T]) expiry(s *Space) time.Time {
+	modTime, ok := lastModified(filepath.Join(s.path, e.ID))
+	if !ok {
+		return time.Time{}
+	}
+	return modTime.Add(e.MaxAge)
+}
+
+func (e *Entry[T]) refresh(s *Space) error {
+	_, err := e.create(filepath.Join(s.path, e.ID))
+	return err
+}
+
+// RefreshStatus describes the background refreshing of a cache entry.
+type RefreshStatus struct {
+	ID          string    `json:"id"`
+	LastRefresh time.Time `json:"lastRefresh,omitempty"`
+	LastError   string    `json:"lastError,omitempty"`
+	NextRefresh time.Time `json:"nextRefresh"`
+}
+
+// A Refresher recreates cache entries in the background shortly before they
+// become stale, so that callers of Entry.Get don't wait for them to be
+// recreated.
+type Refresher struct {
+	s    *Space
+	lead time.Duration
+
+	mu      sync.Mutex
+	entries map[string]Refreshable
+	status  map[string]*RefreshStatus
+	added   chan struct{}
+}
+
+// NewRefresher returns a Refresher for entries in s that refreshes them lead
+// before they become stale.
+func NewRefresher(s *Space, lead time.Duration) *Refresher {
+	return &Refresher{
+		s:       s,
+		lead:    lead,
+		entries: make(map[string]Refreshable),
+		status:  make(map[string]*RefreshStatus),
+		added:   make(chan struct{}, 1),
+	}
+}
+
+// Add adds e to the entries refreshed by r. Adding an entry with the same ID
+// as an existing entry replaces it.
+func (r *Refresher) Add(e Refreshable) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries[e.id()] = e
+	if _, ok := r.status[e.id()]; !ok {
+		r.status[e.id()] = &RefreshStatus{ID: e.id()}
+	}
+	r.status[e.id()].NextRefresh = e.expiry(r.s).Add(-r.lead)
+	select {
+	case r.added <- struct{}{}:
+	default:
+	}
+}
+
+// Publish exports the status of r as an expvar with the given name, which is
+// served as JSON at /debug/vars by servers using http.DefaultServeMux.
+func (r *Refresher) Publish(name string) {
+	expvar.Publish(name, expvar.Func(func() interface{} { return r.Status() }))
+}
+
+// Status returns the status of each entry, ordered by ID.
+func (r *Refresher) Status() []RefreshStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ret := make([]RefreshStatus, 0, len(r.status))
+	for _, s := range r.status {
+		ret = append(ret, *s)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].ID < ret[j].ID })
+	return ret
+}
+
+// next returns the entry due to be refreshed soonest.
+func (r *Refresher) next() (Refreshable, time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var e Refreshable
+	var due time.Time
+	for id, s := range r.status {
+		if e == nil || s.NextRefresh.Before(due) {
+			e, due = r.entries[id], s.NextRefresh
+		}
+	}
+	return e, due
+}
+
+// Run refreshes entries until ctx is done.
+func (r *Refresher) Run(ctx context.Context) {
+	for {
+		e, due := r.next()
+		var timer *time.Timer
+		var fire <-chan time.Time
+		if e != nil {
+			timer = time.NewTimer(time.Until(due))
+			fire = timer.C
+		}
+		stop := func() {
+			if timer != nil {
+				timer.Stop()
+			}
+		}
+		select {
+		case <-ctx.Done():
+			stop()
+			return
+		case <-r.added:
+			stop()
+			continue
+		case <-fire:
+		}
+
+		err := e.refresh(r.s)
+		r.mu.Lock()
+		s := r.status[e.id()]
+		s.LastRefresh = time.Now()
+		s.LastError = ""
+		s.NextRefresh = e.expiry(r.s).Add(-r.lead)
+		if err != nil {
+			// Try again later.
+			s.LastError = err.Error()
+			s.NextRefresh = time.Now().Add(r.lead)
+		}
+		r.mu.Unlock()
+	}
+}
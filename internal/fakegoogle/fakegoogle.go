@@ -0,0 +1,831 @@
+// Package fakegoogle implements an in-process fake of the parts of the Google
+// Calendar and Directory APIs used by gocal, for end-to-end tests that don't
+// need a Workspace domain.
+//
+// The fake keeps all state in memory. Room resources accept invitations when
+// they are free and decline them otherwise, as they do in Calendar. Event
+// lists return sync tokens for incremental sync, and watch channels are
+// notified of changes to their calendar's events.
+package fakegoogle
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vsekhar/gocal/internal/interval"
+	directory "google.golang.org/api/admin/directory/v1"
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/option"
+)
+
+// Server is a fake Calendar and Directory API server.
+type Server struct {
+	// Primary is the email address of the authenticated user, whose calendar
+	// is "primary".
+	Primary string
+
+	srv *httptest.Server
+
+	mu        sync.Mutex
+	buildings []*directory.Building
+	resources []*directory.CalendarResource
+	users     map[string]*directory.User
+	events    map[string][]*calendar.Event // by calendar ID
+	access    map[string]string            // user's access role by calendar ID
+	summaries map[string]string            // by calendar ID
+	approval  map[string]bool              // resources requiring approval
+	nextId    int
+
+	// seq numbers changes to events, changed records the last change to each
+	// event by calendar ID and event ID, and sync tokens name a change.
+	seq      int
+	changed  map[string]map[string]int
+	channels map[string]*channel // by channel ID
+}
+
+// channel is a watch channel on a calendar's events.
+type channel struct {
+	calendar.Channel
+	calId   string
+	message int64
+}
+
+// NewServer starts a Server for the user with email primary. Close it when
+// done.
+func NewServer(primary string) *Server {
+	s := &Server{
+		Primary:   primary,
+		users:     make(map[string]*directory.User),
+		events:    make(map[string][]*calendar.Event),
+		access:    make(map[string]string),
+		summaries: make(map[string]string),
+		approval:  make(map[string]bool),
+		changed:   make(map[string]map[string]int),
+		channels:  make(map[string]*channel),
+	}
+	s.srv = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Close shuts down the server.
+func (s *Server) Close() { s.srv.Close() }
+
+// URL returns the base URL of the server.
+func (s *Server) URL() string { return s.srv.URL }
+
+// CalendarEndpoint and DirectoryEndpoint return the endpoints to use for the
+// Calendar and Directory API clients.
+func (s *Server) CalendarEndpoint() string  { return s.srv.URL + "/calendar/v3/" }
+func (s *Server) DirectoryEndpoint() string { return s.srv.URL + "/" }
+
+// Services returns unauthenticated Directory and Calendar clients for s.
+func (s *Server) Services(ctx context.Context) (*directory.Service, *calendar.Service, error) {
+	dirSrv, err := directory.NewService(ctx, option.WithEndpoint(s.DirectoryEndpoint()), option.WithoutAuthentication())
+	if err != nil {
+		return nil, nil, err
+	}
+	calSrv, err := calendar.NewService(ctx, option.WithEndpoint(s.CalendarEndpoint()), option.WithoutAuthentication())
+	if err != nil {
+		return nil, nil, err
+	}
+	return dirSrv, calSrv, nil
+}
+
+// AddBuilding adds a building to the directory.
+func (s *Server) AddBuilding(b *directory.Building) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.buildings = append(s.buildings, b)
+}
+
+// AddResource adds a calendar resource to the directory.
+func (s *Server) AddResource(r *directory.CalendarResource) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.resources = append(s.resources, r)
+}
+
+// AddUser adds a user to the directory.
+func (s *Server) AddUser(u *directory.User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[u.PrimaryEmail] = u
+}
+
+// Calendars returns the IDs of calendars with the given summary.
+func (s *Server) Calendars(summary string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ret []string
+	for id, sum := range s.summaries {
+		if sum == summary {
+			ret = append(ret, id)
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// SetAccess gives the user access to the calendar with ID calendarId with
+// role, e.g. "reader" or "writer", adding it to their calendar list.
+func (s *Server) SetAccess(calendarId, role string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.access[calendarId] = role
+}
+
+// AddEvent adds e to the calendar with ID calendarId, assigning it an ID if it
+// has none, and returns the ID. Events without an Updated time are stamped
+// with the current time.
+func (s *Server) AddEvent(calendarId string, e *calendar.Event) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.insert(s.calendarId(calendarId), e).Id
+}
+
+// RequireApproval makes the resource with email leave invitations awaiting
+// its owner's response, which is given with Respond.
+func (s *Server) RequireApproval(email string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.approval[email] = true
+}
+
+// Respond sets the response of attendee email to the event with ID id in the
+// calendar with ID calendarId, e.g. as a room's owner approving a booking.
+func (s *Server) Respond(calendarId, id, email, status string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e := s.find(s.calendarId(calendarId), id); e != nil {
+		for _, a := range e.Attendees {
+			if a.Email == email {
+				a.ResponseStatus = status
+			}
+		}
+		s.change(s.calendarId(calendarId), e)
+	}
+}
+
+// Channels returns the IDs of the open watch channels on the calendar with ID
+// calendarId.
+func (s *Server) Channels(calendarId string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ret []string
+	for id, c := range s.channels {
+		if c.calId == s.calendarId(calendarId) {
+			ret = append(ret, id)
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// Events returns copies of the events in the calendar with ID calendarId.
+func (s *Server) Events(calendarId string) []*calendar.Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ret []*calendar.Event
+	for _, e := range s.events[s.calendarId(calendarId)] {
+		ret = append(ret, clone(e))
+	}
+	return ret
+}
+
+// Event returns a copy of the event with ID id in the calendar with ID
+// calendarId, or nil if there is none.
+func (s *Server) Event(calendarId, id string) *calendar.Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e := s.find(s.calendarId(calendarId), id); e != nil {
+		return clone(e)
+	}
+	return nil
+}
+
+func clone(e *calendar.Event) *calendar.Event {
+	b, err := json.Marshal(e)
+	if err != nil {
+		panic(err)
+	}
+	ret := new(calendar.Event)
+	if err := json.Unmarshal(b, ret); err != nil {
+		panic(err)
+	}
+	return ret
+}
+
+// calendarId resolves the "primary" alias. The caller must hold s.mu.
+func (s *Server) calendarId(id string) string {
+	if id == "primary" {
+		return s.Primary
+	}
+	return id
+}
+
+// find returns the event with ID id in calendar calId. The caller must hold
+// s.mu.
+func (s *Server) find(calId, id string) *calendar.Event {
+	for _, e := range s.events[calId] {
+		if e.Id == id {
+			return e
+		}
+	}
+	return nil
+}
+
+// insert adds e to calendar calId. The caller must hold s.mu.
+func (s *Server) insert(calId string, e *calendar.Event) *calendar.Event {
+	if e.Id == "" {
+		s.nextId++
+		e.Id = fmt.Sprintf("event%d", s.nextId)
+	}
+	if e.Status == "" {
+		e.Status = "confirmed"
+	}
+	if e.Updated == "" {
+		e.Updated = time.Now().UTC().Format(time.RFC3339Nano)
+	}
+	s.respondAsResources(calId, e)
+	s.events[calId] = append(s.events[calId], e)
+	s.change(calId, e)
+	return e
+}
+
+// change records a change to e in calendar calId, notifying the calendar's
+// watch channels. The caller must hold s.mu.
+func (s *Server) change(calId string, e *calendar.Event) {
+	s.seq++
+	if s.changed[calId] == nil {
+		s.changed[calId] = make(map[string]int)
+	}
+	s.changed[calId][e.Id] = s.seq
+	for _, c := range s.channels {
+		if c.calId == calId {
+			c.message++
+			go notify(c.Channel, c.message, "exists")
+		}
+	}
+}
+
+// notify posts a notification numbered message on c, as Calendar does.
+func notify(c calendar.Channel, message int64, state string) {
+	req, err := http.NewRequest(http.MethodPost, c.Address, nil)
+	if err != nil {
+		return
+	}
+	req.Header.Set("X-Goog-Channel-ID", c.Id)
+	req.Header.Set("X-Goog-Channel-Token", c.Token)
+	req.Header.Set("X-Goog-Channel-Expiration", time.UnixMilli(c.Expiration).UTC().Format(http.TimeFormat))
+	req.Header.Set("X-Goog-Message-Number", strconv.FormatInt(message, 10))
+	req.Header.Set("X-Goog-Resource-ID", c.ResourceId)
+	req.Header.Set("X-Goog-Resource-State", state)
+	if resp, err := http.DefaultClient.Do(req); err == nil {
+		resp.Body.Close()
+	}
+}
+
+// syncToken returns a token for changes after the latest. The caller must
+// hold s.mu.
+func (s *Server) syncToken() string {
+	return fmt.Sprintf("sync-%d", s.seq)
+}
+
+// isResource returns true if email is that of a calendar resource. The caller
+// must hold s.mu.
+func (s *Server) isResource(email string) bool {
+	for _, r := range s.resources {
+		if r.ResourceEmail == email {
+			return true
+		}
+	}
+	return false
+}
+
+// respondAsResources accepts or declines e on behalf of the resources it
+// invites that have not yet responded. The caller must hold s.mu.
+func (s *Server) respondAsResources(calId string, e *calendar.Event) {
+	span, ok := eventInterval(e)
+	for _, a := range e.Attendees {
+		if !s.isResource(a.Email) {
+			continue
+		}
+		a.Resource = true
+		if a.ResponseStatus == "accepted" || a.ResponseStatus == "declined" {
+			continue
+		}
+		if s.approval[a.Email] {
+			a.ResponseStatus = "needsAction"
+			continue
+		}
+		a.ResponseStatus = "accepted"
+		if ok && s.busy(a.Email, calId, e.Id).Overlaps(span) {
+			a.ResponseStatus = "declined"
+		}
+	}
+}
+
+// busy returns the times during which the calendar identified by email is
+// busy, ignoring event exceptId in calendar exceptCal. The caller must hold
+// s.mu.
+func (s *Server) busy(email, exceptCal, exceptId string) interval.Set {
+	var busy []interval.Interval
+	for calId, events := range s.events {
+		for _, e := range events {
+			if calId == exceptCal && e.Id == exceptId {
+				continue
+			}
+			if e.Status == "cancelled" || e.Transparency == "transparent" {
+				continue
+			}
+			i, ok := eventInterval(e)
+			if !ok {
+				continue
+			}
+			if calId == email {
+				busy = append(busy, i)
+				continue
+			}
+			for _, a := range e.Attendees {
+				if a.Email == email && a.ResponseStatus != "declined" {
+					busy = append(busy, i)
+					break
+				}
+			}
+		}
+	}
+	return interval.NewSet(busy...)
+}
+
+// eventInterval returns the times of e. All-day events are treated as lasting
+// from midnight to midnight UTC.
+func eventInterval(e *calendar.Event) (interval.Interval, bool) {
+	if e.Start == nil || e.End == nil {
+		return interval.Interval{}, false
+	}
+	if e.Start.DateTime != "" {
+		i, err := interval.Parse(e.Start.DateTime, e.End.DateTime)
+		return i, err == nil
+	}
+	start, err := time.Parse("2006-01-02", e.Start.Date)
+	if err != nil {
+		return interval.Interval{}, false
+	}
+	end, err := time.Parse("2006-01-02", e.End.Date)
+	if err != nil {
+		return interval.Interval{}, false
+	}
+	return interval.Interval{Start: start, End: end}, true
+}
+
+// apiError writes an error in the format of Google APIs.
+func apiError(w http.ResponseWriter, code int, format string, args ...interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	msg := fmt.Sprintf(format, args...)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{
+			"code":    code,
+			"message": msg,
+			"errors":  []map[string]string{{"message": msg, "reason": http.StatusText(code)}},
+		},
+	})
+}
+
+func reply(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// pathParts returns the unescaped segments of the request's path.
+func pathParts(r *http.Request) ([]string, error) {
+	parts := strings.Split(strings.Trim(r.URL.EscapedPath(), "/"), "/")
+	for i, p := range parts {
+		u, err := url.PathUnescape(p)
+		if err != nil {
+			return nil, err
+		}
+		parts[i] = u
+	}
+	return parts, nil
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	parts, err := pathParts(r)
+	if err != nil {
+		apiError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case len(parts) >= 2 && parts[0] == "calendar" && parts[1] == "v3":
+		s.serveCalendar(w, r, parts[2:])
+	case len(parts) >= 3 && parts[0] == "admin" && parts[1] == "directory" && parts[2] == "v1":
+		s.serveDirectory(w, r, parts[3:])
+	default:
+		apiError(w, http.StatusNotFound, "unknown path %s", r.URL.Path)
+	}
+}
+
+func (s *Server) serveCalendar(w http.ResponseWriter, r *http.Request, parts []string) {
+	switch {
+	case len(parts) == 4 && parts[0] == "users" && parts[1] == "me" && parts[2] == "calendarList" && r.Method == http.MethodGet:
+		id := s.calendarId(parts[3])
+		role, ok := s.access[id]
+		if id == s.Primary {
+			role, ok = "owner", true
+		}
+		if !ok {
+			apiError(w, http.StatusNotFound, "calendar %s not found", parts[3])
+			return
+		}
+		reply(w, &calendar.CalendarListEntry{Id: id, AccessRole: role})
+	case len(parts) == 3 && parts[0] == "users" && parts[1] == "me" && parts[2] == "calendarList" && r.Method == http.MethodGet:
+		list := &calendar.CalendarList{Items: []*calendar.CalendarListEntry{{Id: s.Primary, Summary: s.Primary, AccessRole: "owner", Primary: true}}}
+		var ids []string
+		for id := range s.access {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			list.Items = append(list.Items, &calendar.CalendarListEntry{Id: id, Summary: s.summaries[id], AccessRole: s.access[id]})
+		}
+		reply(w, list)
+	case len(parts) == 1 && parts[0] == "calendars" && r.Method == http.MethodPost:
+		c := new(calendar.Calendar)
+		if err := json.NewDecoder(r.Body).Decode(c); err != nil {
+			apiError(w, http.StatusBadRequest, "%v", err)
+			return
+		}
+		s.nextId++
+		c.Id = fmt.Sprintf("calendar%d@group.calendar.google.com", s.nextId)
+		s.access[c.Id] = "owner"
+		s.summaries[c.Id] = c.Summary
+		s.events[c.Id] = nil
+		reply(w, c)
+	case len(parts) == 4 && parts[0] == "calendars" && parts[2] == "events" && r.Method == http.MethodDelete:
+		calId := s.calendarId(parts[1])
+		for i, e := range s.events[calId] {
+			if e.Id == parts[3] {
+				s.events[calId] = append(s.events[calId][:i], s.events[calId][i+1:]...)
+				s.change(calId, e)
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		apiError(w, http.StatusNotFound, "event %s not found", parts[3])
+	case len(parts) == 1 && parts[0] == "freeBusy" && r.Method == http.MethodPost:
+		s.freeBusy(w, r)
+	case len(parts) == 2 && parts[0] == "calendars" && r.Method == http.MethodGet:
+		reply(w, &calendar.Calendar{Id: s.calendarId(parts[1]), Summary: s.calendarId(parts[1])})
+	case len(parts) == 3 && parts[0] == "calendars" && parts[2] == "events" && r.Method == http.MethodGet:
+		calId := s.calendarId(parts[1])
+		if _, ok := s.events[calId]; !ok && calId != s.Primary && s.access[calId] == "" && !s.isResource(calId) {
+			apiError(w, http.StatusNotFound, "calendar %s not found", parts[1])
+			return
+		}
+		s.listEvents(w, r, calId)
+	case len(parts) == 3 && parts[0] == "calendars" && parts[2] == "events" && r.Method == http.MethodPost:
+		e := new(calendar.Event)
+		if err := json.NewDecoder(r.Body).Decode(e); err != nil {
+			apiError(w, http.StatusBadRequest, "%v", err)
+			return
+		}
+		e.Id = ""
+		reply(w, s.view(parts[1], s.insert(s.calendarId(parts[1]), e)))
+	case len(parts) == 4 && parts[0] == "calendars" && parts[2] == "events" && r.Method == http.MethodGet:
+		e := s.find(s.calendarId(parts[1]), parts[3])
+		if e == nil {
+			apiError(w, http.StatusNotFound, "event %s not found", parts[3])
+			return
+		}
+		reply(w, s.view(parts[1], e))
+	case len(parts) == 4 && parts[0] == "calendars" && parts[2] == "events" && r.Method == http.MethodPatch:
+		s.patchEvent(w, r, s.calendarId(parts[1]), parts[3])
+	case len(parts) == 4 && parts[0] == "calendars" && parts[2] == "events" && parts[3] == "watch" && r.Method == http.MethodPost:
+		s.watch(w, r, s.calendarId(parts[1]))
+	case len(parts) == 2 && parts[0] == "channels" && parts[1] == "stop" && r.Method == http.MethodPost:
+		c := new(calendar.Channel)
+		if err := json.NewDecoder(r.Body).Decode(c); err != nil {
+			apiError(w, http.StatusBadRequest, "%v", err)
+			return
+		}
+		if old, ok := s.channels[c.Id]; !ok || old.ResourceId != c.ResourceId {
+			apiError(w, http.StatusNotFound, "channel %s not found", c.Id)
+			return
+		}
+		delete(s.channels, c.Id)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		apiError(w, http.StatusNotFound, "unknown calendar method %s %s", r.Method, r.URL.Path)
+	}
+}
+
+// view returns a copy of e as seen from calendar calId.
+func (s *Server) view(calId string, e *calendar.Event) *calendar.Event {
+	ret := clone(e)
+	for _, a := range ret.Attendees {
+		a.Self = a.Email == s.calendarId(calId)
+	}
+	if ret.Organizer != nil {
+		ret.Organizer.Self = ret.Organizer.Email == s.calendarId(calId)
+	}
+	return ret
+}
+
+// watch opens a watch channel on the events of calendar calId.
+func (s *Server) watch(w http.ResponseWriter, r *http.Request, calId string) {
+	c := new(channel)
+	if err := json.NewDecoder(r.Body).Decode(&c.Channel); err != nil {
+		apiError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	if c.Id == "" || c.Type != "web_hook" || c.Address == "" {
+		apiError(w, http.StatusBadRequest, "channel needs an ID, type web_hook and address")
+		return
+	}
+	if _, ok := s.channels[c.Id]; ok {
+		apiError(w, http.StatusBadRequest, "channel %s already exists", c.Id)
+		return
+	}
+	ttl := 7 * 24 * time.Hour
+	if v, ok := c.Params["ttl"]; ok {
+		secs, err := strconv.Atoi(v)
+		if err != nil {
+			apiError(w, http.StatusBadRequest, "ttl: %v", err)
+			return
+		}
+		ttl = time.Duration(secs) * time.Second
+	}
+	s.nextId++
+	c.calId = calId
+	c.Kind = "api#channel"
+	c.ResourceId = fmt.Sprintf("resource%d", s.nextId)
+	c.ResourceUri = s.CalendarEndpoint() + "calendars/" + url.PathEscape(calId) + "/events"
+	c.Expiration = time.Now().Add(ttl).UnixMilli()
+	c.Params = nil
+	s.channels[c.Id] = c
+	go notify(c.Channel, 0, "sync")
+	reply(w, &c.Channel)
+}
+
+func (s *Server) listEvents(w http.ResponseWriter, r *http.Request, calId string) {
+	q := r.URL.Query()
+	if token := q.Get("syncToken"); token != "" {
+		s.syncEvents(w, token, calId)
+		return
+	}
+	var window interval.Interval
+	var err error
+	if window.Start, err = time.Parse(time.RFC3339, q.Get("timeMin")); err != nil && q.Get("timeMin") != "" {
+		apiError(w, http.StatusBadRequest, "timeMin: %v", err)
+		return
+	}
+	if window.End, err = time.Parse(time.RFC3339, q.Get("timeMax")); err != nil && q.Get("timeMax") != "" {
+		apiError(w, http.StatusBadRequest, "timeMax: %v", err)
+		return
+	}
+	if q.Get("timeMax") == "" {
+		window.End = time.Unix(1<<40, 0)
+	}
+	var items []*calendar.Event
+	for _, e := range s.events[calId] {
+		if e.Status == "cancelled" && q.Get("showDeleted") != "true" {
+			continue
+		}
+		if i, ok := eventInterval(e); !ok || !i.Overlaps(window) {
+			continue
+		}
+		items = append(items, s.view(calId, e))
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		a, _ := eventInterval(items[i])
+		b, _ := eventInterval(items[j])
+		return a.Start.Before(b.Start)
+	})
+	reply(w, &calendar.Events{Items: items, TimeZone: q.Get("timeZone"), NextSyncToken: s.syncToken()})
+}
+
+// syncEvents replies with the events in calendar calId changed since token,
+// or 410 Gone if the token is invalid, as Calendar does once tokens expire.
+func (s *Server) syncEvents(w http.ResponseWriter, token, calId string) {
+	var since int
+	if _, err := fmt.Sscanf(token, "sync-%d", &since); err != nil || since > s.seq {
+		apiError(w, http.StatusGone, "sync token %s is no longer valid", token)
+		return
+	}
+	var items []*calendar.Event
+	for _, e := range s.events[calId] {
+		if s.changed[calId][e.Id] > since {
+			items = append(items, s.view(calId, e))
+		}
+	}
+	reply(w, &calendar.Events{Items: items, NextSyncToken: s.syncToken()})
+}
+
+// patchEvent applies a patch, replacing each field present in the request.
+func (s *Server) patchEvent(w http.ResponseWriter, r *http.Request, calId, id string) {
+	e := s.find(calId, id)
+	if e == nil {
+		apiError(w, http.StatusNotFound, "event %s not found", id)
+		return
+	}
+	var patch map[string]json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		apiError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil {
+		apiError(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+	for k, v := range patch {
+		if k == "extendedProperties" {
+			v = mergeExtendedProperties(fields[k], v)
+		}
+		fields[k] = v
+	}
+	if b, err = json.Marshal(fields); err != nil {
+		apiError(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+	patched := new(calendar.Event)
+	if err := json.Unmarshal(b, patched); err != nil {
+		apiError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	patched.Id = id
+	patched.Updated = time.Now().UTC().Format(time.RFC3339Nano)
+	s.respondAsResources(calId, patched)
+	*e = *patched
+	s.change(calId, e)
+	if v, ok := patch["extendedProperties"]; ok {
+		// Instances inherit the properties of their recurring event.
+		for _, inst := range s.events[calId] {
+			if inst.RecurringEventId != id {
+				continue
+			}
+			old, _ := json.Marshal(inst.ExtendedProperties)
+			inst.ExtendedProperties = new(calendar.EventExtendedProperties)
+			json.Unmarshal(mergeExtendedProperties(old, v), inst.ExtendedProperties)
+			s.change(calId, inst)
+		}
+	}
+	reply(w, s.view(calId, e))
+}
+
+// mergeExtendedProperties merges patched extended properties into old, as the
+// Calendar API does.
+func mergeExtendedProperties(old, patch json.RawMessage) json.RawMessage {
+	var o, p calendar.EventExtendedProperties
+	if len(old) > 0 {
+		json.Unmarshal(old, &o)
+	}
+	if err := json.Unmarshal(patch, &p); err != nil {
+		return patch
+	}
+	merge := func(dst *map[string]string, src map[string]string) {
+		if len(src) == 0 {
+			return
+		}
+		if *dst == nil {
+			*dst = make(map[string]string)
+		}
+		for k, v := range src {
+			(*dst)[k] = v
+		}
+	}
+	merge(&o.Private, p.Private)
+	merge(&o.Shared, p.Shared)
+	b, err := json.Marshal(o)
+	if err != nil {
+		return patch
+	}
+	return b
+}
+
+func (s *Server) freeBusy(w http.ResponseWriter, r *http.Request) {
+	req := new(calendar.FreeBusyRequest)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		apiError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	window, err := interval.Parse(req.TimeMin, req.TimeMax)
+	if err != nil {
+		apiError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	loc := time.UTC
+	if req.TimeZone != "" {
+		if loc, err = time.LoadLocation(req.TimeZone); err != nil {
+			apiError(w, http.StatusBadRequest, "%v", err)
+			return
+		}
+	}
+	resp := &calendar.FreeBusyResponse{
+		Kind:      "calendar#freeBusy",
+		TimeMin:   req.TimeMin,
+		TimeMax:   req.TimeMax,
+		Calendars: make(map[string]calendar.FreeBusyCalendar),
+	}
+	for _, item := range req.Items {
+		id := s.calendarId(item.Id)
+		if _, ok := s.events[id]; !ok && !s.isResource(id) && id != s.Primary {
+			resp.Calendars[item.Id] = calendar.FreeBusyCalendar{
+				Errors: []*calendar.Error{{Domain: "global", Reason: "notFound"}},
+			}
+			continue
+		}
+		busy := s.busy(id, "", "").Intersect(interval.NewSet(window))
+		fb := calendar.FreeBusyCalendar{Busy: []*calendar.TimePeriod{}}
+		for _, i := range busy.Intervals() {
+			fb.Busy = append(fb.Busy, &calendar.TimePeriod{
+				Start: i.Start.In(loc).Format(time.RFC3339),
+				End:   i.End.In(loc).Format(time.RFC3339),
+			})
+		}
+		resp.Calendars[item.Id] = fb
+	}
+	reply(w, resp)
+}
+
+func (s *Server) serveDirectory(w http.ResponseWriter, r *http.Request, parts []string) {
+	if r.Method != http.MethodGet {
+		apiError(w, http.StatusMethodNotAllowed, "directory is read-only")
+		return
+	}
+	switch {
+	case len(parts) == 2 && parts[0] == "users":
+		u, ok := s.users[parts[1]]
+		if !ok {
+			apiError(w, http.StatusNotFound, "user %s not found", parts[1])
+			return
+		}
+		reply(w, u)
+	case len(parts) == 4 && parts[0] == "customer" && parts[2] == "resources" && parts[3] == "buildings":
+		reply(w, &directory.Buildings{Buildings: s.buildings})
+	case len(parts) == 5 && parts[0] == "customer" && parts[2] == "resources" && parts[3] == "buildings":
+		for _, b := range s.buildings {
+			if b.BuildingId == parts[4] {
+				reply(w, b)
+				return
+			}
+		}
+		apiError(w, http.StatusNotFound, "building %s not found", parts[4])
+	case len(parts) == 4 && parts[0] == "customer" && parts[2] == "resources" && parts[3] == "calendars":
+		s.listResources(w, r)
+	default:
+		apiError(w, http.StatusNotFound, "unknown directory method %s %s", r.Method, r.URL.Path)
+	}
+}
+
+// listResources lists resources matching a query of the form
+// "field=value AND field=value".
+func (s *Server) listResources(w http.ResponseWriter, r *http.Request) {
+	conds := make(map[string]string)
+	if q := r.URL.Query().Get("query"); q != "" {
+		for _, c := range strings.Split(q, " AND ") {
+			kv := strings.SplitN(strings.TrimSpace(c), "=", 2)
+			if len(kv) != 2 {
+				apiError(w, http.StatusBadRequest, "unsupported query %q", q)
+				return
+			}
+			conds[kv[0]] = strings.Trim(kv[1], `"'`)
+		}
+	}
+	var items []*directory.CalendarResource
+	for _, res := range s.resources {
+		fields := map[string]string{
+			"buildingId":       res.BuildingId,
+			"resourceCategory": res.ResourceCategory,
+			"resourceType":     res.ResourceType,
+			"floorName":        res.FloorName,
+		}
+		match := true
+		for k, v := range conds {
+			if fields[k] != v {
+				match = false
+			}
+		}
+		if match {
+			items = append(items, res)
+		}
+	}
+	reply(w, &directory.CalendarResources{Items: items})
+}
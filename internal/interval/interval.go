@@ -1,6 +1,7 @@
 package interval
 
 import (
+	"fmt"
 	"log"
 	"sort"
 	"sync"
@@ -28,68 +29,189 @@ func (i Interval) Overlaps(j Interval) bool {
 	return false
 }
 
+// Duration returns the length of i.
+func (i Interval) Duration() time.Duration {
+	return i.End.Sub(i.Start)
+}
+
+// Overlap returns the duration for which i and j overlap.
+func (i Interval) Overlap(j Interval) time.Duration {
+	if !i.Overlaps(j) {
+		return 0
+	}
+	start, end := i.Start, i.End
+	if j.Start.After(start) {
+		start = j.Start
+	}
+	if j.End.Before(end) {
+		end = j.End
+	}
+	return end.Sub(start)
+}
+
+// Parse parses an interval from RFC3339 start and end times.
+func Parse(s, e string) (Interval, error) {
+	start, err := parseDateTime(s)
+	if err != nil {
+		return Interval{}, err
+	}
+	end, err := parseDateTime(e)
+	if err != nil {
+		return Interval{}, err
+	}
+	return Interval{start, end}, nil
+}
+
 func OrDie(s, e string) Interval {
-	return Interval{
-		Start: dateTimeOrDie(s),
-		End:   dateTimeOrDie(e),
+	i, err := Parse(s, e)
+	if err != nil {
+		log.Fatal(err)
 	}
+	return i
 }
 
-func dateTimeOrDie(s string) time.Time {
-	if x, err := time.Parse(time.RFC3339, s); err != nil {
-		log.Fatalf("'%s' cannot be converted to time: %v", s, err)
-	} else {
-		return x
+// parseDateTime parses an RFC3339 time. Leap seconds (e.g. "23:59:60Z"), which
+// time.Time cannot represent, are treated as the first instant of the
+// following second.
+func parseDateTime(s string) (time.Time, error) {
+	x, err := time.Parse(time.RFC3339, s)
+	if err == nil {
+		return x, nil
 	}
-	panic("unreachable") // suppress compiler error
+	const secondsOffset = len("2006-01-02T15:04:")
+	if len(s) >= secondsOffset+2 && s[secondsOffset:secondsOffset+2] == "60" {
+		if x, err2 := time.Parse(time.RFC3339, s[:secondsOffset]+"59"+s[secondsOffset+2:]); err2 == nil {
+			return x.Add(time.Second), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("'%s' cannot be converted to time: %v", s, err)
 }
 
+// Merge returns the union of intervals as a sorted list of disjoint intervals.
+// Overlapping and abutting intervals are combined.
+func Merge(intervals []Interval) []Interval {
+	if len(intervals) == 0 {
+		return nil
+	}
+	sorted := append([]Interval(nil), intervals...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Less(sorted[j]) })
+	ret := []Interval{sorted[0]}
+	for _, i := range sorted[1:] {
+		last := &ret[len(ret)-1]
+		if i.Start.After(last.End) {
+			ret = append(ret, i)
+			continue
+		}
+		if i.End.After(last.End) {
+			last.End = i.End
+		}
+	}
+	return ret
+}
+
+// Map associates values with intervals. A Map is safe for concurrent use. The
+// zero value is an empty Map ready to use.
 type Map[T any] struct {
+	mu        sync.RWMutex
 	intervals []Interval
 	data      []T
 }
 
 func (im *Map[T]) Add(start, end time.Time, t T) {
+	im.mu.Lock()
+	defer im.mu.Unlock()
 	itr := Interval{start, end}
 	i := sort.Search(len(im.intervals), func(i int) bool {
 		return itr.Less(im.intervals[i])
 	})
-	wg := sync.WaitGroup{}
-	wg.Add(2)
-	go func() {
-		defer wg.Done()
-		im.intervals = append(im.intervals, Interval{})
-		copy(im.intervals[i+1:], im.intervals[i:])
-		im.intervals[i] = itr
-	}()
-	go func() {
-		defer wg.Done()
-		var zero T
-		im.data = append(im.data, zero)
-		copy(im.data[i+1:], im.data[i:])
-		im.data[i] = t
-	}()
-	wg.Wait()
+	im.intervals = append(im.intervals, Interval{})
+	copy(im.intervals[i+1:], im.intervals[i:])
+	im.intervals[i] = itr
+	var zero T
+	im.data = append(im.data, zero)
+	copy(im.data[i+1:], im.data[i:])
+	im.data[i] = t
 }
 
-// Covering returns all values whose intervals cover [start and end].
-func (im *Map[T]) Covering(start, end time.Time) []T {
-	okFunc := func(i int) bool {
-		if !start.Before(im.intervals[i].Start) && !im.intervals[i].End.Before(end) {
-			return true
+// equalRange returns the range of indexes [i, j) of intervals equal to
+// [start, end]. The caller must hold im.mu.
+func (im *Map[T]) equalRange(start, end time.Time) (i, j int) {
+	itr := Interval{start, end}
+	i = sort.Search(len(im.intervals), func(k int) bool {
+		return !im.intervals[k].Less(itr)
+	})
+	j = i
+	for j < len(im.intervals) && im.intervals[j].Start.Equal(start) && im.intervals[j].End.Equal(end) {
+		j++
+	}
+	return i, j
+}
+
+// Delete removes values with interval [start, end] for which f returns true,
+// and returns the number of values removed.
+func (im *Map[T]) Delete(start, end time.Time, f func(T) bool) int {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	i, j := im.equalRange(start, end)
+	n := i
+	for k := i; k < j; k++ {
+		if !f(im.data[k]) {
+			im.intervals[n], im.data[n] = im.intervals[k], im.data[k]
+			n++
 		}
-		return false
 	}
-	i := sort.Search(len(im.intervals), okFunc)
-	if i == len(im.intervals) {
-		return nil
+	removed := j - n
+	im.intervals = append(im.intervals[:n], im.intervals[j:]...)
+	im.data = append(im.data[:n], im.data[j:]...)
+	return removed
+}
+
+// Update replaces each value with interval [start, end] with the result of
+// calling f on it, and returns the number of values updated.
+func (im *Map[T]) Update(start, end time.Time, f func(T) T) int {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	i, j := im.equalRange(start, end)
+	for k := i; k < j; k++ {
+		im.data[k] = f(im.data[k])
 	}
-	ret := make([]T, 0)
-	for ; i < len(im.intervals); i++ {
-		if !okFunc(i) {
-			break
+	return j - i
+}
+
+// Len returns the number of values in the map.
+func (im *Map[T]) Len() int {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+	return len(im.intervals)
+}
+
+// Iterate calls f for each value in order of interval until f returns false.
+// f must not modify the map.
+func (im *Map[T]) Iterate(f func(i Interval, t T) bool) {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+	for k := range im.intervals {
+		if !f(im.intervals[k], im.data[k]) {
+			return
+		}
+	}
+}
+
+// Covering returns all values whose intervals cover [start and end].
+func (im *Map[T]) Covering(start, end time.Time) []T {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+
+	// Only intervals starting at or before start can cover it. Intervals are
+	// sorted by start time, but not by end time, so each must be checked.
+	n := sort.Search(len(im.intervals), func(i int) bool {
+		return im.intervals[i].Start.After(start)
+	})
+	var ret []T
+	for i := 0; i < n; i++ {
+		if !im.intervals[i].End.Before(end) {
+			ret = append(ret, im.data[i])
 		}
-		ret = append(ret, im.data[i])
 	}
 	return ret
 }
@@ -0,0 +1,212 @@
+package interval_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vsekhar/gocal/internal/interval"
+)
+
+func mustParse(t *testing.T, s, e string) interval.Interval {
+	t.Helper()
+	i, err := interval.Parse(s, e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return i
+}
+
+func TestOverlapsAcrossDST(t *testing.T) {
+	cases := []struct {
+		name     string
+		i, j     [2]string
+		overlaps bool
+		overlap  time.Duration
+	}{
+		{
+			// America/Toronto sprang forward at 2am on 2022-03-13.
+			name:     "spring forward",
+			i:        [2]string{"2022-03-13T01:30:00-05:00", "2022-03-13T03:30:00-04:00"},
+			j:        [2]string{"2022-03-13T03:00:00-04:00", "2022-03-13T04:00:00-04:00"},
+			overlaps: true,
+			overlap:  30 * time.Minute,
+		},
+		{
+			// 1:30 EDT and 1:30 EST are an hour apart on 2022-11-06.
+			name:     "fall back",
+			i:        [2]string{"2022-11-06T01:00:00-04:00", "2022-11-06T01:30:00-04:00"},
+			j:        [2]string{"2022-11-06T01:00:00-05:00", "2022-11-06T01:30:00-05:00"},
+			overlaps: false,
+		},
+		{
+			name:     "fall back repeated hour",
+			i:        [2]string{"2022-11-06T01:00:00-04:00", "2022-11-06T01:30:00-05:00"},
+			j:        [2]string{"2022-11-06T01:15:00-05:00", "2022-11-06T02:00:00-05:00"},
+			overlaps: true,
+			overlap:  15 * time.Minute,
+		},
+		{
+			name:     "different representations",
+			i:        [2]string{"2022-03-13T07:00:00Z", "2022-03-13T08:00:00Z"},
+			j:        [2]string{"2022-03-13T03:59:00-04:00", "2022-03-13T05:00:00-04:00"},
+			overlaps: true,
+			overlap:  time.Minute,
+		},
+		{
+			name:     "abutting",
+			i:        [2]string{"2022-03-13T01:00:00-05:00", "2022-03-13T03:00:00-04:00"},
+			j:        [2]string{"2022-03-13T07:00:00Z", "2022-03-13T08:00:00Z"},
+			overlaps: false,
+		},
+	}
+	for _, c := range cases {
+		i := mustParse(t, c.i[0], c.i[1])
+		j := mustParse(t, c.j[0], c.j[1])
+		if got := i.Overlaps(j); got != c.overlaps {
+			t.Errorf("%s: i.Overlaps(j) = %t, want %t", c.name, got, c.overlaps)
+		}
+		if got := j.Overlaps(i); got != c.overlaps {
+			t.Errorf("%s: j.Overlaps(i) = %t, want %t", c.name, got, c.overlaps)
+		}
+		if got := i.Overlap(j); got != c.overlap {
+			t.Errorf("%s: i.Overlap(j) = %s, want %s", c.name, got, c.overlap)
+		}
+	}
+}
+
+func TestDurationAcrossDST(t *testing.T) {
+	i := mustParse(t, "2022-03-13T00:00:00-05:00", "2022-03-14T00:00:00-04:00")
+	if got, want := i.Duration(), 23*time.Hour; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestLeapSecond(t *testing.T) {
+	i := mustParse(t, "2016-12-31T23:59:60Z", "2017-01-01T00:00:01Z")
+	want := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	if !i.Start.Equal(want) {
+		t.Errorf("got %s, want %s", i.Start, want)
+	}
+	if _, err := interval.Parse("2016-12-31T23:59:61Z", "2017-01-01T00:00:00Z"); err == nil {
+		t.Errorf("expected error")
+	}
+}
+
+func TestMerge(t *testing.T) {
+	got := interval.Merge([]interval.Interval{
+		mustParse(t, "2022-11-06T01:15:00-05:00", "2022-11-06T02:00:00-05:00"),
+		mustParse(t, "2022-11-06T01:00:00-04:00", "2022-11-06T01:30:00-04:00"),
+		mustParse(t, "2022-11-06T05:30:00Z", "2022-11-06T06:15:00Z"), // 1:30 EDT - 1:15 EST
+		mustParse(t, "2022-11-06T09:00:00Z", "2022-11-06T10:00:00Z"),
+	})
+	want := []interval.Interval{
+		mustParse(t, "2022-11-06T01:00:00-04:00", "2022-11-06T02:00:00-05:00"),
+		mustParse(t, "2022-11-06T09:00:00Z", "2022-11-06T10:00:00Z"),
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range got {
+		if !got[i].Start.Equal(want[i].Start) || !got[i].End.Equal(want[i].End) {
+			t.Errorf("%d: got %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestCovering(t *testing.T) {
+	var m interval.Map[string]
+	add := func(s, e, v string) {
+		i := mustParse(t, s, e)
+		m.Add(i.Start, i.End, v)
+	}
+	add("2022-03-13T00:00:00-05:00", "2022-03-13T12:00:00-04:00", "long")
+	add("2022-03-13T01:00:00-05:00", "2022-03-13T03:00:00-04:00", "short")
+	add("2022-03-13T01:30:00-05:00", "2022-03-13T05:00:00-04:00", "later")
+
+	q := mustParse(t, "2022-03-13T06:45:00Z", "2022-03-13T07:00:00Z") // 1:45-3:00
+	got := m.Covering(q.Start, q.End)
+	want := map[string]bool{"long": true, "short": true, "later": true}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want 3 values", got)
+	}
+	for _, v := range got {
+		if !want[v] {
+			t.Errorf("unexpected value %s", v)
+		}
+	}
+
+	q = mustParse(t, "2022-03-13T03:30:00-04:00", "2022-03-13T04:00:00-04:00")
+	got = m.Covering(q.Start, q.End)
+	if len(got) != 2 {
+		t.Errorf("got %v, want [long later]", got)
+	}
+}
+
+func TestMapDeleteUpdate(t *testing.T) {
+	var m interval.Map[int]
+	base := time.Date(2022, 4, 1, 9, 0, 0, 0, time.UTC)
+	hour := func(h int) time.Time { return base.Add(time.Duration(h) * time.Hour) }
+
+	var wg sync.WaitGroup
+	for k := 0; k < 10; k++ {
+		wg.Add(1)
+		go func(k int) {
+			defer wg.Done()
+			m.Add(hour(k%3), hour(k%3+1), k)
+		}(k)
+	}
+	wg.Wait()
+	if m.Len() != 10 {
+		t.Fatalf("got %d values, want 10", m.Len())
+	}
+
+	if n := m.Delete(hour(0), hour(1), func(v int) bool { return v > 5 }); n != 2 {
+		t.Errorf("deleted %d values, want 2", n) // 6, 9
+	}
+	if n := m.Update(hour(1), hour(2), func(v int) int { return -v }); n != 3 {
+		t.Errorf("updated %d values, want 3", n) // 1, 4, 7
+	}
+	var got []int
+	var prev interval.Interval
+	m.Iterate(func(i interval.Interval, v int) bool {
+		if i.Less(prev) {
+			t.Errorf("%v iterated after %v", i, prev)
+		}
+		prev = i
+		got = append(got, v)
+		return true
+	})
+	if len(got) != 8 {
+		t.Fatalf("got %v, want 8 values", got)
+	}
+	for _, v := range got {
+		if v > 5 && v%3 == 0 {
+			t.Errorf("value %d not deleted", v)
+		}
+		if v > 0 && v%3 == 1 {
+			t.Errorf("value %d not updated", v)
+		}
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	f.Add("2022-03-14T09:00:00Z", "2022-03-14T10:00:00-04:00")
+	f.Add("2016-12-31T23:59:60Z", "2017-01-01T00:00:00Z")
+	f.Add("2022-03-14T09:00:00.123+05:30", "")
+	f.Fuzz(func(t *testing.T, s, e string) {
+		i, err := interval.Parse(s, e)
+		if err != nil {
+			return
+		}
+		// Parsed times round-trip, so that leap seconds and offsets are
+		// resolved consistently.
+		j, err := interval.Parse(i.Start.Format(time.RFC3339Nano), i.End.Format(time.RFC3339Nano))
+		if err != nil {
+			t.Fatalf("reparsing %v: %v", i, err)
+		}
+		if !i.Start.Equal(j.Start) || !i.End.Equal(j.End) {
+			t.Errorf("got %v after round trip, want %v", j, i)
+		}
+	})
+}
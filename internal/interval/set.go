@@ -0,0 +1,116 @@
+package interval
+
+import (
+	"time"
+)
+
+// Set is a set of instants in time, represented as a sorted list of disjoint
+// intervals. The zero value is the empty set. Sets are immutable.
+type Set struct {
+	intervals []Interval
+}
+
+// NewSet returns the set of instants covered by any of intervals.
+func NewSet(intervals ...Interval) Set {
+	var nonEmpty []Interval
+	for _, i := range intervals {
+		if i.Start.Before(i.End) {
+			nonEmpty = append(nonEmpty, i)
+		}
+	}
+	return Set{Merge(nonEmpty)}
+}
+
+// Intervals returns the disjoint intervals making up s, in order.
+func (s Set) Intervals() []Interval {
+	return append([]Interval(nil), s.intervals...)
+}
+
+// Empty returns true if s contains no instants.
+func (s Set) Empty() bool { return len(s.intervals) == 0 }
+
+// Duration returns the total duration of the intervals in s.
+func (s Set) Duration() time.Duration {
+	var d time.Duration
+	for _, i := range s.intervals {
+		d += i.Duration()
+	}
+	return d
+}
+
+// Contains returns true if t is in s. Intervals include their start but not
+// their end.
+func (s Set) Contains(t time.Time) bool {
+	for _, i := range s.intervals {
+		if !t.Before(i.Start) && t.Before(i.End) {
+			return true
+		}
+	}
+	return false
+}
+
+// Overlaps returns true if any part of i is in s.
+func (s Set) Overlaps(i Interval) bool {
+	for _, j := range s.intervals {
+		if i.Overlaps(j) {
+			return true
+		}
+	}
+	return false
+}
+
+// Union returns the instants in either s or t.
+func (s Set) Union(t Set) Set {
+	return NewSet(append(s.Intervals(), t.intervals...)...)
+}
+
+// Intersect returns the instants in both s and t.
+func (s Set) Intersect(t Set) Set {
+	var ret []Interval
+	i, j := 0, 0
+	for i < len(s.intervals) && j < len(t.intervals) {
+		a, b := s.intervals[i], t.intervals[j]
+		start, end := a.Start, a.End
+		if b.Start.After(start) {
+			start = b.Start
+		}
+		if b.End.Before(end) {
+			end = b.End
+		}
+		if start.Before(end) {
+			ret = append(ret, Interval{start, end})
+		}
+		if a.End.Before(b.End) {
+			i++
+		} else {
+			j++
+		}
+	}
+	return Set{ret}
+}
+
+// Difference returns the instants in s but not in t.
+func (s Set) Difference(t Set) Set {
+	var ret []Interval
+	j := 0
+	for _, a := range s.intervals {
+		start := a.Start
+		for ; j < len(t.intervals) && !t.intervals[j].Start.After(a.End); j++ {
+			b := t.intervals[j]
+			if b.End.After(start) {
+				if b.Start.After(start) {
+					ret = append(ret, Interval{start, b.Start})
+				}
+				start = b.End
+			}
+			if b.End.After(a.End) {
+				// b may also overlap the next interval in s.
+				break
+			}
+		}
+		if start.Before(a.End) {
+			ret = append(ret, Interval{start, a.End})
+		}
+	}
+	return Set{ret}
+}
@@ -0,0 +1,63 @@
+package interval_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/vsekhar/gocal/internal/interval"
+)
+
+func TestSet(t *testing.T) {
+	base := time.Date(2022, 4, 1, 0, 0, 0, 0, time.UTC)
+	set := func(hours ...int) interval.Set {
+		var is []interval.Interval
+		for k := 0; k < len(hours); k += 2 {
+			is = append(is, interval.Interval{
+				Start: base.Add(time.Duration(hours[k]) * time.Hour),
+				End:   base.Add(time.Duration(hours[k+1]) * time.Hour),
+			})
+		}
+		return interval.NewSet(is...)
+	}
+	equal := func(a, b interval.Set) bool {
+		ai, bi := a.Intervals(), b.Intervals()
+		if len(ai) != len(bi) {
+			return false
+		}
+		for k := range ai {
+			if !ai[k].Start.Equal(bi[k].Start) || !ai[k].End.Equal(bi[k].End) {
+				return false
+			}
+		}
+		return true
+	}
+
+	s := set(1, 3, 2, 5, 8, 10, 12, 12)
+	if !equal(s, set(1, 5, 8, 10)) {
+		t.Errorf("NewSet: got %v", s.Intervals())
+	}
+	if got, want := s.Duration(), 6*time.Hour; got != want {
+		t.Errorf("Duration: got %s, want %s", got, want)
+	}
+	if !s.Contains(base.Add(time.Hour)) || s.Contains(base.Add(5*time.Hour)) {
+		t.Errorf("Contains: wrong result at interval boundaries")
+	}
+
+	u := set(0, 2, 4, 9, 11, 12)
+	cases := []struct {
+		name      string
+		got, want interval.Set
+	}{
+		{"union", s.Union(u), set(0, 10, 11, 12)},
+		{"intersect", s.Intersect(u), set(1, 2, 4, 5, 8, 9)},
+		{"difference", s.Difference(u), set(2, 4, 9, 10)},
+		{"reverse difference", u.Difference(s), set(0, 1, 5, 8, 11, 12)},
+		{"spanning difference", set(0, 2, 3, 5).Difference(set(1, 4)), set(0, 1, 4, 5)},
+		{"empty", s.Difference(s), interval.Set{}},
+	}
+	for _, c := range cases {
+		if !equal(c.got, c.want) {
+			t.Errorf("%s: got %v, want %v", c.name, c.got.Intervals(), c.want.Intervals())
+		}
+	}
+}
@@ -4,147 +4,377 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"log"
-	"os"
+	"math"
 	"path/filepath"
-	"sync"
+	"strings"
 	"time"
 
 	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/search/query"
 	"github.com/vsekhar/gocal/internal/batch"
 	"github.com/vsekhar/gocal/internal/cache"
-	"gonum.org/v1/gonum/stat"
+	"golang.org/x/sync/errgroup"
 	directory "google.golang.org/api/admin/directory/v1"
 )
 
 const maxAge = 7 * 24 * time.Hour
 
+// Versions of cache entries.
+var (
+	buildingsVersion = cache.Version{Number: 1}
+	buildingVersion  = cache.Version{Number: 1}
+	resourcesVersion = cache.Version{Number: 4} // 2: all resource categories; 3: typed Resource; 4: JSON lines
+)
+
 func loadIndex(dir string) (bleve.Index, error) { return bleve.Open(dir) }
 
-func Buildings(ctx context.Context, cacheSpace *cache.Space, srv *directory.Service) (bleve.Index, error) {
-	return cache.GetOrCreate(cacheSpace, "buildings", maxAge, loadIndex, func(dir string) (bleve.Index, error) {
+// entryID returns the ID of the cache entry id for customer. Entries for the
+// DefaultCustomer keep their unqualified IDs.
+func entryID(customer, id string) string {
+	if customer == DefaultCustomer {
+		return id
+	}
+	return customer + "-" + id
+}
+
+func Buildings(ctx context.Context, cacheSpace *cache.Space, srv *directory.Service, customer string) (bleve.Index, error) {
+	return BuildingsEntry(ctx, srv, customer).Get(cacheSpace)
+}
+
+// BuildingsEntry returns the cache entry holding the index of customer's
+// buildings.
+func BuildingsEntry(ctx context.Context, srv *directory.Service, customer string) *cache.Entry[bleve.Index] {
+	entry := &cache.Entry[bleve.Index]{
+		ID:      entryID(customer, "buildings"),
+		Version: buildingsVersion,
+		MaxAge:  maxAge,
+		Source:  "admin.directory.v1 resources.buildings.list",
+		Load:    loadIndex,
+		Count: func(idx bleve.Index) int {
+			n, _ := idx.DocCount()
+			return int(n)
+		},
+		SkipChecksum: true, // bleve modifies the index when opened
+	}
+	entry.Create = func(dir string) (bleve.Index, error) {
 		// Fetch all and save index
 		idx, err := bleve.New(dir, bleve.NewIndexMapping())
 		if err != nil {
 			return nil, err
 		}
 
+		// Buildings are fetched, batched and indexed concurrently. If
+		// fetching or indexing fails, the rest stops.
 		buildings := make(chan *directory.Building, 10000)
 		batches := make(chan []*directory.Building)
-
-		wg := sync.WaitGroup{}
-		wg.Add(2)
+		g, gctx := errgroup.WithContext(ctx)
+		fetchCtx, stopFetching := context.WithCancel(gctx)
+		defer stopFetching()
 
 		// Producer
-		go func() {
-			defer wg.Done()
+		g.Go(func() error {
 			defer close(buildings)
-			err = ForEachBuilding(ctx, srv, func(b *directory.Building) error {
-				buildings <- b
-				return nil
+			err := ForEachBuilding(fetchCtx, srv, customer, func(b *directory.Building) error {
+				select {
+				case buildings <- b:
+					return nil
+				case <-fetchCtx.Done():
+					return fetchCtx.Err()
+				}
 			})
-			if err != nil {
-				log.Fatal(err)
+			if err != nil && gctx.Err() == nil && fetchCtx.Err() != nil {
+				// The consumer failed, and returns why.
+				return nil
 			}
-		}()
+			return err
+		})
+
+		// Batcher
+		g.Go(func() error {
+			defer close(batches)
+			batch.Up(buildings, batches)
+			return nil
+		})
 
 		// Consumer
-		go func() {
-			defer wg.Done()
+		g.Go(func() error {
+			var err error
 			for bs := range batches {
-				batch := idx.NewBatch()
-				for _, b := range bs {
-					batch.Index(b.BuildingId, b)
+				// After an error, drain batches so that the batcher finishes.
+				if err != nil {
+					continue
+				}
+				b := idx.NewBatch()
+				for _, building := range bs {
+					b.Index(building.BuildingId, building)
 				}
-				if err := idx.Batch(batch); err != nil {
-					log.Fatal(err)
+				if err = idx.Batch(b); err != nil {
+					stopFetching()
 				}
 			}
-		}()
+			return err
+		})
 
-		batch.Up(buildings, batches)
-		close(batches)
-		wg.Wait()
+		if err := g.Wait(); err != nil {
+			idx.Close()
+			return nil, err
+		}
+		return idx, nil
+	}
+	return entry
+}
 
-		return idx, err
-	})
+// Building returns the building with ID buildingId, e.g. for its floor names
+// and coordinates.
+func Building(ctx context.Context, cacheSpace *cache.Space, srv *directory.Service, customer, buildingId string) (*directory.Building, error) {
+	return BuildingEntry(ctx, cacheSpace, srv, customer, buildingId).Get(cacheSpace)
 }
 
-type Resources []*directory.CalendarResource
+// BuildingEntry returns the cache entry in cacheSpace holding one of
+// customer's buildings.
+func BuildingEntry(ctx context.Context, cacheSpace *cache.Space, srv *directory.Service, customer, buildingId string) *cache.Entry[*directory.Building] {
+	const buildingFilename = "building.json"
 
-func ResourcesInBuilding(ctx context.Context, cacheSpace *cache.Space, srv *directory.Service, buildingId string) (Resources, error) {
-	const resourcesFilename = "resources.json"
+	return &cache.Entry[*directory.Building]{
+		ID:      entryID(customer, "building-"+buildingId),
+		Version: buildingVersion,
+		MaxAge:  maxAge,
+		Source:  "admin.directory.v1 resources.buildings.get",
+		Load: func(dir string) (*directory.Building, error) {
+			b, err := cacheSpace.ReadFile(filepath.Join(dir, buildingFilename))
+			if err != nil {
+				return nil, err
+			}
+			ret := new(directory.Building)
+			if err := json.Unmarshal(b, ret); err != nil {
+				return nil, err
+			}
+			return ret, nil
+		},
+		Create: func(dir string) (*directory.Building, error) {
+			ret, err := srv.Resources.Buildings.Get(customer, buildingId).Context(ctx).Do()
+			if err != nil {
+				return nil, err
+			}
+			b, err := json.Marshal(ret)
+			if err != nil {
+				return nil, err
+			}
+			if err := cacheSpace.WriteFile(filepath.Join(dir, buildingFilename), b); err != nil {
+				return nil, err
+			}
+			return ret, nil
+		},
+	}
+}
+
+type Resources []*Resource
+
+// ConferenceRooms returns the resources in rs that are conference rooms.
+func (rs Resources) ConferenceRooms() Resources {
+	var ret Resources
+	for _, r := range rs {
+		if r.ResourceCategory == "CONFERENCE_ROOM" {
+			ret = append(ret, r)
+		}
+	}
+	return ret
+}
+
+func ResourcesInBuilding(ctx context.Context, cacheSpace *cache.Space, srv *directory.Service, customer, buildingId string) (Resources, error) {
+	return ResourcesEntry(ctx, cacheSpace, srv, customer, buildingId).Get(cacheSpace)
+}
+
+// ResourcesEntry returns the cache entry in cacheSpace holding the resources in
+// one of customer's buildings.
+func ResourcesEntry(ctx context.Context, cacheSpace *cache.Space, srv *directory.Service, customer, buildingId string) *cache.Entry[Resources] {
+	// Resources are stored one JSON object per line, and written and read
+	// one at a time, so that the file is never held in memory whole.
+	const resourcesFilename = "resources.jsonl"
 
 	loadResources := func(dir string) (Resources, error) {
-		f, err := os.Open(filepath.Join(dir, resourcesFilename))
+		f, err := cacheSpace.Open(filepath.Join(dir, resourcesFilename))
 		if err != nil {
 			return nil, err
 		}
 		defer f.Close()
-		dec := json.NewDecoder(f)
 		var ret Resources
-		if err := dec.Decode(&ret); err != nil {
-			return nil, err
+		dec := json.NewDecoder(f)
+		for dec.More() {
+			r := new(Resource)
+			if err := dec.Decode(r); err != nil {
+				return nil, err
+			}
+			ret = append(ret, r)
 		}
 		return ret, nil
 	}
 
 	createResources := func(dir string) (Resources, error) {
-		var ret Resources
-		err := ForEachResourceInBuilding(ctx, srv, buildingId, func(r *directory.CalendarResource) error {
-			ret = append(ret, r)
-			return nil
-		})
-		if err != nil {
-			return nil, err
-		}
-		f, err := os.Create(filepath.Join(dir, resourcesFilename))
+		f, err := cacheSpace.Create(filepath.Join(dir, resourcesFilename))
 		if err != nil {
 			return nil, err
 		}
-		defer f.Close()
 		enc := json.NewEncoder(f)
-		if err = enc.Encode(ret); err != nil {
+		var ret Resources
+		err = ForEachResourceInBuilding(ctx, srv, customer, buildingId, func(r *directory.CalendarResource) error {
+			res, err := NewResource(r)
+			if err != nil {
+				return err
+			}
+			ret = append(ret, res)
+			return enc.Encode(res)
+		})
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
 			return nil, err
 		}
 		return ret, nil
 	}
 
-	return cache.GetOrCreate(cacheSpace, buildingId, maxAge, loadResources, createResources)
+	entry := &cache.Entry[Resources]{
+		ID:      entryID(customer, buildingId),
+		Version: resourcesVersion,
+		MaxAge:  maxAge,
+		Source:  "admin.directory.v1 resources.calendars.list",
+		Load:    loadResources,
+		Create:  createResources,
+		Count:   func(r Resources) int { return len(r) },
+	}
+	return entry
 }
 
-func confidenceInFirst(f []float64) bool {
-	const minStdScore = 2.0 // standard deviations away from the mean
+// DefaultSearchMargin is the default factor by which the best building match
+// must outscore the next for SearchBuildings to choose it.
+const DefaultSearchMargin = 1.5
 
-	if len(f) == 0 {
-		panic("empty values")
-	}
-	if len(f) == 1 {
+// confidenceInFirst returns true if the first of the descending scores f is
+// at least margin times the second, or is the only score. Unlike a z-score,
+// this is meaningful for the two or three results typical of a specific
+// query.
+func confidenceInFirst(f []float64, margin float64) bool {
+	switch {
+	case len(f) == 0 || math.IsNaN(f[0]) || f[0] <= 0:
+		return false
+	case len(f) == 1 || f[1] <= 0:
 		return true
 	}
+	return f[0] >= margin*f[1]
+}
+
+// BuildingMatch is a building matching a search.
+type BuildingMatch struct {
+	ID, Name string
 
-	mean, stdev := stat.MeanStdDev(f, nil)
-	score := stat.StdScore(f[0], mean, stdev)
-	return score > minStdScore
+	// City and Region are from the building's address, if it has one, e.g.
+	// to distinguish campuses with the same building names.
+	City, Region string
+
+	// Score is the relevance of the building to the search. Higher is
+	// better.
+	Score float64
 }
 
-func SearchBuildings(idx bleve.Index, q string) (buildingID string, err error) {
-	query := bleve.NewQueryStringQuery(q)
-	sr := bleve.NewSearchRequestOptions(query, 50, 0, false)
-	results, err := idx.Search(sr)
+func (m BuildingMatch) String() string {
+	s := m.ID
+	if m.Name != "" {
+		s = fmt.Sprintf("%s (%s)", m.Name, m.ID)
+	}
+	if m.City != "" {
+		s += " in " + m.City
+	}
+	return s
+}
+
+// inCity returns true if m's address is in city, which may name its locality
+// or region.
+func (m BuildingMatch) inCity(city string) bool {
+	return strings.EqualFold(m.City, city) || strings.EqualFold(m.Region, city)
+}
+
+// AmbiguousError is returned by SearchBuildings when no building matches the
+// query clearly better than the others.
+type AmbiguousError struct {
+	Query string
+
+	// Candidates are the matching buildings, best first.
+	Candidates []BuildingMatch
+}
+
+func (e *AmbiguousError) Error() string {
+	if len(e.Candidates) == 0 {
+		return fmt.Sprintf("no buildings match '%s'", e.Query)
+	}
+	return fmt.Sprintf("%d buildings match '%s'", len(e.Candidates), e.Query)
+}
+
+// SearchBuildings returns the building best matching q, which must score at
+// least margin times the next best (see DefaultSearchMargin). If city is not
+// empty, only buildings whose address is in that city or region are
+// considered. If there isn't a clear best match, it returns an
+// *AmbiguousError with the candidates.
+func SearchBuildings(idx bleve.Index, q, city string, margin float64) (BuildingMatch, error) {
+	matches, err := searchBuildings(idx, bleve.NewQueryStringQuery(q), 50)
 	if err != nil {
-		return "", err
+		return BuildingMatch{}, err
 	}
-	scores := make([]float64, results.Total)
-	for i, d := range results.Hits {
-		scores[i] = d.Score
+	if city != "" {
+		var inCity []BuildingMatch
+		for _, m := range matches {
+			if m.inCity(city) {
+				inCity = append(inCity, m)
+			}
+		}
+		matches = inCity
+	}
+	scores := make([]float64, len(matches))
+	for i, m := range matches {
+		scores[i] = m.Score
 	}
-	if confidenceInFirst(scores) {
-		return results.Hits[0].ID, nil
+	if len(matches) == 0 || !confidenceInFirst(scores, margin) {
+		return BuildingMatch{}, &AmbiguousError{Query: q, Candidates: matches}
 	}
+	return matches[0], nil
+}
+
+// HasBuilding returns true if idx has the building with ID id.
+func HasBuilding(idx bleve.Index, id string) (bool, error) {
+	d, err := idx.Document(id)
+	return d != nil, err
+}
 
-	for _, d := range results.Hits {
-		log.Printf("%s: %f", d.ID, d.Score)
+// BuildingCandidates returns up to n buildings matching q, best match first.
+// Matching tolerates misspellings.
+func BuildingCandidates(idx bleve.Index, q string, n int) ([]BuildingMatch, error) {
+	query := bleve.NewDisjunctionQuery(
+		bleve.NewQueryStringQuery(q),
+		bleve.NewFuzzyQuery(strings.ToLower(q)),
+	)
+	return searchBuildings(idx, query, n)
+}
+
+// Indexed fields of buildings.
+const (
+	buildingNameField   = "buildingName"
+	buildingCityField   = "address.locality"
+	buildingRegionField = "address.administrativeArea"
+)
+
+func searchBuildings(idx bleve.Index, q query.Query, n int) ([]BuildingMatch, error) {
+	sr := bleve.NewSearchRequestOptions(q, n, 0, false)
+	sr.Fields = []string{buildingNameField, buildingCityField, buildingRegionField}
+	results, err := idx.Search(sr)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]BuildingMatch, len(results.Hits))
+	for i, d := range results.Hits {
+		ret[i] = BuildingMatch{ID: d.ID, Score: d.Score}
+		ret[i].Name, _ = d.Fields[buildingNameField].(string)
+		ret[i].City, _ = d.Fields[buildingCityField].(string)
+		ret[i].Region, _ = d.Fields[buildingRegionField].(string)
 	}
-	return "", fmt.Errorf("%d buildings found", results.Total)
+	return ret, nil
 }
@@ -0,0 +1,137 @@
+package itercal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/blevesearch/bleve"
+	"github.com/vsekhar/gocal/internal/fakegoogle"
+	directory "google.golang.org/api/admin/directory/v1"
+)
+
+func testBuildingIndex(t *testing.T, buildings ...*directory.Building) bleve.Index {
+	t.Helper()
+	idx, err := bleve.NewMemOnly(bleve.NewIndexMapping())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { idx.Close() })
+	for _, b := range buildings {
+		if err := idx.Index(b.BuildingId, b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return idx
+}
+
+func TestSearchBuildings(t *testing.T) {
+	idx := testBuildingIndex(t,
+		&directory.Building{BuildingId: "tor-111", BuildingName: "Toronto 111 Richmond"},
+		&directory.Building{BuildingId: "tor-65", BuildingName: "Toronto 65 King"},
+		&directory.Building{BuildingId: "mtv-1", BuildingName: "Mountain View 1600 Amphitheatre"},
+	)
+
+	m, err := SearchBuildings(idx, "Richmond", "", DefaultSearchMargin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.ID != "tor-111" || m.Name != "Toronto 111 Richmond" {
+		t.Errorf("got %+v, want tor-111 with its name", m)
+	}
+
+	_, err = SearchBuildings(idx, "Toronto", "", DefaultSearchMargin)
+	var ambiguous *AmbiguousError
+	if !errors.As(err, &ambiguous) {
+		t.Fatalf("got error %v, want AmbiguousError", err)
+	}
+	if len(ambiguous.Candidates) != 2 || ambiguous.Candidates[0].Name == "" {
+		t.Errorf("got candidates %+v, want both Toronto buildings with names", ambiguous.Candidates)
+	}
+
+	_, err = SearchBuildings(idx, "Paris", "", DefaultSearchMargin)
+	if !errors.As(err, &ambiguous) || len(ambiguous.Candidates) != 0 {
+		t.Errorf("got error %v, want AmbiguousError without candidates", err)
+	}
+}
+
+func TestSearchBuildingsCity(t *testing.T) {
+	idx := testBuildingIndex(t,
+		&directory.Building{BuildingId: "lon-1", BuildingName: "King's Cross 1", Address: &directory.BuildingAddress{Locality: "London", AdministrativeArea: "England"}},
+		&directory.Building{BuildingId: "ont-1", BuildingName: "King's Cross 1", Address: &directory.BuildingAddress{Locality: "London", AdministrativeArea: "Ontario"}},
+		&directory.Building{BuildingId: "syd-1", BuildingName: "King's Cross 1", Address: &directory.BuildingAddress{Locality: "Sydney"}},
+	)
+
+	_, err := SearchBuildings(idx, "King's Cross", "", DefaultSearchMargin)
+	var ambiguous *AmbiguousError
+	if !errors.As(err, &ambiguous) || len(ambiguous.Candidates) != 3 {
+		t.Fatalf("got error %v, want AmbiguousError with 3 candidates", err)
+	}
+	m, err := SearchBuildings(idx, "King's Cross", "sydney", DefaultSearchMargin)
+	if err != nil || m.ID != "syd-1" {
+		t.Errorf("got %v, %v, want syd-1", m, err)
+	}
+	if _, err := SearchBuildings(idx, "King's Cross", "London", DefaultSearchMargin); !errors.As(err, &ambiguous) {
+		t.Errorf("got error %v, want AmbiguousError", err)
+	}
+	m, err = SearchBuildings(idx, "King's Cross", "Ontario", DefaultSearchMargin)
+	if err != nil || m.ID != "ont-1" || m.City != "London" {
+		t.Errorf("got %+v, %v, want ont-1 in London", m, err)
+	}
+}
+
+func TestConfidenceInFirst(t *testing.T) {
+	cases := []struct {
+		name   string
+		scores []float64
+		want   bool
+	}{
+		{"none", nil, false},
+		{"one", []float64{0.3}, true},
+		{"two tied", []float64{0.8, 0.8}, false},
+		{"two close", []float64{1.0, 0.8}, false},
+		{"two apart", []float64{1.6, 0.5}, true},
+		{"three apart", []float64{2.1, 0.7, 0.6}, true},
+		{"three close", []float64{0.9, 0.8, 0.1}, false},
+		{"long tail", []float64{3.0, 0.4, 0.4, 0.3, 0.3, 0.2, 0.2, 0.1}, true},
+		{"flat tail", []float64{0.5, 0.4, 0.4, 0.4, 0.4, 0.4, 0.4, 0.4}, false},
+		{"zero second", []float64{0.2, 0}, true},
+		{"zero first", []float64{0, 0}, false},
+		{"NaN", []float64{math.NaN(), 0.5}, false},
+	}
+	for _, c := range cases {
+		if got := confidenceInFirst(c.scores, DefaultSearchMargin); got != c.want {
+			t.Errorf("%s: confidenceInFirst(%v) = %t, want %t", c.name, c.scores, got, c.want)
+		}
+	}
+}
+
+func TestBuildingsIndex(t *testing.T) {
+	fake := fakegoogle.NewServer("user@example.com")
+	defer fake.Close()
+	for i := 0; i < 50; i++ {
+		fake.AddBuilding(&directory.Building{BuildingId: fmt.Sprintf("b-%d", i), BuildingName: fmt.Sprintf("Building %d", i)})
+	}
+	dirSrv, _, err := fake.Services(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := BuildingsEntry(context.Background(), dirSrv, DefaultCustomer)
+	idx, err := entry.Create(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, _ := idx.DocCount()
+	idx.Close()
+	if n != 50 {
+		t.Errorf("indexed %d buildings, want 50", n)
+	}
+
+	// Errors are returned rather than exiting.
+	fake.Close()
+	if _, err := entry.Create(t.TempDir()); err == nil {
+		t.Errorf("indexing succeeded without a server")
+	}
+}
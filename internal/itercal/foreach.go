@@ -2,20 +2,33 @@ package itercal
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
 	"time"
 
+	"github.com/vsekhar/gocal/internal/timeutil"
 	directory "google.golang.org/api/admin/directory/v1"
 	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/googleapi"
 )
 
-func ForEachEvent(ctx context.Context, srv *calendar.Service, calendarId string, start, end time.Time, f func(*calendar.Event) error) error {
-	ec := srv.Events.List(calendarId).
+// ForEachEvent calls f for each event in the calendar between start and end.
+// Times are formatted, and event times are returned, in loc.
+func ForEachEvent(ctx context.Context, srv *calendar.Service, calendarId string, start, end time.Time, loc *time.Location, f func(*calendar.Event) error) error {
+	return NewGoogle(srv).ListEvents(ctx, calendarId, start, end, loc, f)
+}
+
+func (g *Google) ListEvents(ctx context.Context, calendarId string, start, end time.Time, loc *time.Location, f func(*calendar.Event) error) error {
+	ec := g.srv.Events.List(calendarId).
 		Context(ctx).
 		ShowDeleted(false).SingleEvents(true).
-		TimeMin(start.Format(time.RFC3339)).
-		TimeMax(end.Format(time.RFC3339)).
+		TimeMin(timeutil.Format(start, loc)).
+		TimeMax(timeutil.Format(end, loc)).
 		OrderBy("startTime")
+	if tz := timeutil.ZoneName(loc); tz != "" {
+		ec = ec.TimeZone(tz)
+	}
 	return ec.Pages(ctx, func(events *calendar.Events) error {
 		for _, item := range events.Items {
 			if err := f(item); err != nil {
@@ -26,8 +39,45 @@ func ForEachEvent(ctx context.Context, srv *calendar.Service, calendarId string,
 	})
 }
 
-func ForEachBuilding(ctx context.Context, srv *directory.Service, f func(b *directory.Building) error) error {
-	bc := srv.Resources.Buildings.List("my_customer").Context(ctx)
+// ErrSyncTokenExpired is returned by SyncEvents if the sync token is no longer
+// valid, after which a full sync is needed.
+var ErrSyncTokenExpired = errors.New("sync token expired")
+
+// SyncEvents calls f for each event in the calendar changed since the list
+// that returned syncToken, including cancelled events, and returns the token
+// for the next sync. Without a syncToken, f is called for all the calendar's
+// events.
+func SyncEvents(ctx context.Context, srv *calendar.Service, calendarId, syncToken string, f func(*calendar.Event) error) (string, error) {
+	ec := srv.Events.List(calendarId).
+		Context(ctx).
+		ShowDeleted(true).SingleEvents(true).
+		MaxResults(2500)
+	if syncToken != "" {
+		ec = ec.SyncToken(syncToken)
+	}
+	var next string
+	err := ec.Pages(ctx, func(events *calendar.Events) error {
+		for _, item := range events.Items {
+			if err := f(item); err != nil {
+				return err
+			}
+		}
+		next = events.NextSyncToken
+		return nil
+	})
+	var gerr *googleapi.Error
+	if errors.As(err, &gerr) && gerr.Code == http.StatusGone {
+		return "", ErrSyncTokenExpired
+	}
+	return next, err
+}
+
+// DefaultCustomer refers to the customer of the authenticated user in
+// Directory API calls.
+const DefaultCustomer = "my_customer"
+
+func ForEachBuilding(ctx context.Context, srv *directory.Service, customer string, f func(b *directory.Building) error) error {
+	bc := srv.Resources.Buildings.List(customer).Context(ctx)
 	return bc.Pages(ctx, func(buildings *directory.Buildings) error {
 		for _, b := range buildings.Buildings {
 			if err := f(b); err != nil {
@@ -38,12 +88,13 @@ func ForEachBuilding(ctx context.Context, srv *directory.Service, f func(b *dire
 	})
 }
 
-func ForEachResourceInBuilding(ctx context.Context, srv *directory.Service, buildingId string, f func(r *directory.CalendarResource) error) error {
-	qstr := "resourceCategory=CONFERENCE_ROOM"
+// ForEachResourceInBuilding calls f for each resource of any category in the
+// building, or in all buildings if buildingId is empty.
+func ForEachResourceInBuilding(ctx context.Context, srv *directory.Service, customer, buildingId string, f func(r *directory.CalendarResource) error) error {
+	rc := srv.Resources.Calendars.List(customer).Context(ctx)
 	if buildingId != "" {
-		qstr = fmt.Sprintf("buildingId=%s AND %s", buildingId, qstr)
+		rc = rc.Query(fmt.Sprintf("buildingId=%s", buildingId))
 	}
-	rc := srv.Resources.Calendars.List("my_customer").Context(ctx).Query(qstr)
 	return rc.Pages(ctx, func(calendars *directory.CalendarResources) error {
 		for _, c := range calendars.Items {
 			if err := f(c); err != nil {
@@ -0,0 +1,57 @@
+package itercal
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/vsekhar/gocal/internal/fakegoogle"
+	"google.golang.org/api/calendar/v3"
+)
+
+func TestSyncEvents(t *testing.T) {
+	fake := fakegoogle.NewServer("user@example.com")
+	defer fake.Close()
+	ctx := context.Background()
+	_, calSrv, err := fake.Services(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	add := func(summary string) {
+		start := time.Now().Add(time.Hour).Truncate(time.Hour)
+		fake.AddEvent("primary", &calendar.Event{
+			Summary: summary,
+			Start:   &calendar.EventDateTime{DateTime: start.Format(time.RFC3339)},
+			End:     &calendar.EventDateTime{DateTime: start.Add(time.Hour).Format(time.RFC3339)},
+		})
+	}
+	sync := func(token string) ([]string, string) {
+		var got []string
+		next, err := SyncEvents(ctx, calSrv, "primary", token, func(e *calendar.Event) error {
+			got = append(got, e.Summary)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if next == "" {
+			t.Fatal("no sync token")
+		}
+		return got, next
+	}
+
+	add("Old")
+	got, token := sync("")
+	if len(got) != 1 || got[0] != "Old" {
+		t.Errorf("full sync got %v, want [Old]", got)
+	}
+	add("New")
+	if got, _ = sync(token); len(got) != 1 || got[0] != "New" {
+		t.Errorf("incremental sync got %v, want [New]", got)
+	}
+	_, err = SyncEvents(ctx, calSrv, "primary", "sync-999", func(*calendar.Event) error { return nil })
+	if !errors.Is(err, ErrSyncTokenExpired) {
+		t.Errorf("got %v for an invalid token, want ErrSyncTokenExpired", err)
+	}
+}
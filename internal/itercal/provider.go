@@ -0,0 +1,67 @@
+package itercal
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+// CalendarProvider is a calendar backend: a source of events and of rooms'
+// busy times, and a place to book rooms. Google Calendar is provided by
+// Google; others, e.g. CalDAV or Microsoft Graph, can implement it by
+// translating to and from the Calendar API's types, which serve as gocal's
+// model of events.
+type CalendarProvider interface {
+	// ListEvents calls f for each event in the calendar between start and
+	// end, expanding recurring events into their instances, ordered by
+	// start time. Times are formatted, and event times are returned, in loc.
+	ListEvents(ctx context.Context, calendarId string, start, end time.Time, loc *time.Location, f func(*calendar.Event) error) error
+
+	// FreeBusy returns the busy times of the calendars identified by ids
+	// between start and end, in loc. Calendars that are not found are
+	// omitted from the result.
+	FreeBusy(ctx context.Context, ids []string, start, end time.Time, loc *time.Location) (map[string]calendar.FreeBusyCalendar, error)
+
+	// PatchEvent updates the fields of the event in the calendar that are
+	// set in patch, without notifying attendees, and returns the event.
+	PatchEvent(ctx context.Context, calendarId, eventId string, patch *calendar.Event) (*calendar.Event, error)
+
+	// InsertEvent creates event in the calendar, without notifying
+	// attendees, and returns it as created.
+	InsertEvent(ctx context.Context, calendarId string, event *calendar.Event) (*calendar.Event, error)
+}
+
+// Google is the CalendarProvider of Google Calendar.
+type Google struct {
+	srv *calendar.Service
+}
+
+var _ CalendarProvider = (*Google)(nil)
+
+// NewGoogle returns the CalendarProvider using srv.
+func NewGoogle(srv *calendar.Service) *Google {
+	return &Google{srv: srv}
+}
+
+// Service returns the Calendar service of g, for features of Google Calendar
+// beyond CalendarProvider.
+func (g *Google) Service() *calendar.Service {
+	return g.srv
+}
+
+func (g *Google) PatchEvent(ctx context.Context, calendarId, eventId string, patch *calendar.Event) (*calendar.Event, error) {
+	c := g.srv.Events.Patch(calendarId, eventId, patch).SendUpdates("none").Context(ctx)
+	if len(patch.Attachments) > 0 {
+		c = c.SupportsAttachments(true)
+	}
+	return c.Do()
+}
+
+func (g *Google) InsertEvent(ctx context.Context, calendarId string, event *calendar.Event) (*calendar.Event, error) {
+	c := g.srv.Events.Insert(calendarId, event).SendUpdates("none").Context(ctx)
+	if len(event.Attachments) > 0 {
+		c = c.SupportsAttachments(true)
+	}
+	return c.Do()
+}
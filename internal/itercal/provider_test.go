@@ -0,0 +1,52 @@
+package itercal
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/vsekhar/gocal/internal/fakegoogle"
+	"google.golang.org/api/calendar/v3"
+)
+
+func TestGoogle(t *testing.T) {
+	fake := fakegoogle.NewServer("user@example.com")
+	defer fake.Close()
+	ctx := context.Background()
+	_, calSrv, err := fake.Services(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var p CalendarProvider = NewGoogle(calSrv)
+
+	start := time.Now().Add(time.Hour).Truncate(time.Hour)
+	created, err := p.InsertEvent(ctx, "room@example.com", &calendar.Event{
+		Summary: "Hold",
+		Start:   &calendar.EventDateTime{DateTime: start.Format(time.RFC3339)},
+		End:     &calendar.EventDateTime{DateTime: start.Add(time.Hour).Format(time.RFC3339)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.PatchEvent(ctx, "room@example.com", created.Id, &calendar.Event{Summary: "Renamed"}); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	err = p.ListEvents(ctx, "room@example.com", start.Add(-time.Hour), start.Add(2*time.Hour), time.UTC, func(e *calendar.Event) error {
+		got = append(got, e.Summary)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0] != "Renamed" {
+		t.Errorf("listed %v, want [Renamed]", got)
+	}
+	fb, err := p.FreeBusy(ctx, []string{"room@example.com"}, start.Add(-time.Hour), start.Add(2*time.Hour), time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if busy := fb["room@example.com"].Busy; len(busy) != 1 {
+		t.Errorf("got busy times %v, want the event's", busy)
+	}
+}
@@ -0,0 +1,75 @@
+package itercal
+
+import (
+	"encoding/json"
+	"fmt"
+
+	directory "google.golang.org/api/admin/directory/v1"
+)
+
+// Resource is a calendar resource. Fields are named as in the Directory API,
+// but are parsed into Go types once, when the resource is fetched, rather than
+// by each user.
+type Resource struct {
+	ResourceEmail         string `json:"resourceEmail"`
+	ResourceName          string `json:"resourceName"`
+	GeneratedResourceName string `json:"generatedResourceName"`
+	ResourceCategory      string `json:"resourceCategory"`
+	BuildingId            string `json:"buildingId"`
+	FloorName             string `json:"floorName"`
+	FloorSection          string `json:"floorSection"`
+	Capacity              int64  `json:"capacity"`
+
+	// Description is the description shown to users, e.g. in Calendar's room
+	// finder.
+	Description string `json:"description,omitempty"`
+
+	// Features are the names of the resource's features, e.g.
+	// "Wheelchair accessible".
+	Features []string `json:"features,omitempty"`
+}
+
+// NewResource returns the Resource described by r.
+func NewResource(r *directory.CalendarResource) (*Resource, error) {
+	features, err := featureNames(r.FeatureInstances)
+	if err != nil {
+		return nil, fmt.Errorf("parsing features of %s: %v", r.ResourceEmail, err)
+	}
+	return &Resource{
+		ResourceEmail:         r.ResourceEmail,
+		ResourceName:          r.ResourceName,
+		GeneratedResourceName: r.GeneratedResourceName,
+		ResourceCategory:      r.ResourceCategory,
+		BuildingId:            r.BuildingId,
+		FloorName:             r.FloorName,
+		FloorSection:          r.FloorSection,
+		Capacity:              r.Capacity,
+		Description:           r.UserVisibleDescription,
+		Features:              features,
+	}, nil
+}
+
+// featureNames returns the names of the features in instances, which is
+// untyped in the API client.
+func featureNames(instances interface{}) ([]string, error) {
+	if instances == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(instances)
+	if err != nil {
+		return nil, err
+	}
+	var parsed []struct {
+		Feature struct {
+			Name string `json:"name"`
+		} `json:"feature"`
+	}
+	if err := json.Unmarshal(b, &parsed); err != nil {
+		return nil, err
+	}
+	var ret []string
+	for _, fi := range parsed {
+		ret = append(ret, fi.Feature.Name)
+	}
+	return ret, nil
+}
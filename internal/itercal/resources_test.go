@@ -0,0 +1,39 @@
+package itercal
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	directory "google.golang.org/api/admin/directory/v1"
+)
+
+func TestNewResource(t *testing.T) {
+	// FeatureInstances is decoded from the API response as untyped JSON.
+	var api directory.CalendarResource
+	err := json.Unmarshal([]byte(`{
+		"resourceEmail": "room@resource.example.com",
+		"userVisibleDescription": "By the lifts",
+		"capacity": 6,
+		"featureInstances": [
+			{"feature": {"name": "Whiteboard"}},
+			{"feature": {"name": "Wheelchair accessible"}}
+		]
+	}`), &api)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewResource(&api)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Resource{
+		ResourceEmail: "room@resource.example.com",
+		Capacity:      6,
+		Description:   "By the lifts",
+		Features:      []string{"Whiteboard", "Wheelchair accessible"},
+	}
+	if !reflect.DeepEqual(r, want) {
+		t.Errorf("got %+v, want %+v", r, want)
+	}
+}
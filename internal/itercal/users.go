@@ -0,0 +1,31 @@
+package itercal
+
+import (
+	"context"
+	"encoding/json"
+
+	directory "google.golang.org/api/admin/directory/v1"
+)
+
+// UserLocations returns the locations listed in the Directory profile of the
+// user identified by userKey (e.g. their primary email address).
+func UserLocations(ctx context.Context, srv *directory.Service, userKey string) ([]*directory.UserLocation, error) {
+	u, err := srv.Users.Get(userKey).Context(ctx).ViewType("domain_public").Do()
+	if err != nil {
+		return nil, err
+	}
+	if u.Locations == nil {
+		return nil, nil
+	}
+
+	// Locations is untyped in the API client, so round-trip it through JSON.
+	b, err := json.Marshal(u.Locations)
+	if err != nil {
+		return nil, err
+	}
+	var ret []*directory.UserLocation
+	if err := json.Unmarshal(b, &ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
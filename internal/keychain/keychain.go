@@ -0,0 +1,82 @@
+// Package keychain stores secrets in the operating system's keychain using
+// its command line tools: security(1) on macOS and secret-tool(1) (libsecret)
+// on Linux.
+package keychain
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// ErrNotFound is returned by Get if no secret is stored.
+var ErrNotFound = errors.New("keychain: secret not found")
+
+// Get returns the secret stored for service and account.
+func Get(service, account string) (string, error) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("security", "find-generic-password", "-s", service, "-a", account, "-w")
+	case "linux":
+		cmd = exec.Command("secret-tool", "lookup", "service", service, "account", account)
+	default:
+		return "", fmt.Errorf("keychain: unsupported on %s", runtime.GOOS)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		// security exits with errSecItemNotFound (44) if the secret isn't
+		// found, and secret-tool with 1 and no output.
+		switch {
+		case runtime.GOOS == "darwin" && exitErr.ExitCode() == 44,
+			runtime.GOOS == "linux" && exitErr.ExitCode() == 1 && len(out) == 0:
+			return "", ErrNotFound
+		}
+	}
+	if err != nil {
+		return "", fmt.Errorf("keychain: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimRight(string(out), "\n"), nil
+}
+
+// Set stores secret for service and account, replacing any existing secret.
+// The secret is written to the tool's standard input rather than passed as an
+// argument, which other users could see, e.g. with ps(1).
+func Set(service, account, secret string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		// Interactive mode reads the command, secret included, from stdin.
+		cmd = exec.Command("security", "-i")
+		cmd.Stdin = strings.NewReader(fmt.Sprintf("add-generic-password -U -s %s -a %s -w %s\n",
+			securityQuote(service), securityQuote(account), securityQuote(secret)))
+	case "linux":
+		cmd = exec.Command("secret-tool", "store", "--label", service+" ("+account+")", "service", service, "account", account)
+		cmd.Stdin = strings.NewReader(secret)
+	default:
+		return fmt.Errorf("keychain: unsupported on %s", runtime.GOOS)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err == nil && runtime.GOOS == "darwin" && stderr.Len() > 0 {
+		// Interactive mode reports failed commands without failing itself.
+		err = errors.New("add-generic-password failed")
+	}
+	if err != nil {
+		return fmt.Errorf("keychain: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// securityQuote quotes s as an argument in security(1)'s interactive mode.
+func securityQuote(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+	return `"` + r.Replace(s) + `"`
+}
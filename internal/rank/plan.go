@@ -0,0 +1,219 @@
+package rank
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	// backToBackGap is the longest gap between meetings considered to be
+	// back-to-back.
+	backToBackGap = 5 * time.Minute
+
+	// backToBackFactor scales the distance between the rooms of back-to-back
+	// meetings, which leave no time to walk.
+	backToBackFactor = 2
+
+	// chainHorizon is the gap between meetings beyond which the distance
+	// between their rooms doesn't matter.
+	chainHorizon = 3 * time.Hour
+)
+
+// proximityFactor returns the factor by which to scale the distance between
+// the rooms of consecutive meetings separated by gap.
+func proximityFactor(gap time.Duration) float64 {
+	if gap <= backToBackGap {
+		return backToBackFactor
+	}
+	if gap >= chainHorizon {
+		return 0
+	}
+	return 1 - float64(gap-backToBackGap)/float64(chainHorizon-backToBackGap)
+}
+
+// tooSmallPenalty is added to the cost of assigning a room that is too small,
+// so that such rooms are only planned if nothing else is available.
+const tooSmallPenalty = 1e9
+
+// Slot is an event in a day's sequence of events.
+type Slot struct {
+	Request    Request
+	Start, End time.Time
+
+	// Fixed, if non-negative, is the index of the room already booked for the
+	// slot.
+	Fixed int
+
+	// Available returns true if the room at index i can be booked for the
+	// slot. Available is not called for slots with a Fixed room.
+	Available func(i int) bool
+
+	// Weights, if non-nil, replaces the weights given to Plan for scoring
+	// rooms for the slot and the walk to them.
+	Weights *Weights
+
+	// Priority decides which of two overlapping slots gets a room both
+	// could have: the higher priority, then the one with more attendees,
+	// then the earlier one.
+	Priority int
+}
+
+// outranks returns true if s gets a room in preference to o.
+func (s Slot) outranks(o Slot) bool {
+	if s.Priority != o.Priority {
+		return s.Priority > o.Priority
+	}
+	return s.Request.Attendees > o.Request.Attendees
+}
+
+// weights returns the weights for s given the weights w for the plan.
+func (s Slot) weights(w Weights) Weights {
+	if s.Weights != nil {
+		return *s.Weights
+	}
+	return w
+}
+
+// Plan assigns rooms to a day's slots, which must be ordered by start time.
+//
+// Plan minimizes the total score of each room for its slot plus the weighted
+// distance between the rooms of consecutive slots, so that each assignment
+// accounts for the whole day's sequence rather than only its immediate
+// neighbors. Distances between rooms are weighted more heavily for
+// back-to-back meetings and not at all for meetings far apart in time.
+// Back-to-back meetings in the same room earn an additional bonus.
+//
+// Plan returns the index of the room assigned to each slot, or -1 if no room is
+// available for the slot. Slots without a room are skipped when computing
+// distances between consecutive slots. Overlapping slots are not assigned the
+// same room, unless both have it Fixed; of two such slots, the one that
+// outranks the other keeps the room.
+func Plan(w Weights, slots []Slot, rooms []Room) []int {
+	slots = append([]Slot(nil), slots...)
+	for {
+		ret := plan(w, slots, rooms)
+		i, r := conflict(slots, ret)
+		if i < 0 {
+			return ret
+		}
+		// Plan again without the room for one of the conflicting slots. Each
+		// iteration removes a candidate, so this terminates.
+		avail := slots[i].Available
+		slots[i].Available = func(k int) bool { return k != r && avail(k) }
+	}
+}
+
+// conflict returns the index of a slot that isn't Fixed and is assigned the
+// same room r as an overlapping slot, or -1 if there is none. If neither slot
+// is Fixed, it returns the one outranked by the other.
+func conflict(slots []Slot, assigned []int) (i, r int) {
+	for i := range slots {
+		for j := 0; j < i; j++ {
+			if assigned[i] < 0 || assigned[i] != assigned[j] {
+				continue
+			}
+			if !slots[j].Start.Before(slots[i].End) || !slots[i].Start.Before(slots[j].End) {
+				continue
+			}
+			switch {
+			case slots[i].Fixed < 0 && slots[j].Fixed < 0 && slots[i].outranks(slots[j]):
+				return j, assigned[j]
+			case slots[i].Fixed < 0:
+				return i, assigned[i]
+			case slots[j].Fixed < 0:
+				return j, assigned[j]
+			}
+		}
+	}
+	return -1, -1
+}
+
+// plan implements Plan without regard to overlapping slots.
+func plan(w Weights, slots []Slot, rooms []Room) []int {
+	ret := make([]int, len(slots))
+
+	// Candidate rooms for each slot, and the cost of each.
+	type candidate struct {
+		room int
+		cost float64
+	}
+	var layers [][]candidate
+	var layerSlots []int
+	for i, s := range slots {
+		var cs []candidate
+		cost := func(r int) float64 {
+			sc := ScoreRoom(s.weights(w), s.Request, rooms[r])
+			if sc.TooSmall {
+				return sc.Total + tooSmallPenalty
+			}
+			return sc.Total
+		}
+		if s.Fixed >= 0 {
+			cs = append(cs, candidate{s.Fixed, 0})
+		} else {
+			for r := range rooms {
+				if s.Available(r) {
+					cs = append(cs, candidate{r, cost(r)})
+				}
+			}
+		}
+		if len(cs) == 0 {
+			ret[i] = -1
+			continue
+		}
+		layers = append(layers, cs)
+		layerSlots = append(layerSlots, i)
+	}
+	if len(layers) == 0 {
+		return ret
+	}
+
+	// Find the cheapest path through the layers (Viterbi).
+	total := make([][]float64, len(layers))
+	back := make([][]int, len(layers))
+	total[0] = make([]float64, len(layers[0]))
+	for j, c := range layers[0] {
+		total[0][j] = c.cost
+	}
+	for l := 1; l < len(layers); l++ {
+		total[l] = make([]float64, len(layers[l]))
+		back[l] = make([]int, len(layers[l]))
+		gap := slots[layerSlots[l]].Start.Sub(slots[layerSlots[l-1]].End)
+		sw := slots[layerSlots[l]].weights(w)
+		dw := sw.Distance * proximityFactor(gap)
+		req := slots[layerSlots[l]].Request
+		var sameRoomBonus float64
+		if gap <= backToBackGap {
+			sameRoomBonus = sw.SameRoom
+		}
+		for j, c := range layers[l] {
+			best, bestK := math.Inf(1), 0
+			for k, p := range layers[l-1] {
+				t := total[l-1][k] + dw*float64(req.distance(rooms[p.room].Location, rooms[c.room].Location))
+				if p.room == c.room {
+					t -= sameRoomBonus
+				}
+				if t < best {
+					best, bestK = t, k
+				}
+			}
+			total[l][j] = best + c.cost
+			back[l][j] = bestK
+		}
+	}
+
+	last := len(layers) - 1
+	j := 0
+	for k := range layers[last] {
+		if total[last][k] < total[last][j] {
+			j = k
+		}
+	}
+	for l := last; l >= 0; l-- {
+		ret[layerSlots[l]] = layers[l][j].room
+		if l > 0 {
+			j = back[l][j]
+		}
+	}
+	return ret
+}
@@ -0,0 +1,291 @@
+// Package rank scores and orders candidate rooms for an event.
+package rank
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Location is a position within a building.
+type Location struct {
+	Floor, Section int
+}
+
+// ParseLocation parses a location of the form "floor/section", e.g. "3/2".
+func ParseLocation(s string) (Location, error) {
+	f, sec, ok := strings.Cut(s, "/")
+	if !ok {
+		return Location{}, fmt.Errorf("location '%s' is not of the form floor/section", s)
+	}
+	var l Location
+	var err error
+	if l.Floor, err = strconv.Atoi(f); err != nil {
+		return Location{}, fmt.Errorf("location '%s': %v", s, err)
+	}
+	if l.Section, err = strconv.Atoi(sec); err != nil {
+		return Location{}, fmt.Errorf("location '%s': %v", s, err)
+	}
+	return l, nil
+}
+
+// Distance returns the approximate walking distance in meters between two
+// locations.
+func Distance(l1, l2 Location) int {
+	const (
+		subsequentChangeOfSection = 5
+		firstChangeOfSection      = 5
+
+		subsequentChangeOfFloor = 10
+		firstChangeOfFloor      = firstChangeOfSection + subsequentChangeOfFloor
+	)
+
+	distance := 0
+	if l1.Floor != l2.Floor {
+		distance += firstChangeOfFloor
+		distance += (abs(l1.Floor-l2.Floor) - 1) * subsequentChangeOfFloor
+	}
+	if l1.Section != l2.Section {
+		distance += firstChangeOfSection
+		distance += (abs(l1.Section-l2.Section) - 1) * subsequentChangeOfSection
+	}
+	return distance
+}
+
+// ElevatorDistance is like Distance but for someone who takes the elevator
+// between floors. Changing floors costs a roughly fixed wait regardless of
+// how many floors apart the locations are.
+func ElevatorDistance(l1, l2 Location) int {
+	const (
+		subsequentChangeOfSection = 5
+		firstChangeOfSection      = 5
+
+		elevatorWait            = 30
+		subsequentChangeOfFloor = 2
+		firstChangeOfFloor      = firstChangeOfSection + elevatorWait
+	)
+
+	distance := 0
+	if l1.Floor != l2.Floor {
+		distance += firstChangeOfFloor
+		distance += (abs(l1.Floor-l2.Floor) - 1) * subsequentChangeOfFloor
+	}
+	if l1.Section != l2.Section {
+		distance += firstChangeOfSection
+		distance += (abs(l1.Section-l2.Section) - 1) * subsequentChangeOfSection
+	}
+	return distance
+}
+
+// Room is a candidate room.
+type Room struct {
+	Email    string
+	Location Location
+	Capacity int64
+
+	// Features are the names of the features of the room.
+	Features []string
+
+	// Popularity is a measure of how often the room is booked, e.g. the total
+	// time it is busy.
+	Popularity float64
+
+	// GuestAccessible is true if visitors can reach the room, e.g. it is near
+	// reception.
+	GuestAccessible bool
+}
+
+// Request describes the event for which rooms are being ranked.
+type Request struct {
+	Attendees int64
+
+	// Near are the locations the room should be close to (e.g. the rooms of
+	// adjacent meetings or the user's preferred location). If Near is empty,
+	// distance is not considered.
+	Near []Location
+
+	// Features are the names of features the room should have.
+	Features []string
+
+	// Floor, if non-zero, is the preferred floor.
+	Floor int
+
+	// Anchor, if non-nil, is a location the room should be close to
+	// independent of Near, e.g. the entrance for the first meeting of the day.
+	Anchor *Location
+
+	// SameRoom, if non-empty, is the email of a room to prefer for continuity,
+	// e.g. the room previously booked for a recurring series.
+	SameRoom string
+
+	// Guests is true if the event has attendees from outside the
+	// organization, who should be met in a guest-accessible room.
+	Guests bool
+
+	// Elevator is true if attendees take the elevator rather than the stairs
+	// between floors, so distances are measured with ElevatorDistance.
+	Elevator bool
+}
+
+func (req Request) distance(l1, l2 Location) int {
+	if req.Elevator {
+		return ElevatorDistance(l1, l2)
+	}
+	return Distance(l1, l2)
+}
+
+// Score is the score of a room for a request. Lower scores are better.
+type Score struct {
+	// Distance is the distance in meters to the nearest location in
+	// Request.Near.
+	Distance int `json:"distance"`
+
+	// TooSmall is true if the room cannot seat all attendees. Rooms that are
+	// too small are ranked after all others.
+	TooSmall bool `json:"tooSmall"`
+
+	// CapacityPenalty is the number of unused seats.
+	CapacityPenalty int64 `json:"capacityPenalty"`
+
+	// MissingFeatures is the number of requested features the room lacks.
+	MissingFeatures int `json:"missingFeatures"`
+
+	// Floors is the number of floors between the room and Request.Floor.
+	Floors int `json:"floors"`
+
+	// AnchorDistance is the distance in meters to Request.Anchor.
+	AnchorDistance int `json:"anchorDistance"`
+
+	// SameRoom is true if the room is Request.SameRoom.
+	SameRoom bool `json:"sameRoom"`
+
+	// Popularity is copied from Room.Popularity. Higher is better.
+	Popularity float64 `json:"popularity"`
+
+	// GuestInaccessible is true if Request.Guests is set and the room is not
+	// guest-accessible.
+	GuestInaccessible bool `json:"guestInaccessible"`
+
+	// Total is the weighted combination of the above components.
+	Total float64 `json:"total"`
+}
+
+func (s Score) String() string {
+	capacity := fmt.Sprintf("%d", s.CapacityPenalty)
+	if s.TooSmall {
+		capacity = "too small"
+	}
+	return fmt.Sprintf("total %.1f: distance %dm, capacity penalty %s, missing features %d, floors %d, anchor distance %dm, same room %t, popularity %.1f, guest inaccessible %t",
+		s.Total, s.Distance, capacity, s.MissingFeatures, s.Floors, s.AnchorDistance, s.SameRoom, s.Popularity, s.GuestInaccessible)
+}
+
+// Less returns true if s is a better score than t.
+func (s Score) Less(t Score) bool {
+	if s.TooSmall != t.TooSmall {
+		return t.TooSmall
+	}
+	return s.Total < t.Total
+}
+
+// ScoreRoom returns the score of room r for request req, combining components
+// using w.
+func ScoreRoom(w Weights, req Request, r Room) Score {
+	s := Score{Popularity: r.Popularity}
+	if len(req.Near) > 0 {
+		s.Distance = math.MaxInt
+		for _, l := range req.Near {
+			if d := req.distance(l, r.Location); d < s.Distance {
+				s.Distance = d
+			}
+		}
+	}
+	if r.Capacity < req.Attendees {
+		s.TooSmall = true
+	} else {
+		s.CapacityPenalty = r.Capacity - req.Attendees
+	}
+	for _, want := range req.Features {
+		if !HasFeature(r.Features, want) {
+			s.MissingFeatures++
+		}
+	}
+	if req.Floor != 0 {
+		s.Floors = abs(r.Location.Floor - req.Floor)
+	}
+	if req.Anchor != nil {
+		s.AnchorDistance = req.distance(*req.Anchor, r.Location)
+	}
+	s.SameRoom = req.SameRoom != "" && req.SameRoom == r.Email
+	s.GuestInaccessible = req.Guests && !r.GuestAccessible
+	s.Total = w.Distance*float64(s.Distance) +
+		w.Capacity*float64(s.CapacityPenalty) +
+		w.Features*float64(s.MissingFeatures) +
+		w.Floor*float64(s.Floors) +
+		w.Anchor*float64(s.AnchorDistance) -
+		w.Popularity*s.Popularity
+	if s.SameRoom {
+		s.Total -= w.SameRoom
+	}
+	if s.GuestInaccessible {
+		s.Total += w.Guest
+	}
+	return s
+}
+
+// Rank returns the indexes of rooms ordered from best to worst for req, along
+// with the score of each room (indexed as rooms). Rooms with equal scores are
+// ordered by email.
+func Rank(w Weights, req Request, rooms []Room) (order []int, scores []Score) {
+	scores = make([]Score, len(rooms))
+	order = make([]int, len(rooms))
+	for i, r := range rooms {
+		scores[i] = ScoreRoom(w, req, r)
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool {
+		si, sj := scores[order[i]], scores[order[j]]
+		if si.Less(sj) {
+			return true
+		}
+		if sj.Less(si) {
+			return false
+		}
+		return rooms[order[i]].Email < rooms[order[j]].Email
+	})
+	return order, scores
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// HasFeature returns true if one of features, the names of a room's features,
+// is want: its name, a word of it, or its initials, ignoring case. For
+// example, "Video conference" is wanted as "video conference", "video" or
+// "vc".
+func HasFeature(features []string, want string) bool {
+	for _, f := range features {
+		if strings.EqualFold(f, want) {
+			return true
+		}
+		words := strings.Fields(f)
+		initials := ""
+		for _, w := range words {
+			if strings.EqualFold(w, want) {
+				return true
+			}
+			r, _ := utf8.DecodeRuneInString(w)
+			initials += string(r)
+		}
+		if len(words) > 1 && strings.EqualFold(initials, want) {
+			return true
+		}
+	}
+	return false
+}
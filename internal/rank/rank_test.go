@@ -0,0 +1,352 @@
+package rank_test
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/vsekhar/gocal/internal/rank"
+)
+
+func TestDistance(t *testing.T) {
+	cases := []struct {
+		l1, l2 rank.Location
+		want   int
+	}{
+		{rank.Location{1, 1}, rank.Location{1, 1}, 0},
+		{rank.Location{1, 1}, rank.Location{1, 2}, 5},
+		{rank.Location{1, 1}, rank.Location{1, 4}, 15},
+		{rank.Location{1, 1}, rank.Location{2, 1}, 15},
+		{rank.Location{3, 1}, rank.Location{1, 2}, 30},
+	}
+	for _, c := range cases {
+		if got := rank.Distance(c.l1, c.l2); got != c.want {
+			t.Errorf("Distance(%v, %v) = %d, want %d", c.l1, c.l2, got, c.want)
+		}
+		if got := rank.Distance(c.l2, c.l1); got != c.want {
+			t.Errorf("Distance(%v, %v) = %d, want %d", c.l2, c.l1, got, c.want)
+		}
+	}
+}
+
+func TestElevatorDistance(t *testing.T) {
+	cases := []struct {
+		l1, l2 rank.Location
+		want   int
+	}{
+		{rank.Location{1, 1}, rank.Location{1, 1}, 0},
+		{rank.Location{1, 1}, rank.Location{1, 4}, 15},
+		{rank.Location{1, 1}, rank.Location{2, 1}, 35},
+		{rank.Location{1, 1}, rank.Location{6, 1}, 43},
+	}
+	for _, c := range cases {
+		if got := rank.ElevatorDistance(c.l1, c.l2); got != c.want {
+			t.Errorf("ElevatorDistance(%v, %v) = %d, want %d", c.l1, c.l2, got, c.want)
+		}
+	}
+}
+
+func TestRank(t *testing.T) {
+	rooms := []rank.Room{
+		{Email: "far", Location: rank.Location{5, 1}, Capacity: 4, GuestAccessible: true},
+		{Email: "small", Location: rank.Location{1, 1}, Capacity: 2},
+		{Email: "big", Location: rank.Location{1, 1}, Capacity: 20},
+		{Email: "fit", Location: rank.Location{1, 1}, Capacity: 4},
+		{Email: "popular", Location: rank.Location{1, 2}, Capacity: 4, Popularity: 10},
+		{Email: "unpopular", Location: rank.Location{1, 2}, Capacity: 4},
+	}
+	check := func(req rank.Request, want []string) {
+		t.Helper()
+		order, _ := rank.Rank(rank.Presets[rank.DefaultPreset], req, rooms)
+		for i, idx := range order {
+			if rooms[idx].Email != want[i] {
+				var got []string
+				for _, idx := range order {
+					got = append(got, rooms[idx].Email)
+				}
+				t.Fatalf("got %v, want %v", got, want)
+			}
+		}
+	}
+
+	check(rank.Request{Attendees: 3, Near: []rank.Location{{1, 1}}},
+		[]string{"fit", "big", "popular", "unpopular", "far", "small"})
+	check(rank.Request{Attendees: 3, Near: []rank.Location{{5, 1}, {1, 2}}},
+		[]string{"popular", "far", "unpopular", "fit", "big", "small"})
+	check(rank.Request{Attendees: 3},
+		[]string{"popular", "far", "fit", "unpopular", "big", "small"})
+
+	// Guests outweigh a short walk.
+	check(rank.Request{Attendees: 3, Near: []rank.Location{{2, 1}}},
+		[]string{"fit", "big", "popular", "unpopular", "far", "small"})
+	check(rank.Request{Attendees: 3, Near: []rank.Location{{2, 1}}, Guests: true},
+		[]string{"far", "fit", "big", "popular", "unpopular", "small"})
+
+	// Many floors by stairs are further than many sections, but not by
+	// elevator.
+	high := rank.Room{Email: "high", Location: rank.Location{6, 1}, Capacity: 4}
+	across := rank.Room{Email: "across", Location: rank.Location{1, 10}, Capacity: 4}
+	for _, elevator := range []bool{false, true} {
+		req := rank.Request{Attendees: 3, Near: []rank.Location{{1, 1}}, Elevator: elevator}
+		order, _ := rank.Rank(rank.Presets[rank.DefaultPreset], req, []rank.Room{high, across})
+		if got := order[0] == 0; got != elevator {
+			t.Errorf("with elevator %t, high room ranked first: %t", elevator, got)
+		}
+	}
+}
+
+func TestParseWeights(t *testing.T) {
+	base := rank.Presets["balanced"]
+	w, err := rank.ParseWeights("distance=2, floor=0", base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := base
+	want.Distance = 2
+	want.Floor = 0
+	if w != want {
+		t.Errorf("got %+v, want %+v", w, want)
+	}
+	for _, s := range []string{"distance", "distance=x", "height=1"} {
+		if _, err := rank.ParseWeights(s, base); err == nil {
+			t.Errorf("expected error parsing '%s'", s)
+		}
+	}
+}
+
+func TestHasFeature(t *testing.T) {
+	features := []string{"Video conference", "Jamboard", "Wheelchair accessible"}
+	for want, ok := range map[string]bool{
+		"video conference": true,
+		"vc":               true,
+		"jamboard":         true,
+		"wheelchair":       true,
+		"wa":               true,
+		"j":                false,
+		"whiteboard":       false,
+		"conference room":  false,
+	} {
+		if got := rank.HasFeature(features, want); got != ok {
+			t.Errorf("HasFeature(%q) = %t, want %t", want, got, ok)
+		}
+	}
+}
+
+func TestPlan(t *testing.T) {
+	rooms := []rank.Room{
+		{Email: "a", Location: rank.Location{1, 1}, Capacity: 4},
+		{Email: "b", Location: rank.Location{1, 2}, Capacity: 4},
+		{Email: "c", Location: rank.Location{5, 1}, Capacity: 4},
+		{Email: "d", Location: rank.Location{5, 2}, Capacity: 4},
+	}
+	all := func(int) bool { return true }
+	none := func(int) bool { return false }
+	start := time.Date(2022, 4, 1, 9, 0, 0, 0, time.UTC)
+	slot := func(h int, fixed int, avail func(int) bool) rank.Slot {
+		return rank.Slot{
+			Request:   rank.Request{Attendees: 2},
+			Start:     start.Add(time.Duration(h) * time.Hour),
+			End:       start.Add(time.Duration(h)*time.Hour + 30*time.Minute),
+			Fixed:     fixed,
+			Available: avail,
+		}
+	}
+
+	// The unassigned slots should stay on floor 5 rather than jumping to
+	// floor 1 and back.
+	slots := []rank.Slot{
+		slot(0, 3, nil),
+		slot(1, -1, func(r int) bool { return r != 3 }),
+		slot(2, -1, none),
+		slot(3, -1, all),
+		slot(4, 3, nil),
+	}
+	got := rank.Plan(rank.Presets[rank.DefaultPreset], slots, rooms)
+	want := []int{3, 2, -1, 3, 3}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+
+	// Meetings far apart in time aren't chained, so the unassigned slot
+	// should be near the preferred location instead.
+	slots = []rank.Slot{
+		slot(0, 3, nil),
+		slot(4, -1, all),
+	}
+	slots[1].Request.Near = []rank.Location{{1, 2}}
+	got = rank.Plan(rank.Presets[rank.DefaultPreset], slots, rooms)
+	if got[1] != 1 {
+		t.Errorf("got %v, want room 1 for the second slot", got)
+	}
+
+	// A slot's own weights replace the plan's, here ignoring the walk from
+	// the previous meeting in favor of the anchor.
+	slots = []rank.Slot{
+		slot(0, 3, nil),
+		slot(1, -1, all),
+	}
+	slots[1].Request.Anchor = &rank.Location{1, 1}
+	slots[1].Weights = &rank.Weights{Anchor: 1}
+	got = rank.Plan(rank.Presets[rank.DefaultPreset], slots, rooms)
+	if got[1] != 0 {
+		t.Errorf("got %v, want room 0 at the anchor for the second slot", got)
+	}
+
+	// Overlapping slots competing for the last room: the higher priority
+	// wins, then the larger meeting, then the earlier one.
+	onlyA := func(r int) bool { return r == 0 }
+	for _, c := range []struct {
+		priority  [2]int
+		attendees [2]int64
+		winner    int
+	}{
+		{[2]int{0, 0}, [2]int64{2, 2}, 0},
+		{[2]int{0, 1}, [2]int64{2, 2}, 1},
+		{[2]int{0, 0}, [2]int64{2, 3}, 1},
+		{[2]int{1, 0}, [2]int64{2, 3}, 0},
+	} {
+		slots = []rank.Slot{slot(0, -1, onlyA), slot(0, -1, onlyA)}
+		for i := range slots {
+			slots[i].Priority = c.priority[i]
+			slots[i].Request.Attendees = c.attendees[i]
+		}
+		got = rank.Plan(rank.Presets[rank.DefaultPreset], slots, rooms)
+		if got[c.winner] != 0 || got[1-c.winner] != -1 {
+			t.Errorf("priorities %v, attendees %v: got %v, want room 0 for slot %d", c.priority, c.attendees, got, c.winner)
+		}
+	}
+}
+
+// randomFixture returns random rooms and a day of random slots, some of which
+// overlap, have a fixed room, or have unavailable rooms.
+func randomFixture(rng *rand.Rand) ([]rank.Room, []rank.Slot) {
+	rooms := make([]rank.Room, 1+rng.Intn(8))
+	for i := range rooms {
+		rooms[i] = rank.Room{
+			Email:      fmt.Sprintf("room%d", i),
+			Location:   rank.Location{Floor: 1 + rng.Intn(5), Section: 1 + rng.Intn(4)},
+			Capacity:   2 + rng.Int63n(10),
+			Popularity: rng.Float64() * 8,
+		}
+	}
+	slots := make([]rank.Slot, rng.Intn(8))
+	start := time.Date(2022, 4, 1, 8, 0, 0, 0, time.UTC)
+	for i := range slots {
+		start = start.Add(time.Duration(rng.Intn(120)) * time.Minute)
+		busy := make([]bool, len(rooms))
+		for r := range busy {
+			busy[r] = rng.Intn(3) == 0
+		}
+		slots[i] = rank.Slot{
+			Request: rank.Request{
+				Attendees: 1 + rng.Int63n(8),
+				Near:      []rank.Location{{Floor: 1 + rng.Intn(5), Section: 1 + rng.Intn(4)}},
+			},
+			Start:     start,
+			End:       start.Add(time.Duration(15+rng.Intn(120)) * time.Minute),
+			Fixed:     -1,
+			Available: func(r int) bool { return !busy[r] },
+		}
+		if rng.Intn(4) == 0 {
+			slots[i].Fixed = rng.Intn(len(rooms))
+		}
+	}
+	return rooms, slots
+}
+
+func TestPlanProperties(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	w := rank.Presets["balanced"]
+	for n := 0; n < 2000; n++ {
+		rooms, slots := randomFixture(rng)
+		got := rank.Plan(w, slots, rooms)
+		for i, s := range slots {
+			switch {
+			case s.Fixed >= 0 && got[i] != s.Fixed:
+				t.Fatalf("fixture %d: slot %d assigned %d, want fixed room %d", n, i, got[i], s.Fixed)
+			case s.Fixed < 0 && got[i] >= 0 && !s.Available(got[i]):
+				t.Fatalf("fixture %d: slot %d assigned busy room %d", n, i, got[i])
+			}
+			for j := 0; j < i; j++ {
+				overlaps := slots[j].Start.Before(s.End) && s.Start.Before(slots[j].End)
+				bothFixed := s.Fixed >= 0 && slots[j].Fixed >= 0
+				if overlaps && !bothFixed && got[i] >= 0 && got[i] == got[j] {
+					t.Fatalf("fixture %d: overlapping slots %d and %d both assigned room %d", n, j, i, got[i])
+				}
+			}
+		}
+	}
+}
+
+// TestPlanProximityMonotonic checks that the room planned after a fixed
+// meeting is no closer to it when the gap between the meetings grows.
+func TestPlanProximityMonotonic(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	w := rank.Presets["balanced"]
+	all := func(int) bool { return true }
+	for n := 0; n < 500; n++ {
+		rooms, _ := randomFixture(rng)
+		fixed := rng.Intn(len(rooms))
+		req := rank.Request{
+			Attendees: 1 + rng.Int63n(8),
+			Near:      []rank.Location{{Floor: 1 + rng.Intn(5), Section: 1 + rng.Intn(4)}},
+		}
+		start := time.Date(2022, 4, 1, 9, 0, 0, 0, time.UTC)
+		prev := -1
+		for gap := time.Duration(0); gap <= 4*time.Hour; gap += 10 * time.Minute {
+			slots := []rank.Slot{
+				{Start: start, End: start.Add(time.Hour), Fixed: fixed},
+				{Request: req, Start: start.Add(time.Hour + gap), End: start.Add(2*time.Hour + gap), Fixed: -1, Available: all},
+			}
+			got := rank.Plan(w, slots, rooms)
+			d := rank.Distance(rooms[fixed].Location, rooms[got[1]].Location)
+			if d < prev {
+				t.Fatalf("fixture %d: distance from fixed room fell from %d to %d at gap %s", n, prev, d, gap)
+			}
+			prev = d
+		}
+	}
+}
+
+func FuzzParseLocation(f *testing.F) {
+	f.Add("3/2")
+	f.Add("-1/0")
+	f.Add("3/")
+	f.Fuzz(func(t *testing.T, s string) {
+		l, err := rank.ParseLocation(s)
+		if err != nil {
+			return
+		}
+		m, err := rank.ParseLocation(fmt.Sprintf("%d/%d", l.Floor, l.Section))
+		if err != nil {
+			t.Fatalf("reparsing %+v: %v", l, err)
+		}
+		if l != m {
+			t.Errorf("got %+v after round trip, want %+v", m, l)
+		}
+	})
+}
+
+func FuzzParseWeights(f *testing.F) {
+	f.Add("distance=1,capacity=0.5")
+	f.Add("sameroom= 2 ,popularity=NaN")
+	f.Add("floor")
+	f.Fuzz(func(t *testing.T, s string) {
+		base := rank.Presets[rank.DefaultPreset]
+		w, err := rank.ParseWeights(s, base)
+		if err != nil {
+			return
+		}
+		// Applying the same overrides again changes nothing.
+		w2, err := rank.ParseWeights(s, w)
+		if err != nil {
+			t.Fatalf("reapplying %q: %v", s, err)
+		}
+		if fmt.Sprint(w) != fmt.Sprint(w2) {
+			t.Errorf("got %+v after reapplying %q, want %+v", w2, s, w)
+		}
+	})
+}
@@ -0,0 +1,77 @@
+package rank
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Weights determine how the components of a Score are combined into a total.
+type Weights struct {
+	Distance   float64 // per meter
+	Capacity   float64 // per unused seat
+	Features   float64 // per missing feature
+	Floor      float64 // per floor away from the preferred floor
+	Anchor     float64 // per meter from the anchor location
+	SameRoom   float64 // bonus for keeping the same room
+	Popularity float64 // bonus per unit of popularity
+	Guest      float64 // penalty for a room guests can't reach
+}
+
+// Presets are named sets of weights.
+var Presets = map[string]Weights{
+	// closest prefers the nearest room, using fit and popularity to break ties.
+	"closest": {Distance: 1, Capacity: 0.1, Features: 10, Anchor: 0.5, SameRoom: 10, Popularity: 0.01, Guest: 50},
+
+	// best-fit prefers the room whose capacity most closely matches the number
+	// of attendees.
+	"best-fit": {Distance: 0.1, Capacity: 1, Features: 10, Anchor: 0.05, SameRoom: 10, Popularity: 0.01, Guest: 50},
+
+	// balanced trades off all components.
+	"balanced": {Distance: 1, Capacity: 1, Features: 10, Floor: 5, Anchor: 1, SameRoom: 20, Popularity: 0.5, Guest: 50},
+}
+
+// DefaultPreset is the name of the preset used by default.
+const DefaultPreset = "closest"
+
+// ParseWeights overrides weights in base with those in s, which is a
+// comma-separated list of name=value pairs, e.g. "distance=1,capacity=0.5".
+// Names are distance, capacity, features, floor, anchor, sameroom, popularity
+// and guest.
+func ParseWeights(s string, base Weights) (Weights, error) {
+	w := base
+	if s == "" {
+		return w, nil
+	}
+	for _, kv := range strings.Split(s, ",") {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			return w, fmt.Errorf("weight '%s' is not of the form name=value", kv)
+		}
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			return w, fmt.Errorf("weight '%s': %v", kv, err)
+		}
+		switch strings.TrimSpace(k) {
+		case "distance":
+			w.Distance = f
+		case "capacity":
+			w.Capacity = f
+		case "features":
+			w.Features = f
+		case "floor":
+			w.Floor = f
+		case "anchor":
+			w.Anchor = f
+		case "sameroom":
+			w.SameRoom = f
+		case "popularity":
+			w.Popularity = f
+		case "guest":
+			w.Guest = f
+		default:
+			return w, fmt.Errorf("unknown weight '%s'", k)
+		}
+	}
+	return w, nil
+}
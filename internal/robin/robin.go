@@ -0,0 +1,220 @@
+// Package robin is a minimal client for the Robin workplace management API
+// (https://docs.robinpowered.com), covering what is needed to find and book
+// spaces in a location.
+package robin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/vsekhar/gocal/internal/timeutil"
+)
+
+// DefaultEndpoint is the base URL of the Robin API.
+const DefaultEndpoint = "https://api.robinpowered.com/v1.0"
+
+// pageSize is the number of items requested per page of a listing.
+const pageSize = 100
+
+// Client calls the Robin API with an access token.
+type Client struct {
+	endpoint string
+	token    string
+	hc       *http.Client
+}
+
+// NewClient returns a client for the API at endpoint authenticating with
+// token. If hc is nil, http.DefaultClient is used.
+func NewClient(endpoint, token string, hc *http.Client) *Client {
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	return &Client{endpoint: strings.TrimSuffix(endpoint, "/"), token: token, hc: hc}
+}
+
+// Location is an office.
+type Location struct {
+	ID       int64  `json:"id"`
+	Name     string `json:"name"`
+	TimeZone string `json:"time_zone"`
+}
+
+// Level is a floor of a location.
+type Level struct {
+	ID    int64  `json:"id"`
+	Name  string `json:"name"`
+	Level int    `json:"level"`
+}
+
+// Space is a bookable room.
+type Space struct {
+	ID         int64  `json:"id"`
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	LevelID    int64  `json:"level_id"`
+	Capacity   int64  `json:"capacity"`
+	IsDisabled bool   `json:"is_disabled"`
+	Amenities  []struct {
+		Name string `json:"name"`
+	} `json:"amenities"`
+}
+
+// DateTime is a time as represented by the API.
+type DateTime struct {
+	DateTime string `json:"date_time"`
+	TimeZone string `json:"time_zone,omitempty"`
+}
+
+// Event is a booking of a space.
+type Event struct {
+	ID    json.Number `json:"id,omitempty"`
+	Title string      `json:"title"`
+	Start DateTime    `json:"start"`
+	End   DateTime    `json:"end"`
+}
+
+// Error is an error returned by the API.
+type Error struct {
+	Status  int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("robin: %d %s", e.Status, e.Message)
+}
+
+// envelope is the wrapper of every API response.
+type envelope struct {
+	Meta struct {
+		Message string `json:"message"`
+	} `json:"meta"`
+	Data   json.RawMessage `json:"data"`
+	Paging *struct {
+		HasNextPage bool `json:"has_next_page"`
+	} `json:"paging"`
+}
+
+// do calls method on path with query and body, decoding the response's data
+// into v. It returns whether there is a further page of data.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body, v interface{}) (bool, error) {
+	u := c.endpoint + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return false, err
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, r)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Authorization", "Access-Token "+c.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.hc.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	var env envelope
+	if err := json.NewDecoder(resp.Body).Decode(&env); err != nil && resp.StatusCode < 300 {
+		return false, fmt.Errorf("robin: decoding %s %s: %v", method, path, err)
+	}
+	if resp.StatusCode >= 300 {
+		msg := env.Meta.Message
+		if msg == "" {
+			msg = http.StatusText(resp.StatusCode)
+		}
+		return false, &Error{Status: resp.StatusCode, Message: msg}
+	}
+	if v != nil {
+		if err := json.Unmarshal(env.Data, v); err != nil {
+			return false, fmt.Errorf("robin: decoding %s %s: %v", method, path, err)
+		}
+	}
+	return env.Paging != nil && env.Paging.HasNextPage, nil
+}
+
+// list reads each page of the listing at path, appending each page's items
+// to *v.
+func list[T any](ctx context.Context, c *Client, path string, query url.Values, v *[]T) error {
+	if query == nil {
+		query = url.Values{}
+	}
+	query.Set("per_page", fmt.Sprint(pageSize))
+	for page := 1; ; page++ {
+		query.Set("page", fmt.Sprint(page))
+		var items []T
+		more, err := c.do(ctx, http.MethodGet, path, query, nil, &items)
+		if err != nil {
+			return err
+		}
+		*v = append(*v, items...)
+		if !more || len(items) == 0 {
+			return nil
+		}
+	}
+}
+
+// Location returns the location with id.
+func (c *Client) Location(ctx context.Context, id string) (*Location, error) {
+	ret := new(Location)
+	if _, err := c.do(ctx, http.MethodGet, "/locations/"+url.PathEscape(id), nil, nil, ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// Levels returns the levels of the location with id.
+func (c *Client) Levels(ctx context.Context, id string) ([]Level, error) {
+	var ret []Level
+	err := list(ctx, c, "/locations/"+url.PathEscape(id)+"/levels", nil, &ret)
+	return ret, err
+}
+
+// Spaces returns the spaces in the location with id.
+func (c *Client) Spaces(ctx context.Context, id string) ([]Space, error) {
+	var ret []Space
+	err := list(ctx, c, "/locations/"+url.PathEscape(id)+"/spaces", nil, &ret)
+	return ret, err
+}
+
+// Events returns the events booking the space with id between start and
+// end.
+func (c *Client) Events(ctx context.Context, id int64, start, end time.Time) ([]Event, error) {
+	q := url.Values{
+		"after":  {start.Format(time.RFC3339)},
+		"before": {end.Format(time.RFC3339)},
+	}
+	var ret []Event
+	err := list(ctx, c, fmt.Sprintf("/spaces/%d/events", id), q, &ret)
+	return ret, err
+}
+
+// Book creates an event titled title booking the space with id from start
+// to end, and returns it.
+func (c *Client) Book(ctx context.Context, id int64, title string, start, end time.Time) (*Event, error) {
+	body := Event{
+		Title: title,
+		Start: DateTime{DateTime: start.Format(time.RFC3339), TimeZone: timeutil.ZoneName(start.Location())},
+		End:   DateTime{DateTime: end.Format(time.RFC3339), TimeZone: timeutil.ZoneName(end.Location())},
+	}
+	ret := new(Event)
+	if _, err := c.do(ctx, http.MethodPost, fmt.Sprintf("/spaces/%d/events", id), nil, body, ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
@@ -0,0 +1,72 @@
+package robin_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/vsekhar/gocal/internal/robin"
+)
+
+func TestClient(t *testing.T) {
+	var booked robin.Event
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Access-Token secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]interface{}{"meta": map[string]string{"message": "bad token"}})
+			return
+		}
+		reply := func(data interface{}, more bool) {
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"meta":   map[string]interface{}{"status_code": 200},
+				"data":   data,
+				"paging": map[string]bool{"has_next_page": more},
+			})
+		}
+		switch {
+		case r.URL.Path == "/locations/7/spaces" && r.URL.Query().Get("page") == "1":
+			reply([]robin.Space{{ID: 1, Name: "Phoenix"}}, true)
+		case r.URL.Path == "/locations/7/spaces" && r.URL.Query().Get("page") == "2":
+			reply([]robin.Space{{ID: 2, Name: "Osprey"}}, false)
+		case r.URL.Path == "/spaces/2/events" && r.Method == http.MethodPost:
+			json.NewDecoder(r.Body).Decode(&booked)
+			booked.ID = "99"
+			reply(booked, false)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]interface{}{"meta": map[string]string{"message": "not found"}})
+		}
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+
+	c := robin.NewClient(srv.URL+"/", "secret", nil)
+	spaces, err := c.Spaces(ctx, "7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(spaces) != 2 || spaces[0].Name != "Phoenix" || spaces[1].Name != "Osprey" {
+		t.Errorf("got spaces %+v, want Phoenix and Osprey from two pages", spaces)
+	}
+
+	start := time.Date(2022, 4, 1, 9, 0, 0, 0, time.UTC)
+	e, err := c.Book(ctx, 2, "Sync", start, start.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.ID != "99" || booked.Title != "Sync" || booked.Start.DateTime != "2022-04-01T09:00:00Z" {
+		t.Errorf("booked %+v, returned %+v", booked, e)
+	}
+
+	var apiErr *robin.Error
+	if _, err := c.Location(ctx, "8"); !errors.As(err, &apiErr) || apiErr.Status != http.StatusNotFound {
+		t.Errorf("got %v for an unknown location, want a 404 error", err)
+	}
+	if _, err := robin.NewClient(srv.URL, "wrong", nil).Spaces(ctx, "7"); !errors.As(err, &apiErr) || apiErr.Message != "bad token" {
+		t.Errorf("got %v with a wrong token, want 'bad token'", err)
+	}
+}
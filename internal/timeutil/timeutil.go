@@ -0,0 +1,59 @@
+// Package timeutil formats and parses the time strings used by the Calendar
+// API with explicit handling of locations.
+package timeutil
+
+import (
+	"time"
+)
+
+const dateLayout = "2006-01-02"
+
+// Format formats t as an RFC3339 string in loc, so that the string carries the
+// offset in effect in loc at t.
+func Format(t time.Time, loc *time.Location) string {
+	return t.In(loc).Format(time.RFC3339)
+}
+
+// Parse parses an RFC3339 string and returns the time in loc.
+func Parse(s string, loc *time.Location) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return t.In(loc), nil
+}
+
+// ParseDate parses a date of the form "2006-01-02" and returns midnight at the
+// start of that date in loc.
+func ParseDate(s string, loc *time.Location) (time.Time, error) {
+	return time.ParseInLocation(dateLayout, s, loc)
+}
+
+// Date returns the date of t in loc in the form "2006-01-02".
+func Date(t time.Time, loc *time.Location) string {
+	return t.In(loc).Format(dateLayout)
+}
+
+// Add returns t+d, treating any whole days in d as calendar days in loc. Adding
+// 24h across a daylight saving time transition therefore yields the same
+// wall-clock time on the next day rather than one hour earlier or later.
+func Add(t time.Time, d time.Duration, loc *time.Location) time.Time {
+	const day = 24 * time.Hour
+	days, rem := d/day, d%day
+	t = t.In(loc)
+	y, m, dd := t.Date()
+	h, mm, s := t.Clock()
+	t = time.Date(y, m, dd+int(days), h, mm, s, t.Nanosecond(), loc)
+	return t.Add(rem)
+}
+
+// ZoneName returns the IANA name of loc for use in API requests, or the empty
+// string if loc has no such name (e.g. time.Local).
+func ZoneName(loc *time.Location) string {
+	switch n := loc.String(); n {
+	case "Local", "":
+		return ""
+	default:
+		return n
+	}
+}
@@ -0,0 +1,45 @@
+package timeutil_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/vsekhar/gocal/internal/timeutil"
+)
+
+func TestAddAcrossDST(t *testing.T) {
+	loc, err := time.LoadLocation("America/Toronto")
+	if err != nil {
+		t.Skip(err)
+	}
+	// Clocks went forward at 2am on 2022-03-13.
+	start := time.Date(2022, 3, 12, 9, 0, 0, 0, loc)
+	got := timeutil.Add(start, 24*time.Hour, loc)
+	want := time.Date(2022, 3, 13, 9, 0, 0, 0, loc)
+	if !got.Equal(want) {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	if d := got.Sub(start); d != 23*time.Hour {
+		t.Errorf("got elapsed %s, want 23h", d)
+	}
+	got = timeutil.Add(start, 25*time.Hour+30*time.Minute, loc)
+	want = time.Date(2022, 3, 13, 10, 30, 0, 0, loc)
+	if !got.Equal(want) {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestFormat(t *testing.T) {
+	loc, err := time.LoadLocation("America/Toronto")
+	if err != nil {
+		t.Skip(err)
+	}
+	before := time.Date(2022, 3, 13, 6, 0, 0, 0, time.UTC)
+	after := time.Date(2022, 3, 13, 8, 0, 0, 0, time.UTC)
+	if got, want := timeutil.Format(before, loc), "2022-03-13T01:00:00-05:00"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	if got, want := timeutil.Format(after, loc), "2022-03-13T04:00:00-04:00"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}